@@ -0,0 +1,99 @@
+package saml
+
+import (
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// AddressCheck configures the verification of the Address of the subject
+// confirmations against the IP address of the user agent posting the
+// response.
+type AddressCheck struct {
+	// Required rejects the confirmations without an Address.
+	Required bool
+
+	// TrustedProxies lists the networks of the reverse proxies whose
+	// X-Forwarded-For header is trusted to find the client IP address.
+	TrustedProxies []*net.IPNet
+
+	// IPv4PrefixLen and IPv6PrefixLen allow the client and the Address to be
+	// different IPs of the same network, e.g. for users behind a pool of NAT
+	// gateways. Zero requires the exact same IP address.
+	IPv4PrefixLen int
+	IPv6PrefixLen int
+}
+
+// ClientIP returns the IP address of the user agent that sent r, following
+// the X-Forwarded-For header through the trusted proxies.
+func (c *AddressCheck) ClientIP(r *http.Request) net.IP {
+	ip := parseAddress(r.RemoteAddr)
+	if ip == nil || !c.isTrustedProxy(ip) {
+		return ip
+	}
+	hops := strings.Split(strings.Join(r.Header["X-Forwarded-For"], ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := parseAddress(strings.TrimSpace(hops[i]))
+		if hop == nil {
+			break
+		}
+		ip = hop
+		if !c.isTrustedProxy(hop) {
+			break
+		}
+	}
+	return ip
+}
+
+func (c *AddressCheck) isTrustedProxy(ip net.IP) bool {
+	for _, network := range c.TrustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// check verifies the Address of a subject confirmation against the client
+// IP address.
+func (c *AddressCheck) check(address string, clientIP net.IP) error {
+	if address == "" {
+		if c.Required {
+			return errors.New("missing SubjectConfirmationData Address")
+		}
+		return nil
+	}
+	ip := parseAddress(address)
+	if ip == nil {
+		return errors.Errorf("invalid SubjectConfirmationData Address %q", address)
+	}
+	if clientIP == nil {
+		return errors.New("unknown client address")
+	}
+	if ip.Equal(clientIP) {
+		return nil
+	}
+
+	prefixLen, bits := c.IPv6PrefixLen, 8*net.IPv6len
+	if ip4 := ip.To4(); ip4 != nil {
+		ip, clientIP = ip4, clientIP.To4()
+		prefixLen, bits = c.IPv4PrefixLen, 8*net.IPv4len
+	}
+	if prefixLen > 0 && clientIP != nil {
+		mask := net.CIDRMask(prefixLen, bits)
+		if ip.Mask(mask).Equal(clientIP.Mask(mask)) {
+			return nil
+		}
+	}
+	return errors.Errorf("address %s does not match client %s", ip, clientIP)
+}
+
+// parseAddress parses an IP address, with an optional port.
+func parseAddress(address string) net.IP {
+	if host, _, err := net.SplitHostPort(address); err == nil {
+		address = host
+	}
+	return net.ParseIP(address)
+}
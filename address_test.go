@@ -0,0 +1,50 @@
+package saml
+
+import (
+	"net"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAddressCheckClientIP(t *testing.T) {
+	_, proxies, _ := net.ParseCIDR("10.0.0.0/8")
+	c := &AddressCheck{TrustedProxies: []*net.IPNet{proxies}}
+
+	r := httptest.NewRequest("POST", "/saml/acs", nil)
+	r.RemoteAddr = "192.0.2.1:1234"
+	r.Header.Set("X-Forwarded-For", "198.51.100.1")
+	assert.Equal(t, "192.0.2.1", c.ClientIP(r).String())
+
+	r.RemoteAddr = "10.0.0.1:1234"
+	r.Header.Set("X-Forwarded-For", "198.51.100.1, 203.0.113.1, 10.0.0.2")
+	assert.Equal(t, "203.0.113.1", c.ClientIP(r).String())
+
+	r.Header.Del("X-Forwarded-For")
+	assert.Equal(t, "10.0.0.1", c.ClientIP(r).String())
+}
+
+func TestAddressCheck(t *testing.T) {
+	c := &AddressCheck{}
+	client := net.ParseIP("192.0.2.1")
+
+	assert.NoError(t, c.check("", client))
+	assert.NoError(t, c.check("192.0.2.1", client))
+	assert.NoError(t, c.check("192.0.2.1:4321", client))
+	assert.Error(t, c.check("192.0.2.2", client))
+	assert.Error(t, c.check("example.org", client))
+	assert.Error(t, c.check("192.0.2.1", nil))
+
+	c.Required = true
+	assert.Error(t, c.check("", client))
+
+	c.IPv4PrefixLen = 24
+	assert.NoError(t, c.check("192.0.2.2", client))
+	assert.Error(t, c.check("192.0.3.1", client))
+
+	c.IPv6PrefixLen = 64
+	assert.NoError(t, c.check("2001:db8::1", net.ParseIP("2001:db8::2")))
+	assert.Error(t, c.check("2001:db8:1::1", net.ParseIP("2001:db8::2")))
+	assert.Error(t, c.check("2001:db8::1", client))
+}
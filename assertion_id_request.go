@@ -0,0 +1,259 @@
+package saml
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"net/http"
+	"time"
+
+	"github.com/goware/saml/xmlsec"
+	"github.com/pkg/errors"
+)
+
+// AssertionIDRequest represents the SAML object of the same name, asking an
+// IdP for the assertions it issued with the given IDs.
+//
+// See http://docs.oasis-open.org/security/saml/v2.0/saml-core-2.0-os.pdf section 3.3.1
+type AssertionIDRequest struct {
+	XMLName         xml.Name          `xml:"urn:oasis:names:tc:SAML:2.0:protocol AssertionIDRequest"`
+	ID              string            `xml:",attr"`
+	Version         string            `xml:",attr"`
+	IssueInstant    time.Time         `xml:",attr"`
+	Destination     string            `xml:",attr,omitempty"`
+	Issuer          Issuer            `xml:"urn:oasis:names:tc:SAML:2.0:assertion Issuer"`
+	Signature       *xmlsec.Signature `xml:"http://www.w3.org/2000/09/xmldsig# Signature"`
+	AssertionIDRefs []string          `xml:"urn:oasis:names:tc:SAML:2.0:assertion AssertionIDRef"`
+}
+
+// MarshalXML implements xml.Marshaler. The request is written with the
+// samlp: and saml: prefixes, as AuthnRequest.
+func (req AssertionIDRequest) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	type assertionIDRequest AssertionIDRequest
+	buf, err := xml.Marshal(assertionIDRequest(req))
+	if err != nil {
+		return err
+	}
+	return encodePrefixed(e, buf)
+}
+
+// ErrUnknownAssertion is returned by an AssertionStore which does not have
+// the requested assertion.
+var ErrUnknownAssertion = errors.New("unknown assertion")
+
+// AssertionStore keeps the assertions issued by an IdP, so that the SPs can
+// fetch them again by ID with an AssertionIDRequest. Implementations must be
+// safe for concurrent use.
+type AssertionStore interface {
+	// GetAssertion returns the XML of the assertion with the given ID, as
+	// issued, signature included, or ErrUnknownAssertion.
+	GetAssertion(ctx context.Context, id string) ([]byte, error)
+}
+
+// assertionIDResponse is the Response to an AssertionIDRequest, carrying the
+// assertions as returned by the AssertionStore, so that their signature
+// stays valid.
+type assertionIDResponse struct {
+	XMLName      xml.Name  `xml:"urn:oasis:names:tc:SAML:2.0:protocol Response"`
+	ID           string    `xml:",attr"`
+	InResponseTo string    `xml:",attr"`
+	IssueInstant time.Time `xml:",attr"`
+	Version      string    `xml:",attr"`
+	Issuer       *Issuer   `xml:"urn:oasis:names:tc:SAML:2.0:assertion Issuer"`
+	Status       *Status   `xml:"urn:oasis:names:tc:SAML:2.0:protocol Status"`
+	Assertions   []byte    `xml:",innerxml"`
+}
+
+// AssertionIDRequestHandler serves the AssertionIDRequests sent with the
+// SOAP binding, which must be signed by the SP, answering them with the
+// assertions of AssertionStore. Only the assertions restricted to the
+// audience of the SP are returned.
+func (idp *IdentityProvider) AssertionIDRequestHandler(w http.ResponseWriter, r *http.Request) {
+	if idp.AssertionStore == nil {
+		idp.internalErr(w, r, errors.New("no assertion store"))
+		return
+	}
+	var req AssertionIDRequest
+	if !idp.readSignedRequest(w, r, "AssertionIDRequest", &req, &req.Signature) {
+		return
+	}
+	assertions, err := idp.requestedAssertions(r.Context(), &req)
+	if err != nil {
+		idp.internalErr(w, r, err)
+		return
+	}
+	buf, err := idp.assertionIDResponse(&req, assertions)
+	if err != nil {
+		idp.internalErr(w, r, errors.Wrap(err, "failed to build response"))
+		return
+	}
+	writeSOAP(w, buf)
+}
+
+// requestedAssertions returns the assertions of AssertionStore requested
+// by req whose audience is the issuer of req.
+func (idp *IdentityProvider) requestedAssertions(ctx context.Context, req *AssertionIDRequest) ([]byte, error) {
+	var assertions bytes.Buffer
+	for _, id := range req.AssertionIDRefs {
+		data, err := idp.AssertionStore.GetAssertion(ctx, id)
+		if errors.Cause(err) == ErrUnknownAssertion {
+			idp.logger().Debug("unknown assertion requested", "id", id, "issuer", req.Issuer.Value)
+			continue
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to get assertion %q", id)
+		}
+		var assertion Assertion
+		if err := xml.Unmarshal(data, &assertion); err != nil {
+			return nil, errors.Wrapf(err, "invalid assertion %q", id)
+		}
+		if conditions := assertion.Conditions; conditions == nil || len(conditions.AudienceRestrictions) == 0 ||
+			checkAudience(conditions.AudienceRestrictions, req.Issuer.Value) != nil {
+			idp.logger().Debug("assertion requested outside of its audience", "id", id, "issuer", req.Issuer.Value)
+			continue
+		}
+		assertions.Write(data)
+	}
+	return assertions.Bytes(), nil
+}
+
+// assertionIDResponse returns the Response to req carrying assertions, or
+// a ResourceNotRecognized status if there is none.
+func (idp *IdentityProvider) assertionIDResponse(req *AssertionIDRequest, assertions []byte) ([]byte, error) {
+	status := &Status{StatusCode: StatusCode{Value: StatusSuccess}}
+	if len(assertions) == 0 {
+		status = &Status{StatusCode: StatusCode{
+			Value:      StatusRequester,
+			StatusCode: &StatusCode{Value: StatusResourceNotRecognized},
+		}}
+	}
+	return xml.Marshal(assertionIDResponse{
+		ID:           idp.newID(),
+		InResponseTo: req.ID,
+		IssueInstant: idp.now(),
+		Version:      "2.0",
+		Issuer: &Issuer{
+			Format: "urn:oasis:names:tc:SAML:2.0:nameid-format:entity",
+			Value:  idp.MetadataURL,
+		},
+		Status:     status,
+		Assertions: assertions,
+	})
+}
+
+// RequestAssertion fetches the assertion with the given ID from the IdP,
+// with an AssertionIDRequest sent to its AssertionIDRequestService with the
+// SOAP binding, signed with the SP's key. The assertion, or the response
+// carrying it, must be signed by the IdP. The conditions of the assertion
+// are not checked: it may be one the SP already accepted.
+func (sp *ServiceProvider) RequestAssertion(ctx context.Context, id string) (*Assertion, error) {
+	meta, err := sp.GetIdPMetadataContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	location := ""
+	if meta.IDPSSODescriptor != nil {
+		location = soapLocation(meta.IDPSSODescriptor.AssertionIDRequestService)
+	}
+	if location == "" {
+		return nil, errors.New("the IdP has no AssertionIDRequestService with the SOAP binding")
+	}
+
+	req := &AssertionIDRequest{
+		ID:           sp.newID(),
+		Version:      "2.0",
+		IssueInstant: sp.now(),
+		Destination:  location,
+		Issuer: Issuer{
+			Format: "urn:oasis:names:tc:SAML:2.0:nameid-format:entity",
+			Value:  sp.entityID(),
+		},
+		AssertionIDRefs: []string{id},
+	}
+	msg, err := sp.marshalSigned(req, &req.Signature)
+	if err != nil {
+		return nil, err
+	}
+	buf, err := soapCall(ctx, sp.soapClient(), location, msg, sp.maxXMLSize())
+	if err != nil {
+		return nil, errors.Wrap(err, "AssertionIDRequest failed")
+	}
+
+	if err := checkSignedStructure(buf, samlpNamespace, "Response"); err != nil {
+		return nil, validationErrorf(ErrMalformedResponse, err, "")
+	}
+	var res Response
+	if err := xml.Unmarshal(buf, &res); err != nil {
+		return nil, validationErrorf(ErrMalformedResponse, err, "")
+	}
+	switch {
+	case res.Status == nil:
+		return nil, validationErrorf(ErrStatusNotSuccess, nil, "missing Response > Status")
+	case res.Status.StatusCode.Value != StatusSuccess:
+		return nil, &ValidationError{Kind: ErrStatusNotSuccess, cause: newStatusError(res.Status)}
+	case res.InResponseTo != req.ID:
+		return nil, validationErrorf(ErrUnexpectedInResponseTo, nil, "expecting %q, got %q", req.ID, res.InResponseTo)
+	case meta.EntityID != "" && (res.Issuer == nil || res.Issuer.Value != meta.EntityID):
+		return nil, validationErrorf(ErrIssuerMismatch, nil, "expected %q", meta.EntityID)
+	}
+
+	assertion := res.Assertion
+	switch {
+	case res.EncryptedAssertion != nil:
+		if assertion, err = sp.decryptRequestedAssertion(ctx, res.EncryptedAssertion); err != nil {
+			return nil, err
+		}
+	case assertion == nil:
+		return nil, validationErrorf(ErrMissingAssertion, nil, "")
+	case res.Signature != nil:
+		if err := validateSignedNode(res.Signature, res.ID); err != nil {
+			return nil, validationErrorf(ErrInvalidSignature, err, "failed to validate Response + Signature")
+		}
+		if err := sp.verifySignature(ctx, buf, ""); err != nil {
+			return nil, validationErrorf(ErrInvalidSignature, err, "unable to verify message signature")
+		}
+	case assertion.Signature != nil:
+		if err := validateSignedNode(assertion.Signature, assertion.ID); err != nil {
+			return nil, validationErrorf(ErrInvalidSignature, err, "failed to validate Assertion + Signature")
+		}
+		if err := sp.verifySignature(ctx, buf, assertion.ID); err != nil {
+			return nil, validationErrorf(ErrInvalidSignature, err, "unable to verify assertion signature")
+		}
+	default:
+		return nil, validationErrorf(ErrMissingSignature, nil, "")
+	}
+	if assertion.ID != id {
+		return nil, validationErrorf(ErrMalformedResponse, nil, "expected assertion %q, got %q", id, assertion.ID)
+	}
+	return assertion, nil
+}
+
+// decryptRequestedAssertion decrypts the assertion returned to
+// RequestAssertion, which must be signed.
+func (sp *ServiceProvider) decryptRequestedAssertion(ctx context.Context, encrypted *EncryptedAssertion) (*Assertion, error) {
+	keyFile, err := sp.PrivkeyFile()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get private key")
+	}
+	plainText, err := xmlsec.Decrypt(encrypted.EncryptedData, keyFile)
+	if err != nil {
+		return nil, validationErrorf(ErrDecryption, err, "unable to decrypt message")
+	}
+	if err := checkSignedStructure(plainText, samlNamespace, "Assertion"); err != nil {
+		return nil, validationErrorf(ErrDecryption, err, "invalid assertion XML document")
+	}
+	assertion := &Assertion{}
+	if err := xml.Unmarshal(plainText, assertion); err != nil {
+		return nil, validationErrorf(ErrDecryption, err, "unable to parse assertion")
+	}
+	if assertion.Signature == nil {
+		return nil, validationErrorf(ErrMissingSignature, nil, "")
+	}
+	if err := validateSignedNode(assertion.Signature, assertion.ID); err != nil {
+		return nil, validationErrorf(ErrInvalidSignature, err, "failed to validate Assertion + Signature")
+	}
+	if err := sp.verifySignature(ctx, plainText, ""); err != nil {
+		return nil, validationErrorf(ErrInvalidSignature, err, "unable to verify assertion signature")
+	}
+	return assertion, nil
+}
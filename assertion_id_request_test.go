@@ -0,0 +1,176 @@
+package saml
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testAssertionStore map[string][]byte
+
+func (s testAssertionStore) GetAssertion(ctx context.Context, id string) ([]byte, error) {
+	if data, ok := s[id]; ok {
+		return data, nil
+	}
+	return nil, ErrUnknownAssertion
+}
+
+func newTestAssertionIDRequestIdP(t *testing.T, sp *ServiceProvider) *IdentityProvider {
+	assertion, err := xml.Marshal(newTestAssertionResponse(sp, Now()).Assertion)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	noAudience := newTestAssertionResponse(sp, Now()).Assertion
+	noAudience.Conditions.AudienceRestrictions = nil
+	unrestricted, err := xml.Marshal(noAudience)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	twoAudiences := newTestAssertionResponse(sp, Now()).Assertion
+	twoAudiences.ID = "id-shared"
+	twoAudiences.Conditions.AudienceRestrictions[0].Audiences = []Audience{{Value: "https://other.example.org"}, {Value: sp.entityID()}}
+	shared, err := xml.Marshal(twoAudiences)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	return &IdentityProvider{
+		MetadataURL: sp.IdPMetadata.EntityID,
+		SPMetadata:  &Metadata{EntityID: sp.entityID()},
+		AssertionStore: testAssertionStore{
+			"id-assertion":    assertion,
+			"id-other":        bytes.Replace(assertion, []byte(sp.entityID()), []byte("https://other.example.org"), 1),
+			"id-unrestricted": unrestricted,
+			"id-shared":       shared,
+		},
+		AssertionIDRequestURL: "http://localhost:1233/saml/assertion",
+	}
+}
+
+func TestAssertionIDRequestHandler(t *testing.T) {
+	tearUp()
+
+	sp := newTestResponseSP()
+	idp := newTestAssertionIDRequestIdP(t, sp)
+	var failure error
+	idp.OnFailure = func(r *http.Request, level FailureLevel, err error) {
+		failure = err
+	}
+	serve := func(issuer string) int {
+		failure = nil
+		msg, err := xml.Marshal(&AssertionIDRequest{
+			ID:              "id-request",
+			Version:         "2.0",
+			IssueInstant:    Now(),
+			Issuer:          Issuer{Value: issuer},
+			AssertionIDRefs: []string{"id-assertion"},
+		})
+		assert.NoError(t, err)
+		w := httptest.NewRecorder()
+		idp.AssertionIDRequestHandler(w, httptest.NewRequest("POST", idp.AssertionIDRequestURL, bytes.NewReader(wrapSOAP(msg))))
+		return w.Code
+	}
+
+	// The requests of the SP must be signed.
+	assert.Equal(t, http.StatusBadRequest, serve(sp.entityID()))
+	assert.True(t, errors.Is(failure, ErrMissingSignature), "%v", failure)
+	assert.Equal(t, http.StatusBadRequest, serve("https://other.example.org"))
+	assert.True(t, errors.Is(failure, ErrIssuerMismatch), "%v", failure)
+
+	w := httptest.NewRecorder()
+	idp.AssertionIDRequestHandler(w, httptest.NewRequest("GET", idp.AssertionIDRequestURL, nil))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	// Only the assertions restricted to the audience of the SP are
+	// returned.
+	request := func(id string) *Response {
+		req := &AssertionIDRequest{ID: "id-request", Issuer: Issuer{Value: sp.entityID()}, AssertionIDRefs: []string{id}}
+		assertions, err := idp.requestedAssertions(context.Background(), req)
+		assert.NoError(t, err)
+		buf, err := idp.assertionIDResponse(req, assertions)
+		assert.NoError(t, err)
+		var res Response
+		assert.NoError(t, xml.Unmarshal(buf, &res))
+		assert.Equal(t, "id-request", res.InResponseTo)
+		assert.Equal(t, idp.MetadataURL, res.Issuer.Value)
+		return &res
+	}
+	for _, id := range []string{"id-assertion", "id-shared"} {
+		res := request(id)
+		if assert.NotNil(t, res.Assertion, id) {
+			assert.Equal(t, StatusSuccess, res.Status.StatusCode.Value)
+			assert.Equal(t, id, res.Assertion.ID)
+		}
+	}
+	for _, id := range []string{"id-unknown", "id-other", "id-unrestricted"} {
+		res := request(id)
+		assert.Nil(t, res.Assertion, id)
+		assert.Equal(t, StatusRequester, res.Status.StatusCode.Value)
+		assert.Equal(t, StatusResourceNotRecognized, res.Status.StatusCode.StatusCode.Value)
+	}
+
+	metadata, err := (&IdentityProvider{
+		MetadataURL:           idp.MetadataURL,
+		PubkeyPEM:             testIdP.PubkeyPEM,
+		AssertionIDRequestURL: idp.AssertionIDRequestURL,
+	}).Metadata()
+	if assert.NoError(t, err) {
+		assert.Equal(t, []Endpoint{{Binding: SOAPBinding, Location: idp.AssertionIDRequestURL}}, metadata.IDPSSODescriptor.AssertionIDRequestService)
+	}
+}
+
+func TestRequestAssertion(t *testing.T) {
+	tearUp()
+
+	sp := newTestResponseSP()
+	_, err := sp.RequestAssertion(context.Background(), "id-assertion")
+	assert.Error(t, err)
+
+	// The IdP answers without verifying the signature of the request,
+	// which requires xmlsec1.
+	idp := newTestAssertionIDRequestIdP(t, sp)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		msg, err := readSOAPRequest(r, idp.maxXMLSize())
+		assert.NoError(t, err)
+		var req AssertionIDRequest
+		assert.NoError(t, xml.Unmarshal(msg, &req))
+		assertions, err := idp.requestedAssertions(r.Context(), &req)
+		assert.NoError(t, err)
+		buf, err := idp.assertionIDResponse(&req, assertions)
+		assert.NoError(t, err)
+		writeSOAP(w, buf)
+	}))
+	defer server.Close()
+	sp.IdPMetadata.IDPSSODescriptor = &IDPSSODescriptor{
+		AssertionIDRequestService: []Endpoint{{Binding: SOAPBinding, Location: server.URL}},
+	}
+
+	// The assertions of the store are not signed.
+	_, err = sp.RequestAssertion(context.Background(), "id-assertion")
+	assert.True(t, errors.Is(err, ErrMissingSignature), "%v", err)
+
+	_, err = sp.RequestAssertion(context.Background(), "id-unknown")
+	assert.True(t, errors.Is(err, ErrStatusNotSuccess), "%v", err)
+	var statusErr *StatusError
+	if assert.True(t, errors.As(err, &statusErr)) {
+		assert.Equal(t, StatusResourceNotRecognized, statusErr.SubCode)
+	}
+}
+
+func TestSOAPEnvelope(t *testing.T) {
+	msg := []byte(`<samlp:Response xmlns:samlp="urn:oasis:names:tc:SAML:2.0:protocol"/>`)
+	buf, err := unwrapSOAP(wrapSOAP(msg))
+	assert.NoError(t, err)
+	assert.Equal(t, msg, buf)
+
+	_, err = unwrapSOAP([]byte(`<soap11:Envelope xmlns:soap11="` + soapNamespace + `"><soap11:Body><soap11:Fault><faultcode>soap11:Server</faultcode><faultstring>oops</faultstring></soap11:Fault></soap11:Body></soap11:Envelope>`))
+	if assert.Error(t, err) {
+		assert.True(t, strings.Contains(err.Error(), "oops"))
+	}
+}
@@ -0,0 +1,86 @@
+package saml
+
+// attributeOIDs maps the friendly names of common attributes to their
+// urn:oid names, as defined by the eduPerson, inetOrgPerson and SCHAC
+// schemas.
+var attributeOIDs = map[string]string{
+	// eduPerson
+	"eduPersonAffiliation":        "urn:oid:1.3.6.1.4.1.5923.1.1.1.1",
+	"eduPersonNickname":           "urn:oid:1.3.6.1.4.1.5923.1.1.1.2",
+	"eduPersonOrgDN":              "urn:oid:1.3.6.1.4.1.5923.1.1.1.3",
+	"eduPersonOrgUnitDN":          "urn:oid:1.3.6.1.4.1.5923.1.1.1.4",
+	"eduPersonPrimaryAffiliation": "urn:oid:1.3.6.1.4.1.5923.1.1.1.5",
+	"eduPersonPrincipalName":      "urn:oid:1.3.6.1.4.1.5923.1.1.1.6",
+	"eduPersonEntitlement":        "urn:oid:1.3.6.1.4.1.5923.1.1.1.7",
+	"eduPersonPrimaryOrgUnitDN":   "urn:oid:1.3.6.1.4.1.5923.1.1.1.8",
+	"eduPersonScopedAffiliation":  "urn:oid:1.3.6.1.4.1.5923.1.1.1.9",
+	"eduPersonTargetedID":         "urn:oid:1.3.6.1.4.1.5923.1.1.1.10",
+	"eduPersonAssurance":          "urn:oid:1.3.6.1.4.1.5923.1.1.1.11",
+	"eduPersonUniqueId":           "urn:oid:1.3.6.1.4.1.5923.1.1.1.13",
+	"eduPersonOrcid":              "urn:oid:1.3.6.1.4.1.5923.1.1.1.16",
+
+	// inetOrgPerson and its parents
+	"cn":                "urn:oid:2.5.4.3",
+	"sn":                "urn:oid:2.5.4.4",
+	"l":                 "urn:oid:2.5.4.7",
+	"st":                "urn:oid:2.5.4.8",
+	"street":            "urn:oid:2.5.4.9",
+	"o":                 "urn:oid:2.5.4.10",
+	"ou":                "urn:oid:2.5.4.11",
+	"title":             "urn:oid:2.5.4.12",
+	"postalAddress":     "urn:oid:2.5.4.16",
+	"postalCode":        "urn:oid:2.5.4.17",
+	"telephoneNumber":   "urn:oid:2.5.4.20",
+	"givenName":         "urn:oid:2.5.4.42",
+	"initials":          "urn:oid:2.5.4.43",
+	"uid":               "urn:oid:0.9.2342.19200300.100.1.1",
+	"mail":              "urn:oid:0.9.2342.19200300.100.1.3",
+	"manager":           "urn:oid:0.9.2342.19200300.100.1.10",
+	"mobile":            "urn:oid:0.9.2342.19200300.100.1.41",
+	"employeeNumber":    "urn:oid:2.16.840.1.113730.3.1.3",
+	"employeeType":      "urn:oid:2.16.840.1.113730.3.1.4",
+	"preferredLanguage": "urn:oid:2.16.840.1.113730.3.1.39",
+	"displayName":       "urn:oid:2.16.840.1.113730.3.1.241",
+
+	// SCHAC
+	"schacHomeOrganization":     "urn:oid:1.3.6.1.4.1.25178.1.2.9",
+	"schacHomeOrganizationType": "urn:oid:1.3.6.1.4.1.25178.1.2.10",
+}
+
+// attributeFriendlyNames is the reverse of attributeOIDs.
+var attributeFriendlyNames = map[string]string{}
+
+func init() {
+	for friendlyName, oid := range attributeOIDs {
+		attributeFriendlyNames[oid] = friendlyName
+	}
+}
+
+// RegisterAttributeOID adds an attribute to the table used to resolve
+// attributes by OID or by friendly name. It must be called during the
+// program initialization.
+func RegisterAttributeOID(friendlyName, oid string) {
+	attributeOIDs[friendlyName] = oid
+	attributeFriendlyNames[oid] = friendlyName
+}
+
+// AttributeOID returns the urn:oid name of the attribute with the given
+// friendly name, or "" if it is unknown.
+func AttributeOID(friendlyName string) string {
+	return attributeOIDs[friendlyName]
+}
+
+// AttributeFriendlyName returns the friendly name of the attribute with the
+// given urn:oid name, or "" if it is unknown.
+func AttributeFriendlyName(oid string) string {
+	return attributeFriendlyNames[oid]
+}
+
+// attributeAlias returns the other known name of an attribute: the OID of a
+// friendly name, or the friendly name of an OID.
+func attributeAlias(name string) string {
+	if oid, ok := attributeOIDs[name]; ok {
+		return oid
+	}
+	return attributeFriendlyNames[name]
+}
@@ -1,5 +1,13 @@
 package saml
 
+import (
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
 // AttributesMap is a type that provides methods for working with SAML
 // attributes.
 type AttributesMap map[string][]string
@@ -25,7 +33,9 @@ func NewAttributesMap(assertion *Assertion) *AttributesMap {
 			if key == "" {
 				key = attr.FriendlyName
 			}
-			props[key] = values
+			// Some IdPs (Keycloak) send each value of a multi-valued
+			// attribute in its own Attribute element.
+			props[key] = append(props[key], values...)
 		}
 		return &props
 	}
@@ -33,12 +43,159 @@ func NewAttributesMap(assertion *Assertion) *AttributesMap {
 	return &props
 }
 
-// Get returns the first value of the given attribute, if any.
+// Get returns the first value of the given attribute, if any. The attribute
+// can be given by OID or by friendly name, whichever the IdP sent, for the
+// attributes known by AttributeOID.
 func (a *AttributesMap) Get(name string) string {
-	if v, ok := (map[string][]string)(*a)[name]; ok {
-		if len(v) > 0 {
-			return v[0]
-		}
+	if v := a.Values(name); len(v) > 0 {
+		return v[0]
 	}
 	return ""
 }
+
+// Values returns all the values of the given attribute. Like Get, it resolves
+// known attributes by OID or by friendly name.
+func (a *AttributesMap) Values(name string) []string {
+	m := (map[string][]string)(*a)
+	if v, ok := m[name]; ok {
+		return v
+	}
+	if alias := attributeAlias(name); alias != "" {
+		return m[alias]
+	}
+	return nil
+}
+
+// DecodeAttributes stores the attributes of the assertion in the struct
+// pointed to by v. Each field is mapped to an attribute with a tag giving its
+// name, and optionally its friendly name:
+//
+//	type User struct {
+//		Mail   string   `saml:"urn:oid:0.9.2342.19200300.100.1.3,friendly=mail"`
+//		Groups []string `saml:",friendly=groups"`
+//		Staff  bool     `saml:"urn:example:staff"`
+//	}
+//
+// An attribute matches a field if its Name is the name of the tag, or if its
+// FriendlyName is the friendly name of the tag. For the attributes known by
+// AttributeOID, one of the two names is enough. Fields can be strings, bools,
+// integers, floats, or slices of those; scalar fields receive the first value
+// of the attribute. Fields without a tag and missing attributes are left
+// untouched.
+func (a *Assertion) DecodeAttributes(v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return errors.Errorf("DecodeAttributes: expected a pointer to a struct, got %T", v)
+	}
+	rv = rv.Elem()
+	rt := rv.Type()
+
+	for i := 0; i < rt.NumField(); i++ {
+		field := rt.Field(i)
+		tag, ok := field.Tag.Lookup("saml")
+		if !ok || tag == "-" || field.PkgPath != "" {
+			continue
+		}
+		name, friendly := parseAttributeTag(tag)
+
+		attr := a.findAttribute(name, friendly)
+		if attr == nil {
+			continue
+		}
+		values := make([]string, len(attr.Values))
+		for j, value := range attr.Values {
+			values[j] = strings.TrimSpace(value.Value)
+		}
+		if err := setAttributeField(rv.Field(i), values); err != nil {
+			return errors.Wrapf(err, "attribute %s", field.Name)
+		}
+	}
+	return nil
+}
+
+func parseAttributeTag(tag string) (name, friendly string) {
+	parts := strings.Split(tag, ",")
+	name = parts[0]
+	for _, opt := range parts[1:] {
+		if strings.HasPrefix(opt, "friendly=") {
+			friendly = strings.TrimPrefix(opt, "friendly=")
+		}
+	}
+	return name, friendly
+}
+
+// findAttribute returns the attribute with the given name or friendly name.
+func (a *Assertion) findAttribute(name, friendly string) *Attribute {
+	if a.AttributeStatement == nil {
+		return nil
+	}
+	if friendly == "" {
+		friendly = AttributeFriendlyName(name)
+	}
+	if name == "" {
+		name = AttributeOID(friendly)
+	}
+	attrs := a.AttributeStatement.Attributes
+	for i := range attrs {
+		if name != "" && attrs[i].Name == name {
+			return &attrs[i]
+		}
+	}
+	for i := range attrs {
+		if friendly != "" && attrs[i].FriendlyName == friendly {
+			return &attrs[i]
+		}
+	}
+	return nil
+}
+
+func setAttributeField(field reflect.Value, values []string) error {
+	if field.Kind() == reflect.Slice {
+		slice := reflect.MakeSlice(field.Type(), len(values), len(values))
+		for i, value := range values {
+			if err := setAttributeValue(slice.Index(i), value); err != nil {
+				return err
+			}
+		}
+		field.Set(slice)
+		return nil
+	}
+	if len(values) == 0 {
+		return nil
+	}
+	return setAttributeValue(field, values[0])
+}
+
+func setAttributeValue(field reflect.Value, value string) error {
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		field.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(value, 10, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(value, 10, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(value, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetFloat(f)
+	default:
+		return errors.Errorf("unsupported type %s", field.Type())
+	}
+	return nil
+}
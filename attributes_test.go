@@ -0,0 +1,86 @@
+package saml
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testAttributeAssertion() *Assertion {
+	attr := func(name, friendly string, values ...string) Attribute {
+		a := Attribute{Name: name, FriendlyName: friendly}
+		for _, v := range values {
+			a.Values = append(a.Values, AttributeValue{Type: "xs:string", Value: v})
+		}
+		return a
+	}
+	return &Assertion{
+		AttributeStatement: &AttributeStatement{
+			Attributes: []Attribute{
+				attr("urn:oid:0.9.2342.19200300.100.1.3", "mail", "jane@example.org"),
+				attr("groups", "", "staff", "admins"),
+				attr("urn:example:staff", "", "true"),
+				attr("urn:example:age", "", " 42 "),
+				attr("urn:example:ids", "", "1", "2", "3"),
+			},
+		},
+	}
+}
+
+func TestDecodeAttributes(t *testing.T) {
+	var user struct {
+		Mail     string   `saml:"urn:oid:0.9.2342.19200300.100.1.3,friendly=mail"`
+		Email    string   `saml:",friendly=mail"`
+		Groups   []string `saml:"groups"`
+		Staff    bool     `saml:"urn:example:staff"`
+		Age      int      `saml:"urn:example:age"`
+		IDs      []uint16 `saml:"urn:example:ids"`
+		Missing  string   `saml:"urn:example:missing"`
+		Untagged string
+	}
+	user.Missing = "default"
+
+	err := testAttributeAssertion().DecodeAttributes(&user)
+	assert.NoError(t, err)
+	assert.Equal(t, "jane@example.org", user.Mail)
+	assert.Equal(t, "jane@example.org", user.Email)
+	assert.Equal(t, []string{"staff", "admins"}, user.Groups)
+	assert.True(t, user.Staff)
+	assert.Equal(t, 42, user.Age)
+	assert.Equal(t, []uint16{1, 2, 3}, user.IDs)
+	assert.Equal(t, "default", user.Missing)
+
+	var invalid struct {
+		Staff int `saml:"urn:example:staff"`
+	}
+	assert.Error(t, testAttributeAssertion().DecodeAttributes(&invalid))
+
+	assert.Error(t, testAttributeAssertion().DecodeAttributes(user))
+	assert.NoError(t, (&Assertion{}).DecodeAttributes(&user))
+}
+
+func TestAttributesMapAliases(t *testing.T) {
+	assertion := testAttributeAssertion()
+	assertion.AttributeStatement.Attributes = append(assertion.AttributeStatement.Attributes, Attribute{
+		FriendlyName: "eduPersonPrincipalName",
+		Values:       []AttributeValue{{Value: "jane@example.org"}},
+	})
+	attrs := NewAttributesMap(assertion)
+
+	assert.Equal(t, "jane@example.org", attrs.Get("mail"))
+	assert.Equal(t, "jane@example.org", attrs.Get("urn:oid:0.9.2342.19200300.100.1.3"))
+	assert.Equal(t, "jane@example.org", attrs.Get("urn:oid:1.3.6.1.4.1.5923.1.1.1.6"))
+	assert.Equal(t, []string{"staff", "admins"}, attrs.Values("groups"))
+	assert.Equal(t, "", attrs.Get("displayName"))
+
+	var user struct {
+		Mail string `saml:",friendly=mail"`
+		EPPN string `saml:"urn:oid:1.3.6.1.4.1.5923.1.1.1.6"`
+	}
+	assert.NoError(t, assertion.DecodeAttributes(&user))
+	assert.Equal(t, "jane@example.org", user.Mail)
+	assert.Equal(t, "jane@example.org", user.EPPN)
+
+	assert.Equal(t, "urn:oid:2.16.840.1.113730.3.1.241", AttributeOID("displayName"))
+	assert.Equal(t, "displayName", AttributeFriendlyName("urn:oid:2.16.840.1.113730.3.1.241"))
+}
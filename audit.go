@@ -0,0 +1,159 @@
+package saml
+
+import (
+	"bufio"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// AuditRecord describes a decision of the ACS about a SAML response.
+type AuditRecord struct {
+	Time         time.Time `json:"time"`
+	ResponseID   string    `json:"response_id,omitempty"`
+	AssertionID  string    `json:"assertion_id,omitempty"`
+	Issuer       string    `json:"issuer,omitempty"`
+	NameIDHash   string    `json:"name_id_hash,omitempty"`
+	SessionIndex string    `json:"session_index,omitempty"`
+	ClientIP     string    `json:"client_ip,omitempty"`
+	// Outcome is "success", or the FailureReason of the rejection.
+	Outcome string `json:"outcome"`
+	Error   string `json:"error,omitempty"`
+}
+
+// AuditSink receives a record for every SAML response received by
+// AssertionMiddleware, accepted or not, and for the requests rejected before
+// their response is validated, e.g. when rate limited.
+type AuditSink interface {
+	Audit(ctx context.Context, rec *AuditRecord) error
+}
+
+// HashNameID returns the hex-encoded HMAC-SHA-256 of a NameID with key,
+// which identifies the subject in audit records without disclosing it. The
+// key keeps the NameIDs, often email addresses, from being recovered by
+// hashing guesses.
+func HashNameID(key []byte, nameID string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(nameID))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// newAuditRecord builds the audit record of a response rejected with err,
+// or accepted if err is nil, after the validation described by result. The
+// NameID is hashed with key, and left out without key.
+func newAuditRecord(now time.Time, result *ValidationResult, err error, clientIP string, key []byte) *AuditRecord {
+	rec := &AuditRecord{
+		Time:     now,
+		ClientIP: clientIP,
+		Outcome:  FailureReason(err),
+	}
+	if err != nil {
+		rec.Error = err.Error()
+	}
+	if res := result.Response; res != nil {
+		rec.ResponseID = res.ID
+		if res.Issuer != nil {
+			rec.Issuer = res.Issuer.Value
+		}
+	}
+	if a := result.Assertion; a != nil {
+		rec.AssertionID = a.ID
+		if rec.Issuer == "" && a.Issuer != nil {
+			rec.Issuer = a.Issuer.Value
+		}
+		if a.Subject != nil && a.Subject.NameID != nil && len(key) > 0 {
+			rec.NameIDHash = HashNameID(key, a.Subject.NameID.Value)
+		}
+		if a.AuthnStatement != nil {
+			rec.SessionIndex = a.AuthnStatement.SessionIndex
+		}
+	}
+	return rec
+}
+
+// audit sends the record of the decision about a response to the AuditSink,
+// if any. result is empty for the requests rejected before the validation.
+func (sp *ServiceProvider) audit(r *http.Request, result *ValidationResult, err error, clientIP net.IP) {
+	if sp.AuditSink == nil {
+		return
+	}
+	if err := sp.AuditSink.Audit(r.Context(), newAuditRecord(sp.now(), result, err, remoteIP(r, clientIP), sp.AuditKey)); err != nil {
+		sp.logger().Error("failed to write audit record", "err", err)
+	}
+}
+
+// JSONAuditSink writes the audit records as JSON lines. Each line has a
+// "prev" field holding the SHA-256 of the previous line, so that removing or
+// altering a record breaks the chain; see VerifyAuditLog.
+type JSONAuditSink struct {
+	mu   sync.Mutex
+	w    io.Writer
+	prev string
+}
+
+// NewJSONAuditSink returns a JSONAuditSink writing to w. prev is the hash
+// of the last line already written to the log, or empty for a new log.
+func NewJSONAuditSink(w io.Writer, prev string) *JSONAuditSink {
+	return &JSONAuditSink{w: w, prev: prev}
+}
+
+type chainedAuditRecord struct {
+	*AuditRecord
+	Prev string `json:"prev"`
+}
+
+// Audit implements AuditSink.
+func (s *JSONAuditSink) Audit(ctx context.Context, rec *AuditRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	line, err := json.Marshal(chainedAuditRecord{rec, s.prev})
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+	if _, err := s.w.Write(line); err != nil {
+		return err
+	}
+	s.prev = hashLine(line)
+	return nil
+}
+
+func hashLine(line []byte) string {
+	sum := sha256.Sum256(line)
+	return hex.EncodeToString(sum[:])
+}
+
+// VerifyAuditLog checks the hash chain of a log written by JSONAuditSink
+// and returns the hash of its last line. The "prev" field of the first line
+// is not checked, so that rotated logs can be verified one at a time.
+func VerifyAuditLog(r io.Reader) (last string, err error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1<<20)
+	for n := 1; scanner.Scan(); n++ {
+		line := scanner.Bytes()
+		var rec struct {
+			Prev string `json:"prev"`
+		}
+		if err := json.Unmarshal(line, &rec); err != nil {
+			return "", errors.Wrapf(err, "line %d", n)
+		}
+		if n > 1 && rec.Prev != last {
+			return "", errors.Errorf("line %d: broken hash chain", n)
+		}
+		h := sha256.New()
+		h.Write(line)
+		h.Write([]byte{'\n'})
+		last = hex.EncodeToString(h.Sum(nil))
+	}
+	return last, scanner.Err()
+}
@@ -0,0 +1,143 @@
+package saml
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type recordingAuditSink []*AuditRecord
+
+func (s *recordingAuditSink) Audit(ctx context.Context, rec *AuditRecord) error {
+	*s = append(*s, rec)
+	return nil
+}
+
+func TestAuditRecord(t *testing.T) {
+	tearUp()
+
+	sp := newTestResponseSP()
+	sink := &recordingAuditSink{}
+	sp.AuditSink = sink
+
+	res := &Response{
+		ID:          "_r1",
+		Destination: "http://localhost:1235/saml/other",
+		Issuer:      &Issuer{Value: "http://localhost:1233/saml/service.xml"},
+	}
+	r := httptest.NewRequest("POST", sp.AcsURL, strings.NewReader(url.Values{
+		"SAMLResponse": {encodeTestResponse(t, res)},
+	}.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.RemoteAddr = "192.0.2.1:4242"
+
+	w := httptest.NewRecorder()
+	sp.AssertionMiddleware(http.NotFoundHandler()).ServeHTTP(w, r)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	if assert.Len(t, *sink, 1) {
+		rec := (*sink)[0]
+		assert.Equal(t, "_r1", rec.ResponseID)
+		assert.Equal(t, "http://localhost:1233/saml/service.xml", rec.Issuer)
+		assert.Equal(t, "192.0.2.1", rec.ClientIP)
+		assert.Equal(t, "wrong_destination", rec.Outcome)
+		assert.Contains(t, rec.Error, "wrong ACS destination")
+	}
+
+	result := &ValidationResult{
+		Assertion: &Assertion{
+			ID:             "_a1",
+			Issuer:         &Issuer{Value: "https://idp.example.com"},
+			Subject:        &Subject{NameID: &NameID{Value: "jdoe"}},
+			AuthnStatement: &AuthnStatement{SessionIndex: "_s1"},
+		},
+	}
+	key := []byte("0123456789abcdef")
+	rec := newAuditRecord(time.Now(), result, nil, "", key)
+	assert.Equal(t, "success", rec.Outcome)
+	assert.Equal(t, "_a1", rec.AssertionID)
+	assert.Equal(t, "https://idp.example.com", rec.Issuer)
+	assert.Equal(t, HashNameID(key, "jdoe"), rec.NameIDHash)
+	assert.NotEqual(t, HashNameID([]byte("other"), "jdoe"), rec.NameIDHash)
+	assert.NotContains(t, rec.NameIDHash, "jdoe")
+	assert.Equal(t, "_s1", rec.SessionIndex)
+
+	// Without key, the subject is not identified.
+	rec = newAuditRecord(time.Now(), result, nil, "", nil)
+	assert.Equal(t, "", rec.NameIDHash)
+}
+
+func TestAuditRejectedRequests(t *testing.T) {
+	tearUp()
+
+	sp := newTestResponseSP()
+	sink := &recordingAuditSink{}
+	sp.AuditSink = sink
+	sp.RelayStateKey = []byte("0123456789abcdef0123456789abcdef")
+	sp.RateLimit = &RateLimit{Global: NewTokenBucket(0, 3)}
+
+	post := func(form url.Values) int {
+		r := httptest.NewRequest("POST", sp.AcsURL, strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		r.RemoteAddr = "192.0.2.1:4242"
+		w := httptest.NewRecorder()
+		sp.AssertionMiddleware(http.NotFoundHandler()).ServeHTTP(w, r)
+		return w.Code
+	}
+	response := encodeTestResponse(t, &Response{ID: "_r1"})
+
+	// The requests rejected before the validation of the response are
+	// audited too.
+	assert.Equal(t, http.StatusBadRequest, post(url.Values{}))
+	assert.Equal(t, http.StatusBadRequest, post(url.Values{"SAMLResponse": {response}, "Signature": {"%"}}))
+	assert.Equal(t, http.StatusBadRequest, post(url.Values{"SAMLResponse": {response}, "RelayState": {"/unsigned"}}))
+	assert.Equal(t, http.StatusTooManyRequests, post(url.Values{"SAMLResponse": {response}}))
+
+	var outcomes []string
+	for _, rec := range *sink {
+		outcomes = append(outcomes, rec.Outcome)
+		assert.Equal(t, "192.0.2.1", rec.ClientIP)
+		assert.NotEmpty(t, rec.Error)
+	}
+	assert.Equal(t, []string{"malformed_response", "malformed_response", "invalid_relay_state", "rate_limited"}, outcomes)
+}
+
+func TestJSONAuditSink(t *testing.T) {
+	var buf bytes.Buffer
+	sink := NewJSONAuditSink(&buf, "")
+	for _, id := range []string{"_a1", "_a2", "_a3"} {
+		assert.NoError(t, sink.Audit(context.Background(), &AuditRecord{AssertionID: id, Outcome: "success"}))
+	}
+
+	last, err := VerifyAuditLog(bytes.NewReader(buf.Bytes()))
+	assert.NoError(t, err)
+	assert.Equal(t, sink.prev, last)
+
+	lines := strings.SplitAfter(buf.String(), "\n")
+	var rec map[string]interface{}
+	assert.NoError(t, json.Unmarshal([]byte(lines[1]), &rec))
+	assert.Equal(t, "_a2", rec["assertion_id"])
+
+	// Removing a record breaks the chain.
+	_, err = VerifyAuditLog(strings.NewReader(lines[0] + lines[2]))
+	assert.Error(t, err)
+
+	// So does altering one.
+	_, err = VerifyAuditLog(strings.NewReader(lines[0] + strings.Replace(lines[1], "_a2", "_a9", 1) + lines[2]))
+	assert.Error(t, err)
+
+	// A log can be continued.
+	var next bytes.Buffer
+	sink = NewJSONAuditSink(&next, last)
+	assert.NoError(t, sink.Audit(context.Background(), &AuditRecord{AssertionID: "_a4", Outcome: "success"}))
+	_, err = VerifyAuditLog(strings.NewReader(buf.String() + next.String()))
+	assert.NoError(t, err)
+}
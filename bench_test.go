@@ -0,0 +1,112 @@
+package saml
+
+import (
+	"bytes"
+	"encoding/base64"
+	"net/http/httptest"
+	"net/url"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/goware/saml/xmlsec"
+)
+
+// The benchmarks of the ACS path use the interop corpus. Without xmlsec1,
+// only the steps before the signature verification are measured.
+
+func BenchmarkValidateResponse(b *testing.B) {
+	samlResponse := base64.StdEncoding.EncodeToString(readInteropFile(b, "okta.xml"))
+	sp := newInteropSP(b, "http://www.okta.com/exk1a2b3c4d5e6f7g8h9")
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		sp.ValidateResponse(samlResponse, []string{"id-okta-request"}, interopNow)
+	}
+}
+
+func BenchmarkValidateSignedResponse(b *testing.B) {
+	if _, err := exec.LookPath("xmlsec1"); err != nil {
+		b.Skip("xmlsec1 not found")
+	}
+	buf, err := xmlsec.Sign(readInteropFile(b, "okta.xml"), filepath.Join("_testdata", "interop", "idp-key.pem"), &xmlsec.ValidationOptions{EnableIDAttrHack: true})
+	if err != nil {
+		b.Fatal(err)
+	}
+	samlResponse := base64.StdEncoding.EncodeToString(buf)
+	sp := newInteropSP(b, "http://www.okta.com/exk1a2b3c4d5e6f7g8h9")
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if result := sp.ValidateResponse(samlResponse, []string{"id-okta-request"}, interopNow); !result.Valid() {
+			b.Fatal(result)
+		}
+	}
+}
+
+func BenchmarkGetIdPCertFile(b *testing.B) {
+	sp := newInteropSP(b, "http://www.okta.com/exk1a2b3c4d5e6f7g8h9")
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := sp.GetIdPCertFile(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkCheckSignedStructure(b *testing.B) {
+	buf := readInteropFile(b, "okta.xml")
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if err := checkSignedStructure(buf, samlpNamespace, "Response"); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkParseACSForm compares the reading of a large response posted to
+// the ACS by Request.ParseForm and by readPostForm.
+func BenchmarkParseACSForm(b *testing.B) {
+	samlResponse := base64.StdEncoding.EncodeToString(bytes.Repeat(readInteropFile(b, "okta.xml"), 64))
+	body := "SAMLResponse=" + url.QueryEscape(samlResponse) + "&RelayState=%2Fhome"
+
+	b.Run("ParseForm", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			r := httptest.NewRequest("POST", "/saml/acs", strings.NewReader(body))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			if err := r.ParseForm(); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("readPostForm", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := readPostForm(strings.NewReader(body), "SAMLResponse", DefaultMaxMessageSize*4, int64(len(body))); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkAuthnRequestHandler(b *testing.B) {
+	tearUp()
+	sp := newTestResponseSP()
+	sp.IdPMetadata.IDPSSODescriptor = &IDPSSODescriptor{
+		SingleSignOnService: []Endpoint{{Binding: HTTPRedirectBinding, Location: "http://localhost:1233/saml/sso"}},
+	}
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		w := httptest.NewRecorder()
+		sp.AuthnRequestHandler(w, httptest.NewRequest("GET", "/saml/login", nil))
+		if w.Code != 302 {
+			b.Fatal(w.Code, w.Body.String())
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package saml
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"io/ioutil"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultCertificateExpiryWarning is the default of
+// ServiceProvider.CertificateExpiryWarning.
+const DefaultCertificateExpiryWarning = 30 * 24 * time.Hour
+
+// CertificateExpiry describes a certificate of the SP or of one of its IdPs
+// that expires soon, or has expired.
+type CertificateExpiry struct {
+	// EntityID is the entity ID of the SP or IdP using the certificate.
+	EntityID string
+
+	// Use is the use of the certificate in the metadata: "signing",
+	// "encryption" or empty for both.
+	Use string
+
+	Subject  string
+	NotAfter time.Time
+
+	// Fingerprint is the SHA-256 fingerprint of the certificate, in
+	// hexadecimal, which tells apart the certificates of a rotation.
+	Fingerprint string
+}
+
+// Expired returns whether the certificate expired at now.
+func (e CertificateExpiry) Expired(now time.Time) bool {
+	return now.After(e.NotAfter)
+}
+
+// CertificateExpiryCollector is implemented by the MetricsCollectors that
+// also track the expiry dates of the certificates. CertificatesChecked is
+// called by CheckCertificateExpiry with all the certificates of the SP and of
+// its IdPs, expiring soon or not: the certificates of a previous check that
+// are not listed were rotated out, or their IdP was removed.
+type CertificateExpiryCollector interface {
+	CertificatesChecked(certs []CertificateExpiry)
+}
+
+func (sp *ServiceProvider) certificateExpiryWarning() time.Duration {
+	if sp.CertificateExpiryWarning == 0 {
+		return DefaultCertificateExpiryWarning
+	}
+	return sp.CertificateExpiryWarning
+}
+
+// CheckCertificateExpiry looks up the certificates of the SP and of its IdPs
+// expiring within CertificateExpiryWarning, or expired, so that they are
+// rotated before the logins fail. They are logged, passed to
+// OnCertificateExpiry and returned. The IdP metadata is downloaded if
+// needed. RefreshIdPMetadataEvery calls it after every refresh.
+func (sp *ServiceProvider) CheckCertificateExpiry(ctx context.Context) ([]CertificateExpiry, error) {
+	type certificate struct {
+		entityID, use string
+		cert          *x509.Certificate
+	}
+	var certs []certificate
+
+	if sp.CertFile != "" || sp.PubkeyPEM != "" {
+		cert, err := sp.certificate()
+		if err != nil {
+			return nil, errors.Wrap(err, "SP certificate")
+		}
+		certs = append(certs, certificate{entityID: sp.entityID(), cert: cert})
+	}
+
+	var idps []*Metadata
+	if sp.IdPs != nil {
+		idps = sp.IdPs.List()
+	} else {
+		meta, err := sp.GetIdPMetadataContext(ctx)
+		if err != nil {
+			return nil, errors.Wrap(err, "IdP metadata")
+		}
+		idps = []*Metadata{meta}
+	}
+	for _, meta := range idps {
+		if meta.IDPSSODescriptor == nil {
+			continue
+		}
+		for _, keyDescriptor := range meta.IDPSSODescriptor.KeyDescriptor {
+			if keyDescriptor.KeyInfo.Certificate == "" {
+				continue
+			}
+			cert, err := parseKeyInfoCertificate(keyDescriptor.KeyInfo.Certificate)
+			if err != nil {
+				return nil, errors.Wrapf(err, "IdP %s", meta.EntityID)
+			}
+			certs = append(certs, certificate{entityID: meta.EntityID, use: keyDescriptor.Use, cert: cert})
+		}
+	}
+
+	collector, _ := sp.metrics().(CertificateExpiryCollector)
+	now := sp.now()
+	deadline := now.Add(sp.certificateExpiryWarning())
+	var checked, expiring []CertificateExpiry
+	for _, c := range certs {
+		fingerprint := sha256.Sum256(c.cert.Raw)
+		expiry := CertificateExpiry{
+			EntityID:    c.entityID,
+			Use:         c.use,
+			Subject:     c.cert.Subject.String(),
+			NotAfter:    c.cert.NotAfter,
+			Fingerprint: hex.EncodeToString(fingerprint[:]),
+		}
+		checked = append(checked, expiry)
+		if c.cert.NotAfter.After(deadline) {
+			continue
+		}
+		keyvals := []interface{}{"entity_id", expiry.EntityID, "use", expiry.Use, "subject", expiry.Subject, "not_after", expiry.NotAfter}
+		if expiry.Expired(now) {
+			sp.logger().Error("certificate expired", keyvals...)
+		} else {
+			sp.logger().Info("certificate expires soon", keyvals...)
+		}
+		if sp.OnCertificateExpiry != nil {
+			sp.OnCertificateExpiry(expiry)
+		}
+		expiring = append(expiring, expiry)
+	}
+	if collector != nil {
+		collector.CertificatesChecked(checked)
+	}
+	return expiring, nil
+}
+
+// certificate returns the certificate of the SP. Unlike Cert, it doesn't
+// check it against KeyPolicy, which may reject the expired certificates.
+func (sp *ServiceProvider) certificate() (*x509.Certificate, error) {
+	data := []byte(sp.PubkeyPEM)
+	if sp.CertFile != "" {
+		var err error
+		if data, err = ioutil.ReadFile(sp.CertFile); err != nil {
+			return nil, errors.Wrap(err, "failed to read certificate")
+		}
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("failed to decode certificate")
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
@@ -0,0 +1,80 @@
+package saml
+
+import (
+	"context"
+	"encoding/base64"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type expiryMetrics struct {
+	nopMetrics
+	checked map[string]time.Time
+}
+
+func (m *expiryMetrics) CertificatesChecked(certs []CertificateExpiry) {
+	m.checked = map[string]time.Time{}
+	for _, cert := range certs {
+		m.checked[cert.EntityID+" "+cert.Use] = cert.NotAfter
+	}
+}
+
+func TestCheckCertificateExpiry(t *testing.T) {
+	tearUp()
+
+	_, spCert, _ := newTestKeyPair(t, KeyPairOptions{Type: KeyTypeECDSA, CommonName: "sp", Validity: 10 * 24 * time.Hour})
+	_, _, signing := newTestKeyPair(t, KeyPairOptions{Type: KeyTypeECDSA, CommonName: "idp"})
+	Now = func() time.Time { return time.Now().Add(-48 * time.Hour) }
+	_, _, expired := newTestKeyPair(t, KeyPairOptions{Type: KeyTypeECDSA, CommonName: "old", Validity: time.Hour})
+	tearUp()
+
+	p := &testPrinter{}
+	metrics := &expiryMetrics{checked: map[string]time.Time{}}
+	var reported []CertificateExpiry
+	sp := &ServiceProvider{
+		MetadataURL: "https://sp.example.com/saml/metadata",
+		PubkeyPEM:   spCert,
+		IdPMetadata: &Metadata{
+			EntityID: "https://idp.example.com",
+			IDPSSODescriptor: &IDPSSODescriptor{
+				KeyDescriptor: []KeyDescriptor{
+					{Use: "signing", KeyInfo: KeyInfo{Certificate: base64.StdEncoding.EncodeToString(signing.Raw)}},
+					{Use: "encryption", KeyInfo: KeyInfo{Certificate: base64.StdEncoding.EncodeToString(expired.Raw)}},
+				},
+			},
+		},
+		Logger:  NewLogrusLogger(p),
+		Metrics: metrics,
+		OnCertificateExpiry: func(expiry CertificateExpiry) {
+			reported = append(reported, expiry)
+		},
+	}
+
+	expiring, err := sp.CheckCertificateExpiry(context.Background())
+	assert.NoError(t, err)
+	if assert.Len(t, expiring, 2) {
+		assert.Equal(t, sp.MetadataURL, expiring[0].EntityID)
+		assert.Equal(t, "CN=sp", expiring[0].Subject)
+		assert.False(t, expiring[0].Expired(Now()))
+		assert.Equal(t, "https://idp.example.com", expiring[1].EntityID)
+		assert.Equal(t, "encryption", expiring[1].Use)
+		assert.True(t, expiring[1].Expired(Now()))
+		assert.Len(t, expiring[1].Fingerprint, 64)
+	}
+	assert.Equal(t, expiring, reported)
+	assert.Len(t, metrics.checked, 3)
+	assert.Equal(t, signing.NotAfter, metrics.checked["https://idp.example.com signing"])
+	if assert.Len(t, p.lines, 2) {
+		assert.True(t, strings.HasPrefix(p.lines[0], "info certificate expires soon"), p.lines[0])
+		assert.True(t, strings.HasPrefix(p.lines[1], "error certificate expired"), p.lines[1])
+	}
+
+	// The SP certificate is no longer reported within a shorter window.
+	sp.CertificateExpiryWarning = 24 * time.Hour
+	expiring, err = sp.CheckCertificateExpiry(context.Background())
+	assert.NoError(t, err)
+	assert.Len(t, expiring, 1)
+}
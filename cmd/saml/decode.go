@@ -0,0 +1,223 @@
+package main
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"strings"
+
+	"github.com/goware/saml"
+	"github.com/pkg/errors"
+)
+
+var decodeCmd = &command{
+	name:  "decode",
+	usage: "decode and pretty-print a SAMLRequest or SAMLResponse",
+	run:   runDecode,
+}
+
+// messageParams are the parameters of the HTTP-Redirect and HTTP-POST
+// bindings carrying a SAML message.
+var messageParams = []string{"SAMLRequest", "SAMLResponse"}
+
+func runDecode(args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	fs := newFlagSet("decode", "<URL|file|->", stderr)
+	raw := fs.Bool("raw", false, "print the XML as is, without indentation")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() > 1 {
+		fs.Usage()
+		return errUsage
+	}
+
+	var input []byte
+	if arg := fs.Arg(0); isURL(arg) {
+		u, err := url.Parse(arg)
+		if err != nil {
+			return err
+		}
+		input = []byte(u.RawQuery)
+	} else {
+		var err error
+		if input, err = readInput(arg, stdin); err != nil {
+			return err
+		}
+	}
+
+	message, params, err := decodeMessage(input)
+	if err != nil {
+		return err
+	}
+	// The other parameters of the binding go to stderr to keep the output
+	// a well-formed XML document.
+	for _, name := range []string{"RelayState", "SigAlg", "Signature"} {
+		if v := params.Get(name); v != "" {
+			fmt.Fprintf(stderr, "%s: %s\n", name, v)
+		}
+	}
+
+	if !*raw {
+		if message, err = indentXML(message); err != nil {
+			return err
+		}
+	}
+	_, err = stdout.Write(message)
+	return err
+}
+
+// decodeMessage decodes a SAML message given as a query string or form
+// body, as a base64 string, deflated or not, or as XML. The parameters of
+// the query string or form, if any, are returned as well.
+func decodeMessage(input []byte) ([]byte, url.Values, error) {
+	input = bytes.TrimSpace(input)
+	if bytes.HasPrefix(input, []byte("<")) {
+		return input, nil, nil
+	}
+
+	encoded := string(input)
+	params := url.Values{}
+	if hasMessageParam(encoded) {
+		var err error
+		if params, err = url.ParseQuery(encoded); err != nil {
+			return nil, nil, errors.Wrap(err, "invalid query string")
+		}
+		encoded = ""
+		for _, name := range messageParams {
+			if v := params.Get(name); v != "" {
+				encoded = v
+				break
+			}
+		}
+		if encoded == "" {
+			return nil, nil, errors.New("no SAMLRequest or SAMLResponse parameter found")
+		}
+	} else if strings.Contains(encoded, "%") {
+		// URL-encoded parameter value.
+		if v, err := url.QueryUnescape(encoded); err == nil {
+			encoded = v
+		}
+	}
+
+	// Line breaks are common in copy-pasted messages.
+	encoded = strings.Join(strings.Fields(encoded), "")
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to base64-decode the message")
+	}
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("<")) {
+		// HTTP-POST binding.
+		return data, params, nil
+	}
+
+	// HTTP-Redirect binding.
+	r := flate.NewReader(bytes.NewReader(data))
+	defer r.Close()
+	buf, err := ioutil.ReadAll(io.LimitReader(r, saml.DefaultMaxXMLSize+1))
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to inflate the message")
+	}
+	if len(buf) > saml.DefaultMaxXMLSize {
+		return nil, nil, errors.Errorf("inflated message is larger than %d bytes", saml.DefaultMaxXMLSize)
+	}
+	return buf, params, nil
+}
+
+func hasMessageParam(s string) bool {
+	for _, name := range messageParams {
+		if strings.HasPrefix(s, name+"=") || strings.Contains(s, "&"+name+"=") {
+			return true
+		}
+	}
+	return false
+}
+
+// indentXML re-indents an XML document. Unlike xml.Encoder, it preserves
+// the namespace prefixes of the document.
+func indentXML(data []byte) ([]byte, error) {
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	var out bytes.Buffer
+	depth := 0
+	// last is the kind of the previous token written: 's' for a start
+	// element, 't' for text, 'e' for an end element or anything else.
+	var last byte
+
+	newline := func() {
+		if out.Len() > 0 {
+			out.WriteByte('\n')
+		}
+		out.WriteString(strings.Repeat("  ", depth))
+	}
+
+	for {
+		tok, err := dec.RawToken()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid XML")
+		}
+
+		switch tok := tok.(type) {
+		case xml.StartElement:
+			if last == 's' {
+				out.WriteByte('>')
+			}
+			newline()
+			out.WriteString("<" + qualifiedName(tok.Name))
+			for _, attr := range tok.Attr {
+				out.WriteString(" " + qualifiedName(attr.Name) + `="`)
+				xml.EscapeText(&out, []byte(attr.Value))
+				out.WriteByte('"')
+			}
+			depth++
+			last = 's'
+		case xml.EndElement:
+			depth--
+			switch last {
+			case 's':
+				out.WriteString("/>")
+			case 't':
+				out.WriteString("</" + qualifiedName(tok.Name) + ">")
+			default:
+				newline()
+				out.WriteString("</" + qualifiedName(tok.Name) + ">")
+			}
+			last = 'e'
+		case xml.CharData:
+			if len(bytes.TrimSpace(tok)) == 0 {
+				continue
+			}
+			if last == 's' {
+				out.WriteByte('>')
+			}
+			xml.EscapeText(&out, tok)
+			last = 't'
+		case xml.Comment:
+			if last == 's' {
+				out.WriteByte('>')
+			}
+			newline()
+			out.WriteString("<!--" + string(tok) + "-->")
+			last = 'e'
+		case xml.ProcInst:
+			newline()
+			out.WriteString("<?" + tok.Target + " " + string(tok.Inst) + "?>")
+			last = 'e'
+		}
+	}
+	out.WriteByte('\n')
+	return out.Bytes(), nil
+}
+
+func qualifiedName(name xml.Name) string {
+	if name.Space == "" {
+		return name.Local
+	}
+	return name.Space + ":" + name.Local
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/base64"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testRequest = `<samlp:AuthnRequest xmlns:samlp="urn:oasis:names:tc:SAML:2.0:protocol" xmlns:saml="urn:oasis:names:tc:SAML:2.0:assertion" ID="id-1" Version="2.0"><saml:Issuer>https://sp.example.com/saml/metadata</saml:Issuer><samlp:NameIDPolicy AllowCreate="true"/></samlp:AuthnRequest>`
+
+const testRequestIndented = `<samlp:AuthnRequest xmlns:samlp="urn:oasis:names:tc:SAML:2.0:protocol" xmlns:saml="urn:oasis:names:tc:SAML:2.0:assertion" ID="id-1" Version="2.0">
+  <saml:Issuer>https://sp.example.com/saml/metadata</saml:Issuer>
+  <samlp:NameIDPolicy AllowCreate="true"/>
+</samlp:AuthnRequest>
+`
+
+func deflate(t *testing.T, s string) string {
+	var buf bytes.Buffer
+	w, err := flate.NewWriter(&buf, flate.BestCompression)
+	assert.NoError(t, err)
+	w.Write([]byte(s))
+	w.Close()
+	return base64.StdEncoding.EncodeToString(buf.Bytes())
+}
+
+func TestDecode(t *testing.T) {
+	redirect := deflate(t, testRequest)
+	post := base64.StdEncoding.EncodeToString([]byte(testRequest))
+
+	for name, input := range map[string]string{
+		"xml":             testRequest,
+		"redirect":        redirect,
+		"redirect value":  url.QueryEscape(redirect),
+		"redirect query":  "SAMLRequest=" + url.QueryEscape(redirect) + "&RelayState=abc",
+		"post":            post,
+		"post form":       "RelayState=abc&SAMLRequest=" + url.QueryEscape(post),
+		"post multi-line": post[:20] + "\n" + post[20:] + "\n",
+	} {
+		var stdout, stderr bytes.Buffer
+		code := run([]string{"decode"}, strings.NewReader(input), &stdout, &stderr)
+		assert.Equal(t, 0, code, "%s: %s", name, stderr.String())
+		assert.Equal(t, testRequestIndented, stdout.String(), name)
+		if strings.Contains(input, "RelayState") {
+			assert.Equal(t, "RelayState: abc\n", stderr.String(), name)
+		}
+	}
+
+	var stdout, stderr bytes.Buffer
+	code := run([]string{"decode", "-raw", "https://idp.example.com/sso?SAMLRequest=" + url.QueryEscape(redirect) + "&SigAlg=rsa"}, nil, &stdout, &stderr)
+	assert.Equal(t, 0, code)
+	assert.Equal(t, testRequest, stdout.String())
+	assert.Equal(t, "SigAlg: rsa\n", stderr.String())
+
+	stderr.Reset()
+	code = run([]string{"decode"}, strings.NewReader("RelayState=abc&foo=bar&SAMLRequest="), &stdout, &stderr)
+	assert.Equal(t, 1, code)
+	assert.Contains(t, stderr.String(), "no SAMLRequest or SAMLResponse")
+
+	stderr.Reset()
+	code = run([]string{"decode"}, strings.NewReader("%%%"), &stdout, &stderr)
+	assert.Equal(t, 1, code)
+}
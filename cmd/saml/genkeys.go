@@ -0,0 +1,56 @@
+package main
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/goware/saml"
+	"github.com/pkg/errors"
+)
+
+var genKeysCmd = &command{
+	name:  "gen-keys",
+	usage: "generate an SP key and self-signed certificate",
+	run:   runGenKeys,
+}
+
+func runGenKeys(args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	fs := newFlagSet("gen-keys", "", stderr)
+	keyType := fs.String("type", string(saml.KeyTypeRSA), "key type: rsa or ecdsa")
+	bits := fs.Int("bits", saml.DefaultRSAKeySize, "size of RSA keys")
+	commonName := fs.String("cn", "", "common name of the certificate, e.g. the SP host name")
+	validity := fs.Duration("validity", saml.DefaultKeyValidity, "validity of the certificate")
+	keyFile := fs.String("key", "sp.key", "private key output file")
+	certFile := fs.String("cert", "sp.crt", "certificate output file")
+	force := fs.Bool("f", false, "overwrite existing files")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		fs.Usage()
+		return errUsage
+	}
+
+	if !*force {
+		for _, name := range []string{*keyFile, *certFile} {
+			if _, err := os.Stat(name); err == nil {
+				return errors.Errorf("%s already exists, use -f to overwrite it", name)
+			}
+		}
+	}
+
+	keyPEM, certPEM, err := saml.GenerateKeyPair(saml.KeyPairOptions{
+		Type:       saml.KeyType(*keyType),
+		Bits:       *bits,
+		CommonName: *commonName,
+		Validity:   *validity,
+	})
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(*keyFile, []byte(keyPEM), 0600); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(*certFile, []byte(certPEM), 0644)
+}
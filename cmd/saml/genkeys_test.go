@@ -0,0 +1,34 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/goware/saml"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGenKeys(t *testing.T) {
+	dir := t.TempDir()
+	keyFile := filepath.Join(dir, "sp.key")
+	certFile := filepath.Join(dir, "sp.crt")
+	args := []string{"gen-keys", "-type", "ecdsa", "-cn", "sp.example.com", "-key", keyFile, "-cert", certFile}
+
+	var stdout, stderr bytes.Buffer
+	assert.Equal(t, 0, run(args, nil, &stdout, &stderr), stderr.String())
+
+	info, err := os.Stat(keyFile)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	sp := &saml.ServiceProvider{KeyFile: keyFile, CertFile: certFile}
+	_, err = sp.Cert()
+	assert.NoError(t, err)
+
+	// Existing files are kept.
+	assert.Equal(t, 1, run(args, nil, &stdout, &stderr))
+	assert.Contains(t, stderr.String(), "already exists")
+	assert.Equal(t, 0, run(append(args, "-f"), nil, &stdout, &stderr))
+}
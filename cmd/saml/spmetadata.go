@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/xml"
+	"io"
+	"strings"
+
+	"github.com/goware/saml"
+)
+
+var spMetadataCmd = &command{
+	name:  "sp-metadata",
+	usage: "generate the metadata of an SP to register it with an IdP",
+	run:   runSPMetadata,
+}
+
+func runSPMetadata(args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	fs := newFlagSet("sp-metadata", "", stderr)
+	entityID := fs.String("entity-id", "", "entity ID of the SP (required)")
+	certFile := fs.String("cert", "", "SP certificate PEM file (required)")
+	var acsURLs, sloURLs, nameIDFormats stringList
+	fs.Var(&acsURLs, "acs-url", "ACS URL, with the HTTP-POST binding (required, repeatable)")
+	fs.Var(&sloURLs, "slo-url", "single logout URL, with the HTTP-Redirect binding (repeatable)")
+	fs.Var(&nameIDFormats, "nameid-format", "supported NameID format, by order of preference (repeatable, default transient)")
+	signingPolicy := fs.String("signing-policy", "either", "signatures required: either, response, assertion or both")
+	validFor := fs.Duration("valid-for", 0, "validity of the metadata (default 48h, negative to omit validUntil)")
+	cacheDuration := fs.Duration("cache-duration", 0, "cacheDuration of the metadata")
+	orgName := fs.String("org-name", "", "name of the organization")
+	orgURL := fs.String("org-url", "", "URL of the organization")
+	var technical, support stringList
+	fs.Var(&technical, "technical-contact", "email address of a technical contact (repeatable)")
+	fs.Var(&support, "support-contact", "email address of a support contact (repeatable)")
+	output := fs.String("o", "-", "output file")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *entityID == "" || *certFile == "" || len(acsURLs) == 0 || fs.NArg() > 0 {
+		fs.Usage()
+		return errUsage
+	}
+
+	policy, err := parseSigningPolicy(*signingPolicy)
+	if err != nil {
+		return err
+	}
+
+	sp := &saml.ServiceProvider{
+		EntityID:      *entityID,
+		AcsURL:        acsURLs[0],
+		CertFile:      *certFile,
+		NameIDFormats: nameIDFormats,
+
+		MetadataValidDuration: *validFor,
+		MetadataCacheDuration: *cacheDuration,
+		SigningPolicy:         policy,
+	}
+	if len(acsURLs) > 1 {
+		for i, location := range acsURLs {
+			sp.AssertionConsumerServices = append(sp.AssertionConsumerServices, saml.IndexedEndpoint{
+				Binding:   saml.HTTPPostBinding,
+				Location:  location,
+				Index:     i + 1,
+				IsDefault: i == 0,
+			})
+		}
+	}
+
+	if *orgName != "" {
+		name := []saml.LocalizedName{{Lang: "en", Value: *orgName}}
+		sp.Organization = &saml.Organization{
+			Names:        name,
+			DisplayNames: name,
+			URLs:         []saml.LocalizedName{{Lang: "en", Value: *orgURL}},
+		}
+	}
+	for _, contact := range []struct {
+		contactType string
+		emails      stringList
+	}{{saml.ContactTypeTechnical, technical}, {saml.ContactTypeSupport, support}} {
+		for _, email := range contact.emails {
+			if !strings.HasPrefix(email, "mailto:") {
+				email = "mailto:" + email
+			}
+			sp.ContactPersons = append(sp.ContactPersons, saml.ContactPerson{
+				ContactType:    contact.contactType,
+				EmailAddresses: []string{email},
+			})
+		}
+	}
+
+	for _, location := range sloURLs {
+		sp.SingleLogoutServices = append(sp.SingleLogoutServices, saml.Endpoint{
+			Binding:  saml.HTTPRedirectBinding,
+			Location: location,
+		})
+	}
+
+	metadata, err := sp.Metadata()
+	if err != nil {
+		return err
+	}
+
+	out, err := xml.MarshalIndent(metadata, "", "\t")
+	if err != nil {
+		return err
+	}
+	out = append([]byte(xml.Header), out...)
+	return writeOutput(*output, append(out, '\n'), stdout)
+}
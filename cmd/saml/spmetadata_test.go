@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/goware/saml"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSPMetadata(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	code := run([]string{
+		"sp-metadata",
+		"-entity-id", "https://sp.example.com/saml/metadata",
+		"-cert", filepath.Join(interopDir, "idp-cert.pem"),
+		"-acs-url", "https://sp.example.com/saml/acs",
+		"-acs-url", "https://sp2.example.com/saml/acs",
+		"-slo-url", "https://sp.example.com/saml/slo",
+		"-nameid-format", saml.NameIDFormatPersistent,
+		"-cache-duration", "6h",
+		"-signing-policy", "assertion",
+		"-org-name", "Example",
+		"-org-url", "https://www.example.com",
+		"-technical-contact", "sso@example.com",
+	}, nil, &stdout, &stderr)
+	assert.Equal(t, 0, code, stderr.String())
+	assert.Contains(t, stdout.String(), xml.Header)
+
+	var metadata saml.Metadata
+	assert.NoError(t, xml.Unmarshal(stdout.Bytes(), &metadata))
+	assert.Equal(t, "https://sp.example.com/saml/metadata", metadata.EntityID)
+
+	assert.Equal(t, 6*time.Hour, metadata.CacheDuration.Duration())
+	assert.Equal(t, "Example", metadata.Organization.Names[0].Value)
+	assert.Equal(t, []saml.ContactPerson{
+		{ContactType: saml.ContactTypeTechnical, EmailAddresses: []string{"mailto:sso@example.com"}},
+	}, metadata.ContactPersons)
+
+	sp := metadata.SPSSODescriptor
+	if assert.NotNil(t, sp) {
+		assert.True(t, sp.WantAssertionsSigned)
+		assert.Equal(t, []saml.IndexedEndpoint{
+			{Binding: saml.HTTPPostBinding, Location: "https://sp.example.com/saml/acs", Index: 1, IsDefault: true},
+			{Binding: saml.HTTPPostBinding, Location: "https://sp2.example.com/saml/acs", Index: 2},
+		}, sp.AssertionConsumerService)
+		assert.Equal(t, []saml.Endpoint{
+			{Binding: saml.HTTPRedirectBinding, Location: "https://sp.example.com/saml/slo"},
+		}, sp.SingleLogoutService)
+		assert.Equal(t, []string{saml.NameIDFormatPersistent}, sp.NameIDFormat)
+		assert.NotEmpty(t, sp.KeyDescriptor[0].KeyInfo.Certificate)
+	}
+
+	output := filepath.Join(t.TempDir(), "metadata.xml")
+	assert.Equal(t, 0, run([]string{
+		"sp-metadata",
+		"-entity-id", "https://sp.example.com/saml/metadata",
+		"-cert", filepath.Join(interopDir, "idp-cert.pem"),
+		"-acs-url", "https://sp.example.com/saml/acs",
+		"-o", output,
+	}, nil, &stdout, &stderr))
+	assert.FileExists(t, output)
+
+	assert.Equal(t, 2, run([]string{"sp-metadata", "-entity-id", "x"}, nil, &stdout, &stderr))
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/goware/saml"
+	"github.com/pkg/errors"
+)
+
+var validateCmd = &command{
+	name:  "validate",
+	usage: "run the validation of a SAMLResponse and report every check",
+	run:   runValidate,
+}
+
+func runValidate(args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	fs := newFlagSet("validate", "<response file|->", stderr)
+	idpMetadata := fs.String("idp-metadata", "", "IdP metadata file, URL or - for stdin (required)")
+	acsURL := fs.String("acs-url", "", "ACS URL of the SP (required)")
+	entityID := fs.String("sp-entity-id", "", "entity ID of the SP")
+	keyFile := fs.String("key", "", "SP private key PEM file, to decrypt the assertion")
+	certFile := fs.String("cert", "", "SP certificate PEM file")
+	requestIDs := fs.String("request-id", "", "comma-separated IDs of the AuthnRequests the response may answer")
+	signingPolicy := fs.String("signing-policy", "either", "signatures required: either, response, assertion or both")
+	at := fs.String("now", "", "validate at this RFC 3339 time instead of the current time")
+	schema := fs.String("schema", "", "XML schema to validate the response against with xmllint, e.g. saml-schema-protocol-2.0.xsd")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *idpMetadata == "" || *acsURL == "" || fs.NArg() > 1 {
+		fs.Usage()
+		return errUsage
+	}
+	if *idpMetadata == "-" && (fs.Arg(0) == "" || fs.Arg(0) == "-") {
+		return errors.New("the response and the IdP metadata cannot both be read from stdin")
+	}
+
+	sp := &saml.ServiceProvider{
+		AcsURL:     *acsURL,
+		EntityID:   *entityID,
+		KeyFile:    *keyFile,
+		CertFile:   *certFile,
+		SchemaFile: *schema,
+	}
+	if isURL(*idpMetadata) {
+		sp.IdPMetadataURL = *idpMetadata
+	} else {
+		buf, err := readInput(*idpMetadata, stdin)
+		if err != nil {
+			return err
+		}
+		sp.IdPMetadataXML = buf
+	}
+
+	policy, err := parseSigningPolicy(*signingPolicy)
+	if err != nil {
+		return err
+	}
+	sp.SigningPolicy = policy
+
+	now := time.Now()
+	if *at != "" {
+		if now, err = time.Parse(time.RFC3339, *at); err != nil {
+			return errors.Wrap(err, "invalid -now")
+		}
+	}
+
+	buf, err := readInput(fs.Arg(0), stdin)
+	if err != nil {
+		return err
+	}
+
+	var ids []string
+	if *requestIDs != "" {
+		ids = strings.Split(*requestIDs, ",")
+	}
+
+	result := sp.ValidateResponse(encodeResponse(buf), ids, now)
+	io.WriteString(stdout, result.String())
+	if result.Assertion != nil && result.Valid() {
+		printAssertion(stdout, result.Assertion)
+	}
+	if !result.Valid() {
+		return errors.New("invalid response")
+	}
+	return nil
+}
+
+// encodeResponse returns the base64 encoding of a response given either as
+// XML or already base64-encoded.
+func encodeResponse(buf []byte) string {
+	buf = bytes.TrimSpace(buf)
+	if bytes.HasPrefix(buf, []byte("<")) {
+		return base64.StdEncoding.EncodeToString(buf)
+	}
+	// Line breaks are common in copy-pasted messages.
+	return strings.Join(strings.Fields(string(buf)), "")
+}
+
+func parseSigningPolicy(s string) (saml.SigningPolicy, error) {
+	for _, p := range []saml.SigningPolicy{saml.SigningPolicyEither, saml.SigningPolicyResponse, saml.SigningPolicyAssertion, saml.SigningPolicyBoth} {
+		if p.String() == s {
+			return p, nil
+		}
+	}
+	return 0, errors.Errorf("unknown signing policy %q", s)
+}
+
+// printAssertion prints the subject and the attributes of a valid
+// assertion.
+func printAssertion(w io.Writer, assertion *saml.Assertion) {
+	fmt.Fprintln(w)
+	if assertion.Subject != nil && assertion.Subject.NameID != nil {
+		fmt.Fprintf(w, "NameID: %s (%s)\n", assertion.Subject.NameID.Value, assertion.Subject.NameID.Format)
+	}
+	if assertion.AttributeStatement == nil {
+		return
+	}
+	for _, attr := range assertion.AttributeStatement.Attributes {
+		name := attr.Name
+		if attr.FriendlyName != "" {
+			name = fmt.Sprintf("%s (%s)", attr.FriendlyName, attr.Name)
+		}
+		var values []string
+		for _, value := range attr.Values {
+			values = append(values, value.Value)
+		}
+		fmt.Fprintf(w, "%s: %s\n", name, strings.Join(values, ", "))
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/pem"
+	"encoding/xml"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/goware/saml"
+	"github.com/stretchr/testify/assert"
+)
+
+const interopDir = "../../_testdata/interop"
+
+// writeIdPMetadata writes the metadata of the IdP of the interop corpus
+// and returns its file name.
+func writeIdPMetadata(t *testing.T, entityID string) string {
+	buf, err := ioutil.ReadFile(filepath.Join(interopDir, "idp-cert.pem"))
+	assert.NoError(t, err)
+	block, _ := pem.Decode(buf)
+
+	metadata := saml.Metadata{
+		EntityID: entityID,
+		IDPSSODescriptor: &saml.IDPSSODescriptor{
+			KeyDescriptor: []saml.KeyDescriptor{{
+				Use:     "signing",
+				KeyInfo: saml.KeyInfo{Certificate: base64.StdEncoding.EncodeToString(block.Bytes)},
+			}},
+		},
+	}
+	buf, err = xml.Marshal(metadata)
+	assert.NoError(t, err)
+
+	name := filepath.Join(t.TempDir(), "metadata.xml")
+	assert.NoError(t, ioutil.WriteFile(name, buf, 0600))
+	return name
+}
+
+func TestValidate(t *testing.T) {
+	metadata := writeIdPMetadata(t, "http://www.okta.com/exk1a2b3c4d5e6f7g8h9")
+	response, err := ioutil.ReadFile(filepath.Join(interopDir, "okta.xml"))
+	assert.NoError(t, err)
+
+	for _, input := range []string{string(response), base64.StdEncoding.EncodeToString(response)} {
+		var stdout, stderr bytes.Buffer
+		code := run([]string{
+			"validate",
+			"-idp-metadata", metadata,
+			"-acs-url", "https://sp.example.com/saml/acs",
+			"-request-id", "id-other,id-okta-request",
+			"-now", "2024-05-14T09:31:00Z",
+		}, strings.NewReader(input), &stdout, &stderr)
+
+		// The corpus only has signature templates.
+		assert.Equal(t, 1, code)
+		assert.Contains(t, stdout.String(), "PASS destination\n")
+		assert.Contains(t, stdout.String(), "PASS in-response-to\n")
+		assert.Contains(t, stdout.String(), "FAIL signature")
+		assert.Equal(t, "saml validate: invalid response\n", stderr.String())
+	}
+
+	var stdout, stderr bytes.Buffer
+	code := run([]string{
+		"validate",
+		"-idp-metadata", metadata,
+		"-acs-url", "https://sp.example.com/saml/other",
+		filepath.Join(interopDir, "okta.xml"),
+	}, nil, &stdout, &stderr)
+	assert.Equal(t, 1, code)
+	assert.Contains(t, stdout.String(), "FAIL destination")
+
+	// The metadata may be read from stdin, but not with the response.
+	buf, err := ioutil.ReadFile(metadata)
+	assert.NoError(t, err)
+	stdout.Reset()
+	stderr.Reset()
+	code = run([]string{
+		"validate",
+		"-idp-metadata", "-",
+		"-acs-url", "https://sp.example.com/saml/acs",
+		"-now", "2024-05-14T09:31:00Z",
+		filepath.Join(interopDir, "okta.xml"),
+	}, bytes.NewReader(buf), &stdout, &stderr)
+	assert.Equal(t, 1, code)
+	assert.Contains(t, stdout.String(), "PASS idp-metadata\n")
+
+	stdout.Reset()
+	stderr.Reset()
+	code = run([]string{
+		"validate",
+		"-idp-metadata", "-",
+		"-acs-url", "https://sp.example.com/saml/acs",
+	}, bytes.NewReader(buf), &stdout, &stderr)
+	assert.Equal(t, 1, code)
+	assert.Contains(t, stderr.String(), "cannot both be read from stdin")
+}
+
+func TestValidateUsage(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	assert.Equal(t, 2, run([]string{"validate", "-acs-url", "x"}, nil, &stdout, &stderr))
+	assert.Contains(t, stderr.String(), "Usage: saml validate")
+
+	stderr.Reset()
+	assert.Equal(t, 2, run([]string{"validate", "-bogus"}, nil, &stdout, &stderr))
+
+	stderr.Reset()
+	assert.Equal(t, 2, run([]string{"bogus"}, nil, &stdout, &stderr))
+	assert.Contains(t, stderr.String(), `unknown command "bogus"`)
+	assert.Contains(t, stderr.String(), "validate")
+}
@@ -0,0 +1,90 @@
+package saml
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// CommonDomainCookieName is the name of the common domain cookie, listing the
+// IdPs the user authenticated with, set in the common domain of a
+// federation.
+//
+// See section 4.3 of saml-profiles-2.0-os.
+const CommonDomainCookieName = "_saml_idp"
+
+// maxCommonDomainIdPs is the number of IdPs kept in the common domain cookie,
+// for the cookie to stay well under the 4096 bytes allowed by the browsers.
+const maxCommonDomainIdPs = 10
+
+// ReadCommonDomainCookie returns the entity IDs of the IdPs listed in the
+// common domain cookie of r, the most recent last. The invalid entries are
+// ignored.
+func ReadCommonDomainCookie(r *http.Request) []string {
+	cookie, err := r.Cookie(CommonDomainCookieName)
+	if err != nil {
+		return nil
+	}
+	return decodeCommonDomainCookie(cookie.Value)
+}
+
+func decodeCommonDomainCookie(value string) []string {
+	value, err := url.QueryUnescape(value)
+	if err != nil {
+		return nil
+	}
+	var entityIDs []string
+	for _, field := range strings.Fields(value) {
+		entityID, err := base64.StdEncoding.DecodeString(field)
+		if err != nil || len(entityID) == 0 {
+			continue
+		}
+		entityIDs = append(entityIDs, string(entityID))
+	}
+	return entityIDs
+}
+
+func encodeCommonDomainCookie(entityIDs []string) string {
+	fields := make([]string, len(entityIDs))
+	for i, entityID := range entityIDs {
+		fields[i] = base64.StdEncoding.EncodeToString([]byte(entityID))
+	}
+	return url.QueryEscape(strings.Join(fields, " "))
+}
+
+// WriteCommonDomainCookie makes entityID the most recent IdP of the common
+// domain cookie of the user agent of r, in domain, such as ".example.org".
+// The host of r must be in domain for the cookie to be accepted.
+func WriteCommonDomainCookie(w http.ResponseWriter, r *http.Request, domain, entityID string) {
+	entityIDs := []string{}
+	for _, id := range ReadCommonDomainCookie(r) {
+		if id != entityID {
+			entityIDs = append(entityIDs, id)
+		}
+	}
+	entityIDs = append(entityIDs, entityID)
+	if len(entityIDs) > maxCommonDomainIdPs {
+		entityIDs = entityIDs[len(entityIDs)-maxCommonDomainIdPs:]
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:   CommonDomainCookieName,
+		Value:  encodeCommonDomainCookie(entityIDs),
+		Path:   "/",
+		Domain: domain,
+		Secure: r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https",
+	})
+}
+
+// recentIdPs returns the entity IDs of the IdPs of the common domain cookie
+// of r, the most recent first, if the SP has a CommonDomain.
+func (sp *ServiceProvider) recentIdPs(r *http.Request) []string {
+	if sp.CommonDomain == "" {
+		return nil
+	}
+	entityIDs := ReadCommonDomainCookie(r)
+	for i, j := 0, len(entityIDs)-1; i < j; i, j = i+1, j-1 {
+		entityIDs[i], entityIDs[j] = entityIDs[j], entityIDs[i]
+	}
+	return entityIDs
+}
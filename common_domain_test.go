@@ -0,0 +1,91 @@
+package saml
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCommonDomainCookie(t *testing.T) {
+	r := httptest.NewRequest("GET", "https://sp.example.org/saml/login", nil)
+	assert.Empty(t, ReadCommonDomainCookie(r))
+
+	// Base64-encoded entity IDs separated by spaces, URL-encoded.
+	r.AddCookie(&http.Cookie{Name: CommonDomainCookieName, Value: "aHR0cHM6Ly9pZHAxLmV4YW1wbGUub3Jn%20bm90IGJhc2U2NA%3D%3D+%21%21%21%20aHR0cHM6Ly9pZHAyLmV4YW1wbGUub3Jn"})
+	assert.Equal(t, []string{"https://idp1.example.org", "not base64", "https://idp2.example.org"}, ReadCommonDomainCookie(r))
+
+	w := httptest.NewRecorder()
+	WriteCommonDomainCookie(w, r, ".example.org", "https://idp1.example.org")
+	cookies := w.Result().Cookies()
+	if assert.Len(t, cookies, 1) {
+		assert.Equal(t, "example.org", cookies[0].Domain)
+		assert.Equal(t, "/", cookies[0].Path)
+		assert.True(t, cookies[0].Secure)
+		assert.Equal(t, []string{"not base64", "https://idp2.example.org", "https://idp1.example.org"}, decodeCommonDomainCookie(cookies[0].Value))
+	}
+
+	// Only the most recent IdPs are kept.
+	var entityIDs []string
+	for i := 0; i < 2*maxCommonDomainIdPs; i++ {
+		entityIDs = append(entityIDs, fmt.Sprintf("https://idp%d.example.org", i))
+	}
+	r = httptest.NewRequest("GET", "/", nil)
+	r.AddCookie(&http.Cookie{Name: CommonDomainCookieName, Value: encodeCommonDomainCookie(entityIDs)})
+	w = httptest.NewRecorder()
+	WriteCommonDomainCookie(w, r, ".example.org", "https://idp.example.org")
+	recent := decodeCommonDomainCookie(w.Result().Cookies()[0].Value)
+	assert.Len(t, recent, maxCommonDomainIdPs)
+	assert.Equal(t, "https://idp.example.org", recent[len(recent)-1])
+}
+
+func TestCommonDomainIdPSelection(t *testing.T) {
+	sp := newTestDiscoverySP()
+	sp.DiscoveryURL = ""
+	sp.CommonDomain = ".example.org"
+
+	r := httptest.NewRequest("GET", "/saml/login", nil)
+	r.AddCookie(&http.Cookie{Name: CommonDomainCookieName, Value: encodeCommonDomainCookie([]string{"https://idp2.example.org", "https://unknown.example.org"})})
+	w := httptest.NewRecorder()
+	sp.AuthnRequestHandler(w, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+	body := w.Body.String()
+	assert.True(t, strings.Index(body, "idp2.example.org") < strings.Index(body, "idp1.example.org"))
+	assert.Contains(t, body, "(recently used)")
+	assert.NotContains(t, body, "unknown.example.org")
+
+	sp.CommonDomain = ""
+	w = httptest.NewRecorder()
+	sp.AuthnRequestHandler(w, r)
+	assert.NotContains(t, w.Body.String(), "(recently used)")
+}
+
+func TestAssertionMiddlewareCommonDomain(t *testing.T) {
+	tearUp()
+
+	sp, simpleSign := newSimpleSignTestSP(t)
+	sp.CommonDomain = ".localhost"
+	samlResponse := encodeTestResponse(t, newTestAssertionResponse(sp, Now()))
+	sig := simpleSign(samlResponse)
+	r := httptest.NewRequest("POST", sp.AcsURL, strings.NewReader(url.Values{
+		"SAMLResponse": {samlResponse},
+		"SigAlg":       {sig.SigAlg},
+		"Signature":    {base64.StdEncoding.EncodeToString(sig.Signature)},
+	}.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	sp.AssertionMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).ServeHTTP(w, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.Name == CommonDomainCookieName {
+			assert.Equal(t, []string{sp.IdPMetadata.EntityID}, decodeCommonDomainCookie(cookie.Value))
+			return
+		}
+	}
+	t.Error("no common domain cookie")
+}
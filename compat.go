@@ -0,0 +1,272 @@
+package saml
+
+import (
+	"encoding/base64"
+	"encoding/pem"
+	"net/url"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// IdPProfile bundles the settings working around the quirks of an IdP
+// product, which each integrator would otherwise rediscover by trial and
+// error. See ServiceProvider.ApplyProfile.
+type IdPProfile struct {
+	// Name identifies the IdP product, e.g. "ADFS".
+	Name string
+
+	// SignAuthnRequests, NormalizeURLs, ClockDrift, NameIDPolicy and
+	// SigningPolicy are the settings of the same names of the SP.
+	SignAuthnRequests bool
+	NormalizeURLs     bool
+	ClockDrift        *ClockDrift
+	NameIDPolicy      *NameIDPolicy
+	SigningPolicy     SigningPolicy
+
+	// AttributeNames maps the attribute names sent by the IdP to simple
+	// names, see ServiceProvider.AttributeNames.
+	AttributeNames map[string]string
+}
+
+// ApplyProfile applies the settings of an IdP profile to the SP: the flags
+// set by the profile are set, its ClockDrift, NameIDPolicy and
+// SigningPolicy, unless SigningPolicyEither, replace those of the SP, and
+// its attribute names are added to AttributeNames, whose existing entries
+// take precedence. It must be called before the SP is used.
+func (sp *ServiceProvider) ApplyProfile(p IdPProfile) {
+	if p.SignAuthnRequests {
+		sp.SignAuthnRequests = true
+	}
+	if p.NormalizeURLs {
+		sp.NormalizeURLs = true
+	}
+	if p.ClockDrift != nil {
+		drift := *p.ClockDrift
+		sp.ClockDrift = &drift
+	}
+	if p.NameIDPolicy != nil {
+		policy := *p.NameIDPolicy
+		sp.NameIDPolicy = &policy
+	}
+	if p.SigningPolicy != SigningPolicyEither {
+		sp.SigningPolicy = p.SigningPolicy
+	}
+	if len(p.AttributeNames) > 0 {
+		names := make(map[string]string, len(sp.AttributeNames)+len(p.AttributeNames))
+		for name, simple := range p.AttributeNames {
+			names[name] = simple
+		}
+		for name, simple := range sp.AttributeNames {
+			names[name] = simple
+		}
+		sp.AttributeNames = names
+	}
+}
+
+// renameAttributes renames the attributes of the assertion according to
+// AttributeNames.
+func (sp *ServiceProvider) renameAttributes(assertion *Assertion) {
+	if len(sp.AttributeNames) == 0 || assertion.AttributeStatement == nil {
+		return
+	}
+	attrs := assertion.AttributeStatement.Attributes
+	for i := range attrs {
+		if name, ok := sp.AttributeNames[attrs[i].Name]; ok {
+			attrs[i].Name = name
+		}
+	}
+}
+
+// ADFSClaimNames maps the URIs of the claims issued by default by ADFS to
+// simple names.
+var ADFSClaimNames = map[string]string{
+	"http://schemas.xmlsoap.org/ws/2005/05/identity/claims/emailaddress":         "email",
+	"http://schemas.xmlsoap.org/ws/2005/05/identity/claims/givenname":            "givenName",
+	"http://schemas.xmlsoap.org/ws/2005/05/identity/claims/name":                 "name",
+	"http://schemas.xmlsoap.org/ws/2005/05/identity/claims/nameidentifier":       "nameIdentifier",
+	"http://schemas.xmlsoap.org/ws/2005/05/identity/claims/surname":              "surname",
+	"http://schemas.xmlsoap.org/ws/2005/05/identity/claims/upn":                  "upn",
+	"http://schemas.xmlsoap.org/claims/CommonName":                               "commonName",
+	"http://schemas.xmlsoap.org/claims/EmailAddress":                             "email",
+	"http://schemas.xmlsoap.org/claims/Group":                                    "group",
+	"http://schemas.xmlsoap.org/claims/UPN":                                      "upn",
+	"http://schemas.microsoft.com/ws/2008/06/identity/claims/groups":             "groups",
+	"http://schemas.microsoft.com/ws/2008/06/identity/claims/primarysid":         "primarySID",
+	"http://schemas.microsoft.com/ws/2008/06/identity/claims/role":               "role",
+	"http://schemas.microsoft.com/ws/2008/06/identity/claims/windowsaccountname": "windowsAccountName",
+}
+
+// ADFSProfile returns the profile of Active Directory Federation Services:
+//
+//   - ADFS requires signed AuthnRequests once the SP metadata declares a
+//     signing certificate, which is always the case;
+//   - the Destination and Recipient are built from the endpoints registered
+//     in the relying party trust, in which the administrators often change
+//     the case of the host or add the default port;
+//   - ADFS sets NotBefore to the time of issuance, without the skew the other
+//     IdPs allow, so that the assertions are rejected by SPs whose clock is
+//     slightly behind;
+//   - the attributes are claims named by URIs, see ADFSClaimNames.
+func ADFSProfile() IdPProfile {
+	return IdPProfile{
+		Name:              "ADFS",
+		SignAuthnRequests: true,
+		NormalizeURLs:     true,
+		ClockDrift: &ClockDrift{
+			NotBefore:    5 * time.Minute,
+			NotOnOrAfter: ClockDriftTolerance,
+		},
+		AttributeNames: ADFSClaimNames,
+	}
+}
+
+// AzureADClaimNames maps the URIs of the claims issued by default by Azure
+// AD (Microsoft Entra ID) to simple names. It includes ADFSClaimNames.
+var AzureADClaimNames = func() map[string]string {
+	names := map[string]string{
+		"http://schemas.microsoft.com/claims/authnmethodsreferences":    "authnMethodsReferences",
+		"http://schemas.microsoft.com/identity/claims/displayname":      "displayName",
+		"http://schemas.microsoft.com/identity/claims/identityprovider": "identityProvider",
+		"http://schemas.microsoft.com/identity/claims/objectidentifier": "objectID",
+		"http://schemas.microsoft.com/identity/claims/tenantid":         "tenantID",
+		"http://schemas.microsoft.com/ws/2008/06/identity/claims/wids":  "wids",
+		"http://schemas.microsoft.com/claims/groups.link":               "groupsLink",
+	}
+	for name, simple := range ADFSClaimNames {
+		names[name] = simple
+	}
+	return names
+}()
+
+// AzureADMetadataURL returns the URL of the federation metadata of an Azure
+// AD (Microsoft Entra ID) tenant, given by its ID or one of its domains.
+// When appID, the application (client) ID of the SP, is not empty, the
+// metadata carries the signing certificate of that application, which
+// differs from the one of the tenant when a custom signing key is set.
+func AzureADMetadataURL(tenant, appID string) string {
+	u := "https://login.microsoftonline.com/" + url.PathEscape(tenant) + "/federationmetadata/2007-06/federationmetadata.xml"
+	if appID != "" {
+		u += "?appid=" + url.QueryEscape(appID)
+	}
+	return u
+}
+
+// AzureADEntityID returns the entity ID of the Azure AD (Microsoft Entra ID)
+// tenant with the given ID, which is the Issuer of its responses.
+func AzureADEntityID(tenantID string) string {
+	return "https://sts.windows.net/" + tenantID + "/"
+}
+
+// AzureADProfile returns the profile of Azure AD (Microsoft Entra ID):
+//
+//   - by default, Azure AD signs the assertion but not the response, which
+//     is required with SigningPolicyAssertion rather than reported as a
+//     warning;
+//   - the attributes are claims named by URIs, see AzureADClaimNames.
+func AzureADProfile() IdPProfile {
+	return IdPProfile{
+		Name:           "Azure AD",
+		SigningPolicy:  SigningPolicyAssertion,
+		AttributeNames: AzureADClaimNames,
+	}
+}
+
+// recipientPlaceholder is the Destination and Recipient of the responses of
+// OneLogin applications whose Recipient is not set, see
+// ServiceProvider.AcceptRecipientPlaceholder.
+const recipientPlaceholder = "{recipient}"
+
+const recipientPlaceholderHint = `"{recipient}" is sent by OneLogin when the Recipient of the application is not set`
+
+// OktaProfile returns the profile of Okta. Okta answers the AuthnRequests
+// asking for a NameID format other than the one of the application, which
+// is unspecified by default, with an InvalidNameIDPolicy status: the
+// profile asks for the unspecified format, letting Okta use the format of
+// the application. The attributes are sent with the unspecified name
+// format and the names of the attribute statements of the application,
+// which need no renaming.
+func OktaProfile() IdPProfile {
+	return IdPProfile{
+		Name: "Okta",
+		NameIDPolicy: &NameIDPolicy{
+			AllowCreate: true,
+			Format:      NameIDFormatUnspecified,
+		},
+	}
+}
+
+// OneLoginAttributeNames maps the names of the parameters of the default
+// OneLogin SAML connector to simple names.
+var OneLoginAttributeNames = map[string]string{
+	"User.email":     "email",
+	"User.FirstName": "firstName",
+	"User.LastName":  "lastName",
+}
+
+// OneLoginProfile returns the profile of OneLogin, which uses the NameID
+// format of the application, like Okta, and names the attributes after the
+// fields of its users, see OneLoginAttributeNames.
+//
+// It does not set AcceptRecipientPlaceholder: the Recipient should rather be
+// set in the configuration of the OneLogin application.
+func OneLoginProfile() IdPProfile {
+	return IdPProfile{
+		Name: "OneLogin",
+		NameIDPolicy: &NameIDPolicy{
+			AllowCreate: true,
+			Format:      NameIDFormatUnspecified,
+		},
+		AttributeNames: OneLoginAttributeNames,
+	}
+}
+
+// GoogleWorkspaceMetadata returns the metadata of the SAML app of a Google
+// Workspace account, whose metadata cannot be fetched by URL: idpID is the
+// idpid parameter of the SSO URL given by the Admin console, and certPEM is
+// the certificate downloaded from there.
+func GoogleWorkspaceMetadata(idpID string, certPEM []byte) (*Metadata, error) {
+	block, _ := pem.Decode(certPEM)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, errors.New("invalid Google Workspace certificate: PEM certificate expected")
+	}
+	ssoURL := "https://accounts.google.com/o/saml2/idp?idpid=" + url.QueryEscape(idpID)
+	return &Metadata{
+		EntityID: "https://accounts.google.com/o/saml2?idpid=" + url.QueryEscape(idpID),
+		IDPSSODescriptor: &IDPSSODescriptor{
+			ProtocolSupportEnumeration: "urn:oasis:names:tc:SAML:2.0:protocol",
+			KeyDescriptor: []KeyDescriptor{{
+				Use:     "signing",
+				KeyInfo: KeyInfo{Certificate: base64.StdEncoding.EncodeToString(block.Bytes)},
+			}},
+			NameIDFormat: []string{NameIDFormatEmailAddress},
+			SingleSignOnService: []Endpoint{
+				{Binding: HTTPRedirectBinding, Location: ssoURL},
+				{Binding: HTTPPostBinding, Location: ssoURL},
+			},
+		},
+	}, nil
+}
+
+// GoogleWorkspaceProfile returns the profile of Google Workspace:
+//
+//   - the NameID is the primary email of the users, and the AuthnRequests
+//     asking for another format are rejected: the Name ID format of the app
+//     must be set to EMAIL in the Admin console;
+//   - Google does not encrypt the assertions, the encryption key published
+//     in the SP metadata is ignored;
+//   - Google does not support single logout, see IdPLogoutURL.
+//
+// With GoogleWorkspaceMetadata, SSO with Google Workspace takes:
+//
+//	sp.IdPMetadata, err = saml.GoogleWorkspaceMetadata(idpID, certPEM)
+//	sp.ApplyProfile(saml.GoogleWorkspaceProfile())
+func GoogleWorkspaceProfile() IdPProfile {
+	return IdPProfile{
+		Name: "Google Workspace",
+		NameIDPolicy: &NameIDPolicy{
+			AllowCreate: true,
+			Format:      NameIDFormatEmailAddress,
+		},
+	}
+}
@@ -0,0 +1,206 @@
+package saml
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/pem"
+	"encoding/xml"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApplyProfile(t *testing.T) {
+	sp := &ServiceProvider{
+		AttributeNames: map[string]string{
+			"http://schemas.xmlsoap.org/ws/2005/05/identity/claims/emailaddress": "mail",
+		},
+	}
+	sp.ApplyProfile(ADFSProfile())
+
+	assert.True(t, sp.SignAuthnRequests)
+	assert.True(t, sp.NormalizeURLs)
+	assert.Equal(t, 5*time.Minute, sp.clockDrift().NotBefore)
+	assert.Equal(t, "mail", sp.AttributeNames["http://schemas.xmlsoap.org/ws/2005/05/identity/claims/emailaddress"])
+	assert.Equal(t, "upn", sp.AttributeNames["http://schemas.xmlsoap.org/ws/2005/05/identity/claims/upn"])
+	// The profile is not modified through the SP.
+	assert.Equal(t, "email", ADFSClaimNames["http://schemas.xmlsoap.org/ws/2005/05/identity/claims/emailaddress"])
+
+	sp.ApplyProfile(IdPProfile{Name: "none"})
+	assert.True(t, sp.SignAuthnRequests)
+	assert.Equal(t, 5*time.Minute, sp.clockDrift().NotBefore)
+}
+
+func TestADFSProfile(t *testing.T) {
+	tearUp()
+
+	sp, simpleSign := newSimpleSignTestSP(t)
+	sp.ApplyProfile(ADFSProfile())
+	now := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	res := newTestAssertionResponse(sp, now)
+	res.Destination = "HTTP://LOCALHOST:1235/saml/acs"
+	res.Assertion.Subject.SubjectConfirmations[0].SubjectConfirmationData.Recipient = "http://localhost:1235/saml/acs"
+	res.Assertion.Conditions.NotBefore = now.Add(2 * time.Minute)
+	res.Assertion.AttributeStatement = &AttributeStatement{
+		Attributes: []Attribute{
+			{Name: "http://schemas.xmlsoap.org/ws/2005/05/identity/claims/upn", Values: []AttributeValue{{Value: "jane@example.com"}}},
+			{Name: "http://schemas.microsoft.com/ws/2008/06/identity/claims/role", Values: []AttributeValue{{Value: "admin"}}},
+			{Name: "department", Values: []AttributeValue{{Value: "sales"}}},
+		},
+	}
+
+	samlResponse := encodeTestResponse(t, res)
+	result := sp.validateResponse(context.Background(), samlResponse, simpleSign(samlResponse), []TrackedRequest{{}}, now, nil, nil, true)
+	if assert.NoError(t, result.Err()) {
+		attrs := NewAttributesMap(result.Assertion)
+		assert.Equal(t, "jane@example.com", attrs.Get("upn"))
+		assert.Equal(t, "admin", attrs.Get("role"))
+		assert.Equal(t, "sales", attrs.Get("department"))
+	}
+
+	// Without the clock drift of the profile, the assertion is not yet valid.
+	sp.ClockDrift = nil
+	result = sp.validateResponse(context.Background(), samlResponse, simpleSign(samlResponse), []TrackedRequest{{}}, now, nil, nil, true)
+	assert.Error(t, result.Err())
+}
+
+func TestAzureAD(t *testing.T) {
+	tearUp()
+
+	assert.Equal(t, "https://login.microsoftonline.com/contoso.onmicrosoft.com/federationmetadata/2007-06/federationmetadata.xml", AzureADMetadataURL("contoso.onmicrosoft.com", ""))
+	assert.Equal(t, "https://login.microsoftonline.com/00000000-1111-2222-3333-444444444444/federationmetadata/2007-06/federationmetadata.xml?appid=app+1", AzureADMetadataURL("00000000-1111-2222-3333-444444444444", "app 1"))
+
+	buf := readInteropFile(t, "azuread.xml")
+	var res Response
+	assert.NoError(t, xml.Unmarshal(buf, &res))
+	issuer := AzureADEntityID("00000000-1111-2222-3333-444444444444")
+	assert.Equal(t, issuer, res.Issuer.Value)
+
+	sp := newInteropSP(t, issuer)
+	sp.ApplyProfile(AzureADProfile())
+	assert.Equal(t, SigningPolicyAssertion, sp.SigningPolicy)
+	result := sp.ValidateResponse(base64.StdEncoding.EncodeToString(buf), []string{"id-azure-request"}, interopNow)
+	assert.Subset(t, result.Passed, []string{CheckDecode, CheckDestination, CheckIssuer, CheckStatus, CheckInResponseTo}, "%s", result)
+
+	sp.renameAttributes(res.Assertion)
+	attrs := NewAttributesMap(res.Assertion)
+	assert.Equal(t, "aaaaaaaa-bbbb-cccc-dddd-eeeeeeeeeeee", attrs.Get("objectID"))
+	assert.Equal(t, "00000000-1111-2222-3333-444444444444", attrs.Get("tenantID"))
+	assert.Equal(t, "jane.doe@example.com", attrs.Get("email"))
+	assert.Equal(t, []string{"11111111-2222-3333-4444-555555555555", "66666666-7777-8888-9999-000000000000"}, attrs.Values("groups"))
+
+	// Only the assertions signed by Azure AD are accepted.
+	sp, simpleSign := newSimpleSignTestSP(t)
+	sp.ApplyProfile(AzureADProfile())
+	now := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+	samlResponse := encodeTestResponse(t, newTestAssertionResponse(sp, now))
+	result = sp.validateResponse(context.Background(), samlResponse, simpleSign(samlResponse), []TrackedRequest{{}}, now, nil, nil, true)
+	assert.True(t, errors.Is(result.Err(), ErrMissingSignature), "%s", result)
+}
+
+func TestAcceptRecipientPlaceholder(t *testing.T) {
+	tearUp()
+
+	sp, simpleSign := newSimpleSignTestSP(t)
+	now := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+	res := newTestAssertionResponse(sp, now)
+	res.Destination = "{recipient}"
+	res.Assertion.Subject.SubjectConfirmations[0].SubjectConfirmationData.Recipient = "{recipient}"
+	samlResponse := encodeTestResponse(t, res)
+
+	result := sp.validateResponse(context.Background(), samlResponse, simpleSign(samlResponse), []TrackedRequest{{}}, now, nil, nil, false)
+	if assert.Len(t, result.Failures, 2) {
+		assert.Equal(t, CheckDestination, result.Failures[0].Check)
+		assert.True(t, errors.Is(result.Failures[0].Err, ErrWrongDestination))
+		assert.Contains(t, result.Failures[0].Err.Error(), "OneLogin")
+		assert.Equal(t, CheckRecipient, result.Failures[1].Check)
+		assert.True(t, errors.Is(result.Failures[1].Err, ErrWrongRecipient))
+	}
+
+	sp.AcceptRecipientPlaceholder = true
+	result = sp.validateResponse(context.Background(), samlResponse, simpleSign(samlResponse), []TrackedRequest{{}}, now, nil, nil, true)
+	assert.NoError(t, result.Err())
+	if assert.Len(t, result.Warnings, 2) {
+		assert.Equal(t, CheckDestination, result.Warnings[0].Check)
+		assert.Equal(t, CheckRecipient, result.Warnings[1].Check)
+	}
+
+	// Only the placeholder is accepted.
+	res.Destination = "http://localhost:1235/saml/other"
+	samlResponse = encodeTestResponse(t, res)
+	result = sp.validateResponse(context.Background(), samlResponse, simpleSign(samlResponse), []TrackedRequest{{}}, now, nil, nil, true)
+	assert.True(t, errors.Is(result.Err(), ErrWrongDestination))
+}
+
+func TestOktaOneLoginProfiles(t *testing.T) {
+	tearUp()
+
+	for _, test := range []struct {
+		profile IdPProfile
+		file    string
+		names   map[string]string
+	}{
+		{OktaProfile(), "okta.xml", map[string]string{"email": "jane.doe@example.com"}},
+		{OneLoginProfile(), "onelogin.xml", map[string]string{"email": "jane.doe@example.com"}},
+	} {
+		var res Response
+		assert.NoError(t, xml.Unmarshal(readInteropFile(t, test.file), &res), test.file)
+
+		sp := newInteropSP(t, res.Issuer.Value)
+		sp.ApplyProfile(test.profile)
+		req, err := sp.NewAuthnRequest("https://idp.example.com/sso")
+		if assert.NoError(t, err, test.file) {
+			assert.Equal(t, NameIDFormatUnspecified, req.NameIDPolicy.Format, test.file)
+			assert.True(t, req.NameIDPolicy.AllowCreate, test.file)
+		}
+		assert.Empty(t, sp.nameIDFormats(), test.file)
+
+		sp.renameAttributes(res.Assertion)
+		attrs := NewAttributesMap(res.Assertion)
+		for name, value := range test.names {
+			assert.Equal(t, value, attrs.Get(name), "%s: %s", test.file, name)
+		}
+	}
+}
+
+func TestGoogleWorkspace(t *testing.T) {
+	tearUp()
+
+	_, err := GoogleWorkspaceMetadata("C01abc", []byte("not a certificate"))
+	assert.Error(t, err)
+
+	sp, simpleSign := newSimpleSignTestSP(t)
+	cert, err := base64.StdEncoding.DecodeString(sp.IdPMetadata.IDPSSODescriptor.KeyDescriptor[0].KeyInfo.Certificate)
+	assert.NoError(t, err)
+	sp.IdPMetadata, err = GoogleWorkspaceMetadata("C01abc", pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert}))
+	if !assert.NoError(t, err) {
+		return
+	}
+	sp.ApplyProfile(GoogleWorkspaceProfile())
+	assert.Equal(t, "https://accounts.google.com/o/saml2?idpid=C01abc", sp.IdPMetadata.EntityID)
+
+	ssoURL, err := sp.GetIdPAuthResourceContext(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "https://accounts.google.com/o/saml2/idp?idpid=C01abc", ssoURL)
+	req, err := sp.NewAuthnRequest(ssoURL)
+	if assert.NoError(t, err) {
+		assert.Equal(t, NameIDFormatEmailAddress, req.NameIDPolicy.Format)
+	}
+	assert.Equal(t, []string{NameIDFormatEmailAddress}, sp.nameIDFormats())
+
+	logoutURL, err := sp.IdPLogoutURL(context.Background())
+	assert.NoError(t, err)
+	assert.Empty(t, logoutURL)
+
+	now := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+	res := newTestAssertionResponse(sp, now)
+	res.Assertion.Subject.NameID = &NameID{Format: NameIDFormatEmailAddress, Value: "jane@example.com"}
+	samlResponse := encodeTestResponse(t, res)
+	result := sp.validateResponse(context.Background(), samlResponse, simpleSign(samlResponse), []TrackedRequest{{}}, now, nil, nil, true)
+	if assert.NoError(t, result.Err()) {
+		assert.Equal(t, "jane@example.com", result.Assertion.Subject.NameID.Value)
+	}
+}
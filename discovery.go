@@ -0,0 +1,112 @@
+package saml
+
+import (
+	"net/http"
+	"net/url"
+
+	"github.com/pkg/errors"
+)
+
+// IdPDiscoveryProtocol is the namespace of the Identity Provider Discovery
+// Protocol, which is also the binding of the DiscoveryResponse endpoints.
+//
+// See https://docs.oasis-open.org/security/saml/Post2.0/sstc-saml-idp-discovery.pdf
+const IdPDiscoveryProtocol = "urn:oasis:names:tc:SAML:profiles:SSO:idp-discovery-protocol"
+
+// discoveryReturnIDParam is the query parameter of the discovery response
+// carrying the entity ID of the chosen IdP, which is the default of the
+// protocol.
+const discoveryReturnIDParam = "entityID"
+
+// IdPHintParam is the query parameter of the requests to
+// AuthnRequestHandler naming the entity ID of the IdP to log in with.
+const IdPHintParam = "idp"
+
+// idpHint returns the entity ID of the IdP to log in with, given by the
+// IdPHint of the SP or by the IdPHintParam parameter of r, or an empty
+// string.
+func (sp *ServiceProvider) idpHint(r *http.Request) string {
+	if sp.IdPHint != nil {
+		if entityID := sp.IdPHint(r); entityID != "" {
+			return entityID
+		}
+	}
+	return r.URL.Query().Get(IdPHintParam)
+}
+
+// discoveryResponses returns the DiscoveryResponse endpoints published in
+// the SP metadata.
+func (sp *ServiceProvider) discoveryResponses() []IndexedEndpoint {
+	if sp.DiscoveryResponseURL == "" {
+		return nil
+	}
+	return []IndexedEndpoint{{
+		Binding:  IdPDiscoveryProtocol,
+		Location: sp.DiscoveryResponseURL,
+		Index:    1,
+	}}
+}
+
+// DiscoveryRequestURL returns the URL of the discovery service at
+// DiscoveryURL, asking the user to choose an IdP and to come back to
+// DiscoveryResponseURL. relayState is carried by the return URL, signed
+// when the SP has a RelayStateKey, and passed to the IdP.
+//
+// See section 2.4.1 of sstc-saml-idp-discovery.
+func (sp *ServiceProvider) DiscoveryRequestURL(relayState string) (string, error) {
+	if sp.DiscoveryURL == "" {
+		return "", errors.New("missing discovery URL")
+	}
+	if sp.DiscoveryResponseURL == "" {
+		return "", errors.New("missing discovery response URL")
+	}
+	discoveryURL, err := url.Parse(sp.DiscoveryURL)
+	if err != nil {
+		return "", errors.Wrap(err, "invalid discovery URL")
+	}
+	returnURL, err := url.Parse(sp.DiscoveryResponseURL)
+	if err != nil {
+		return "", errors.Wrap(err, "invalid discovery response URL")
+	}
+
+	if relayState != "" {
+		query := returnURL.Query()
+		query.Set("RelayState", sp.signRelayState(relayState, ""))
+		returnURL.RawQuery = query.Encode()
+	}
+	query := discoveryURL.Query()
+	query.Set("entityID", sp.entityID())
+	query.Set("return", returnURL.String())
+	query.Set("returnIDParam", discoveryReturnIDParam)
+	discoveryURL.RawQuery = query.Encode()
+	return discoveryURL.String(), nil
+}
+
+// DiscoveryResponseHandler serves DiscoveryResponseURL: it starts the login
+// with the IdP of IdPs chosen with the discovery service or on the IdP
+// selection page, see ForIdP. The choice is remembered when the remember
+// parameter is 1 and the SP has RememberIdP set.
+//
+// See section 2.4.2 of sstc-saml-idp-discovery.
+func (sp *ServiceProvider) DiscoveryResponseHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	relayState, err := sp.verifyRelayState(query.Get("RelayState"), "")
+	if err != nil {
+		sp.clientErr(w, r, err)
+		return
+	}
+	entityID := query.Get(discoveryReturnIDParam)
+	if entityID == "" {
+		sp.clientErr(w, r, errors.New("no IdP was chosen"))
+		return
+	}
+	idpSP, err := sp.ForIdP(entityID)
+	if err != nil {
+		sp.clientErr(w, r, err)
+		return
+	}
+	if query.Get("remember") == "1" && sp.RememberIdP > 0 {
+		sp.rememberIdP(w, r, entityID)
+	}
+	idpSP.SendAuthnRequest(w, r, relayState)
+}
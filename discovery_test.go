@@ -0,0 +1,209 @@
+package saml
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestDiscoverySP() *ServiceProvider {
+	sp := newTestResponseSP()
+	sp.IdPMetadata = nil
+	sp.DiscoveryURL = "https://disco.example.org/ds?lang=en"
+	sp.DiscoveryResponseURL = "http://localhost:1235/saml/disco"
+	sp.IdPs = NewIdPSet(
+		&Metadata{
+			EntityID: "https://idp1.example.org",
+			IDPSSODescriptor: &IDPSSODescriptor{
+				SingleSignOnService: []Endpoint{{Binding: HTTPRedirectBinding, Location: "https://idp1.example.org/sso"}},
+			},
+		},
+		&Metadata{
+			EntityID: "https://idp2.example.org",
+			IDPSSODescriptor: &IDPSSODescriptor{
+				SingleSignOnService: []Endpoint{{Binding: HTTPRedirectBinding, Location: "https://idp2.example.org/sso"}},
+			},
+		},
+		// Not an IdP.
+		&Metadata{EntityID: "https://sp.example.org", SPSSODescriptor: &SPSSODescriptor{}},
+	)
+	return sp
+}
+
+func TestIdPSet(t *testing.T) {
+	sp := newTestDiscoverySP()
+	assert.Equal(t, 2, sp.IdPs.Len())
+	list := sp.IdPs.List()
+	if assert.Len(t, list, 2) {
+		assert.Equal(t, "https://idp1.example.org", list[0].EntityID)
+		assert.Equal(t, "https://idp2.example.org", list[1].EntityID)
+	}
+	_, ok := sp.IdPs.Lookup("https://sp.example.org")
+	assert.False(t, ok)
+
+	idpSP, err := sp.ForIdP("https://idp2.example.org")
+	assert.NoError(t, err)
+	assert.Nil(t, idpSP.IdPs)
+	location, err := idpSP.idpSSOLocation(context.Background(), HTTPRedirectBinding)
+	assert.NoError(t, err)
+	assert.Equal(t, "https://idp2.example.org/sso", location)
+
+	again, err := sp.ForIdP("https://idp2.example.org")
+	assert.NoError(t, err)
+	assert.True(t, idpSP == again)
+
+	sp.IdPs.Remove("https://idp2.example.org")
+	_, err = sp.ForIdP("https://idp2.example.org")
+	assert.Error(t, err)
+}
+
+func TestDiscovery(t *testing.T) {
+	tearUp()
+
+	sp := newTestDiscoverySP()
+	sp.RelayStateKey = []byte("0123456789abcdef0123456789abcdef")
+	keyPEM, certPEM, err := GenerateKeyPair(KeyPairOptions{})
+	assert.NoError(t, err)
+	sp.PrivkeyPEM, sp.PubkeyPEM = keyPEM, certPEM
+
+	metadata, err := sp.MetadataXML()
+	assert.NoError(t, err)
+	assert.Contains(t, string(metadata), `<DiscoveryResponse xmlns="urn:oasis:names:tc:SAML:profiles:SSO:idp-discovery-protocol" Binding="urn:oasis:names:tc:SAML:profiles:SSO:idp-discovery-protocol" Location="http://localhost:1235/saml/disco" index="1"></DiscoveryResponse>`)
+
+	// The login starts with the discovery service.
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/saml/login", nil)
+	sp.AuthnRequestHandler(w, r.WithContext(context.WithValue(r.Context(), "saml.RelayState", "/home")))
+	assert.Equal(t, http.StatusFound, w.Code)
+	u, err := url.Parse(w.Header().Get("Location"))
+	assert.NoError(t, err)
+	assert.Equal(t, "disco.example.org", u.Host)
+	assert.Equal(t, "en", u.Query().Get("lang"))
+	assert.Equal(t, sp.MetadataURL, u.Query().Get("entityID"))
+	assert.Equal(t, "entityID", u.Query().Get("returnIDParam"))
+	returnURL, err := url.Parse(u.Query().Get("return"))
+	assert.NoError(t, err)
+	assert.Equal(t, "/saml/disco", returnURL.Path)
+	assert.True(t, strings.HasPrefix(returnURL.Query().Get("RelayState"), "/home."))
+
+	// The discovery service returns the chosen IdP.
+	query := returnURL.Query()
+	query.Set("entityID", "https://idp2.example.org")
+	routes, err := sp.Routes()
+	assert.NoError(t, err)
+	w = httptest.NewRecorder()
+	routes.ServeHTTP(w, httptest.NewRequest("GET", "/saml/disco?"+query.Encode(), nil))
+	assert.Equal(t, http.StatusFound, w.Code)
+	location := w.Header().Get("Location")
+	assert.True(t, strings.HasPrefix(location, "https://idp2.example.org/sso?"), location)
+	u, err = url.Parse(location)
+	assert.NoError(t, err)
+	msg, err := DecodeRedirect(u.RawQuery, SizeLimits{})
+	if assert.NoError(t, err) {
+		var req AuthnRequest
+		assert.NoError(t, xml.Unmarshal(msg.XML, &req))
+		relayState, err := sp.verifyRelayState(msg.RelayState, req.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, "/home", relayState)
+	}
+
+	for _, rawQuery := range []string{
+		"",
+		"entityID=https%3A%2F%2Fevil.example.org",
+		"entityID=https%3A%2F%2Fidp1.example.org&RelayState=%2Fhome.forged",
+	} {
+		w = httptest.NewRecorder()
+		sp.DiscoveryResponseHandler(w, httptest.NewRequest("GET", "/saml/disco?"+rawQuery, nil))
+		assert.Equal(t, http.StatusBadRequest, w.Code, rawQuery)
+	}
+}
+
+func TestIdPHint(t *testing.T) {
+	tearUp()
+
+	sp := newTestDiscoverySP()
+	login := func(target string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		sp.AuthnRequestHandler(w, httptest.NewRequest("GET", target, nil))
+		return w
+	}
+
+	// The IdP named by the request is used directly.
+	w := login("/saml/login?idp=" + url.QueryEscape("https://idp2.example.org"))
+	assert.Equal(t, http.StatusFound, w.Code)
+	assert.True(t, strings.HasPrefix(w.Header().Get("Location"), "https://idp2.example.org/sso?"), w.Header().Get("Location"))
+
+	w = login("/saml/login?idp=" + url.QueryEscape("https://evil.example.org"))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	// The IdPHint of the SP takes precedence, and falls back to the query.
+	sp.IdPHint = func(r *http.Request) string {
+		if strings.HasPrefix(r.URL.Path, "/one/") {
+			return "https://idp1.example.org"
+		}
+		return ""
+	}
+	w = login("/one/saml/login?idp=" + url.QueryEscape("https://idp2.example.org"))
+	assert.True(t, strings.HasPrefix(w.Header().Get("Location"), "https://idp1.example.org/sso?"), w.Header().Get("Location"))
+	w = login("/two/saml/login?idp=" + url.QueryEscape("https://idp2.example.org"))
+	assert.True(t, strings.HasPrefix(w.Header().Get("Location"), "https://idp2.example.org/sso?"), w.Header().Get("Location"))
+
+	// Without hint, the user chooses.
+	w = login("/two/saml/login")
+	assert.True(t, strings.HasPrefix(w.Header().Get("Location"), "https://disco.example.org/ds?"), w.Header().Get("Location"))
+}
+
+func TestValidateResponseIdPs(t *testing.T) {
+	tearUp()
+
+	sp, simpleSign := newSimpleSignTestSP(t)
+	now := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+	res := newTestAssertionResponse(sp, now)
+	sp.IdPs = NewIdPSet(sp.IdPMetadata, &Metadata{
+		EntityID:         "https://idp2.example.org",
+		IDPSSODescriptor: &IDPSSODescriptor{},
+	})
+	sp.IdPMetadata = nil
+
+	samlResponse := encodeTestResponse(t, res)
+	result := sp.validateResponse(context.Background(), samlResponse, simpleSign(samlResponse), []TrackedRequest{{}}, now, nil, nil, true)
+	assert.NoError(t, result.Err())
+
+	// The response must be issued by the IdP the request was sent to.
+	idp1 := res.Issuer.Value
+	res.InResponseTo = "id-req"
+	res.Assertion.Subject.SubjectConfirmations[0].SubjectConfirmationData.InResponseTo = "id-req"
+	samlResponse = encodeTestResponse(t, res)
+	result = sp.validateResponse(context.Background(), samlResponse, simpleSign(samlResponse), []TrackedRequest{{ID: "id-req", IdP: idp1}}, now, nil, nil, true)
+	assert.NoError(t, result.Err())
+	result = sp.validateResponse(context.Background(), samlResponse, simpleSign(samlResponse), []TrackedRequest{{ID: "id-req", IdP: "https://idp2.example.org"}}, now, nil, nil, true)
+	if assert.Len(t, result.Failures, 1) {
+		assert.Equal(t, CheckIssuer, result.Failures[0].Check)
+		assert.True(t, errors.Is(result.Failures[0].Err, ErrIssuerMismatch))
+	}
+
+	// So must be the assertion.
+	res.InResponseTo = ""
+	samlResponse = encodeTestResponse(t, res)
+	result = sp.validateResponse(context.Background(), samlResponse, simpleSign(samlResponse), []TrackedRequest{{}, {ID: "id-req", IdP: "https://idp2.example.org"}}, now, nil, nil, true)
+	if assert.Len(t, result.Failures, 1) {
+		assert.Equal(t, CheckAssertionInResponseTo, result.Failures[0].Check)
+		assert.True(t, errors.Is(result.Failures[0].Err, ErrIssuerMismatch))
+	}
+
+	res.Issuer.Value = "https://evil.example.org"
+	samlResponse = encodeTestResponse(t, res)
+	result = sp.validateResponse(context.Background(), samlResponse, simpleSign(samlResponse), []TrackedRequest{{}}, now, nil, nil, true)
+	if assert.Len(t, result.Failures, 1) {
+		assert.Equal(t, CheckIdPMetadata, result.Failures[0].Check)
+		assert.True(t, errors.Is(result.Failures[0].Err, ErrIssuerMismatch))
+	}
+}
@@ -0,0 +1,81 @@
+// Package eduperson provides typed accessors to the eduPerson and
+// inetOrgPerson attributes commonly released by the IdPs of research and
+// education federations.
+package eduperson
+
+import (
+	"github.com/goware/saml"
+)
+
+// Person gives access to the attributes of a validated assertion.
+type Person struct {
+	attrs *saml.AttributesMap
+}
+
+// New returns the Person described by the given assertion. The assertion
+// must have been validated by the ServiceProvider.
+func New(assertion *saml.Assertion) *Person {
+	return &Person{attrs: saml.NewAttributesMap(assertion)}
+}
+
+// EPPN returns the eduPersonPrincipalName, e.g. "jane@example.org".
+func (p *Person) EPPN() string {
+	return p.attrs.Get("eduPersonPrincipalName")
+}
+
+// Mail returns the first mail address.
+func (p *Person) Mail() string {
+	return p.attrs.Get("mail")
+}
+
+// DisplayName returns the displayName.
+func (p *Person) DisplayName() string {
+	return p.attrs.Get("displayName")
+}
+
+// GivenName returns the givenName.
+func (p *Person) GivenName() string {
+	return p.attrs.Get("givenName")
+}
+
+// Surname returns the sn.
+func (p *Person) Surname() string {
+	return p.attrs.Get("sn")
+}
+
+// Affiliations returns the eduPersonAffiliation values, e.g. "member" or
+// "staff".
+func (p *Person) Affiliations() []string {
+	return p.attrs.Values("eduPersonAffiliation")
+}
+
+// ScopedAffiliations returns the eduPersonScopedAffiliation values, e.g.
+// "staff@example.org".
+func (p *Person) ScopedAffiliations() []string {
+	return p.attrs.Values("eduPersonScopedAffiliation")
+}
+
+// Entitlements returns the eduPersonEntitlement values.
+func (p *Person) Entitlements() []string {
+	return p.attrs.Values("eduPersonEntitlement")
+}
+
+// HasAffiliation returns whether the person has the given affiliation.
+func (p *Person) HasAffiliation(affiliation string) bool {
+	for _, a := range p.Affiliations() {
+		if a == affiliation {
+			return true
+		}
+	}
+	return false
+}
+
+// HasEntitlement returns whether the person has the given entitlement.
+func (p *Person) HasEntitlement(entitlement string) bool {
+	for _, e := range p.Entitlements() {
+		if e == entitlement {
+			return true
+		}
+	}
+	return false
+}
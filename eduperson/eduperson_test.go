@@ -0,0 +1,40 @@
+package eduperson
+
+import (
+	"testing"
+
+	"github.com/goware/saml"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPerson(t *testing.T) {
+	attr := func(name, friendly string, values ...string) saml.Attribute {
+		a := saml.Attribute{Name: name, FriendlyName: friendly}
+		for _, v := range values {
+			a.Values = append(a.Values, saml.AttributeValue{Value: v})
+		}
+		return a
+	}
+	assertion := &saml.Assertion{
+		AttributeStatement: &saml.AttributeStatement{
+			Attributes: []saml.Attribute{
+				attr("urn:oid:1.3.6.1.4.1.5923.1.1.1.6", "", "jane@example.org"),
+				attr("", "mail", "jane.doe@example.org"),
+				attr("urn:oid:2.16.840.1.113730.3.1.241", "displayName", "Jane Doe"),
+				attr("urn:oid:1.3.6.1.4.1.5923.1.1.1.1", "eduPersonAffiliation", "member", "staff"),
+				attr("urn:oid:1.3.6.1.4.1.5923.1.1.1.7", "", "urn:mace:dir:entitlement:common-lib-terms"),
+			},
+		},
+	}
+
+	p := New(assertion)
+	assert.Equal(t, "jane@example.org", p.EPPN())
+	assert.Equal(t, "jane.doe@example.org", p.Mail())
+	assert.Equal(t, "Jane Doe", p.DisplayName())
+	assert.Equal(t, "", p.GivenName())
+	assert.Equal(t, []string{"member", "staff"}, p.Affiliations())
+	assert.True(t, p.HasAffiliation("staff"))
+	assert.False(t, p.HasAffiliation("student"))
+	assert.Nil(t, p.ScopedAffiliations())
+	assert.True(t, p.HasEntitlement("urn:mace:dir:entitlement:common-lib-terms"))
+}
@@ -0,0 +1,110 @@
+package saml
+
+import (
+	"bytes"
+	"encoding/xml"
+
+	"github.com/goware/saml/xmlsec"
+	"github.com/pkg/errors"
+)
+
+// encryptXML encrypts the XML element data for the holder of the
+// certificate in certFile. The result is an EncryptedData element.
+func encryptXML(data []byte, certFile string) ([]byte, error) {
+	tpl := xmlsec.NewEncryptedDataTemplate(
+		"http://www.w3.org/2001/04/xmlenc#aes128-cbc",
+		"http://www.w3.org/2001/04/xmlenc#rsa-oaep-mgf1p",
+	)
+
+	// TODO: pick an encryption algorithm from the actual metadata.
+	buf, err := xmlsec.Encrypt(tpl, data, certFile, "aes-128-cbc")
+	if err != nil {
+		return nil, err
+	}
+
+	return bytes.TrimSpace(bytes.TrimPrefix(buf, []byte(`<?xml version="1.0"?>`))), nil
+}
+
+// nameIDElement and attributeElement are used to marshal and unmarshal a
+// NameID and an Attribute as standalone elements.
+type nameIDElement struct {
+	XMLName xml.Name `xml:"urn:oasis:names:tc:SAML:2.0:assertion NameID"`
+	NameID
+}
+
+type attributeElement struct {
+	XMLName xml.Name `xml:"urn:oasis:names:tc:SAML:2.0:assertion Attribute"`
+	Attribute
+}
+
+// EncryptNameID encrypts nameID for the holder of the certificate in
+// certFile, e.g. the encryption certificate of the recipient of a
+// LogoutRequest.
+func EncryptNameID(nameID *NameID, certFile string) (*EncryptedID, error) {
+	buf, err := xml.Marshal(nameIDElement{NameID: *nameID})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to marshal NameID")
+	}
+	buf, err = encryptXML(buf, certFile)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to encrypt NameID")
+	}
+	return &EncryptedID{EncryptedData: buf}, nil
+}
+
+// DecryptNameID decrypts an EncryptedID, found in the Subject of an
+// assertion or in a LogoutRequest, with the SP's private key.
+func (sp *ServiceProvider) DecryptNameID(encryptedID *EncryptedID) (*NameID, error) {
+	var decoded nameIDElement
+	if err := sp.decryptXML(encryptedID.EncryptedData, &decoded); err != nil {
+		return nil, errors.Wrap(err, "unable to decrypt NameID")
+	}
+	return &decoded.NameID, nil
+}
+
+// DecryptAttribute decrypts an EncryptedAttribute of an AttributeStatement
+// with the SP's private key.
+func (sp *ServiceProvider) DecryptAttribute(encryptedAttribute *EncryptedAttribute) (*Attribute, error) {
+	var decoded attributeElement
+	if err := sp.decryptXML(encryptedAttribute.EncryptedData, &decoded); err != nil {
+		return nil, errors.Wrap(err, "unable to decrypt Attribute")
+	}
+	return &decoded.Attribute, nil
+}
+
+// decryptXML decrypts the EncryptedData element data with the SP's private
+// key, and decodes the resulting element into v.
+func (sp *ServiceProvider) decryptXML(data []byte, v interface{}) error {
+	keyFile, err := sp.PrivkeyFile()
+	if err != nil {
+		return errors.Wrap(err, "failed to get private key")
+	}
+
+	plaintext, err := decrypt(data, keyFile, &sp.SecurityOpts)
+	if err != nil {
+		return err
+	}
+
+	if err := xml.Unmarshal(plaintext, v); err != nil {
+		return errors.Wrap(err, "unable to parse decrypted element")
+	}
+	return nil
+}
+
+// decrypt decrypts the EncryptedData element data with the private key in
+// keyFile. Like for the encrypted assertions, the xmlsec1 errors that are
+// not security exceptions according to opts are ignored, as long as there
+// is a plaintext.
+func decrypt(data []byte, keyFile string, opts *SecurityOpts) ([]byte, error) {
+	plaintext, err := xmlsec.Decrypt(data, keyFile)
+	if err != nil && IsSecurityException(err, opts) {
+		return nil, err
+	}
+	if len(bytes.TrimSpace(plaintext)) == 0 {
+		if err != nil {
+			return nil, errors.Wrap(err, "empty plaintext")
+		}
+		return nil, errors.New("empty plaintext")
+	}
+	return plaintext, nil
+}
@@ -0,0 +1,146 @@
+package saml
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Errors reported when a SAML response fails validation. The errors returned
+// by ParseResponse and AssertResponse match them with errors.Is.
+var (
+	ErrMalformedResponse      = errors.New("malformed SAML response")
+	ErrMessageTooLarge        = errors.New("SAML message too large")
+	ErrWrongDestination       = errors.New("wrong ACS destination")
+	ErrIssuerMismatch         = errors.New("issuer does not match expected entity ID")
+	ErrStatusNotSuccess       = errors.New("unexpected status code")
+	ErrUnexpectedInResponseTo = errors.New("unexpected InResponseTo value")
+	ErrMissingSignature       = errors.New("missing signature")
+	ErrInvalidSignature       = errors.New("invalid signature")
+	ErrDecryption             = errors.New("unable to decrypt assertion")
+	ErrMissingAssertion       = errors.New("missing assertion")
+	ErrWrongRecipient         = errors.New("invalid assertion recipient")
+	ErrNoBearerConfirmation   = errors.New("no bearer subject confirmation")
+	ErrWrongAddress           = errors.New("invalid subject confirmation address")
+	ErrWrongKey               = errors.New("subject confirmation key does not match the client certificate")
+	ErrMissingConditions      = errors.New("missing assertion conditions")
+	ErrAssertionNotYetValid   = errors.New("assertion is not valid yet")
+	ErrExpiredAssertion       = errors.New("assertion expired")
+	ErrWrongAudience          = errors.New("assertion audience does not match SP entity ID")
+	ErrProxyRestriction       = errors.New("proxy restriction not satisfied")
+	ErrAuthnTooOld            = errors.New("authentication is too old")
+	ErrMissingAuthnStatement  = errors.New("missing authentication statement")
+	ErrAuthnContext           = errors.New("insufficient authentication context")
+	ErrWrongSubject           = errors.New("assertion subject does not match the requested subject")
+	ErrInvalidRelayState      = errors.New("invalid RelayState")
+	ErrRedirectNotAllowed     = errors.New("redirect target not allowed")
+)
+
+// ErrRateLimited is reported when AssertionMiddleware rejects a request
+// because of its RateLimit.
+var ErrRateLimited = errors.New("too many SAML responses")
+
+// ValidationError describes why a SAML message was rejected. Kind is one of
+// the Err* values above and is matched by errors.Is; the underlying cause, if
+// any, is available with errors.Unwrap and errors.As.
+type ValidationError struct {
+	Kind    error
+	Details string
+	cause   error
+}
+
+func (e *ValidationError) Error() string {
+	msg := e.Kind.Error()
+	if e.Details != "" {
+		msg += ": " + e.Details
+	}
+	if e.cause != nil {
+		msg += ": " + e.cause.Error()
+	}
+	return msg
+}
+
+// Is reports whether target is the kind of this error.
+func (e *ValidationError) Is(target error) bool {
+	return target == e.Kind
+}
+
+// Unwrap returns the underlying cause of the error, if any.
+func (e *ValidationError) Unwrap() error {
+	return e.cause
+}
+
+func validationErrorf(kind error, cause error, format string, args ...interface{}) error {
+	return &ValidationError{
+		Kind:    kind,
+		Details: fmt.Sprintf(format, args...),
+		cause:   cause,
+	}
+}
+
+// StatusError is the cause of an ErrStatusNotSuccess validation error: the
+// status of a response reporting that the IdP did not authenticate the user.
+// It is retrieved with errors.As.
+type StatusError struct {
+	// Code is the top-level status code, e.g. StatusResponder.
+	Code string
+
+	// SubCode is the nested status code telling why the request failed,
+	// e.g. StatusNoPassive, if any.
+	SubCode string
+
+	// Message is the StatusMessage of the response, if any.
+	Message string
+
+	// Detail is the raw XML content of the StatusDetail of the response,
+	// if any.
+	Detail string
+
+	// Signed is true when the response carried a signature, which was
+	// verified. The status of an unsigned response may be forged.
+	Signed bool
+}
+
+func newStatusError(status *Status) *StatusError {
+	e := &StatusError{
+		Code:    status.StatusCode.Value,
+		SubCode: status.subCode(),
+		Message: status.StatusMessage,
+	}
+	if status.StatusDetail != nil {
+		e.Detail = strings.TrimSpace(string(status.StatusDetail.Content))
+	}
+	return e
+}
+
+func (e *StatusError) Error() string {
+	msg := e.Code
+	if e.SubCode != "" {
+		msg += " (" + e.SubCode + ")"
+	}
+	if e.Message != "" {
+		msg += ": " + e.Message
+	}
+	if e.Detail != "" {
+		msg += " [" + e.Detail + "]"
+	}
+	return msg
+}
+
+// UserMessage returns a description of the status that can be shown to the
+// user, without the URNs of the codes.
+func (e *StatusError) UserMessage() string {
+	switch e.SubCode {
+	case StatusAuthnFailed:
+		return "The identity provider could not authenticate you."
+	case StatusNoPassive:
+		return "You are not logged in at the identity provider."
+	case StatusRequestDenied:
+		return "The identity provider denied the login request."
+	case StatusUnknownPrincipal:
+		return "The identity provider does not know your account."
+	case StatusPartialLogout:
+		return "You could not be logged out of every application."
+	}
+	return "The identity provider could not log you in."
+}
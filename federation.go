@@ -0,0 +1,244 @@
+package saml
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/goware/saml/xmlsec"
+	"github.com/pkg/errors"
+)
+
+// mdNamespace is the namespace of the metadata elements.
+const mdNamespace = "urn:oasis:names:tc:SAML:2.0:metadata"
+
+// Federation is a source of the metadata of the entities of a federation:
+// an aggregate, downloaded at once, or a Metadata Query (MDQ) service,
+// queried for each entity. Unlike the metadata of a single IdP, fetched from
+// its own host, this metadata must be signed by the federation with the key
+// of one of the certificates of Roots.
+//
+//	entities, err := federation.Entities(ctx)
+//	...
+//	sp.IdPs.AddEntities(entities)
+type Federation struct {
+	// AggregateURL is the location of the aggregate of the federation.
+	AggregateURL string
+
+	// MDQURL is the base URL of the MDQ service of the federation. The
+	// metadata of an entity is downloaded from
+	// MDQURL/entities/{URL-escaped entity ID}.
+	MDQURL string
+
+	// Roots are the metadata signing certificates of the federation, the
+	// roots of trust of its metadata. The metadata signed with any of them
+	// is accepted, so that both the current and the next certificates can
+	// be listed during a rotation. At least one is required.
+	Roots []*x509.Certificate
+
+	// Client downloads the metadata. It defaults to http.DefaultClient.
+	Client *http.Client
+
+	// Clock is used to check the validUntil of the metadata. When nil, the
+	// package-level Now is used.
+	Clock Clock
+}
+
+func (f *Federation) client() *http.Client {
+	if f.Client == nil {
+		return http.DefaultClient
+	}
+	return f.Client
+}
+
+func (f *Federation) now() time.Time {
+	if f.Clock == nil {
+		return Now()
+	}
+	return f.Clock.Now()
+}
+
+// Entities downloads the aggregate at AggregateURL and verifies its
+// signature.
+func (f *Federation) Entities(ctx context.Context) (*EntitiesDescriptor, error) {
+	if f.AggregateURL == "" {
+		return nil, errors.New("missing aggregate URL")
+	}
+	buf, _, err := fetchMetadata(ctx, f.client(), f.AggregateURL, cacheValidators{})
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to download %s", f.AggregateURL)
+	}
+	if err := f.verify(buf, "EntitiesDescriptor"); err != nil {
+		return nil, errors.Wrapf(err, "metadata of %s", f.AggregateURL)
+	}
+	var entities EntitiesDescriptor
+	if err := xml.Unmarshal(buf, &entities); err != nil {
+		return nil, err
+	}
+	return &entities, nil
+}
+
+// Entity queries the MDQ service at MDQURL for the metadata of an entity and
+// verifies its signature.
+func (f *Federation) Entity(ctx context.Context, entityID string) (*Metadata, error) {
+	if f.MDQURL == "" {
+		return nil, errors.New("missing MDQ URL")
+	}
+	location := strings.TrimSuffix(f.MDQURL, "/") + "/entities/" + url.PathEscape(entityID)
+	buf, _, err := fetchMetadata(ctx, f.client(), location, cacheValidators{})
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to download %s", location)
+	}
+	if err := f.verify(buf, "EntityDescriptor"); err != nil {
+		return nil, errors.Wrapf(err, "metadata of %s", entityID)
+	}
+	var metadata Metadata
+	if err := xml.Unmarshal(buf, &metadata); err != nil {
+		return nil, err
+	}
+	if metadata.EntityID != entityID {
+		return nil, errors.Errorf("metadata of entity %q found at %s, expected %q", metadata.EntityID, location, entityID)
+	}
+	return &metadata, nil
+}
+
+// verify verifies that data is a metadata document of root element
+// rootLocal, valid and signed with the key of one of the Roots. The
+// KeyInfo of the signature is removed before the verification, so that
+// xmlsec1 uses the key of the root rather than the certificate of the
+// KeyInfo, whose chain the roots don't vouch for.
+func (f *Federation) verify(data []byte, rootLocal string) error {
+	if len(f.Roots) == 0 {
+		return errors.New("no federation roots to verify the metadata with")
+	}
+	root, err := checkSignedMetadata(data, rootLocal)
+	if err != nil {
+		return err
+	}
+	if !root.ValidUntil.IsZero() && f.now().After(root.ValidUntil) {
+		return errors.Errorf("metadata expired (validUntil=%v)", root.ValidUntil)
+	}
+	data, err = stripKeyInfo(data)
+	if err != nil {
+		return err
+	}
+
+	opts := &xmlsec.ValidationOptions{
+		EnableIDAttrHack: true,
+		IDAttrs:          []string{mdNamespace + ":" + rootLocal},
+		NodeID:           root.ID,
+	}
+	for _, cert := range f.Roots {
+		certFile, err := writeFile(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}))
+		if err != nil {
+			return err
+		}
+		if err := xmlsec.Verify(data, certFile, opts); err == nil {
+			return nil
+		}
+	}
+	return validationErrorf(ErrInvalidSignature, nil, "metadata not signed by the federation roots")
+}
+
+// stripKeyInfo removes the KeyInfo of the signature of the root element of
+// data, which checkSignedMetadata checked.
+func stripKeyInfo(data []byte) ([]byte, error) {
+	d := xml.NewDecoder(bytes.NewReader(data))
+	depth := 0
+	for {
+		offset := d.InputOffset()
+		tok, err := d.Token()
+		if err == io.EOF {
+			return data, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch tok := tok.(type) {
+		case xml.StartElement:
+			depth++
+			if depth == 3 && tok.Name.Space == dsigNamespace && tok.Name.Local == "KeyInfo" {
+				if err := d.Skip(); err != nil {
+					return nil, err
+				}
+				stripped := append([]byte{}, data[:offset]...)
+				return append(stripped, data[d.InputOffset():]...), nil
+			}
+			if depth == 2 && (tok.Name.Space != dsigNamespace || tok.Name.Local != "Signature") {
+				if err := d.Skip(); err != nil {
+					return nil, err
+				}
+				depth--
+			}
+		case xml.EndElement:
+			depth--
+			if depth == 1 {
+				// The end of the signature.
+				return data, nil
+			}
+		}
+	}
+}
+
+// signedMetadataRoot is the root element of a metadata document.
+type signedMetadataRoot struct {
+	XMLName    xml.Name
+	ID         string    `xml:"ID,attr"`
+	ValidUntil time.Time `xml:"validUntil,attr"`
+}
+
+// checkSignedMetadata checks that the root element of data is
+// md:rootLocal, and that the first signature of the document, the one
+// xmlsec1 verifies, is a direct child of the root signing the whole
+// document. It returns the root element, without its children.
+func checkSignedMetadata(data []byte, rootLocal string) (*signedMetadataRoot, error) {
+	if err := checkXML(data); err != nil {
+		return nil, err
+	}
+	var root signedMetadataRoot
+	if err := xml.Unmarshal(data, &root); err != nil {
+		return nil, err
+	}
+	if root.XMLName.Space != mdNamespace || root.XMLName.Local != rootLocal {
+		return nil, errors.Errorf("unexpected root element %s", root.XMLName.Local)
+	}
+
+	d := xml.NewDecoder(bytes.NewReader(data))
+	depth := 0
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			return nil, validationErrorf(ErrMissingSignature, nil, "unsigned metadata")
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch tok := tok.(type) {
+		case xml.StartElement:
+			depth++
+			if tok.Name.Space != dsigNamespace || tok.Name.Local != "Signature" {
+				continue
+			}
+			if depth != 2 {
+				return nil, errors.New("the first signature does not sign the root element")
+			}
+			var sig xmlsec.Signature
+			if err := d.DecodeElement(&sig, &tok); err != nil {
+				return nil, err
+			}
+			if sig.Reference.URI != "" && sig.Reference.URI != "#"+root.ID {
+				return nil, errors.Errorf("unexpected signature reference %q", sig.Reference.URI)
+			}
+			return &root, nil
+		case xml.EndElement:
+			depth--
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package saml
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/xml"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/goware/saml/xmlsec"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckSignedMetadata(t *testing.T) {
+	const signature = `<ds:Signature xmlns:ds="http://www.w3.org/2000/09/xmldsig#"><ds:SignedInfo><ds:Reference URI="#id-aggregate"/></ds:SignedInfo></ds:Signature>`
+	const entity = `<md:EntityDescriptor entityID="https://idp.example.com"><md:IDPSSODescriptor/></md:EntityDescriptor>`
+	aggregate := func(validUntil, content string) []byte {
+		return []byte(`<md:EntitiesDescriptor xmlns:md="urn:oasis:names:tc:SAML:2.0:metadata" ID="id-aggregate" validUntil="` + validUntil + `">` + content + `</md:EntitiesDescriptor>`)
+	}
+	validUntil := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+
+	root, err := checkSignedMetadata(aggregate(validUntil, signature+entity), "EntitiesDescriptor")
+	if assert.NoError(t, err) {
+		assert.Equal(t, "id-aggregate", root.ID)
+		assert.False(t, root.ValidUntil.IsZero())
+	}
+
+	_, err = checkSignedMetadata(aggregate(validUntil, entity), "EntitiesDescriptor")
+	assert.True(t, errors.Is(err, ErrMissingSignature), "%v", err)
+	_, err = checkSignedMetadata(aggregate(validUntil, signature+entity), "EntityDescriptor")
+	assert.Error(t, err)
+
+	// The first signature must be the one of the root element.
+	nested := `<md:EntityDescriptor entityID="https://idp.example.com">` + signature + `</md:EntityDescriptor>`
+	_, err = checkSignedMetadata(aggregate(validUntil, nested+signature), "EntitiesDescriptor")
+	assert.Error(t, err)
+	other := `<ds:Signature xmlns:ds="http://www.w3.org/2000/09/xmldsig#"><ds:SignedInfo><ds:Reference URI="#id-other"/></ds:SignedInfo></ds:Signature>`
+	_, err = checkSignedMetadata(aggregate(validUntil, other+entity), "EntitiesDescriptor")
+	assert.Error(t, err)
+}
+
+func TestFederation(t *testing.T) {
+	tearUp()
+
+	var path string
+	var body string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.EscapedPath()
+		w.Write([]byte(body))
+	}))
+	defer server.Close()
+
+	_, _, root := newTestKeyPair(t, KeyPairOptions{Type: KeyTypeECDSA})
+	f := &Federation{AggregateURL: server.URL + "/aggregate.xml", MDQURL: server.URL + "/mdq/"}
+
+	// The metadata of federations must be verified with its roots.
+	body = `<md:EntitiesDescriptor xmlns:md="urn:oasis:names:tc:SAML:2.0:metadata"/>`
+	_, err := f.Entities(context.Background())
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "no federation roots")
+	}
+
+	f.Roots = []*x509.Certificate{root}
+	_, err = f.Entities(context.Background())
+	assert.True(t, errors.Is(err, ErrMissingSignature), "%v", err)
+
+	body = `<md:EntitiesDescriptor xmlns:md="urn:oasis:names:tc:SAML:2.0:metadata" validUntil="2001-01-01T00:00:00Z"><ds:Signature xmlns:ds="http://www.w3.org/2000/09/xmldsig#"/></md:EntitiesDescriptor>`
+	_, err = f.Entities(context.Background())
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "metadata expired")
+	}
+
+	// The validity is checked with the clock of the federation.
+	f.Clock = fixedClock(time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC))
+	_, err = f.Entities(context.Background())
+	if assert.Error(t, err) {
+		assert.NotContains(t, err.Error(), "metadata expired")
+	}
+	f.Clock = nil
+
+	body = `<md:EntityDescriptor xmlns:md="urn:oasis:names:tc:SAML:2.0:metadata" entityID="https://idp.example.com/saml"/>`
+	_, err = f.Entity(context.Background(), "https://idp.example.com/saml")
+	assert.True(t, errors.Is(err, ErrMissingSignature), "%v", err)
+	assert.Equal(t, "/mdq/entities/https:%2F%2Fidp.example.com%2Fsaml", path)
+}
+
+func TestStripKeyInfo(t *testing.T) {
+	const keyInfo = `<ds:KeyInfo><ds:X509Data><ds:X509Certificate>MIIB</ds:X509Certificate></ds:X509Data></ds:KeyInfo>`
+	signature := func(keyInfo string) string {
+		return `<ds:Signature xmlns:ds="http://www.w3.org/2000/09/xmldsig#"><ds:SignedInfo><ds:Reference URI=""/></ds:SignedInfo>` + keyInfo + `</ds:Signature>`
+	}
+	aggregate := func(content string) []byte {
+		return []byte(`<md:EntitiesDescriptor xmlns:md="urn:oasis:names:tc:SAML:2.0:metadata">` + content + `</md:EntitiesDescriptor>`)
+	}
+	// Only the KeyInfo of the signature of the root is removed.
+	entity := `<md:EntityDescriptor entityID="https://idp.example.com">` + signature(keyInfo) + `</md:EntityDescriptor>`
+
+	stripped, err := stripKeyInfo(aggregate(signature(keyInfo) + entity))
+	if assert.NoError(t, err) {
+		assert.Equal(t, string(aggregate(signature("")+entity)), string(stripped))
+	}
+	stripped, err = stripKeyInfo(aggregate(signature("") + entity))
+	if assert.NoError(t, err) {
+		assert.Equal(t, string(aggregate(signature("")+entity)), string(stripped))
+	}
+}
+
+func TestFederationForeignSigner(t *testing.T) {
+	if _, err := exec.LookPath("xmlsec1"); err != nil {
+		t.Skip("xmlsec1 not found")
+	}
+	tearUp()
+
+	sign := func(keyPEM, certPEM string) []byte {
+		sig, err := xml.Marshal(xmlsec.DefaultSignature([]byte(certPEM)))
+		assert.NoError(t, err)
+		keyFile, err := writeFile([]byte(keyPEM))
+		assert.NoError(t, err)
+		signed, err := signXML([]byte(`<md:EntitiesDescriptor xmlns:md="urn:oasis:names:tc:SAML:2.0:metadata" ID="id-aggregate">`+
+			string(sig)+`<md:EntityDescriptor entityID="https://idp.example.com"/></md:EntitiesDescriptor>`), keyFile)
+		if !assert.NoError(t, err) {
+			t.FailNow()
+		}
+		return signed
+	}
+	rootKey, rootCert, root := newTestKeyPair(t, KeyPairOptions{})
+	foreignKey, foreignCert, _ := newTestKeyPair(t, KeyPairOptions{})
+	f := &Federation{Roots: []*x509.Certificate{root}}
+
+	assert.NoError(t, f.verify(sign(rootKey, rootCert), "EntitiesDescriptor"))
+
+	// The certificate of the KeyInfo is not trusted, even self-signed.
+	err := f.verify(sign(foreignKey, foreignCert), "EntitiesDescriptor")
+	assert.True(t, errors.Is(err, ErrInvalidSignature), "%v", err)
+	forged := strings.Replace(string(sign(foreignKey, foreignCert)), base64Cert(foreignCert), base64Cert(rootCert), 1)
+	err = f.verify([]byte(forged), "EntitiesDescriptor")
+	assert.True(t, errors.Is(err, ErrInvalidSignature), "%v", err)
+}
+
+// base64Cert returns the base64 DER of the PEM certificate certPEM, as in a
+// KeyInfo.
+func base64Cert(certPEM string) string {
+	sig := xmlsec.DefaultSignature([]byte(certPEM))
+	return sig.X509Certificate.X509Certificate
+}
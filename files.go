@@ -4,6 +4,7 @@ import (
 	"crypto/sha1"
 	"fmt"
 	"os"
+	"sync/atomic"
 )
 
 // WorkDir is a temporary directory for files. We need to write keys to disk in
@@ -39,3 +40,31 @@ func writeFile(buf []byte) (string, error) {
 
 	return fileName, nil
 }
+
+// fileCache remembers the file written by writeFile for a given key, e.g.
+// the base64 certificate of the IdP, so that the content is not rebuilt and
+// hashed again for every message.
+type fileCache struct {
+	v atomic.Value // of cachedFile
+}
+
+type cachedFile struct {
+	key  string
+	name string
+}
+
+// get returns the file of key, writing the data returned by content to a
+// new file if key changed or the file was removed.
+func (c *fileCache) get(key string, content func() []byte) (string, error) {
+	if f, ok := c.v.Load().(cachedFile); ok && f.key == key {
+		if _, err := os.Stat(f.name); err == nil {
+			return f.name, nil
+		}
+	}
+	name, err := writeFile(content())
+	if err != nil {
+		return "", err
+	}
+	c.v.Store(cachedFile{key: key, name: name})
+	return name, nil
+}
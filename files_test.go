@@ -0,0 +1,44 @@
+package saml
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileCache(t *testing.T) {
+	WorkDir = t.TempDir()
+	defer func() { WorkDir = "/tmp" }()
+
+	var c fileCache
+	calls := 0
+	content := func() []byte {
+		calls++
+		return []byte("content")
+	}
+
+	name, err := c.get("k", content)
+	assert.NoError(t, err)
+	buf, _ := os.ReadFile(name)
+	assert.Equal(t, "content", string(buf))
+
+	again, err := c.get("k", content)
+	assert.NoError(t, err)
+	assert.Equal(t, name, again)
+	assert.Equal(t, 1, calls)
+
+	// The file is written again if it was removed.
+	os.Remove(name)
+	again, err = c.get("k", content)
+	assert.NoError(t, err)
+	assert.Equal(t, name, again)
+	assert.Equal(t, 2, calls)
+	assert.FileExists(t, name)
+
+	_, err = c.get("other", func() []byte { return []byte("other") })
+	assert.NoError(t, err)
+	_, err = c.get("k", content)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, calls)
+}
@@ -0,0 +1,97 @@
+package saml
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/base64"
+	"encoding/xml"
+	"path/filepath"
+	"testing"
+)
+
+// The fuzz targets are seeded with the interop corpus. Run them with e.g.
+//
+//	go test -run='^$' -fuzz=FuzzValidateResponse
+
+func addInteropSeeds(f *testing.F) {
+	files, err := filepath.Glob(filepath.Join("_testdata", "interop", "*.xml"))
+	if err != nil {
+		f.Fatal(err)
+	}
+	for _, name := range files {
+		f.Add(readInteropFile(f, filepath.Base(name)))
+	}
+}
+
+func FuzzValidateResponse(f *testing.F) {
+	addInteropSeeds(f)
+	f.Add([]byte(`<Response xmlns="urn:oasis:names:tc:SAML:2.0:protocol"/>`))
+
+	sp := newInteropSP(f, "http://www.okta.com/exk1a2b3c4d5e6f7g8h9")
+	f.Fuzz(func(t *testing.T, data []byte) {
+		result := sp.ValidateResponse(base64.StdEncoding.EncodeToString(data), nil, interopNow)
+		if result.Valid() {
+			t.Fatalf("unsigned response accepted:\n%s", result)
+		}
+	})
+}
+
+func FuzzUnmarshalResponse(f *testing.F) {
+	addInteropSeeds(f)
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		if checkXML(data) != nil || checkSignedStructure(data, samlpNamespace, "Response") != nil {
+			return
+		}
+		var res Response
+		if xml.Unmarshal(data, &res) != nil {
+			return
+		}
+		NewAttributesMap(res.Assertion)
+		if res.Assertion != nil && res.Assertion.Signature != nil {
+			decodeElementByID(data, res.Assertion.ID, &Assertion{})
+		}
+	})
+}
+
+func FuzzUnmarshalMetadata(f *testing.F) {
+	sp := newInteropSP(f, "https://idp.example.com")
+	buf, err := xml.Marshal(sp.IdPMetadata)
+	if err != nil {
+		f.Fatal(err)
+	}
+	f.Add(buf)
+	f.Add([]byte(`<EntityDescriptor xmlns="urn:oasis:names:tc:SAML:2.0:metadata" validUntil="2024-05-14T09:30:00Z" cacheDuration="PT1H"/>`))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var metadata Metadata
+		if xml.Unmarshal(data, &metadata) != nil {
+			return
+		}
+		if _, err := xml.Marshal(&metadata); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+func FuzzDecodeRedirect(f *testing.F) {
+	var buf bytes.Buffer
+	w, _ := flate.NewWriter(&buf, flate.BestCompression)
+	w.Write([]byte(`<samlp:AuthnRequest xmlns:samlp="urn:oasis:names:tc:SAML:2.0:protocol" ID="id-1"/>`))
+	w.Close()
+	f.Add(base64.StdEncoding.EncodeToString(buf.Bytes()))
+	f.Add("")
+
+	limits := SizeLimits{MaxXMLSize: 4096}
+	f.Fuzz(func(t *testing.T, value string) {
+		data, err := limits.decodeRedirect("SAMLRequest", value)
+		if err != nil {
+			return
+		}
+		if int64(len(data)) > limits.MaxXMLSize {
+			t.Fatalf("inflated to %d bytes", len(data))
+		}
+		var req AuthnRequest
+		xml.Unmarshal(data, &req)
+	})
+}
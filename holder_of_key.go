@@ -0,0 +1,111 @@
+package saml
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"net/http"
+	"net/url"
+
+	"github.com/pkg/errors"
+)
+
+// SubjectConfirmationMethodHolderOfKey is the method of the subject
+// confirmations of the Holder-of-Key Web Browser SSO profile: the subject is
+// the holder of the key of the KeyInfo of the confirmation, which it proves
+// with the TLS client certificate of its connection to the ACS.
+//
+// See http://docs.oasis-open.org/security/saml/Post2.0/sstc-saml-holder-of-key-browser-sso.pdf
+const SubjectConfirmationMethodHolderOfKey = "urn:oasis:names:tc:SAML:2.0:cm:holder-of-key"
+
+// HolderOfKeyCheck enables the holder-of-key subject confirmations, which
+// are verified against the TLS client certificate of the user agent posting
+// the response.
+type HolderOfKeyCheck struct {
+	// Required rejects the assertions without a holder-of-key
+	// confirmation, i.e. with only bearer confirmations.
+	Required bool
+
+	// CertificateHeader, when set, is the header in which the reverse proxy
+	// terminating TLS passes the client certificate, either PEM-encoded and
+	// URL-escaped, as the $ssl_client_escaped_cert variable of nginx, or
+	// base64-encoded DER. The proxy must remove this header from the
+	// requests of the clients.
+	CertificateHeader string
+}
+
+// ClientCertificate returns the TLS client certificate of the user agent
+// that sent r: the certificate of the TLS connection, or else the one passed
+// by the reverse proxy in CertificateHeader. c may be nil, to only look at
+// the TLS connection.
+func (c *HolderOfKeyCheck) ClientCertificate(r *http.Request) *x509.Certificate {
+	if r.TLS != nil && len(r.TLS.PeerCertificates) > 0 {
+		return r.TLS.PeerCertificates[0]
+	}
+	if c == nil || c.CertificateHeader == "" {
+		return nil
+	}
+	value := r.Header.Get(c.CertificateHeader)
+	if value == "" {
+		return nil
+	}
+	der, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		if value, err = url.PathUnescape(value); err != nil {
+			return nil
+		}
+		block, _ := pem.Decode([]byte(value))
+		if block == nil || block.Type != "CERTIFICATE" {
+			return nil
+		}
+		der = block.Bytes
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil
+	}
+	return cert
+}
+
+// accepts returns whether the subject confirmations of the given method
+// may confirm the subject.
+func (c *HolderOfKeyCheck) accepts(method string) bool {
+	switch method {
+	case SubjectConfirmationMethodBearer:
+		return c == nil || !c.Required
+	case SubjectConfirmationMethodHolderOfKey:
+		return c != nil
+	}
+	return false
+}
+
+// check verifies that the client certificate holds the key of one of the
+// KeyInfo of a holder-of-key subject confirmation.
+func (c *HolderOfKeyCheck) check(data *SubjectConfirmationData, clientCert *x509.Certificate) error {
+	if clientCert == nil {
+		return errors.New("no client certificate")
+	}
+	if len(data.KeyInfos) == 0 {
+		return errors.New("missing SubjectConfirmationData > KeyInfo")
+	}
+	for _, keyInfo := range data.KeyInfos {
+		cert, err := parseKeyInfoCertificate(keyInfo.Certificate)
+		if err != nil {
+			continue
+		}
+		if bytes.Equal(cert.RawSubjectPublicKeyInfo, clientCert.RawSubjectPublicKeyInfo) {
+			return nil
+		}
+	}
+	return errors.Errorf("the key of the client certificate %q is not confirmed", clientCert.Subject)
+}
+
+// checkHolderOfKey verifies a holder-of-key subject confirmation against the
+// client certificate. The bearer confirmations need no verification.
+func (sp *ServiceProvider) checkHolderOfKey(sc *SubjectConfirmation, clientCert *x509.Certificate) error {
+	if sc.Method != SubjectConfirmationMethodHolderOfKey {
+		return nil
+	}
+	return sp.HolderOfKeyCheck.check(&sc.SubjectConfirmationData, clientCert)
+}
@@ -0,0 +1,115 @@
+package saml
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestClientCert(t *testing.T) *x509.Certificate {
+	_, certPEM, err := GenerateKeyPair(KeyPairOptions{Type: KeyTypeECDSA, CommonName: "jane"})
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	block, _ := pem.Decode([]byte(certPEM))
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	return cert
+}
+
+func TestHolderOfKeyClientCertificate(t *testing.T) {
+	cert := newTestClientCert(t)
+	check := &HolderOfKeyCheck{}
+
+	r := httptest.NewRequest("POST", "/saml/acs", nil)
+	r.Header.Set("X-Client-Cert", base64.StdEncoding.EncodeToString(cert.Raw))
+	assert.Nil(t, check.ClientCertificate(r))
+
+	check.CertificateHeader = "X-Client-Cert"
+	assert.Equal(t, cert, check.ClientCertificate(r))
+	r.Header.Set("X-Client-Cert", url.PathEscape(string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}))))
+	assert.Equal(t, cert, check.ClientCertificate(r))
+	r.Header.Set("X-Client-Cert", "garbage")
+	assert.Nil(t, check.ClientCertificate(r))
+
+	other := newTestClientCert(t)
+	r.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{other}}
+	assert.Equal(t, other, check.ClientCertificate(r))
+}
+
+func TestHolderOfKeyConfirmation(t *testing.T) {
+	tearUp()
+
+	cert, other := newTestClientCert(t), newTestClientCert(t)
+	sp, simpleSign := newSimpleSignTestSP(t)
+	now := Now()
+	validate := func(method string, clientCert *x509.Certificate) *ValidationResult {
+		res := newTestAssertionResponse(sp, now)
+		sc := &res.Assertion.Subject.SubjectConfirmations[0]
+		sc.Method = method
+		if method == SubjectConfirmationMethodHolderOfKey {
+			sc.SubjectConfirmationData.KeyInfos = []KeyInfo{
+				{Certificate: base64.StdEncoding.EncodeToString(other.Raw)},
+				{Certificate: base64.StdEncoding.EncodeToString(cert.Raw)},
+			}
+		}
+		samlResponse := encodeTestResponse(t, res)
+		return sp.validateResponse(context.Background(), samlResponse, simpleSign(samlResponse), []TrackedRequest{{}}, now, nil, clientCert, true)
+	}
+
+	// The holder-of-key confirmations are only accepted with the
+	// HolderOfKeyCheck.
+	result := validate(SubjectConfirmationMethodHolderOfKey, cert)
+	assert.True(t, errors.Is(result.Err(), ErrNoBearerConfirmation), "%v", result.Err())
+
+	sp.HolderOfKeyCheck = &HolderOfKeyCheck{}
+	result = validate(SubjectConfirmationMethodHolderOfKey, cert)
+	assert.NoError(t, result.Err())
+	assert.Contains(t, result.Passed, CheckHolderOfKey)
+
+	result = validate(SubjectConfirmationMethodHolderOfKey, newTestClientCert(t))
+	assert.True(t, errors.Is(result.Err(), ErrWrongKey), "%v", result.Err())
+	result = validate(SubjectConfirmationMethodHolderOfKey, nil)
+	assert.True(t, errors.Is(result.Err(), ErrWrongKey), "%v", result.Err())
+
+	assert.NoError(t, validate(SubjectConfirmationMethodBearer, nil).Err())
+	sp.HolderOfKeyCheck.Required = true
+	result = validate(SubjectConfirmationMethodBearer, cert)
+	assert.True(t, errors.Is(result.Err(), ErrWrongKey), "%v", result.Err())
+}
+
+func TestAssertionMiddlewareHolderOfKey(t *testing.T) {
+	tearUp()
+
+	cert := newTestClientCert(t)
+	sp, simpleSign := newSimpleSignTestSP(t)
+	sp.HolderOfKeyCheck = &HolderOfKeyCheck{Required: true}
+	res := newTestAssertionResponse(sp, Now())
+	sc := &res.Assertion.Subject.SubjectConfirmations[0]
+	sc.Method = SubjectConfirmationMethodHolderOfKey
+	sc.SubjectConfirmationData.KeyInfos = []KeyInfo{{Certificate: base64.StdEncoding.EncodeToString(cert.Raw)}}
+	samlResponse := encodeTestResponse(t, res)
+	sig := simpleSign(samlResponse)
+	r := httptest.NewRequest("POST", sp.AcsURL, strings.NewReader(url.Values{
+		"SAMLResponse": {samlResponse},
+		"SigAlg":       {sig.SigAlg},
+		"Signature":    {base64.StdEncoding.EncodeToString(sig.Signature)},
+	}.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}
+	w := httptest.NewRecorder()
+	sp.AssertionMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).ServeHTTP(w, r)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+}
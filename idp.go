@@ -1,11 +1,12 @@
 package saml
 
 import (
-	"bytes"
+	"crypto"
+	"crypto/x509"
 	"encoding/base64"
 	"encoding/pem"
 	"encoding/xml"
-	"errors"
+	"html/template"
 	"io/ioutil"
 	"net/http"
 	"os"
@@ -13,6 +14,7 @@ import (
 	"time"
 
 	"github.com/goware/saml/xmlsec"
+	"github.com/pkg/errors"
 )
 
 // Session represents a user session. It is returned by the
@@ -57,6 +59,10 @@ type IdentityProvider struct {
 	PrivkeyPEM string
 	PubkeyPEM  string
 
+	// KeyPolicy is the policy of the keys of the IdP and of the
+	// certificates of the SP. When nil, they are not checked.
+	KeyPolicy *KeyPolicy
+
 	SSOURL      string
 	MetadataURL string
 
@@ -69,9 +75,72 @@ type IdentityProvider struct {
 
 	SecurityOpts
 
+	// SizeLimits bounds the size of the messages received by the IdP.
+	SizeLimits
+
+	// Clock is used for all the time comparisons and the timestamps of the
+	// messages generated by the IdP. When nil, the package-level Now is used.
+	Clock Clock
+
+	// IDGenerator generates the IDs of the messages created by the IdP. When
+	// nil, the package-level NewID is used.
+	IDGenerator IDGenerator
+
+	// Logger receives the IdP's log messages. When nil, messages are written
+	// to the standard logger and debug messages are dropped.
+	Logger Logger
+
+	// OnFailure is called for every request the IdP's HTTP handlers fail to
+	// serve. When nil, internal failures are logged as errors and client
+	// failures as debug messages.
+	OnFailure FailureFunc
+
+	// PostFormTemplate renders the form posting the responses to the SPs,
+	// see WritePostForm. When nil, DefaultPostFormTemplate is used.
+	PostFormTemplate *template.Template
+
+	// SimpleSignResponses signs the forms posting the responses with the
+	// HTTP-POST-SimpleSign binding, for SPs which do not verify XML
+	// signatures. The binding is also used when the ACSEndpoint of the
+	// request has it.
+	SimpleSignResponses bool
+
+	// AssertionIDRequestURL, when set, is the location of
+	// AssertionIDRequestHandler, published in the metadata with the SOAP
+	// binding.
+	AssertionIDRequestURL string
+
+	// AssertionStore keeps the assertions issued by the IdP for
+	// AssertionIDRequestHandler.
+	AssertionStore AssertionStore
+
+	// ManageNameIDURL and NameIDMappingURL, when set, are the locations of
+	// ManageNameIDHandler and NameIDMappingHandler, published in the
+	// metadata with the SOAP binding.
+	ManageNameIDURL  string
+	NameIDMappingURL string
+
+	// NameIDManager manages the persistent identifiers for
+	// ManageNameIDHandler and NameIDMappingHandler.
+	NameIDManager NameIDManager
+
 	pemCert atomic.Value
 }
 
+func (idp *IdentityProvider) newID() string {
+	if idp.IDGenerator == nil {
+		return NewID()
+	}
+	return idp.IDGenerator()
+}
+
+func (idp *IdentityProvider) now() time.Time {
+	if idp.Clock == nil {
+		return Now()
+	}
+	return idp.Clock.Now()
+}
+
 // PrivkeyFile returns a physical path where the IdP's key can be accessed.
 func (idp *IdentityProvider) PrivkeyFile() (string, error) {
 	if idp.KeyFile != "" {
@@ -83,14 +152,32 @@ func (idp *IdentityProvider) PrivkeyFile() (string, error) {
 	return "", errors.New("No private key given.")
 }
 
+// signingKey returns the IdP's private key.
+func (idp *IdentityProvider) signingKey() (crypto.Signer, error) {
+	data := []byte(idp.PrivkeyPEM)
+	if idp.KeyFile != "" {
+		var err error
+		if data, err = ioutil.ReadFile(idp.KeyFile); err != nil {
+			return nil, errors.Wrap(err, "failed to read private key")
+		}
+	} else if idp.PrivkeyPEM == "" {
+		return nil, errors.New("No private key given.")
+	}
+	return parsePrivateKey(data)
+}
+
 // PubkeyFile returns a physical path where the IdP's public key can be
 // accessed.
 func (idp *IdentityProvider) PubkeyFile() (string, error) {
 	if idp.CertFile != "" {
-		return validateKeyFile(idp.CertFile, nil)
+		return validateKeyFile(idp.CertFile, idp.now())
 	}
 	if idp.PubkeyPEM != "" {
-		return validateKeyFile(writeFile([]byte(idp.PubkeyPEM)))
+		file, err := writeFile([]byte(idp.PubkeyPEM))
+		if err != nil {
+			return "", err
+		}
+		return validateKeyFile(file, idp.now())
 	}
 	return "", errors.New("No public key given.")
 }
@@ -120,6 +207,13 @@ func (idp *IdentityProvider) Cert() (*pem.Block, error) {
 	if cert == nil {
 		return nil, errors.New("Invalid certificate.")
 	}
+	c, err := x509.ParseCertificate(cert.Bytes)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid certificate")
+	}
+	if err := idp.KeyPolicy.checkCertificate(c, "", idp.now(), false); err != nil {
+		return nil, errors.Wrap(err, "invalid certificate")
+	}
 
 	idp.pemCert.Store(cert)
 
@@ -136,7 +230,7 @@ func (idp *IdentityProvider) Metadata() (*Metadata, error) {
 
 	metadata := &Metadata{
 		EntityID:   idp.MetadataURL,
-		ValidUntil: Now().Add(defaultValidDuration),
+		ValidUntil: idp.now().Add(defaultValidDuration),
 		IDPSSODescriptor: &IDPSSODescriptor{
 			ProtocolSupportEnumeration: "urn:oasis:names:tc:SAML:2.0:protocol",
 			KeyDescriptor: []KeyDescriptor{
@@ -174,6 +268,24 @@ func (idp *IdentityProvider) Metadata() (*Metadata, error) {
 			},
 		},
 	}
+	if idp.ManageNameIDURL != "" {
+		metadata.IDPSSODescriptor.ManageNameIDService = []Endpoint{{
+			Binding:  SOAPBinding,
+			Location: idp.ManageNameIDURL,
+		}}
+	}
+	if idp.NameIDMappingURL != "" {
+		metadata.IDPSSODescriptor.NameIDMappingService = []Endpoint{{
+			Binding:  SOAPBinding,
+			Location: idp.NameIDMappingURL,
+		}}
+	}
+	if idp.AssertionIDRequestURL != "" {
+		metadata.IDPSSODescriptor.AssertionIDRequestService = []Endpoint{{
+			Binding:  SOAPBinding,
+			Location: idp.AssertionIDRequestURL,
+		}}
+	}
 
 	return metadata, nil
 }
@@ -307,9 +419,10 @@ func (req *IdpAuthnRequest) MakeAssertion(session *Session) error {
 		return ""
 	}
 
+	now := req.IDP.now()
 	req.Assertion = &Assertion{
-		ID:           NewID(),
-		IssueInstant: Now(),
+		ID:           req.IDP.newID(),
+		IssueInstant: now,
 		Version:      "2.0",
 		Issuer: &Issuer{
 			Format: "XXX",
@@ -323,12 +436,12 @@ func (req *IdpAuthnRequest) MakeAssertion(session *Session) error {
 				SPNameQualifier: spNameQualifier(),
 				Value:           session.NameID,
 			},
-			SubjectConfirmation: &SubjectConfirmation{
-				Method: "urn:oasis:names:tc:SAML:2.0:cm:bearer",
+			SubjectConfirmations: []SubjectConfirmation{{
+				Method: SubjectConfirmationMethodBearer,
 				SubjectConfirmationData: SubjectConfirmationData{
 					Address:      req.HTTPRequest.RemoteAddr,
 					InResponseTo: req.Request.ID,
-					NotOnOrAfter: Now().Add(IssueLifetime),
+					NotOnOrAfter: now.Add(IssueLifetime),
 					Recipient: func() string {
 						switch {
 						case req.ACSEndpoint != nil:
@@ -345,16 +458,16 @@ func (req *IdpAuthnRequest) MakeAssertion(session *Session) error {
 						return ""
 					}(),
 				},
-			},
+			}},
 		},
 		Conditions: &Conditions{
-			NotBefore:    Now(),
-			NotOnOrAfter: Now().Add(IssueLifetime),
-			AudienceRestriction: func() *AudienceRestriction {
+			NotBefore:    now,
+			NotOnOrAfter: now.Add(IssueLifetime),
+			AudienceRestrictions: func() []AudienceRestriction {
 				if req.ServiceProviderMetadata != nil {
-					return &AudienceRestriction{
-						Audience: &Audience{Value: req.ServiceProviderMetadata.EntityID},
-					}
+					return []AudienceRestriction{{
+						Audiences: []Audience{{Value: req.ServiceProviderMetadata.EntityID}},
+					}}
 				}
 				return nil
 			}(),
@@ -367,7 +480,7 @@ func (req *IdpAuthnRequest) MakeAssertion(session *Session) error {
 			},
 			AuthnContext: AuthnContext{
 				AuthnContextClassRef: &AuthnContextClassRef{
-					Value: "urn:oasis:names:tc:SAML:2.0:ac:classes:PasswordProtectedTransport",
+					Value: AuthnContextPasswordProtectedTransport,
 				},
 			},
 		},
@@ -415,23 +528,8 @@ func (req *IdpAuthnRequest) MarshalAssertion() error {
 		return err
 	}
 
-	// EncryptedDataTemplate
-	tpl := xmlsec.NewEncryptedDataTemplate(
-		"http://www.w3.org/2001/04/xmlenc#aes128-cbc",
-		"http://www.w3.org/2001/04/xmlenc#rsa-oaep-mgf1p",
-	)
-
-	// TODO: pick an encryption algorithm from the actual metadata.
-	buf, err = xmlsec.Encrypt(tpl, buf, spCertFile, "aes-128-cbc")
-	if err != nil {
-		if IsSecurityException(err, &req.IDP.SecurityOpts) {
-			return err
-		}
-	}
-
-	req.AssertionBuffer = bytes.TrimSpace(bytes.TrimPrefix(buf, []byte(`<?xml version="1.0"?>`)))
-
-	return nil
+	req.AssertionBuffer, err = encryptXML(buf, spCertFile)
+	return err
 }
 
 // MakeResponse computes the Response field of the IdpAuthnRequest
@@ -443,10 +541,10 @@ func (req *IdpAuthnRequest) MakeResponse() error {
 	}
 
 	req.Response = &Response{
-		Destination:  req.Assertion.Subject.SubjectConfirmation.SubjectConfirmationData.Recipient,
-		ID:           NewID(),
+		Destination:  req.Assertion.Subject.SubjectConfirmations[0].SubjectConfirmationData.Recipient,
+		ID:           req.IDP.newID(),
 		InResponseTo: req.Request.ID,
-		IssueInstant: Now(),
+		IssueInstant: req.IDP.now(),
 		Version:      "2.0",
 		Issuer: &Issuer{
 			Format: "urn:oasis:names:tc:SAML:2.0:nameid-format:entity",
@@ -467,6 +565,22 @@ func (req *IdpAuthnRequest) MakeResponse() error {
 	return nil
 }
 
+// PostForm returns the form posting the response, marshaled as buf, to the
+// SP with relayState. The form is signed with the HTTP-POST-SimpleSign
+// binding if the IdP or the ACSEndpoint asks for it.
+func (req *IdpAuthnRequest) PostForm(buf []byte, relayState string) (PostForm, error) {
+	form := NewPostForm(req.Response.Destination, "SAMLResponse", buf, relayState)
+	if !req.IDP.SimpleSignResponses && (req.ACSEndpoint == nil || req.ACSEndpoint.Binding != HTTPPostSimpleSignBinding) {
+		return form, nil
+	}
+	key, err := req.IDP.signingKey()
+	if err != nil {
+		return form, err
+	}
+	err = form.SimpleSign(key)
+	return form, err
+}
+
 // GetSPCertFile returns a physical path where the SP's certificate can be
 // accessed.
 func (idp *IdentityProvider) GetSPCertFile() (string, error) {
@@ -479,10 +593,10 @@ func (idp *IdentityProvider) GetSPCertFile() (string, error) {
 		return "", errors.New("Missing SPSSODescriptor data")
 	}
 
-	cert := ""
+	cert, use := "", ""
 	for _, keyDescriptor := range meta.SPSSODescriptor.KeyDescriptor {
 		if keyDescriptor.Use == "encryption" {
-			cert = keyDescriptor.KeyInfo.Certificate
+			cert, use = keyDescriptor.KeyInfo.Certificate, keyDescriptor.Use
 			break
 		}
 	}
@@ -490,7 +604,7 @@ func (idp *IdentityProvider) GetSPCertFile() (string, error) {
 	if cert == "" {
 		for _, keyDescriptor := range meta.SPSSODescriptor.KeyDescriptor {
 			if keyDescriptor.KeyInfo.Certificate != "" {
-				cert = keyDescriptor.KeyInfo.Certificate
+				cert, use = keyDescriptor.KeyInfo.Certificate, keyDescriptor.Use
 				break
 			}
 		}
@@ -500,7 +614,14 @@ func (idp *IdentityProvider) GetSPCertFile() (string, error) {
 		return "", errors.New("Missing certificate data.")
 	}
 
-	certBytes, _ := base64.StdEncoding.DecodeString(cert)
+	c, err := parseKeyInfoCertificate(cert)
+	if err == nil {
+		err = idp.KeyPolicy.checkCertificate(c, use, idp.now(), true)
+	}
+	if err != nil {
+		return "", errors.Wrap(err, "invalid SP certificate")
+	}
+	certBytes := c.Raw
 
 	certBytes = pem.EncodeToMemory(&pem.Block{
 		Type:  "CERTIFICATE",
@@ -1,28 +1,22 @@
 package saml
 
 import (
-	"bytes"
-	"compress/flate"
-	"encoding/base64"
 	"encoding/xml"
-	"io/ioutil"
-	"log"
 	"net/http"
-	"text/template"
+
+	"github.com/pkg/errors"
 )
 
 // MetadataHandler generates and serves the IdP's metadata.xml file.
 func (idp *IdentityProvider) MetadataHandler(w http.ResponseWriter, r *http.Request) {
 	metadata, err := idp.Metadata()
 	if err != nil {
-		log.Printf("Failed to generate metadata: %v", err)
-		writeErr(w, err)
+		idp.internalErr(w, r, errors.Wrap(err, "failed to generate metadata"))
 		return
 	}
 	out, err := xml.MarshalIndent(metadata, "", "\t")
 	if err != nil {
-		log.Printf("Failed to build metadata: %v", err)
-		writeErr(w, err)
+		idp.internalErr(w, r, errors.Wrap(err, "failed to build metadata"))
 		return
 	}
 	w.Header().Set("Content-Type", "application/xml; charset=utf8")
@@ -34,7 +28,7 @@ func (idp *IdentityProvider) MetadataHandler(w http.ResponseWriter, r *http.Requ
 func (idp *IdentityProvider) NewLoginRequest(spMetadataURL string, authFn Authenticator) (*LoginRequest, error) {
 	metadata, err := GetMetadata(spMetadataURL)
 	if err != nil {
-		log.Printf("Failed to get metadata: %v", err)
+		idp.logger().Error("failed to get SP metadata", "url", spMetadataURL, "err", err)
 		return nil, err
 	}
 	lr := &LoginRequest{
@@ -51,33 +45,25 @@ func (idp *IdentityProvider) ServeSSO(authFn Authenticator) func(http.ResponseWr
 	return func(w http.ResponseWriter, r *http.Request) {
 		sess, err := authFn(w, r)
 		if err != nil {
-			log.Printf("authFn: %v", err)
+			idp.fail(r, ClientFailure, errors.Wrap(err, "authFn"))
 			return
 		}
 
-		values := r.URL.Query()
-
-		relayState := values.Get("RelayState")
-		samlRequest := values.Get("SAMLRequest")
-
-		data, err := base64.StdEncoding.DecodeString(samlRequest)
+		msg, err := DecodeRedirect(r.URL.RawQuery, idp.SizeLimits)
 		if err != nil {
-			log.Printf("Failed to decode SAMLRequest: %v", err)
-			writeErr(w, err)
+			idp.clientErr(w, r, err)
 			return
 		}
-		buf, err := ioutil.ReadAll(flate.NewReader(bytes.NewBuffer(data)))
-		if err != nil {
-			log.Printf("Failed to read SAMLRequest: %v", err)
-			writeErr(w, err)
+		if msg.Param != "SAMLRequest" {
+			idp.clientErr(w, r, errors.New("missing SAMLRequest"))
 			return
 		}
+		relayState := msg.RelayState
 
 		var authnRequest AuthnRequest
-		err = xml.Unmarshal(buf, &authnRequest)
+		err = xml.Unmarshal(msg.XML, &authnRequest)
 		if err != nil {
-			log.Printf("Failed to unmarshal SAMLRequest: %v", err)
-			writeErr(w, err)
+			idp.clientErr(w, r, errors.Wrap(err, "failed to unmarshal SAMLRequest"))
 			return
 		}
 
@@ -89,58 +75,68 @@ func (idp *IdentityProvider) ServeSSO(authFn Authenticator) func(http.ResponseWr
 
 		err = idpAuthnRequest.MakeAssertion(sess)
 		if err != nil {
-			log.Printf("Failed to make assertion: %v", err)
-			writeErr(w, err)
+			idp.internalErr(w, r, errors.Wrap(err, "failed to make assertion"))
 			return
 		}
 
 		err = idpAuthnRequest.MarshalAssertion()
 		if err != nil {
-			log.Printf("Failed to marshal assertion: %v", err)
-			writeErr(w, err)
+			idp.internalErr(w, r, errors.Wrap(err, "failed to marshal assertion"))
 			return
 		}
 
 		err = idpAuthnRequest.MakeResponse()
 		if err != nil {
-			log.Printf("Failed to build response: %v", err)
-			writeErr(w, err)
+			idp.internalErr(w, r, errors.Wrap(err, "failed to build response"))
 			return
 		}
 
-		buf, err = xml.MarshalIndent(idpAuthnRequest.Response, "", "\t")
+		buf, err := xml.MarshalIndent(idpAuthnRequest.Response, "", "\t")
 		if err != nil {
-			log.Printf("Failed to format response: %v", err)
-			writeErr(w, err)
+			idp.internalErr(w, r, errors.Wrap(err, "failed to format response"))
 			return
 		}
 
-		form := redirectForm{
-			FormAction:   idpAuthnRequest.Assertion.Subject.SubjectConfirmation.SubjectConfirmationData.Recipient,
-			RelayState:   relayState, // RelayState is passed as is.
-			SAMLResponse: base64.StdEncoding.EncodeToString(buf),
-		}
-
-		formTpl, err := template.New("").Parse(redirectFormTemplate)
+		// RelayState is passed as is.
+		form, err := idpAuthnRequest.PostForm(buf, relayState)
 		if err != nil {
-			log.Printf("Failed to create form: %v", err)
-			writeErr(w, err)
+			idp.internalErr(w, r, errors.Wrap(err, "failed to sign form"))
 			return
 		}
-
-		formBuf := bytes.NewBuffer(nil)
-		if err := formTpl.Execute(formBuf, form); err != nil {
-			log.Printf("Failed to build form: %v", err)
-			writeErr(w, err)
-			return
+		if err := WritePostForm(w, form, idp.PostFormTemplate); err != nil {
+			idp.internalErr(w, r, err)
 		}
+	}
+}
 
-		w.Header().Set("Content-Type", "text/html")
-		w.Write(formBuf.Bytes())
+func (idp *IdentityProvider) logger() Logger {
+	if idp.Logger == nil {
+		return defaultLogger
+	}
+	return idp.Logger
+}
+
+func (idp *IdentityProvider) fail(r *http.Request, level FailureLevel, err error) {
+	if idp.OnFailure != nil {
+		idp.OnFailure(r, level, err)
+		return
 	}
+	logFailure(idp.logger(), r, level, err)
+}
+
+// clientErr reports an error caused by the request sent by the user agent.
+func (idp *IdentityProvider) clientErr(w http.ResponseWriter, r *http.Request, err error) {
+	idp.fail(r, ClientFailure, err)
+	http.Error(w, err.Error(), http.StatusBadRequest)
+}
+
+// internalErr reports an error caused by the IdP configuration or
+// environment.
+func (idp *IdentityProvider) internalErr(w http.ResponseWriter, r *http.Request, err error) {
+	idp.fail(r, InternalFailure, err)
+	writeErr(w, err)
 }
 
 func writeErr(w http.ResponseWriter, err error) {
-	w.WriteHeader(http.StatusInternalServerError)
-	w.Write([]byte(err.Error()))
+	http.Error(w, err.Error(), http.StatusInternalServerError)
 }
@@ -1,38 +1,16 @@
 package saml
 
 import (
-	"bytes"
-	"encoding/base64"
 	"encoding/xml"
-	"log"
 	"net/http"
-	"text/template"
-)
 
-var redirectFormTemplate = `<!DOCTYPE html>
-<html>
-	<head></head>
-	<body>
-		<form id="redirect" method="POST" action="{{.FormAction}}">
-			<input type="hidden" name="RelayState" value="{{.RelayState}}" />
-			<input type="hidden" name="SAMLResponse" value="{{.SAMLResponse}}" />
-		</form>
-		<script type="text/javascript">
-			document.getElementById("redirect").submit();
-		</script>
-	</body>
-</html>`
+	"github.com/pkg/errors"
+)
 
 // Authenticator defines an authentication function that returns a
 // *saml.Session value.
 type Authenticator func(w http.ResponseWriter, r *http.Request) (*Session, error)
 
-type redirectForm struct {
-	FormAction   string
-	RelayState   string
-	SAMLResponse string
-}
-
 // LoginRequest represents a login request that the IdP creates in order to try
 // autenticating against a SP.
 type LoginRequest struct {
@@ -48,7 +26,7 @@ func (lr *LoginRequest) PostForm(w http.ResponseWriter, r *http.Request) {
 
 	sess, err := lr.authFn(w, r)
 	if err != nil {
-		log.Printf("authFn: %v", err)
+		lr.idp.fail(r, ClientFailure, errors.Wrap(err, "authFn"))
 		return
 	}
 
@@ -62,29 +40,25 @@ func (lr *LoginRequest) PostForm(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if err = idpAuthnRequest.MakeAssertion(sess); err != nil {
-		log.Printf("Failed to build assertion %v", err)
-		writeErr(w, err)
+		lr.idp.internalErr(w, r, errors.Wrap(err, "failed to build assertion"))
 		return
 	}
 
 	err = idpAuthnRequest.MarshalAssertion()
 	if err != nil {
-		log.Printf("Failed to marshal assertion %v", err)
-		writeErr(w, err)
+		lr.idp.internalErr(w, r, errors.Wrap(err, "failed to marshal assertion"))
 		return
 	}
 
 	err = idpAuthnRequest.MakeResponse()
 	if err != nil {
-		log.Printf("Failed to build response %v", err)
-		writeErr(w, err)
+		lr.idp.internalErr(w, r, errors.Wrap(err, "failed to build response"))
 		return
 	}
 
 	buf, err := xml.MarshalIndent(idpAuthnRequest.Response, "", "\t")
 	if err != nil {
-		log.Printf("Failed to format response %v", err)
-		writeErr(w, err)
+		lr.idp.internalErr(w, r, errors.Wrap(err, "failed to format response"))
 		return
 	}
 
@@ -96,26 +70,13 @@ func (lr *LoginRequest) PostForm(w http.ResponseWriter, r *http.Request) {
 		relayState, _ = token.(string)
 	}
 
-	form := redirectForm{
-		FormAction:   lr.metadata.SPSSODescriptor.AssertionConsumerService[0].Location,
-		RelayState:   relayState,
-		SAMLResponse: base64.StdEncoding.EncodeToString(buf),
-	}
-
-	formTpl, err := template.New("").Parse(redirectFormTemplate)
+	form, err := idpAuthnRequest.PostForm(buf, relayState)
 	if err != nil {
-		log.Printf("Failed to create form %v", err)
-		writeErr(w, err)
+		lr.idp.internalErr(w, r, errors.Wrap(err, "failed to sign form"))
 		return
 	}
-
-	formBuf := bytes.NewBuffer(nil)
-	if err := formTpl.Execute(formBuf, form); err != nil {
-		log.Printf("Failed to build form %v", err)
-		writeErr(w, err)
-		return
+	form.Action = lr.metadata.SPSSODescriptor.AssertionConsumerService[0].Location
+	if err := WritePostForm(w, form, lr.idp.PostFormTemplate); err != nil {
+		lr.idp.internalErr(w, r, err)
 	}
-
-	w.Header().Set("Content-Type", "text/html")
-	w.Write(formBuf.Bytes())
 }
@@ -0,0 +1,232 @@
+package saml
+
+import (
+	"bytes"
+	"html/template"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// IdPCookieName is the cookie remembering the IdP chosen on the IdP
+// selection page, see ServiceProvider.RememberIdP.
+const IdPCookieName = "saml_idp"
+
+// DefaultIdPSelectionTemplate is the IdP selection page rendered when no
+// template is given: a button per IdP, with its logo, submitting the choice
+// to the discovery response endpoint of the SP.
+var DefaultIdPSelectionTemplate = template.Must(template.New("saml-idp-selection").Parse(`<!DOCTYPE html>
+<html>
+	<head>
+		<meta charset="utf-8" />
+		<title>Choose your identity provider</title>
+	</head>
+	<body>
+		<h1>Choose your identity provider</h1>
+		<form method="GET" action="{{.Action}}">
+			{{- if .RelayState}}
+			<input type="hidden" name="RelayState" value="{{.RelayState}}" />
+			{{- end}}
+			<ul>
+			{{- range .IdPs}}
+				<li>
+					<button type="submit" name="entityID" value="{{.EntityID}}">
+						{{- if .LogoURL}}<img src="{{.LogoURL}}" alt="" height="32" /> {{end -}}
+						{{.DisplayName -}}
+					</button>
+					{{- if .Recent}} (recently used){{end}}
+					{{- if .Description}}
+					<p>{{.Description}}</p>
+					{{- end}}
+				</li>
+			{{- end}}
+			</ul>
+			{{- if .Remember}}
+			<label><input type="checkbox" name="remember" value="1" /> Remember my choice</label>
+			{{- end}}
+		</form>
+	</body>
+</html>
+`))
+
+// IdPSelection is the data rendered by the template of the IdP selection
+// page.
+type IdPSelection struct {
+	// Action is the URL the choice is submitted to, the
+	// DiscoveryResponseURL of the SP, with the entityID parameter.
+	Action string
+
+	// RelayState is to be submitted with the choice, as is.
+	RelayState string
+
+	// IdPs are the IdPs to choose from: the IdPs the user recently
+	// authenticated with first, see ServiceProvider.CommonDomain, and then
+	// the others, sorted by display name.
+	IdPs []IdPChoice
+
+	// Remember tells whether the user may ask to remember the choice, with
+	// the remember parameter set to 1.
+	Remember bool
+}
+
+// IdPChoice describes an IdP on the IdP selection page, from its mdui:UIInfo
+// in the language of the user.
+type IdPChoice struct {
+	EntityID    string
+	DisplayName string
+	Description string
+	LogoURL     string
+
+	// Recent tells whether the user recently authenticated with the IdP,
+	// according to the common domain cookie.
+	Recent bool
+}
+
+// newIdPChoice returns the choice of the IdP described by m in the language
+// lang. The display name defaults to the name of the organization, and then
+// to the entity ID.
+func newIdPChoice(m *Metadata, lang string) IdPChoice {
+	ui := m.UIInfo()
+	choice := IdPChoice{
+		EntityID:    m.EntityID,
+		DisplayName: ui.DisplayName(lang),
+		Description: ui.Description(lang),
+	}
+	if logo, ok := ui.Logo(lang); ok {
+		choice.LogoURL = logo.URL
+	}
+	if choice.DisplayName == "" && m.Organization != nil {
+		choice.DisplayName = localized(m.Organization.DisplayNames, lang)
+	}
+	if choice.DisplayName == "" {
+		choice.DisplayName = m.EntityID
+	}
+	return choice
+}
+
+// preferredLanguage returns the primary language tag of the first language
+// accepted by the user agent of r, or an empty string.
+func preferredLanguage(r *http.Request) string {
+	lang := r.Header.Get("Accept-Language")
+	if i := strings.IndexAny(lang, ",;"); i >= 0 {
+		lang = lang[:i]
+	}
+	if i := strings.IndexByte(lang, '-'); i >= 0 {
+		lang = lang[:i]
+	}
+	lang = strings.TrimSpace(lang)
+	if lang == "*" {
+		return ""
+	}
+	return lang
+}
+
+// IdPSelectionHandler renders the IdP selection page, which lets the users
+// choose an IdP of IdPs without an external discovery service. The choice is
+// submitted to DiscoveryResponseHandler, like the response of a discovery
+// service. The RelayState is read from the "saml.RelayState" context value,
+// if any.
+//
+// AuthnRequestHandler serves this page when the SP has IdPs but no
+// DiscoveryURL.
+func (sp *ServiceProvider) IdPSelectionHandler(w http.ResponseWriter, r *http.Request) {
+	relayState, _ := r.Context().Value("saml.RelayState").(string)
+	sp.serveIdPSelection(w, r, relayState)
+}
+
+func (sp *ServiceProvider) serveIdPSelection(w http.ResponseWriter, r *http.Request, relayState string) {
+	if sp.IdPs == nil {
+		sp.internalErr(w, r, errors.New("the SP has no IdP set"))
+		return
+	}
+	if sp.DiscoveryResponseURL == "" {
+		sp.internalErr(w, r, errors.New("missing discovery response URL"))
+		return
+	}
+
+	lang := preferredLanguage(r)
+	selection := IdPSelection{
+		Action:   sp.DiscoveryResponseURL,
+		Remember: sp.RememberIdP > 0,
+	}
+	if relayState != "" {
+		selection.RelayState = sp.signRelayState(relayState, "")
+	}
+	var recent []IdPChoice
+	for _, entityID := range sp.recentIdPs(r) {
+		if m, ok := sp.IdPs.Lookup(entityID); ok {
+			choice := newIdPChoice(m, lang)
+			choice.Recent = true
+			recent = append(recent, choice)
+		}
+	}
+	for _, m := range sp.IdPs.List() {
+		if !isRecentIdP(recent, m.EntityID) {
+			selection.IdPs = append(selection.IdPs, newIdPChoice(m, lang))
+		}
+	}
+	sort.SliceStable(selection.IdPs, func(i, j int) bool {
+		return strings.ToLower(selection.IdPs[i].DisplayName) < strings.ToLower(selection.IdPs[j].DisplayName)
+	})
+	selection.IdPs = append(recent, selection.IdPs...)
+
+	tmpl := sp.IdPSelectionTemplate
+	if tmpl == nil {
+		tmpl = DefaultIdPSelectionTemplate
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, selection); err != nil {
+		sp.internalErr(w, r, errors.Wrap(err, "failed to build IdP selection page"))
+		return
+	}
+	if w.Header().Get("Content-Security-Policy") == "" {
+		w.Header().Set("Content-Security-Policy", "default-src 'none'; img-src https: data:")
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache, no-store")
+	w.Write(buf.Bytes())
+}
+
+func isRecentIdP(recent []IdPChoice, entityID string) bool {
+	for _, choice := range recent {
+		if choice.EntityID == entityID {
+			return true
+		}
+	}
+	return false
+}
+
+// rememberedIdP returns the SP to use with the IdP remembered by the
+// IdPCookieName cookie of r, or nil.
+func (sp *ServiceProvider) rememberedIdP(r *http.Request) *ServiceProvider {
+	if sp.RememberIdP <= 0 {
+		return nil
+	}
+	cookie, err := r.Cookie(IdPCookieName)
+	if err != nil {
+		return nil
+	}
+	idpSP, err := sp.ForIdP(cookie.Value)
+	if err != nil {
+		return nil
+	}
+	return idpSP
+}
+
+// rememberIdP sets the cookie remembering the IdP with the given entity ID,
+// for RememberIdP.
+func (sp *ServiceProvider) rememberIdP(w http.ResponseWriter, r *http.Request, entityID string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     IdPCookieName,
+		Value:    entityID,
+		Path:     "/",
+		Expires:  sp.now().Add(sp.RememberIdP),
+		MaxAge:   int(sp.RememberIdP / time.Second),
+		Secure:   r.TLS != nil || strings.HasPrefix(sp.DiscoveryResponseURL, "https:"),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
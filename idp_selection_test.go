@@ -0,0 +1,103 @@
+package saml
+
+import (
+	"context"
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIdPSelection(t *testing.T) {
+	tearUp()
+
+	sp := newTestDiscoverySP()
+	sp.DiscoveryURL = ""
+	sp.RememberIdP = 30 * 24 * time.Hour
+	sp.IdPs.Add(&Metadata{
+		EntityID: "https://idp3.example.org",
+		IDPSSODescriptor: &IDPSSODescriptor{
+			Extensions: &Extensions{UIInfo: &UIInfo{
+				DisplayNames: []LocalizedName{{Lang: "en", Value: "Example University"}, {Lang: "fr", Value: "Université Exemple"}},
+				Logos:        []Logo{{Height: 16, Width: 16, URL: "https://idp3.example.org/logo.png"}},
+			}},
+			SingleSignOnService: []Endpoint{{Binding: HTTPRedirectBinding, Location: "https://idp3.example.org/sso"}},
+		},
+	})
+
+	// Without a discovery service, the login starts with the selection page.
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/saml/login", nil)
+	r.Header.Set("Accept-Language", "fr-FR,fr;q=0.9,en;q=0.8")
+	sp.AuthnRequestHandler(w, r.WithContext(context.WithValue(r.Context(), "saml.RelayState", "/home")))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "text/html; charset=utf-8", w.Header().Get("Content-Type"))
+	assert.NotEmpty(t, w.Header().Get("Content-Security-Policy"))
+	body := w.Body.String()
+	assert.Contains(t, body, `action="http://localhost:1235/saml/disco"`)
+	assert.Contains(t, body, `name="RelayState" value="/home"`)
+	assert.Contains(t, body, `value="https://idp3.example.org"><img src="https://idp3.example.org/logo.png" alt="" height="32" /> Université Exemple</button>`)
+	assert.Contains(t, body, `name="remember"`)
+	assert.True(t, strings.Index(body, "idp1.example.org") < strings.Index(body, "idp2.example.org"))
+
+	// The choice is submitted to the discovery response endpoint.
+	w = httptest.NewRecorder()
+	sp.DiscoveryResponseHandler(w, httptest.NewRequest("GET", "/saml/disco?RelayState=%2Fhome&entityID=https%3A%2F%2Fidp3.example.org&remember=1", nil))
+	assert.Equal(t, http.StatusFound, w.Code)
+	assert.True(t, strings.HasPrefix(w.Header().Get("Location"), "https://idp3.example.org/sso?"))
+	cookies := w.Result().Cookies()
+	if assert.Len(t, cookies, 2) {
+		assert.Equal(t, IdPCookieName, cookies[0].Name)
+		assert.Equal(t, "https://idp3.example.org", cookies[0].Value)
+		assert.True(t, cookies[0].HttpOnly)
+		// The AuthnRequest sent to the IdP is tracked.
+		assert.Equal(t, RequestCookiePrefix+"id-MOCKID", cookies[1].Name)
+	}
+
+	// The remembered IdP is used directly.
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest("GET", "/saml/login", nil)
+	r.AddCookie(cookies[0])
+	sp.AuthnRequestHandler(w, r)
+	assert.Equal(t, http.StatusFound, w.Code)
+	assert.True(t, strings.HasPrefix(w.Header().Get("Location"), "https://idp3.example.org/sso?"))
+
+	// Unless the IdP is no longer known.
+	sp.IdPs.Remove("https://idp3.example.org")
+	w = httptest.NewRecorder()
+	sp.AuthnRequestHandler(w, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NotContains(t, w.Body.String(), "idp3")
+
+	// The choice is not remembered unless asked.
+	w = httptest.NewRecorder()
+	sp.DiscoveryResponseHandler(w, httptest.NewRequest("GET", "/saml/disco?entityID=https%3A%2F%2Fidp1.example.org", nil))
+	assert.Equal(t, http.StatusFound, w.Code)
+	for _, cookie := range w.Result().Cookies() {
+		assert.NotEqual(t, IdPCookieName, cookie.Name)
+	}
+
+	sp.IdPSelectionTemplate = template.Must(template.New("").Parse(`{{range .IdPs}}{{.DisplayName}};{{end}}`))
+	w = httptest.NewRecorder()
+	sp.IdPSelectionHandler(w, httptest.NewRequest("GET", "/saml/login", nil))
+	assert.Equal(t, "https://idp1.example.org;https://idp2.example.org;", w.Body.String())
+}
+
+func TestPreferredLanguage(t *testing.T) {
+	for header, lang := range map[string]string{
+		"":                      "",
+		"*":                     "",
+		"fr":                    "fr",
+		"de-CH":                 "de",
+		"en-US,en;q=0.9":        "en",
+		" nl ;q=0.8, en;q=0.5 ": "nl",
+	} {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("Accept-Language", header)
+		assert.Equal(t, lang, preferredLanguage(r), header)
+	}
+}
@@ -3,6 +3,7 @@ package saml
 import (
 	"encoding/xml"
 	"net/http"
+	"net/http/httptest"
 	"testing"
 	"time"
 
@@ -210,3 +211,27 @@ func TestParseAuthenticationRequest(t *testing.T) {
 
 	assert.Equal(t, expectedOutput, string(out))
 }
+
+func TestServeSSOClientFailure(t *testing.T) {
+	tearUp()
+
+	idp := &IdentityProvider{}
+
+	var levels []FailureLevel
+	idp.OnFailure = func(r *http.Request, level FailureLevel, err error) {
+		levels = append(levels, level)
+	}
+
+	authFn := func(w http.ResponseWriter, r *http.Request) (*Session, error) {
+		return &Session{}, nil
+	}
+
+	r := httptest.NewRequest("GET", "/saml/sso?SAMLRequest=%25%25%25", nil)
+	w := httptest.NewRecorder()
+	idp.ServeSSO(authFn)(w, r)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, "text/plain; charset=utf-8", w.Header().Get("Content-Type"))
+	assert.Equal(t, "nosniff", w.Header().Get("X-Content-Type-Options"))
+	assert.Equal(t, []FailureLevel{ClientFailure}, levels)
+}
@@ -0,0 +1,119 @@
+package saml
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// IdPSet is the set of IdPs the users of an SP may log in with, e.g. the
+// IdPs of a federation, keyed by entity ID. It is safe for concurrent use,
+// so that the IdPs can be updated while the SP is serving requests.
+type IdPSet struct {
+	mu   sync.RWMutex
+	idps map[string]*Metadata
+}
+
+// NewIdPSet returns a set of the given IdPs, see Add.
+func NewIdPSet(idps ...*Metadata) *IdPSet {
+	s := &IdPSet{}
+	s.Add(idps...)
+	return s
+}
+
+// Add adds the IdPs to the set, replacing the IdPs with the same entity ID.
+// The entities without IDPSSODescriptor are ignored. The metadata must not
+// be modified once added.
+func (s *IdPSet) Add(idps ...*Metadata) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.idps == nil {
+		s.idps = map[string]*Metadata{}
+	}
+	for _, m := range idps {
+		if m.IDPSSODescriptor != nil {
+			s.idps[m.EntityID] = m
+		}
+	}
+}
+
+// AddEntities adds the IdPs of an aggregate, such as the metadata of a
+// federation, see Add. Federation.Entities downloads the aggregates and
+// verifies their signature.
+func (s *IdPSet) AddEntities(entities *EntitiesDescriptor) {
+	s.Add(entities.EntityDescriptor...)
+}
+
+// Remove removes the IdP with the given entity ID from the set.
+func (s *IdPSet) Remove(entityID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.idps, entityID)
+}
+
+// Lookup returns the metadata of the IdP with the given entity ID.
+func (s *IdPSet) Lookup(entityID string) (*Metadata, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	m, ok := s.idps[entityID]
+	return m, ok
+}
+
+// List returns the IdPs of the set, sorted by entity ID.
+func (s *IdPSet) List() []*Metadata {
+	s.mu.RLock()
+	list := make([]*Metadata, 0, len(s.idps))
+	for _, m := range s.idps {
+		list = append(list, m)
+	}
+	s.mu.RUnlock()
+	sort.Slice(list, func(i, j int) bool { return list[i].EntityID < list[j].EntityID })
+	return list
+}
+
+// Len returns the number of IdPs in the set.
+func (s *IdPSet) Len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.idps)
+}
+
+// ForIdP returns the SP to use with the IdP of IdPs with the given entity
+// ID: a copy of sp whose IdPMetadata is the metadata of that IdP. The copies
+// are kept, so that their files for xmlsec1 are written once.
+func (sp *ServiceProvider) ForIdP(entityID string) (*ServiceProvider, error) {
+	if sp.IdPs == nil {
+		return nil, errors.New("the SP has no IdP set")
+	}
+	metadata, ok := sp.IdPs.Lookup(entityID)
+	if !ok {
+		return nil, errors.Errorf("unknown IdP %q", entityID)
+	}
+
+	metadataMu.Lock()
+	defer metadataMu.Unlock()
+	// The copies are shared with the copies of sp, hence the check of the
+	// parent.
+	if idpSP := sp.idpSPs[entityID]; idpSP != nil && idpSP.parent == sp && idpSP.IdPMetadata == metadata {
+		return idpSP, nil
+	}
+
+	idpSP := new(ServiceProvider)
+	*idpSP = *sp
+	idpSP.IdPs = nil
+	idpSP.IdPMetadataURL = ""
+	idpSP.IdPMetadataXML = nil
+	idpSP.IdPMetadata = metadata
+	idpSP.IdPEntityID = entityID
+	idpSP.idpMetadataValidators = cacheValidators{}
+	idpSP.idpCertFile = fileCache{}
+	idpSP.idpSPs = nil
+	idpSP.parent = sp
+
+	if sp.idpSPs == nil {
+		sp.idpSPs = map[string]*ServiceProvider{}
+	}
+	sp.idpSPs[entityID] = idpSP
+	return idpSP, nil
+}
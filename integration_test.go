@@ -0,0 +1,326 @@
+//go:build integration
+// +build integration
+
+package saml
+
+// The integration tests run a complete SP login, and a single logout,
+// against an independent IdP implementation. They are opt-in:
+//
+//	docker run --rm -p 8080:8080 \
+//		-e SIMPLESAMLPHP_SP_ENTITY_ID=http://localhost:9009/saml/metadata \
+//		-e SIMPLESAMLPHP_SP_ASSERTION_CONSUMER_SERVICE=http://localhost:9009/saml/acs \
+//		-e SIMPLESAMLPHP_SP_SINGLE_LOGOUT_SERVICE=http://localhost:9009/saml/slo \
+//		kenchan0130/simplesamlphp
+//	go test -tags integration -run Integration .
+//
+// The IdP is configured with the environment variables below, with defaults
+// matching the SimpleSAMLphp container. samltest.id can be used as well,
+// after uploading the SP metadata, which the SP serves at its MetadataURL.
+//
+//	SAML_IDP_METADATA_URL   metadata of the IdP
+//	SAML_IDP_USERNAME       user to log in with
+//	SAML_IDP_PASSWORD       password of the user
+//	SAML_SP_URL             base URL of the SP, on which the tests listen
+//	SAML_SP_KEY_FILE        key of the SP, known by the IdP
+//	SAML_SP_CERT_FILE       certificate of the SP, known by the IdP
+//	SAML_IDP_ENCRYPTS       set to 1 if the IdP encrypts the assertions
+//
+// xmlsec1 is required to verify the signatures of the IdP.
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/xml"
+	"html"
+	"io"
+	"net"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func integrationEnv(name, def string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return def
+}
+
+// integrationSP is an SP listening on SAML_SP_URL, recording what it
+// receives.
+type integrationSP struct {
+	*ServiceProvider
+	server *http.Server
+
+	samlResponses chan string
+	assertions    chan *Assertion
+	errors        chan error
+	logouts       chan *RedirectMessage
+}
+
+func newIntegrationSP(t *testing.T) *integrationSP {
+	if _, err := exec.LookPath("xmlsec1"); err != nil {
+		t.Skip("xmlsec1 not found")
+	}
+
+	baseURL := integrationEnv("SAML_SP_URL", "http://localhost:9009")
+	sp := &integrationSP{
+		ServiceProvider: &ServiceProvider{
+			IdPMetadataURL: integrationEnv("SAML_IDP_METADATA_URL", "http://localhost:8080/simplesaml/saml2/idp/metadata.php"),
+			MetadataURL:    baseURL + "/saml/metadata",
+			AcsURL:         baseURL + "/saml/acs",
+			SloURL:         baseURL + "/saml/slo",
+		},
+		samlResponses: make(chan string, 1),
+		assertions:    make(chan *Assertion, 1),
+		errors:        make(chan error, 1),
+		logouts:       make(chan *RedirectMessage, 1),
+	}
+	if keyFile := os.Getenv("SAML_SP_KEY_FILE"); keyFile != "" {
+		sp.KeyFile, sp.CertFile = keyFile, os.Getenv("SAML_SP_CERT_FILE")
+	} else {
+		keyPEM, certPEM, err := GenerateKeyPair(KeyPairOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		sp.PrivkeyPEM, sp.PubkeyPEM = keyPEM, certPEM
+	}
+	sp.ErrorHandler = func(w http.ResponseWriter, r *http.Request, status int, err error) {
+		sp.errors <- err
+		http.Error(w, err.Error(), status)
+	}
+	if _, err := sp.GetIdPMetadataContext(context.Background()); err != nil {
+		t.Skipf("IdP not available: %v", err)
+	}
+
+	acs := sp.AssertionMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sp.assertions <- GetAssertionFromCtx(r.Context())
+	}))
+	mux := http.NewServeMux()
+	mux.HandleFunc(urlPath(sp.MetadataURL, ""), sp.MetadataHandler)
+	mux.HandleFunc(urlPath(sp.AcsURL, ""), func(w http.ResponseWriter, r *http.Request) {
+		// Keep the raw response for the checks of the tests.
+		r.ParseForm()
+		sp.samlResponses <- r.PostForm.Get("SAMLResponse")
+		acs.ServeHTTP(w, r)
+	})
+	mux.HandleFunc(urlPath(sp.SloURL, ""), func(w http.ResponseWriter, r *http.Request) {
+		msg, err := DecodeRedirect(r.URL.RawQuery, sp.SizeLimits)
+		if err != nil {
+			sp.errors <- err
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		sp.logouts <- msg
+	})
+
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := net.Listen("tcp", u.Host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sp.server = &http.Server{Handler: mux}
+	go sp.server.Serve(l)
+	t.Cleanup(func() { sp.server.Close() })
+	return sp
+}
+
+// newBrowser returns an HTTP client keeping the cookies, as a user agent.
+func newBrowser(t *testing.T) *http.Client {
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &http.Client{Jar: jar, Timeout: 30 * time.Second}
+}
+
+var (
+	formRe  = regexp.MustCompile(`(?is)<form\b([^>]*)>(.*?)</form>`)
+	inputRe = regexp.MustCompile(`(?is)<(?:input|button)\b([^>]*)>`)
+	attrRe  = regexp.MustCompile(`(?is)([a-z_:-]+)\s*=\s*(?:"([^"]*)"|'([^']*)')`)
+)
+
+func htmlAttrs(tag string) map[string]string {
+	attrs := map[string]string{}
+	for _, m := range attrRe.FindAllStringSubmatch(tag, -1) {
+		attrs[strings.ToLower(m[1])] = html.UnescapeString(m[2] + m[3])
+	}
+	return attrs
+}
+
+// submitForm submits the first form of the page of res, with the fields
+// set by fill, like a user agent would.
+func submitForm(t *testing.T, browser *http.Client, res *http.Response, fill func(form url.Values, attrs map[string]string)) *http.Response {
+	defer res.Body.Close()
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := formRe.FindStringSubmatch(string(body))
+	if m == nil {
+		t.Fatalf("no form at %s:\n%s", res.Request.URL, body)
+	}
+	formAttrs := htmlAttrs(m[1])
+	action, err := res.Request.URL.Parse(formAttrs["action"])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	form := url.Values{}
+	submitted := false
+	for _, input := range inputRe.FindAllStringSubmatch(m[2], -1) {
+		attrs := htmlAttrs(input[1])
+		if attrs["name"] == "" {
+			continue
+		}
+		typ := strings.ToLower(attrs["type"])
+		isButton := strings.HasPrefix(strings.ToLower(input[0]), "<button")
+		switch {
+		case typ == "submit" || isButton && typ == "":
+			// Only the first submit button is sent.
+			if submitted {
+				continue
+			}
+			submitted = true
+		case typ == "checkbox" || typ == "radio":
+			if _, ok := attrs["checked"]; !ok {
+				continue
+			}
+		}
+		form.Set(attrs["name"], attrs["value"])
+		if fill != nil {
+			fill(form, attrs)
+		}
+	}
+
+	if strings.EqualFold(formAttrs["method"], "get") {
+		action.RawQuery = form.Encode()
+		res, err = browser.Get(action.String())
+	} else {
+		res, err = browser.PostForm(action.String(), form)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res
+}
+
+// login runs an SP-initiated login in browser and returns the raw SAML
+// response received at the ACS, with the assertion it carried.
+func (sp *integrationSP) login(t *testing.T, browser *http.Client) (string, *Assertion) {
+	authnRequestURL, err := sp.AuthnRequestURL("/home")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := browser.Get(authnRequestURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	username := integrationEnv("SAML_IDP_USERNAME", "user1")
+	password := integrationEnv("SAML_IDP_PASSWORD", "user1pass")
+	res = submitForm(t, browser, res, func(form url.Values, attrs map[string]string) {
+		name := attrs["name"]
+		switch {
+		case strings.EqualFold(attrs["type"], "password"):
+			form.Set(name, password)
+		case strings.Contains(strings.ToLower(name), "user"):
+			form.Set(name, username)
+		}
+	})
+	// The page posting the response to the ACS.
+	res = submitForm(t, browser, res, nil)
+	res.Body.Close()
+
+	samlResponse := <-sp.samlResponses
+	select {
+	case assertion := <-sp.assertions:
+		return samlResponse, assertion
+	case err := <-sp.errors:
+		t.Fatalf("login failed: %v", err)
+	case <-time.After(10 * time.Second):
+		t.Fatal("no assertion received")
+	}
+	return "", nil
+}
+
+func TestIntegrationLogin(t *testing.T) {
+	sp := newIntegrationSP(t)
+	samlResponse, assertion := sp.login(t, newBrowser(t))
+
+	assert.NotEmpty(t, assertion.Subject.NameID.Value)
+	if assert.NotNil(t, assertion.AuthnStatement) {
+		assert.NotEmpty(t, assertion.AuthnStatement.SessionIndex)
+	}
+	assert.NotEmpty(t, assertion.AttributeStatement.Attributes)
+
+	if os.Getenv("SAML_IDP_ENCRYPTS") == "1" {
+		buf, err := base64.StdEncoding.DecodeString(samlResponse)
+		assert.NoError(t, err)
+		assert.Contains(t, string(buf), "EncryptedAssertion")
+	}
+}
+
+func TestIntegrationLogout(t *testing.T) {
+	sp := newIntegrationSP(t)
+	browser := newBrowser(t)
+	_, assertion := sp.login(t, browser)
+
+	sloURL, err := sp.IdPLogoutURL(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sloURL == "" {
+		t.Skip("the IdP has no HTTP-Redirect SingleLogoutService")
+	}
+
+	req, err := sp.NewLogoutRequest(sloURL, *assertion.Subject.NameID, assertion.AuthnStatement.SessionIndex)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf, err := xml.Marshal(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := sp.signingKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	logoutURL, err := EncodeRedirectURL(sloURL, RedirectMessage{Param: "SAMLRequest", XML: buf}, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := browser.Get(logoutURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	select {
+	case msg := <-sp.logouts:
+		assert.Equal(t, "SAMLResponse", msg.Param)
+		var logoutResponse struct {
+			InResponseTo string `xml:",attr"`
+			Status       Status
+		}
+		if assert.NoError(t, xml.Unmarshal(msg.XML, &logoutResponse)) {
+			assert.Equal(t, req.ID, logoutResponse.InResponseTo)
+			assert.Equal(t, StatusSuccess, logoutResponse.Status.StatusCode.Value)
+		}
+	case err := <-sp.errors:
+		t.Fatalf("logout failed: %v", err)
+	case <-time.After(10 * time.Second):
+		t.Fatal("no logout response received")
+	}
+}
@@ -0,0 +1,244 @@
+package saml
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Defaults of KeyPolicy.
+const (
+	DefaultMinRSAKeySize   = 2048
+	DefaultMinECDSAKeySize = 256
+)
+
+// KeyPolicy is the policy of the keys and certificates of a SP or IdP and of
+// its peers. The zero value requires RSA keys of at least 2048 bits, ECDSA
+// keys of at least 256 bits, certificates in their validity period and with
+// a key usage, if any, allowing the use they are published for. The policy
+// is opt-in: a nil policy checks nothing, as many IdPs publish certificates
+// that the zero value would reject.
+//
+// The policy is enforced when the keys are loaded, so that a weak or expired
+// certificate is reported as such rather than as a signature verification
+// failure. Use CheckKeys to verify the configuration at startup.
+type KeyPolicy struct {
+	// MinRSAKeySize is the minimum size in bits of the RSA keys,
+	// DefaultMinRSAKeySize if zero.
+	MinRSAKeySize int
+
+	// MinECDSAKeySize is the minimum size in bits of the curves of the ECDSA
+	// keys, DefaultMinECDSAKeySize if zero.
+	MinECDSAKeySize int
+
+	// AllowExpired accepts the certificates of the peers, found in their
+	// metadata, outside of their validity period. SAML does not require it
+	// and some IdPs publish long-expired self-signed certificates. The
+	// certificate of the SP or IdP itself must always be valid.
+	AllowExpired bool
+
+	// IgnoreKeyUsage accepts the certificates whose key usage extension
+	// doesn't allow the use they are published for.
+	IgnoreKeyUsage bool
+}
+
+func (p *KeyPolicy) minRSAKeySize() int {
+	if p == nil || p.MinRSAKeySize == 0 {
+		return DefaultMinRSAKeySize
+	}
+	return p.MinRSAKeySize
+}
+
+func (p *KeyPolicy) minECDSAKeySize() int {
+	if p == nil || p.MinECDSAKeySize == 0 {
+		return DefaultMinECDSAKeySize
+	}
+	return p.MinECDSAKeySize
+}
+
+// checkPublicKey verifies the type and the size of a key.
+func (p *KeyPolicy) checkPublicKey(pub crypto.PublicKey) error {
+	switch pub := pub.(type) {
+	case *rsa.PublicKey:
+		if size := pub.N.BitLen(); size < p.minRSAKeySize() {
+			return errors.Errorf("RSA key of %d bits, at least %d required", size, p.minRSAKeySize())
+		}
+	case *ecdsa.PublicKey:
+		if size := pub.Curve.Params().BitSize; size < p.minECDSAKeySize() {
+			return errors.Errorf("ECDSA key of %d bits, at least %d required", size, p.minECDSAKeySize())
+		}
+	case ed25519.PublicKey:
+	default:
+		return errors.Errorf("unsupported public key type %T", pub)
+	}
+	return nil
+}
+
+// checkCertificate verifies a certificate published for use, "signing",
+// "encryption" or empty for both, as in KeyDescriptor. The validity period
+// is checked at now, unless peer is set and expired certificates are
+// allowed. A nil policy accepts any certificate.
+func (p *KeyPolicy) checkCertificate(cert *x509.Certificate, use string, now time.Time, peer bool) error {
+	if p == nil {
+		return nil
+	}
+	if err := p.checkPublicKey(cert.PublicKey); err != nil {
+		return err
+	}
+	if !peer || !p.AllowExpired {
+		if now.Before(cert.NotBefore) {
+			return errors.Errorf("certificate is not valid yet (notBefore=%v)", cert.NotBefore)
+		}
+		if now.After(cert.NotAfter) {
+			return errors.Errorf("certificate has expired (notAfter=%v)", cert.NotAfter)
+		}
+	}
+	if cert.KeyUsage == 0 || p.IgnoreKeyUsage {
+		return nil
+	}
+	signing := cert.KeyUsage&x509.KeyUsageDigitalSignature != 0
+	encryption := cert.KeyUsage&(x509.KeyUsageKeyEncipherment|x509.KeyUsageKeyAgreement) != 0
+	switch {
+	case use == "signing" && !signing:
+		return errors.New("certificate key usage does not allow digital signatures")
+	case use == "encryption" && !encryption:
+		return errors.New("certificate key usage does not allow key encipherment")
+	case use == "" && !signing && !encryption:
+		return errors.New("certificate key usage allows neither digital signatures nor key encipherment")
+	}
+	return nil
+}
+
+// checkKeyDescriptors verifies the certificates of the KeyDescriptors of a
+// peer.
+func (p *KeyPolicy) checkKeyDescriptors(keyDescriptors []KeyDescriptor, now time.Time) error {
+	for _, keyDescriptor := range keyDescriptors {
+		if keyDescriptor.KeyInfo.Certificate == "" {
+			continue
+		}
+		cert, err := parseKeyInfoCertificate(keyDescriptor.KeyInfo.Certificate)
+		if err == nil {
+			err = p.checkCertificate(cert, keyDescriptor.Use, now, true)
+		}
+		if err != nil {
+			if keyDescriptor.Use != "" {
+				return errors.Wrapf(err, "%s certificate", keyDescriptor.Use)
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// parseKeyInfoCertificate parses the base64 DER certificate of a KeyInfo.
+func parseKeyInfoCertificate(data string) (*x509.Certificate, error) {
+	der, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(data), ""))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to decode certificate")
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse certificate")
+	}
+	return cert, nil
+}
+
+// checkKeyPair verifies that key is the private key of cert.
+func checkKeyPair(key crypto.Signer, cert *x509.Certificate) error {
+	pub, ok := key.Public().(interface{ Equal(crypto.PublicKey) bool })
+	if !ok || !pub.Equal(cert.PublicKey) {
+		return errors.New("private key does not match the certificate")
+	}
+	return nil
+}
+
+// CheckKeys verifies that the private key of the SP matches its
+// certificate, and the keys of the SP and the certificates of its IdPs
+// against KeyPolicy, if any, downloading the IdP metadata if needed. It is
+// meant to report configuration errors at startup.
+func (sp *ServiceProvider) CheckKeys(ctx context.Context) error {
+	if sp.CertFile != "" || sp.PubkeyPEM != "" {
+		block, err := sp.Cert()
+		if err != nil {
+			return errors.Wrap(err, "SP certificate")
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return errors.Wrap(err, "SP certificate")
+		}
+		if sp.KeyFile != "" || sp.PrivkeyPEM != "" {
+			key, err := sp.signingKey()
+			if err == nil {
+				err = checkKeyPair(key, cert)
+			}
+			if err != nil {
+				return errors.Wrap(err, "SP keys")
+			}
+		}
+	}
+
+	var idps []*Metadata
+	if sp.IdPs != nil {
+		idps = sp.IdPs.List()
+	} else {
+		meta, err := sp.GetIdPMetadataContext(ctx)
+		if err != nil {
+			return errors.Wrap(err, "IdP metadata")
+		}
+		idps = []*Metadata{meta}
+	}
+	for _, meta := range idps {
+		if meta.IDPSSODescriptor == nil {
+			return errors.Errorf("IdP %s: missing IDPSSODescriptor", meta.EntityID)
+		}
+		if err := sp.KeyPolicy.checkKeyDescriptors(meta.IDPSSODescriptor.KeyDescriptor, sp.now()); err != nil {
+			return errors.Wrapf(err, "IdP %s", meta.EntityID)
+		}
+	}
+	return nil
+}
+
+// CheckKeys verifies that the private key of the IdP matches its
+// certificate, and the keys of the IdP and the certificates of its SP
+// against KeyPolicy, if any, downloading the SP metadata if needed. It is
+// meant to report configuration errors at startup.
+func (idp *IdentityProvider) CheckKeys() error {
+	block, err := idp.Cert()
+	if err != nil {
+		return errors.Wrap(err, "IdP certificate")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return errors.Wrap(err, "IdP certificate")
+	}
+	key, err := idp.signingKey()
+	if err == nil {
+		err = checkKeyPair(key, cert)
+	}
+	if err != nil {
+		return errors.Wrap(err, "IdP keys")
+	}
+
+	if idp.SPMetadata == nil && idp.SPMetadataURL == "" {
+		return nil
+	}
+	meta, err := idp.GetSPMetadata()
+	if err != nil {
+		return errors.Wrap(err, "SP metadata")
+	}
+	if meta.SPSSODescriptor == nil {
+		return errors.Errorf("SP %s: missing SPSSODescriptor", meta.EntityID)
+	}
+	if err := idp.KeyPolicy.checkKeyDescriptors(meta.SPSSODescriptor.KeyDescriptor, idp.now()); err != nil {
+		return errors.Wrapf(err, "SP %s", meta.EntityID)
+	}
+	return nil
+}
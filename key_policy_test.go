@@ -0,0 +1,112 @@
+package saml
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestKeyPair(t *testing.T, opts KeyPairOptions) (string, string, *x509.Certificate) {
+	keyPEM, certPEM, err := GenerateKeyPair(opts)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	block, _ := pem.Decode([]byte(certPEM))
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	return keyPEM, certPEM, cert
+}
+
+func TestKeyPolicyCertificate(t *testing.T) {
+	tearUp()
+
+	_, _, weak := newTestKeyPair(t, KeyPairOptions{Bits: 1024})
+	_, _, ecdsa := newTestKeyPair(t, KeyPairOptions{Type: KeyTypeECDSA})
+	Now = func() time.Time { return time.Now().Add(-48 * time.Hour) }
+	_, _, expired := newTestKeyPair(t, KeyPairOptions{Type: KeyTypeECDSA, Validity: time.Hour})
+	tearUp()
+	now := Now()
+
+	// The policy is opt-in.
+	var none *KeyPolicy
+	assert.NoError(t, none.checkCertificate(weak, "signing", now, true))
+	assert.NoError(t, none.checkCertificate(expired, "signing", now, false))
+
+	policy := &KeyPolicy{}
+	err := policy.checkCertificate(weak, "signing", now, true)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "RSA key of 1024 bits")
+	}
+	assert.NoError(t, (&KeyPolicy{MinRSAKeySize: 1024}).checkCertificate(weak, "signing", now, true))
+	assert.Error(t, (&KeyPolicy{MinECDSAKeySize: 384}).checkCertificate(ecdsa, "signing", now, true))
+
+	// The ECDSA certificates of GenerateKeyPair are only for signing.
+	assert.NoError(t, policy.checkCertificate(ecdsa, "", now, true))
+	assert.Error(t, policy.checkCertificate(ecdsa, "encryption", now, true))
+	assert.NoError(t, (&KeyPolicy{IgnoreKeyUsage: true}).checkCertificate(ecdsa, "encryption", now, true))
+
+	// The expired certificates are only allowed for the peers.
+	err = policy.checkCertificate(expired, "signing", now, true)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "expired")
+	}
+	assert.NoError(t, (&KeyPolicy{AllowExpired: true}).checkCertificate(expired, "signing", now, true))
+	assert.Error(t, (&KeyPolicy{AllowExpired: true}).checkCertificate(expired, "signing", now, false))
+}
+
+func TestCheckKeys(t *testing.T) {
+	tearUp()
+
+	keyPEM, certPEM, _ := newTestKeyPair(t, KeyPairOptions{Type: KeyTypeECDSA})
+	otherKeyPEM, _, _ := newTestKeyPair(t, KeyPairOptions{Type: KeyTypeECDSA})
+	_, _, idpCert := newTestKeyPair(t, KeyPairOptions{Bits: 1024})
+	idpMetadata := func() *Metadata {
+		return &Metadata{
+			EntityID: "https://idp.example.com",
+			IDPSSODescriptor: &IDPSSODescriptor{
+				KeyDescriptor: []KeyDescriptor{
+					{Use: "signing", KeyInfo: KeyInfo{Certificate: base64.StdEncoding.EncodeToString(idpCert.Raw)}},
+				},
+			},
+		}
+	}
+
+	sp := &ServiceProvider{
+		PrivkeyPEM:  otherKeyPEM,
+		PubkeyPEM:   certPEM,
+		IdPMetadata: idpMetadata(),
+	}
+	err := sp.CheckKeys(context.Background())
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "private key does not match the certificate")
+	}
+
+	// Without KeyPolicy, the certificates of the IdP are not checked.
+	sp = &ServiceProvider{
+		PrivkeyPEM:  keyPEM,
+		PubkeyPEM:   certPEM,
+		IdPMetadata: idpMetadata(),
+	}
+	assert.NoError(t, sp.CheckKeys(context.Background()))
+
+	sp.KeyPolicy = &KeyPolicy{}
+	err = sp.CheckKeys(context.Background())
+	if assert.Error(t, err) {
+		assert.True(t, strings.HasPrefix(err.Error(), "IdP https://idp.example.com: signing certificate: RSA key of 1024 bits"), err.Error())
+	}
+	_, err = sp.GetIdPCertFileContext(context.Background())
+	assert.Error(t, err)
+
+	sp.KeyPolicy = &KeyPolicy{MinRSAKeySize: 1024}
+	assert.NoError(t, sp.CheckKeys(context.Background()))
+	_, err = sp.GetIdPCertFileContext(context.Background())
+	assert.NoError(t, err)
+}
@@ -0,0 +1,116 @@
+package saml
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// KeyType is the type of the keys generated by GenerateKeyPair.
+type KeyType string
+
+// Key types supported by GenerateKeyPair.
+const (
+	KeyTypeRSA   KeyType = "rsa"
+	KeyTypeECDSA KeyType = "ecdsa"
+)
+
+// Defaults of KeyPairOptions.
+const (
+	DefaultRSAKeySize  = 2048
+	DefaultKeyValidity = 10 * 365 * 24 * time.Hour
+)
+
+// KeyPairOptions configures GenerateKeyPair. The zero value generates a
+// 2048-bit RSA key and a certificate valid for ten years.
+type KeyPairOptions struct {
+	// Type defaults to KeyTypeRSA. ECDSA keys use the P-256 curve; they can
+	// sign but, unlike RSA keys, can't be used to encrypt assertions.
+	Type KeyType
+
+	// Bits is the size of RSA keys, DefaultRSAKeySize if zero.
+	Bits int
+
+	// CommonName is the subject of the certificate, usually the host name
+	// of the SP.
+	CommonName string
+
+	// Validity is the lifetime of the certificate, DefaultKeyValidity if
+	// zero. SAML peers usually don't check it, so it is long.
+	Validity time.Duration
+}
+
+// GenerateKeyPair generates a private key and a self-signed certificate
+// suitable for SAML signing and encryption, both PEM encoded, as expected
+// by ServiceProvider.PrivkeyPEM and PubkeyPEM.
+func GenerateKeyPair(opts KeyPairOptions) (keyPEM, certPEM string, err error) {
+	var (
+		key      crypto.Signer
+		keyBlock *pem.Block
+		usage    x509.KeyUsage
+	)
+	switch opts.Type {
+	case "", KeyTypeRSA:
+		bits := opts.Bits
+		if bits == 0 {
+			bits = DefaultRSAKeySize
+		}
+		rsaKey, err := rsa.GenerateKey(rand.Reader, bits)
+		if err != nil {
+			return "", "", err
+		}
+		key = rsaKey
+		keyBlock = &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)}
+		usage = x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment
+	case KeyTypeECDSA:
+		ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			return "", "", err
+		}
+		der, err := x509.MarshalECPrivateKey(ecKey)
+		if err != nil {
+			return "", "", err
+		}
+		key = ecKey
+		keyBlock = &pem.Block{Type: "EC PRIVATE KEY", Bytes: der}
+		usage = x509.KeyUsageDigitalSignature
+	default:
+		return "", "", errors.Errorf("unknown key type %q", opts.Type)
+	}
+
+	validity := opts.Validity
+	if validity == 0 {
+		validity = DefaultKeyValidity
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return "", "", err
+	}
+	// Backdate the certificate a little to tolerate clock drifts.
+	notBefore := Now().Add(-time.Hour)
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: opts.CommonName},
+		NotBefore:             notBefore,
+		NotAfter:              notBefore.Add(validity),
+		KeyUsage:              usage,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		return "", "", errors.Wrap(err, "failed to create certificate")
+	}
+
+	keyPEM = string(pem.EncodeToMemory(keyBlock))
+	certPEM = string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+	return keyPEM, certPEM, nil
+}
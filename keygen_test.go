@@ -0,0 +1,52 @@
+package saml
+
+import (
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGenerateKeyPair(t *testing.T) {
+	tearUp()
+
+	keyPEM, certPEM, err := GenerateKeyPair(KeyPairOptions{CommonName: "sp.example.com"})
+	assert.NoError(t, err)
+
+	block, _ := pem.Decode([]byte(keyPEM))
+	assert.Equal(t, "RSA PRIVATE KEY", block.Type)
+	key, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+	assert.NoError(t, err)
+	assert.Equal(t, DefaultRSAKeySize, key.N.BitLen())
+
+	block, _ = pem.Decode([]byte(certPEM))
+	cert, err := x509.ParseCertificate(block.Bytes)
+	assert.NoError(t, err)
+	assert.Equal(t, "sp.example.com", cert.Subject.CommonName)
+	assert.Equal(t, key.N, cert.PublicKey.(*rsa.PublicKey).N)
+	assert.True(t, cert.NotBefore.Before(Now()))
+	assert.Equal(t, DefaultKeyValidity, cert.NotAfter.Sub(cert.NotBefore))
+	assert.NoError(t, cert.CheckSignature(cert.SignatureAlgorithm, cert.RawTBSCertificate, cert.Signature))
+
+	// The pair is usable by a ServiceProvider.
+	sp := &ServiceProvider{PrivkeyPEM: keyPEM, PubkeyPEM: certPEM}
+	_, err = sp.Cert()
+	assert.NoError(t, err)
+
+	keyPEM, certPEM, err = GenerateKeyPair(KeyPairOptions{Type: KeyTypeECDSA, Validity: time.Hour})
+	assert.NoError(t, err)
+	block, _ = pem.Decode([]byte(keyPEM))
+	assert.Equal(t, "EC PRIVATE KEY", block.Type)
+	block, _ = pem.Decode([]byte(certPEM))
+	cert, err = x509.ParseCertificate(block.Bytes)
+	assert.NoError(t, err)
+	assert.IsType(t, &ecdsa.PublicKey{}, cert.PublicKey)
+	assert.Equal(t, time.Hour, cert.NotAfter.Sub(cert.NotBefore))
+
+	_, _, err = GenerateKeyPair(KeyPairOptions{Type: "dsa"})
+	assert.Error(t, err)
+}
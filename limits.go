@@ -0,0 +1,79 @@
+package saml
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/base64"
+	"io"
+	"io/ioutil"
+
+	"github.com/pkg/errors"
+)
+
+// Default size limits of the SAML messages received by a ServiceProvider or
+// an IdentityProvider.
+const (
+	// DefaultMaxMessageSize is the maximum size of an encoded message, as
+	// sent in a form or a query parameter.
+	DefaultMaxMessageSize = 512 << 10
+	// DefaultMaxXMLSize is the maximum size of a decoded and, for the
+	// HTTP-Redirect binding, inflated XML document.
+	DefaultMaxXMLSize = 1 << 20
+)
+
+// SizeLimits bounds the size of the SAML messages a provider accepts. Zero
+// values use the defaults above.
+type SizeLimits struct {
+	MaxMessageSize int64
+	MaxXMLSize     int64
+}
+
+func (l SizeLimits) maxMessageSize() int64 {
+	if l.MaxMessageSize <= 0 {
+		return DefaultMaxMessageSize
+	}
+	return l.MaxMessageSize
+}
+
+func (l SizeLimits) maxXMLSize() int64 {
+	if l.MaxXMLSize <= 0 {
+		return DefaultMaxXMLSize
+	}
+	return l.MaxXMLSize
+}
+
+// inflate decompresses a deflate-encoded message, stopping as soon as the
+// output grows over max bytes.
+func inflate(data []byte, max int64) ([]byte, error) {
+	r := flate.NewReader(bytes.NewReader(data))
+	defer r.Close()
+
+	buf, err := ioutil.ReadAll(io.LimitReader(r, max+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(buf)) > max {
+		return nil, errors.Errorf("inflated message is larger than %d bytes", max)
+	}
+	return buf, nil
+}
+
+// decodeRedirect decodes the value of the parameter param of the
+// HTTP-Redirect binding: a base64-encoded and deflated XML document.
+func (l SizeLimits) decodeRedirect(param, value string) ([]byte, error) {
+	if max := l.maxMessageSize(); int64(len(value)) > max {
+		return nil, errors.Errorf("%s is larger than %d bytes", param, max)
+	}
+	data, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to decode %s", param)
+	}
+	buf, err := inflate(data, l.maxXMLSize())
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read %s", param)
+	}
+	if err := checkXML(buf); err != nil {
+		return nil, errors.Wrapf(err, "invalid %s", param)
+	}
+	return buf, nil
+}
@@ -0,0 +1,49 @@
+package saml
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func deflate(t *testing.T, data []byte) []byte {
+	var buf bytes.Buffer
+	w, err := flate.NewWriter(&buf, flate.BestCompression)
+	assert.NoError(t, err)
+	w.Write(data)
+	w.Close()
+	return buf.Bytes()
+}
+
+func TestInflate(t *testing.T) {
+	data := deflate(t, []byte("<AuthnRequest/>"))
+	buf, err := inflate(data, 100)
+	assert.NoError(t, err)
+	assert.Equal(t, "<AuthnRequest/>", string(buf))
+
+	bomb := deflate(t, bytes.Repeat([]byte{' '}, 10<<20))
+	assert.True(t, len(bomb) < 20<<10)
+	_, err = inflate(bomb, DefaultMaxXMLSize)
+	assert.Error(t, err)
+}
+
+func TestParseResponseSizeLimits(t *testing.T) {
+	tearUp()
+
+	sp := newTestResponseSP()
+	sp.MaxMessageSize = 1024
+	now := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	_, err := sp.ParseResponse(strings.Repeat("A", 1025), nil, now)
+	assert.True(t, errors.Is(err, ErrMessageTooLarge))
+
+	sp.MaxXMLSize = 10
+	_, err = sp.ParseResponse(base64.StdEncoding.EncodeToString([]byte("<Response/>")), nil, now)
+	assert.True(t, errors.Is(err, ErrMessageTooLarge))
+}
@@ -0,0 +1,116 @@
+package saml
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net/http"
+)
+
+// Logger receives the messages logged by a ServiceProvider or an
+// IdentityProvider. Each message comes with an optional list of alternating
+// keys and values.
+//
+// A *slog.Logger satisfies this interface as is; use NewLogrusLogger for
+// logrus.
+type Logger interface {
+	Debug(msg string, keyvals ...interface{})
+	Info(msg string, keyvals ...interface{})
+	Error(msg string, keyvals ...interface{})
+}
+
+// NewStdLogger returns a Logger writing to l, or to the standard logger if l
+// is nil. Debug messages are dropped unless debug is true.
+func NewStdLogger(l *log.Logger, debug bool) Logger {
+	return &stdLogger{l: l, debug: debug}
+}
+
+type stdLogger struct {
+	l     *log.Logger
+	debug bool
+}
+
+func (s *stdLogger) Debug(msg string, keyvals ...interface{}) {
+	if s.debug {
+		s.output("DEBUG", msg, keyvals)
+	}
+}
+
+func (s *stdLogger) Info(msg string, keyvals ...interface{}) {
+	s.output("INFO", msg, keyvals)
+}
+
+func (s *stdLogger) Error(msg string, keyvals ...interface{}) {
+	s.output("ERROR", msg, keyvals)
+}
+
+func (s *stdLogger) output(level, msg string, keyvals []interface{}) {
+	line := "saml: " + level + " " + formatLogLine(msg, keyvals)
+	if s.l == nil {
+		log.Output(3, line)
+		return
+	}
+	s.l.Output(3, line)
+}
+
+// LeveledPrinter is implemented by *logrus.Logger, *logrus.Entry and
+// logrus.FieldLogger.
+type LeveledPrinter interface {
+	Debug(args ...interface{})
+	Info(args ...interface{})
+	Error(args ...interface{})
+}
+
+// NewLogrusLogger returns a Logger writing to a logrus logger. The key/value
+// pairs are appended to the message.
+func NewLogrusLogger(l LeveledPrinter) Logger {
+	return logrusLogger{l}
+}
+
+type logrusLogger struct {
+	l LeveledPrinter
+}
+
+func (l logrusLogger) Debug(msg string, keyvals ...interface{}) {
+	l.l.Debug(formatLogLine(msg, keyvals))
+}
+
+func (l logrusLogger) Info(msg string, keyvals ...interface{}) {
+	l.l.Info(formatLogLine(msg, keyvals))
+}
+
+func (l logrusLogger) Error(msg string, keyvals ...interface{}) {
+	l.l.Error(formatLogLine(msg, keyvals))
+}
+
+// formatLogLine appends keyvals to msg in the key=value format.
+func formatLogLine(msg string, keyvals []interface{}) string {
+	if len(keyvals) == 0 {
+		return msg
+	}
+	var buf bytes.Buffer
+	buf.WriteString(msg)
+	for i := 0; i < len(keyvals); i += 2 {
+		var value interface{} = "(MISSING)"
+		if i+1 < len(keyvals) {
+			value = keyvals[i+1]
+		}
+		fmt.Fprintf(&buf, " %v=%q", keyvals[i], fmt.Sprint(value))
+	}
+	return buf.String()
+}
+
+// defaultLogger writes to the standard logger and drops debug messages.
+var defaultLogger Logger = &stdLogger{}
+
+// logFailure is used when no FailureFunc is set. Client failures are logged
+// at the debug level only, so that malformed requests can't be used to flood
+// the logs.
+func logFailure(logger Logger, r *http.Request, level FailureLevel, err error) {
+	keyvals := []interface{}{"method", r.Method, "path", r.URL.Path, "err", err}
+	if level == InternalFailure {
+		logger.Error("request failed", keyvals...)
+		return
+	}
+	logger.Debug("request rejected", keyvals...)
+}
@@ -0,0 +1,8 @@
+//go:build go1.21
+// +build go1.21
+
+package saml
+
+import "log/slog"
+
+var _ Logger = (*slog.Logger)(nil)
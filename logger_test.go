@@ -0,0 +1,55 @@
+package saml
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStdLogger(t *testing.T) {
+	var buf bytes.Buffer
+	logger := NewStdLogger(log.New(&buf, "", 0), false)
+
+	logger.Debug("dropped")
+	logger.Info("hello", "id", "id-1", "n", 2)
+	logger.Error("odd", "key")
+	assert.Equal(t, "saml: INFO hello id=\"id-1\" n=\"2\"\nsaml: ERROR odd key=\"(MISSING)\"\n", buf.String())
+
+	buf.Reset()
+	logger = NewStdLogger(log.New(&buf, "", 0), true)
+	logger.Debug("kept")
+	assert.Equal(t, "saml: DEBUG kept\n", buf.String())
+}
+
+type testPrinter struct {
+	lines []string
+}
+
+func (p *testPrinter) Debug(args ...interface{}) {
+	p.lines = append(p.lines, "debug "+fmt.Sprint(args...))
+}
+func (p *testPrinter) Info(args ...interface{}) {
+	p.lines = append(p.lines, "info "+fmt.Sprint(args...))
+}
+func (p *testPrinter) Error(args ...interface{}) {
+	p.lines = append(p.lines, "error "+fmt.Sprint(args...))
+}
+
+func TestFailureLogging(t *testing.T) {
+	p := &testPrinter{}
+	sp := &ServiceProvider{Logger: NewLogrusLogger(p)}
+
+	r := httptest.NewRequest("POST", "/saml/acs", nil)
+	sp.fail(r, ClientFailure, errors.New("bad"))
+	sp.fail(r, InternalFailure, errors.New("broken"))
+
+	assert.Equal(t, []string{
+		`debug request rejected method="POST" path="/saml/acs" err="bad"`,
+		`error request failed method="POST" path="/saml/acs" err="broken"`,
+	}, p.lines)
+}
@@ -0,0 +1,120 @@
+package saml
+
+import (
+	"encoding/xml"
+	"strings"
+)
+
+// MDUINamespace is the namespace of the metadata extensions for login and
+// discovery user interfaces.
+//
+// See https://docs.oasis-open.org/security/saml/Post2.0/sstc-saml-metadata-ui/v1.0/sstc-saml-metadata-ui-v1.0.html
+const MDUINamespace = "urn:oasis:names:tc:SAML:metadata:ui"
+
+// Extensions represents the md:Extensions element of a role descriptor.
+// Only the extensions known by this package are kept.
+type Extensions struct {
+	UIInfo *UIInfo `xml:"urn:oasis:names:tc:SAML:metadata:ui UIInfo,omitempty"`
+
+	// DiscoveryResponses are the endpoints of an SP receiving the IdP
+	// chosen with a discovery service, see IdPDiscoveryProtocol.
+	DiscoveryResponses []IndexedEndpoint `xml:"urn:oasis:names:tc:SAML:profiles:SSO:idp-discovery-protocol DiscoveryResponse"`
+}
+
+// UIInfo represents the mdui:UIInfo element: the information displayed
+// about an entity by login and discovery pages.
+type UIInfo struct {
+	XMLName              xml.Name        `xml:"urn:oasis:names:tc:SAML:metadata:ui UIInfo"`
+	DisplayNames         []LocalizedName `xml:"urn:oasis:names:tc:SAML:metadata:ui DisplayName"`
+	Descriptions         []LocalizedName `xml:"urn:oasis:names:tc:SAML:metadata:ui Description"`
+	Keywords             []LocalizedName `xml:"urn:oasis:names:tc:SAML:metadata:ui Keywords"`
+	Logos                []Logo          `xml:"urn:oasis:names:tc:SAML:metadata:ui Logo"`
+	InformationURLs      []LocalizedName `xml:"urn:oasis:names:tc:SAML:metadata:ui InformationURL"`
+	PrivacyStatementURLs []LocalizedName `xml:"urn:oasis:names:tc:SAML:metadata:ui PrivacyStatementURL"`
+}
+
+// LocalizedName is a string in a given language.
+type LocalizedName struct {
+	Lang  string `xml:"http://www.w3.org/XML/1998/namespace lang,attr"`
+	Value string `xml:",chardata"`
+}
+
+// Logo represents the mdui:Logo element.
+type Logo struct {
+	Height int    `xml:"height,attr"`
+	Width  int    `xml:"width,attr"`
+	Lang   string `xml:"http://www.w3.org/XML/1998/namespace lang,attr,omitempty"`
+	URL    string `xml:",chardata"`
+}
+
+// DisplayName returns the display name in the language lang, or else in
+// English, or else the first one.
+func (u *UIInfo) DisplayName(lang string) string {
+	if u == nil {
+		return ""
+	}
+	return localized(u.DisplayNames, lang)
+}
+
+// Description returns the description in the language lang, or else in
+// English, or else the first one.
+func (u *UIInfo) Description(lang string) string {
+	if u == nil {
+		return ""
+	}
+	return localized(u.Descriptions, lang)
+}
+
+// InformationURL returns the information URL in the language lang, or else
+// in English, or else the first one.
+func (u *UIInfo) InformationURL(lang string) string {
+	if u == nil {
+		return ""
+	}
+	return localized(u.InformationURLs, lang)
+}
+
+// Logo returns the logo in the language lang, or else the first one without
+// a language, or else the first one. ok is false if there is no logo.
+func (u *UIInfo) Logo(lang string) (logo Logo, ok bool) {
+	if u == nil || len(u.Logos) == 0 {
+		return Logo{}, false
+	}
+	for _, l := range u.Logos {
+		if l.Lang != "" && strings.EqualFold(l.Lang, lang) {
+			return l, true
+		}
+	}
+	for _, l := range u.Logos {
+		if l.Lang == "" {
+			return l, true
+		}
+	}
+	return u.Logos[0], true
+}
+
+func localized(names []LocalizedName, lang string) string {
+	for _, fallback := range []string{lang, "en"} {
+		for _, name := range names {
+			if strings.EqualFold(name.Lang, fallback) {
+				return name.Value
+			}
+		}
+	}
+	if len(names) > 0 {
+		return names[0].Value
+	}
+	return ""
+}
+
+// UIInfo returns the mdui:UIInfo of the IdP or, for an SP, of the SP role
+// descriptor. It returns nil if there is none.
+func (m *Metadata) UIInfo() *UIInfo {
+	if m.IDPSSODescriptor != nil && m.IDPSSODescriptor.Extensions != nil && m.IDPSSODescriptor.Extensions.UIInfo != nil {
+		return m.IDPSSODescriptor.Extensions.UIInfo
+	}
+	if m.SPSSODescriptor != nil && m.SPSSODescriptor.Extensions != nil {
+		return m.SPSSODescriptor.Extensions.UIInfo
+	}
+	return nil
+}
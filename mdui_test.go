@@ -0,0 +1,69 @@
+package saml
+
+import (
+	"encoding/xml"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testIdPMetadataMDUI = `<md:EntityDescriptor xmlns:md="urn:oasis:names:tc:SAML:2.0:metadata" xmlns:mdui="urn:oasis:names:tc:SAML:metadata:ui" entityID="https://idp.example.edu/idp/shibboleth">
+  <md:IDPSSODescriptor protocolSupportEnumeration="urn:oasis:names:tc:SAML:2.0:protocol">
+    <md:Extensions>
+      <shibmd:Scope xmlns:shibmd="urn:mace:shibboleth:metadata:1.0" regexp="false">example.edu</shibmd:Scope>
+      <mdui:UIInfo>
+        <mdui:DisplayName xml:lang="fr">Université Exemple</mdui:DisplayName>
+        <mdui:DisplayName xml:lang="en">Example University</mdui:DisplayName>
+        <mdui:Description xml:lang="en">Login service of Example University</mdui:Description>
+        <mdui:InformationURL xml:lang="en">https://www.example.edu/about</mdui:InformationURL>
+        <mdui:Logo height="16" width="16">https://www.example.edu/favicon.ico</mdui:Logo>
+        <mdui:Logo height="60" width="80" xml:lang="fr">https://www.example.edu/logo-fr.png</mdui:Logo>
+      </mdui:UIInfo>
+    </md:Extensions>
+    <md:SingleSignOnService Binding="urn:oasis:names:tc:SAML:2.0:bindings:HTTP-Redirect" Location="https://idp.example.edu/idp/profile/SAML2/Redirect/SSO"/>
+  </md:IDPSSODescriptor>
+</md:EntityDescriptor>`
+
+func TestParseUIInfo(t *testing.T) {
+	var metadata Metadata
+	assert.NoError(t, xml.Unmarshal([]byte(testIdPMetadataMDUI), &metadata))
+
+	ui := metadata.UIInfo()
+	if !assert.NotNil(t, ui) {
+		return
+	}
+	assert.Equal(t, "Université Exemple", ui.DisplayName("fr"))
+	assert.Equal(t, "Example University", ui.DisplayName("de"))
+	assert.Equal(t, "Login service of Example University", ui.Description("fr"))
+	assert.Equal(t, "https://www.example.edu/about", ui.InformationURL(""))
+
+	logo, ok := ui.Logo("fr")
+	assert.True(t, ok)
+	assert.Equal(t, Logo{Height: 60, Width: 80, Lang: "fr", URL: "https://www.example.edu/logo-fr.png"}, logo)
+	logo, _ = ui.Logo("en")
+	assert.Equal(t, "https://www.example.edu/favicon.ico", logo.URL)
+
+	var none *UIInfo
+	assert.Equal(t, "", none.DisplayName("en"))
+	_, ok = none.Logo("en")
+	assert.False(t, ok)
+	assert.Nil(t, (&Metadata{}).UIInfo())
+}
+
+func TestSPMetadataUIInfo(t *testing.T) {
+	tearUp()
+
+	sp := *testSP
+	sp.UIInfo = &UIInfo{
+		DisplayNames: []LocalizedName{{Lang: "en", Value: "Example App"}},
+		Logos:        []Logo{{Height: 16, Width: 16, URL: "https://app.example.com/favicon.ico"}},
+	}
+	buf, err := sp.MetadataXML()
+	assert.NoError(t, err)
+	assert.Contains(t, string(buf), `<DisplayName xmlns="urn:oasis:names:tc:SAML:metadata:ui" xml:lang="en">Example App</DisplayName>`)
+
+	var metadata Metadata
+	assert.NoError(t, xml.Unmarshal(buf, &metadata))
+	assert.Equal(t, sp.UIInfo.DisplayNames, metadata.UIInfo().DisplayNames)
+	assert.Equal(t, sp.UIInfo.Logos, metadata.UIInfo().Logos)
+}
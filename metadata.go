@@ -38,9 +38,16 @@ import (
 // HTTPPostBinding is the official URN for the HTTP-POST binding (transport)
 const HTTPPostBinding = "urn:oasis:names:tc:SAML:2.0:bindings:HTTP-POST"
 
+// HTTPPostSimpleSignBinding is the official URN for the HTTP-POST-SimpleSign
+// binding (transport)
+const HTTPPostSimpleSignBinding = "urn:oasis:names:tc:SAML:2.0:bindings:HTTP-POST-SimpleSign"
+
 // HTTPRedirectBinding is the official URN for the HTTP-Redirect binding (transport)
 const HTTPRedirectBinding = "urn:oasis:names:tc:SAML:2.0:bindings:HTTP-Redirect"
 
+// HTTPArtifactBinding is the official URN for the HTTP-Artifact binding (transport)
+const HTTPArtifactBinding = "urn:oasis:names:tc:SAML:2.0:bindings:HTTP-Artifact"
+
 // EntitiesDescriptor represents the SAML object of the same name.
 //
 // See http://docs.oasis-open.org/security/saml/v2.0/saml-metadata-2.0-os.pdf section 2.3.1
@@ -59,6 +66,55 @@ type Metadata struct {
 	EntityID         string            `xml:"entityID,attr"`
 	SPSSODescriptor  *SPSSODescriptor  `xml:"SPSSODescriptor"`
 	IDPSSODescriptor *IDPSSODescriptor `xml:"IDPSSODescriptor"`
+	Organization     *Organization     `xml:"Organization,omitempty"`
+	ContactPersons   []ContactPerson   `xml:"ContactPerson"`
+}
+
+// MarshalXML implements xml.Marshaler. The validUntil attribute is omitted
+// when ValidUntil is the zero time.
+func (m Metadata) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	type metadata Metadata
+	// ValidUntil shadows the field of the embedded metadata, and comes
+	// first to keep the order of the attributes.
+	out := struct {
+		ValidUntil *time.Time `xml:"validUntil,attr,omitempty"`
+		metadata
+	}{metadata: metadata(m)}
+	if !m.ValidUntil.IsZero() {
+		out.ValidUntil = &m.ValidUntil
+	}
+	start.Name = xml.Name{Space: "urn:oasis:names:tc:SAML:2.0:metadata", Local: "EntityDescriptor"}
+	return e.EncodeElement(out, start)
+}
+
+// Organization represents the SAML OrganizationType object.
+//
+// See http://docs.oasis-open.org/security/saml/v2.0/saml-metadata-2.0-os.pdf section 2.3.2.1
+type Organization struct {
+	Names        []LocalizedName `xml:"OrganizationName"`
+	DisplayNames []LocalizedName `xml:"OrganizationDisplayName"`
+	URLs         []LocalizedName `xml:"OrganizationURL"`
+}
+
+// Contact types of ContactPerson.
+const (
+	ContactTypeTechnical      = "technical"
+	ContactTypeSupport        = "support"
+	ContactTypeAdministrative = "administrative"
+	ContactTypeBilling        = "billing"
+	ContactTypeOther          = "other"
+)
+
+// ContactPerson represents the SAML ContactType object.
+//
+// See http://docs.oasis-open.org/security/saml/v2.0/saml-metadata-2.0-os.pdf section 2.3.2.2
+type ContactPerson struct {
+	ContactType      string   `xml:"contactType,attr"`
+	Company          string   `xml:"Company,omitempty"`
+	GivenName        string   `xml:"GivenName,omitempty"`
+	SurName          string   `xml:"SurName,omitempty"`
+	EmailAddresses   []string `xml:"EmailAddress"`
+	TelephoneNumbers []string `xml:"TelephoneNumber"`
 }
 
 // KeyDescriptor represents the XMLSEC object of the same name
@@ -76,6 +132,7 @@ type EncryptionMethod struct {
 // KeyInfo represents the XMLSEC object of the same name
 type KeyInfo struct {
 	XMLName     xml.Name `xml:"http://www.w3.org/2000/09/xmldsig# KeyInfo"`
+	KeyNames    []string `xml:"KeyName"`
 	Certificate string   `xml:"X509Data>X509Certificate"`
 }
 
@@ -92,9 +149,10 @@ type Endpoint struct {
 //
 // See http://docs.oasis-open.org/security/saml/v2.0/saml-metadata-2.0-os.pdf section 2.2.3
 type IndexedEndpoint struct {
-	Binding  string `xml:"Binding,attr"`
-	Location string `xml:"Location,attr"`
-	Index    int    `xml:"index,attr"`
+	Binding   string `xml:"Binding,attr"`
+	Location  string `xml:"Location,attr"`
+	Index     int    `xml:"index,attr"`
+	IsDefault bool   `xml:"isDefault,attr,omitempty"`
 }
 
 // SPSSODescriptor represents the SAML SPSSODescriptorType object.
@@ -105,13 +163,38 @@ type SPSSODescriptor struct {
 	AuthnRequestsSigned        bool              `xml:",attr"`
 	WantAssertionsSigned       bool              `xml:",attr"`
 	ProtocolSupportEnumeration string            `xml:"protocolSupportEnumeration,attr"`
+	Extensions                 *Extensions       `xml:"Extensions,omitempty"`
 	KeyDescriptor              []KeyDescriptor   `xml:"KeyDescriptor"`
 	ArtifactResolutionService  []IndexedEndpoint `xml:"ArtifactResolutionService"`
 	SingleLogoutService        []Endpoint        `xml:"SingleLogoutService"`
 	ManageNameIDService        []Endpoint
-	NameIDFormat               []string          `xml:"NameIDFormat"`
-	AssertionConsumerService   []IndexedEndpoint `xml:"AssertionConsumerService"`
-	AttributeConsumingService  []interface{}
+	NameIDFormat               []string                    `xml:"NameIDFormat"`
+	AssertionConsumerService   []IndexedEndpoint           `xml:"AssertionConsumerService"`
+	AttributeConsumingService  []AttributeConsumingService `xml:"AttributeConsumingService"`
+}
+
+// AttributeConsumingService represents the SAML AttributeConsumingService
+// object: the attributes an SP asks the IdP to release.
+//
+// See http://docs.oasis-open.org/security/saml/v2.0/saml-metadata-2.0-os.pdf section 2.4.4.1
+type AttributeConsumingService struct {
+	Index               int                  `xml:"index,attr"`
+	IsDefault           bool                 `xml:"isDefault,attr,omitempty"`
+	ServiceNames        []LocalizedName      `xml:"ServiceName"`
+	ServiceDescriptions []LocalizedName      `xml:"ServiceDescription"`
+	RequestedAttributes []RequestedAttribute `xml:"RequestedAttribute"`
+}
+
+// RequestedAttribute represents the SAML RequestedAttribute object. Values,
+// when set, restrict the values the SP is interested in.
+//
+// See http://docs.oasis-open.org/security/saml/v2.0/saml-metadata-2.0-os.pdf section 2.4.4.2
+type RequestedAttribute struct {
+	Name         string           `xml:"Name,attr"`
+	NameFormat   string           `xml:"NameFormat,attr,omitempty"`
+	FriendlyName string           `xml:"FriendlyName,attr,omitempty"`
+	IsRequired   bool             `xml:"isRequired,attr,omitempty"`
+	Values       []AttributeValue `xml:"urn:oasis:names:tc:SAML:2.0:assertion AttributeValue"`
 }
 
 // IDPSSODescriptor represents the SAML IDPSSODescriptorType object.
@@ -120,9 +203,14 @@ type SPSSODescriptor struct {
 type IDPSSODescriptor struct {
 	XMLName                    xml.Name        `xml:"urn:oasis:names:tc:SAML:2.0:metadata IDPSSODescriptor"`
 	ProtocolSupportEnumeration string          `xml:"protocolSupportEnumeration,attr"`
+	Extensions                 *Extensions     `xml:"Extensions,omitempty"`
 	KeyDescriptor              []KeyDescriptor `xml:"KeyDescriptor"`
+	SingleLogoutService        []Endpoint      `xml:"SingleLogoutService"`
+	ManageNameIDService        []Endpoint      `xml:"ManageNameIDService"`
 	NameIDFormat               []string        `xml:"NameIDFormat"`
 	SingleSignOnService        []Endpoint      `xml:"SingleSignOnService"`
+	NameIDMappingService       []Endpoint      `xml:"NameIDMappingService"`
+	AssertionIDRequestService  []Endpoint      `xml:"AssertionIDRequestService"`
 }
 
 type CacheDuration struct {
@@ -131,13 +219,61 @@ type CacheDuration struct {
 	parsed time.Duration
 }
 
+// NewCacheDuration returns the CacheDuration of d, written as a xsd:duration
+// in days, hours, minutes and seconds.
+func NewCacheDuration(d time.Duration) *CacheDuration {
+	return &CacheDuration{raw: formatCacheDuration(d), parsed: d}
+}
+
+func formatCacheDuration(d time.Duration) string {
+	var b strings.Builder
+	if d < 0 {
+		b.WriteByte('-')
+		d = -d
+	}
+	b.WriteByte('P')
+	days := d / (24 * time.Hour)
+	d -= days * 24 * time.Hour
+	if days > 0 {
+		fmt.Fprintf(&b, "%dD", days)
+	}
+	if d == 0 {
+		if days == 0 {
+			b.WriteString("T0S")
+		}
+		return b.String()
+	}
+	b.WriteByte('T')
+	if hours := d / time.Hour; hours > 0 {
+		fmt.Fprintf(&b, "%dH", hours)
+		d -= hours * time.Hour
+	}
+	if minutes := d / time.Minute; minutes > 0 {
+		fmt.Fprintf(&b, "%dM", minutes)
+		d -= minutes * time.Minute
+	}
+	if d > 0 {
+		b.WriteString(strconv.FormatFloat(d.Seconds(), 'f', -1, 64))
+		b.WriteByte('S')
+	}
+	return b.String()
+}
+
 func (duration *CacheDuration) Duration() time.Duration {
 	return duration.parsed
 }
 
+// MarshalXMLAttr implements xml.MarshalerAttr. The value read from the
+// metadata, or built by NewCacheDuration, is written back as is.
+func (duration *CacheDuration) MarshalXMLAttr(name xml.Name) (xml.Attr, error) {
+	return xml.Attr{Name: name, Value: duration.raw}, nil
+}
+
+// MarshalAttr is an alias of MarshalXMLAttr.
+//
+// Deprecated: this method is not called by encoding/xml, use MarshalXMLAttr.
 func (duration *CacheDuration) MarshalAttr(name xml.Name) (xml.Attr, error) {
-	// TODO: build cacheDuration from time.Duration
-	return duration.attr, nil
+	return duration.MarshalXMLAttr(name)
 }
 
 func (duration *CacheDuration) UnmarshalXMLAttr(attr xml.Attr) error {
@@ -0,0 +1,61 @@
+package saml
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// RefreshIdPMetadata downloads the IdP metadata from IdPMetadataURL, or
+// IdPEntityID, again.
+// The download is skipped by the server if the metadata didn't change,
+// using the ETag and Last-Modified headers of the previous download. On
+// failure, the current metadata is kept.
+func (sp *ServiceProvider) RefreshIdPMetadata(ctx context.Context) error {
+	metadataMu.Lock()
+	metadataURL, prev := sp.idpMetadataURL(), sp.idpMetadataValidators
+	metadataMu.Unlock()
+	if metadataURL == "" {
+		return errors.New("missing metadata URL")
+	}
+
+	buf, metadata, validators, err := sp.fetchIdPMetadata(ctx, prev)
+	if err == errNotModified {
+		sp.logger().Debug("IdP metadata not modified", "url", metadataURL)
+		return nil
+	}
+	if err != nil {
+		return errors.Wrap(err, "failed to refresh IdP metadata")
+	}
+
+	metadataMu.Lock()
+	sp.IdPMetadataXML = buf
+	sp.IdPMetadata = metadata
+	sp.idpMetadataValidators = validators
+	metadataMu.Unlock()
+	sp.logger().Info("IdP metadata refreshed", "url", metadataURL)
+	return nil
+}
+
+// RefreshIdPMetadataEvery calls RefreshIdPMetadata every interval until ctx
+// is done, followed by CheckCertificateExpiry. Failures are logged.
+//
+//	go sp.RefreshIdPMetadataEvery(ctx, time.Hour)
+func (sp *ServiceProvider) RefreshIdPMetadataEvery(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := sp.RefreshIdPMetadata(ctx); err != nil && ctx.Err() == nil {
+				sp.logger().Error("IdP metadata refresh failed", "url", sp.idpMetadataURL(), "err", err)
+			}
+			if _, err := sp.CheckCertificateExpiry(ctx); err != nil && ctx.Err() == nil {
+				sp.logger().Error("certificate expiry check failed", "err", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package saml
+
+import (
+	"context"
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRefreshIdPMetadata(t *testing.T) {
+	entityID := "https://idp.example.com/v1"
+	var requests []*http.Request
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r)
+		etag := `"` + entityID + `"`
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		buf, _ := xml.Marshal(&Metadata{EntityID: entityID})
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Last-Modified", "Tue, 14 May 2024 09:30:00 GMT")
+		w.Write(buf)
+	}))
+	defer srv.Close()
+
+	sp := &ServiceProvider{IdPMetadataURL: srv.URL}
+	metadata, err := sp.GetIdPMetadata()
+	assert.NoError(t, err)
+	assert.Equal(t, "https://idp.example.com/v1", metadata.EntityID)
+	assert.Empty(t, requests[0].Header.Get("If-None-Match"))
+
+	// Not modified.
+	assert.NoError(t, sp.RefreshIdPMetadata(context.Background()))
+	assert.Len(t, requests, 2)
+	assert.Equal(t, `"https://idp.example.com/v1"`, requests[1].Header.Get("If-None-Match"))
+	assert.Equal(t, "Tue, 14 May 2024 09:30:00 GMT", requests[1].Header.Get("If-Modified-Since"))
+	assert.Equal(t, "https://idp.example.com/v1", sp.IdPMetadata.EntityID)
+
+	// Modified.
+	entityID = "https://idp.example.com/v2"
+	assert.NoError(t, sp.RefreshIdPMetadata(context.Background()))
+	metadata, err = sp.GetIdPMetadata()
+	assert.NoError(t, err)
+	assert.Equal(t, "https://idp.example.com/v2", metadata.EntityID)
+	assert.Equal(t, `"https://idp.example.com/v2"`, sp.idpMetadataValidators.ETag)
+
+	// Failures keep the current metadata.
+	srv.Config.Handler = http.NotFoundHandler()
+	assert.Error(t, sp.RefreshIdPMetadata(context.Background()))
+	assert.Equal(t, "https://idp.example.com/v2", sp.IdPMetadata.EntityID)
+
+	assert.Error(t, (&ServiceProvider{}).RefreshIdPMetadata(context.Background()))
+}
+
+func TestWellKnownLocation(t *testing.T) {
+	entityID := ""
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		buf, _ := xml.Marshal(&Metadata{EntityID: entityID})
+		w.Write(buf)
+	}))
+	defer srv.Close()
+	entityID = srv.URL + "/idp"
+
+	sp := &ServiceProvider{IdPEntityID: srv.URL + "/idp"}
+	metadata, err := sp.GetIdPMetadata()
+	assert.NoError(t, err)
+	assert.Equal(t, srv.URL+"/idp", metadata.EntityID)
+
+	// The metadata found must be the IdP's.
+	entityID = "https://evil.example.com"
+	sp = &ServiceProvider{IdPEntityID: srv.URL + "/idp"}
+	_, err = sp.GetIdPMetadata()
+	assert.Error(t, err)
+
+	// Entity IDs that are not URLs can't be resolved.
+	sp = &ServiceProvider{IdPEntityID: "urn:example:idp"}
+	_, err = sp.GetIdPMetadata()
+	assert.Error(t, err)
+}
@@ -128,6 +128,25 @@ func TestMetadataXML(t *testing.T) {
 		}
 	}
 }
+func TestCacheDurationXML(t *testing.T) {
+	var metadata Metadata
+	data := `<EntityDescriptor xmlns="urn:oasis:names:tc:SAML:2.0:metadata" entityID="https://idp.example.com" cacheDuration="PT1H"/>`
+	if err := xml.Unmarshal([]byte(data), &metadata); err != nil {
+		t.Fatal(err)
+	}
+	buf, err := xml.Marshal(&metadata)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var again Metadata
+	if err := xml.Unmarshal(buf, &again); err != nil {
+		t.Fatal(err)
+	}
+	if again.CacheDuration == nil || again.CacheDuration.Duration() != time.Hour {
+		t.Errorf("cacheDuration lost in %s", buf)
+	}
+}
+
 func TestDuration(t *testing.T) {
 	tests := []struct {
 		Name     string
@@ -266,3 +285,33 @@ func TestDuration(t *testing.T) {
 		}
 	}
 }
+
+func TestNewCacheDuration(t *testing.T) {
+	tests := []struct {
+		Duration time.Duration
+		Value    string
+	}{
+		{0, "PT0S"},
+		{24 * time.Hour, "P1D"},
+		{6 * time.Hour, "PT6H"},
+		{36*time.Hour + 90*time.Second, "P1DT12H1M30S"},
+		{1500 * time.Millisecond, "PT1.5S"},
+		{-2 * time.Hour, "-PT2H"},
+	}
+	for _, tt := range tests {
+		attr, err := NewCacheDuration(tt.Duration).MarshalXMLAttr(xml.Name{Local: "cacheDuration"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if attr.Value != tt.Value {
+			t.Errorf("%v: expected %q, got %q", tt.Duration, tt.Value, attr.Value)
+		}
+
+		var parsed CacheDuration
+		if err := parsed.UnmarshalXMLAttr(attr); err != nil {
+			t.Errorf("%v: %v", tt.Value, err)
+		} else if parsed.Duration() != tt.Duration {
+			t.Errorf("%v: parsed as %v", tt.Value, parsed.Duration())
+		}
+	}
+}
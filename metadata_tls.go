@@ -0,0 +1,78 @@
+package saml
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"net/http"
+
+	"github.com/pkg/errors"
+)
+
+// MetadataTLS restricts the TLS certificates accepted when downloading the
+// IdP metadata. Unless the metadata is signed, the integrity of the download
+// is what the trust in the IdP relies on: when set, the metadata URL and the
+// redirects it leads to must be https URLs.
+type MetadataTLS struct {
+	// RootCAs, when set, replaces the system roots to verify the server
+	// certificate. A self-signed server certificate can be trusted by adding
+	// it to the pool.
+	RootCAs *x509.CertPool
+
+	// Pins, when set, lists the accepted public keys, as returned by
+	// PublicKeyPin. One of the certificates of the verified chain must
+	// match.
+	Pins []string
+}
+
+// PublicKeyPin returns the pin of the public key of a certificate for
+// MetadataTLS.Pins: the base64 SHA-256 digest of its SubjectPublicKeyInfo.
+func PublicKeyPin(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// client returns the HTTP client enforcing the restrictions.
+func (m *MetadataTLS) client() *http.Client {
+	if m == nil {
+		return http.DefaultClient
+	}
+	config := &tls.Config{
+		RootCAs: m.RootCAs,
+	}
+	if len(m.Pins) > 0 {
+		config.VerifyConnection = m.verifyPins
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = config
+	return &http.Client{Transport: httpsTransport{transport}}
+}
+
+// httpsTransport refuses the requests not sent over TLS, including those
+// following redirects, which the restrictions of MetadataTLS would not
+// apply to.
+type httpsTransport struct {
+	*http.Transport
+}
+
+func (t httpsTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Scheme != "https" {
+		return nil, errors.Errorf("refusing to download the metadata from %s: https is required", req.URL.Redacted())
+	}
+	return t.Transport.RoundTrip(req)
+}
+
+func (m *MetadataTLS) verifyPins(cs tls.ConnectionState) error {
+	for _, chain := range cs.VerifiedChains {
+		for _, cert := range chain {
+			pin := PublicKeyPin(cert)
+			for _, expected := range m.Pins {
+				if pin == expected {
+					return nil
+				}
+			}
+		}
+	}
+	return errors.New("no pinned public key in the certificate chain of the metadata server")
+}
@@ -0,0 +1,60 @@
+package saml
+
+import (
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetadataTLS(t *testing.T) {
+	buf, err := xml.Marshal(&Metadata{EntityID: "https://idp.example.com"})
+	assert.NoError(t, err)
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(buf)
+	}))
+	defer srv.Close()
+
+	roots := srv.Client().Transport.(*http.Transport).TLSClientConfig.RootCAs
+	pin := PublicKeyPin(srv.Certificate())
+
+	for name, test := range map[string]struct {
+		tls *MetadataTLS
+		ok  bool
+	}{
+		"system roots": {nil, false},
+		"custom roots": {&MetadataTLS{RootCAs: roots}, true},
+		"pinned":       {&MetadataTLS{RootCAs: roots, Pins: []string{"other", pin}}, true},
+		"wrong pin":    {&MetadataTLS{RootCAs: roots, Pins: []string{"other"}}, false},
+	} {
+		sp := &ServiceProvider{IdPMetadataURL: srv.URL, IdPMetadataTLS: test.tls}
+		_, err := sp.GetIdPMetadata()
+		if test.ok {
+			assert.NoError(t, err, name)
+		} else {
+			assert.Error(t, err, name)
+		}
+	}
+
+	// The restrictions only apply to https, which is then required.
+	plain := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(buf)
+	}))
+	defer plain.Close()
+	sp := &ServiceProvider{IdPMetadataURL: plain.URL, IdPMetadataTLS: &MetadataTLS{RootCAs: roots}}
+	_, err = sp.GetIdPMetadata()
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "https is required")
+	}
+
+	redirect := httptest.NewTLSServer(http.RedirectHandler(plain.URL, http.StatusFound))
+	defer redirect.Close()
+	roots.AddCert(redirect.Certificate())
+	sp = &ServiceProvider{IdPMetadataURL: redirect.URL, IdPMetadataTLS: &MetadataTLS{RootCAs: roots}}
+	_, err = sp.GetIdPMetadata()
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "https is required")
+	}
+}
@@ -0,0 +1,83 @@
+package saml
+
+import (
+	"errors"
+	"time"
+)
+
+// MetricsCollector receives the events of a ServiceProvider worth counting.
+// The errors passed are nil on success; FailureReason turns them into a
+// label of bounded cardinality. See the samlprom package for a Prometheus
+// implementation.
+type MetricsCollector interface {
+	// AuthnRequestIssued is called for every AuthnRequest sent to the IdP.
+	AuthnRequestIssued()
+	// ResponseValidated is called with the outcome of the validation of
+	// every SAML response received.
+	ResponseValidated(err error)
+	// MetadataRefreshed is called when the IdP metadata is fetched.
+	MetadataRefreshed(err error)
+	// SignatureVerified is called after every signature verification.
+	SignatureVerified(duration time.Duration, err error)
+}
+
+type nopMetrics struct{}
+
+func (nopMetrics) AuthnRequestIssued()                    {}
+func (nopMetrics) ResponseValidated(error)                {}
+func (nopMetrics) MetadataRefreshed(error)                {}
+func (nopMetrics) SignatureVerified(time.Duration, error) {}
+
+func (sp *ServiceProvider) metrics() MetricsCollector {
+	if sp.Metrics == nil {
+		return nopMetrics{}
+	}
+	return sp.Metrics
+}
+
+// failureReasons are the labels of the validation error kinds.
+var failureReasons = []struct {
+	kind   error
+	reason string
+}{
+	{ErrMalformedResponse, "malformed_response"},
+	{ErrMessageTooLarge, "message_too_large"},
+	{ErrWrongDestination, "wrong_destination"},
+	{ErrIssuerMismatch, "issuer_mismatch"},
+	{ErrStatusNotSuccess, "status_not_success"},
+	{ErrUnexpectedInResponseTo, "unexpected_in_response_to"},
+	{ErrMissingSignature, "missing_signature"},
+	{ErrInvalidSignature, "invalid_signature"},
+	{ErrDecryption, "decryption"},
+	{ErrMissingAssertion, "missing_assertion"},
+	{ErrWrongRecipient, "wrong_recipient"},
+	{ErrNoBearerConfirmation, "no_bearer_confirmation"},
+	{ErrWrongAddress, "wrong_address"},
+	{ErrMissingConditions, "missing_conditions"},
+	{ErrAssertionNotYetValid, "assertion_not_yet_valid"},
+	{ErrExpiredAssertion, "expired_assertion"},
+	{ErrWrongAudience, "wrong_audience"},
+	{ErrProxyRestriction, "proxy_restriction"},
+	{ErrAuthnTooOld, "authn_too_old"},
+	{ErrMissingAuthnStatement, "missing_authn_statement"},
+	{ErrAuthnContext, "authn_context"},
+	{ErrWrongSubject, "wrong_subject"},
+	{ErrInvalidRelayState, "invalid_relay_state"},
+	{ErrRedirectNotAllowed, "redirect_not_allowed"},
+	{ErrRateLimited, "rate_limited"},
+}
+
+// FailureReason returns a short label describing err: "success" if it is
+// nil, the snake-cased kind of a ValidationError such as
+// "wrong_destination", or "error" for any other error.
+func FailureReason(err error) string {
+	if err == nil {
+		return "success"
+	}
+	for _, r := range failureReasons {
+		if errors.Is(err, r.kind) {
+			return r.reason
+		}
+	}
+	return "error"
+}
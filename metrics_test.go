@@ -0,0 +1,48 @@
+package saml
+
+import (
+	"errors"
+	"fmt"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFailureReason(t *testing.T) {
+	assert.Equal(t, "success", FailureReason(nil))
+	assert.Equal(t, "wrong_destination", FailureReason(validationErrorf(ErrWrongDestination, nil, "x")))
+	assert.Equal(t, "expired_assertion", FailureReason(fmt.Errorf("wrapped: %w", validationErrorf(ErrExpiredAssertion, nil, "x"))))
+	assert.Equal(t, "error", FailureReason(errors.New("xmlsec1 not found")))
+}
+
+type countingMetrics struct {
+	nopMetrics
+	authnRequests int
+	responses     []string
+}
+
+func (m *countingMetrics) AuthnRequestIssued() { m.authnRequests++ }
+func (m *countingMetrics) ResponseValidated(err error) {
+	m.responses = append(m.responses, FailureReason(err))
+}
+
+func TestMetrics(t *testing.T) {
+	tearUp()
+
+	m := &countingMetrics{}
+	sp := newTestResponseSP()
+	sp.Metrics = m
+	sp.IdPMetadata.IDPSSODescriptor = &IDPSSODescriptor{
+		SingleSignOnService: []Endpoint{{Binding: HTTPRedirectBinding, Location: "http://localhost:1233/saml/sso"}},
+	}
+
+	_, err := sp.AuthnRequestURL("")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, m.authnRequests)
+
+	res := &Response{Destination: "http://localhost:1235/saml/other"}
+	_, err = sp.AssertResponse(httptest.NewRequest("POST", sp.AcsURL, nil), encodeTestResponse(t, res))
+	assert.Error(t, err)
+	assert.Equal(t, []string{"wrong_destination"}, m.responses)
+}
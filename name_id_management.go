@@ -0,0 +1,708 @@
+package saml
+
+import (
+	"context"
+	"encoding/pem"
+	"encoding/xml"
+	"net/http"
+	"time"
+
+	"github.com/goware/saml/xmlsec"
+	"github.com/pkg/errors"
+)
+
+// ManageNameIDRequest represents the SAML object of the same name, sent by an
+// IdP or an SP to change the persistent identifier of a principal (NewID),
+// or to stop using it (Terminate).
+//
+// See http://docs.oasis-open.org/security/saml/v2.0/saml-core-2.0-os.pdf section 3.6.1
+type ManageNameIDRequest struct {
+	XMLName        xml.Name          `xml:"urn:oasis:names:tc:SAML:2.0:protocol ManageNameIDRequest"`
+	ID             string            `xml:",attr"`
+	Version        string            `xml:",attr"`
+	IssueInstant   time.Time         `xml:",attr"`
+	Destination    string            `xml:",attr,omitempty"`
+	Issuer         Issuer            `xml:"urn:oasis:names:tc:SAML:2.0:assertion Issuer"`
+	Signature      *xmlsec.Signature `xml:"http://www.w3.org/2000/09/xmldsig# Signature"`
+	NameID         *NameID           `xml:"urn:oasis:names:tc:SAML:2.0:assertion NameID"`
+	EncryptedID    *EncryptedID      `xml:"urn:oasis:names:tc:SAML:2.0:assertion EncryptedID"`
+	NewID          string            `xml:"urn:oasis:names:tc:SAML:2.0:protocol NewID,omitempty"`
+	NewEncryptedID *EncryptedID      `xml:"urn:oasis:names:tc:SAML:2.0:protocol NewEncryptedID"`
+	Terminate      *struct{}         `xml:"urn:oasis:names:tc:SAML:2.0:protocol Terminate"`
+}
+
+// MarshalXML implements xml.Marshaler. The request is written with the
+// samlp: and saml: prefixes, as AuthnRequest.
+func (req ManageNameIDRequest) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	type manageNameIDRequest ManageNameIDRequest
+	buf, err := xml.Marshal(manageNameIDRequest(req))
+	if err != nil {
+		return err
+	}
+	return encodePrefixed(e, buf)
+}
+
+// ManageNameIDResponse represents the SAML object of the same name.
+//
+// See http://docs.oasis-open.org/security/saml/v2.0/saml-core-2.0-os.pdf section 3.6.2
+type ManageNameIDResponse struct {
+	XMLName      xml.Name          `xml:"urn:oasis:names:tc:SAML:2.0:protocol ManageNameIDResponse"`
+	ID           string            `xml:",attr"`
+	InResponseTo string            `xml:",attr"`
+	IssueInstant time.Time         `xml:",attr"`
+	Version      string            `xml:",attr"`
+	Destination  string            `xml:",attr,omitempty"`
+	Issuer       *Issuer           `xml:"urn:oasis:names:tc:SAML:2.0:assertion Issuer"`
+	Signature    *xmlsec.Signature `xml:"http://www.w3.org/2000/09/xmldsig# Signature"`
+	Status       *Status           `xml:"urn:oasis:names:tc:SAML:2.0:protocol Status"`
+}
+
+// NameIDMappingRequest represents the SAML object of the same name, sent by an
+// SP to get from the IdP the identifier of a principal for another SP,
+// described by NameIDPolicy.
+//
+// See http://docs.oasis-open.org/security/saml/v2.0/saml-core-2.0-os.pdf section 3.8.1
+type NameIDMappingRequest struct {
+	XMLName      xml.Name          `xml:"urn:oasis:names:tc:SAML:2.0:protocol NameIDMappingRequest"`
+	ID           string            `xml:",attr"`
+	Version      string            `xml:",attr"`
+	IssueInstant time.Time         `xml:",attr"`
+	Destination  string            `xml:",attr,omitempty"`
+	Issuer       Issuer            `xml:"urn:oasis:names:tc:SAML:2.0:assertion Issuer"`
+	Signature    *xmlsec.Signature `xml:"http://www.w3.org/2000/09/xmldsig# Signature"`
+	NameID       *NameID           `xml:"urn:oasis:names:tc:SAML:2.0:assertion NameID"`
+	EncryptedID  *EncryptedID      `xml:"urn:oasis:names:tc:SAML:2.0:assertion EncryptedID"`
+	NameIDPolicy NameIDPolicy
+}
+
+// MarshalXML implements xml.Marshaler. The request is written with the
+// samlp: and saml: prefixes, as AuthnRequest.
+func (req NameIDMappingRequest) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	type nameIDMappingRequest NameIDMappingRequest
+	buf, err := xml.Marshal(nameIDMappingRequest(req))
+	if err != nil {
+		return err
+	}
+	return encodePrefixed(e, buf)
+}
+
+// NameIDMappingResponse represents the SAML object of the same name, carrying
+// the mapped identifier, usually encrypted for the SP it is meant for.
+//
+// See http://docs.oasis-open.org/security/saml/v2.0/saml-core-2.0-os.pdf section 3.8.2
+type NameIDMappingResponse struct {
+	XMLName      xml.Name          `xml:"urn:oasis:names:tc:SAML:2.0:protocol NameIDMappingResponse"`
+	ID           string            `xml:",attr"`
+	InResponseTo string            `xml:",attr"`
+	IssueInstant time.Time         `xml:",attr"`
+	Version      string            `xml:",attr"`
+	Destination  string            `xml:",attr,omitempty"`
+	Issuer       *Issuer           `xml:"urn:oasis:names:tc:SAML:2.0:assertion Issuer"`
+	Signature    *xmlsec.Signature `xml:"http://www.w3.org/2000/09/xmldsig# Signature"`
+	Status       *Status           `xml:"urn:oasis:names:tc:SAML:2.0:protocol Status"`
+	NameID       *NameID           `xml:"urn:oasis:names:tc:SAML:2.0:assertion NameID"`
+	EncryptedID  *EncryptedID      `xml:"urn:oasis:names:tc:SAML:2.0:assertion EncryptedID"`
+}
+
+// newIDElement is used to decrypt the NewEncryptedID of a
+// ManageNameIDRequest.
+type newIDElement struct {
+	XMLName xml.Name `xml:"urn:oasis:names:tc:SAML:2.0:protocol NewID"`
+	Value   string   `xml:",chardata"`
+}
+
+// NameIDChange is the change of identifier asked by a ManageNameIDRequest,
+// with its identifiers decrypted.
+type NameIDChange struct {
+	// NameID is the current identifier of the principal.
+	NameID NameID
+
+	// NewID is the new identifier, when the request changes it.
+	NewID string
+
+	// Terminate tells that the identifier must no longer be used.
+	Terminate bool
+}
+
+// NameIDManager manages the persistent identifiers of an IdP, for its
+// ManageNameIDHandler and NameIDMappingHandler. Implementations must be safe
+// for concurrent use. The errors of type *StatusError are returned to the
+// SP with their status codes, the others with StatusResponder.
+type NameIDManager interface {
+	// ManageNameID applies the change asked by the SP spEntityID.
+	ManageNameID(ctx context.Context, spEntityID string, change *NameIDChange) error
+
+	// MapNameID returns the identifier of the principal of nameID, known
+	// to the SP spEntityID, for the SP described by policy: either in
+	// clear, or, preferably, encrypted for the SP it is meant for with
+	// EncryptNameID.
+	MapNameID(ctx context.Context, spEntityID string, nameID *NameID, policy *NameIDPolicy) (*NameID, *EncryptedID, error)
+}
+
+// errorStatus returns the Status answering a request which failed with err.
+func errorStatus(err error) *Status {
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) {
+		return &Status{StatusCode: StatusCode{Value: StatusResponder}}
+	}
+	status := &Status{
+		StatusCode:    StatusCode{Value: statusErr.Code},
+		StatusMessage: statusErr.Message,
+	}
+	if statusErr.SubCode != "" {
+		status.StatusCode.StatusCode = &StatusCode{Value: statusErr.SubCode}
+	}
+	return status
+}
+
+// signXML signs the message buf, which holds a signature template, with the
+// key in keyFile.
+func signXML(buf []byte, keyFile string) ([]byte, error) {
+	signed, err := xmlsec.Sign(buf, keyFile, &xmlsec.ValidationOptions{
+		EnableIDAttrHack: true,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to sign message")
+	}
+	return signed, nil
+}
+
+// marshalSigned marshals msg, signed with the SP's key if it has one: the
+// signature template is set in *signature, the Signature field of msg.
+func (sp *ServiceProvider) marshalSigned(msg interface{}, signature **xmlsec.Signature) ([]byte, error) {
+	if sp.KeyFile == "" && sp.PrivkeyPEM == "" {
+		return xml.Marshal(msg)
+	}
+	cert, err := sp.Cert()
+	if err != nil {
+		return nil, err
+	}
+	keyFile, err := sp.PrivkeyFile()
+	if err != nil {
+		return nil, err
+	}
+	template := xmlsec.DefaultSignature(pem.EncodeToMemory(cert))
+	*signature = &template
+	buf, err := xml.Marshal(msg)
+	if err != nil {
+		return nil, err
+	}
+	return signXML(buf, keyFile)
+}
+
+// marshalSigned marshals msg, signed with the IdP's key: the signature
+// template is set in *signature, the Signature field of msg.
+func (idp *IdentityProvider) marshalSigned(msg interface{}, signature **xmlsec.Signature) ([]byte, error) {
+	cert, err := idp.Cert()
+	if err != nil {
+		return nil, err
+	}
+	keyFile, err := idp.PrivkeyFile()
+	if err != nil {
+		return nil, err
+	}
+	template := xmlsec.DefaultSignature(pem.EncodeToMemory(cert))
+	*signature = &template
+	buf, err := xml.Marshal(msg)
+	if err != nil {
+		return nil, err
+	}
+	return signXML(buf, keyFile)
+}
+
+// ChangeNameID asks the IdP, with a ManageNameIDRequest sent with the SOAP
+// binding, to use newID as the SPProvidedID of the principal of nameID.
+func (sp *ServiceProvider) ChangeNameID(ctx context.Context, nameID NameID, newID string) error {
+	if newID == "" {
+		return errors.New("missing new identifier")
+	}
+	return sp.manageNameID(ctx, nameID, func(req *ManageNameIDRequest) {
+		req.NewID = newID
+	})
+}
+
+// TerminateNameID tells the IdP, with a ManageNameIDRequest sent with the
+// SOAP binding, that the SP no longer uses the identifier nameID, e.g. when
+// the account of the principal is deleted.
+func (sp *ServiceProvider) TerminateNameID(ctx context.Context, nameID NameID) error {
+	return sp.manageNameID(ctx, nameID, func(req *ManageNameIDRequest) {
+		req.Terminate = &struct{}{}
+	})
+}
+
+func (sp *ServiceProvider) manageNameID(ctx context.Context, nameID NameID, opt func(req *ManageNameIDRequest)) error {
+	if nameID.Value == "" {
+		return errors.New("missing NameID value")
+	}
+	meta, err := sp.GetIdPMetadataContext(ctx)
+	if err != nil {
+		return err
+	}
+	location := ""
+	if meta.IDPSSODescriptor != nil {
+		location = soapLocation(meta.IDPSSODescriptor.ManageNameIDService)
+	}
+	if location == "" {
+		return errors.New("the IdP has no ManageNameIDService with the SOAP binding")
+	}
+
+	req := &ManageNameIDRequest{
+		ID:           sp.newID(),
+		Version:      "2.0",
+		IssueInstant: sp.now(),
+		Destination:  location,
+		Issuer: Issuer{
+			Format: "urn:oasis:names:tc:SAML:2.0:nameid-format:entity",
+			Value:  sp.entityID(),
+		},
+		NameID: &nameID,
+	}
+	opt(req)
+	if req.EncryptedID, err = sp.encryptNameIDForIdP(ctx, meta, &nameID); err != nil {
+		return err
+	}
+	if req.EncryptedID != nil {
+		req.NameID = nil
+	}
+	msg, err := sp.marshalSigned(req, &req.Signature)
+	if err != nil {
+		return err
+	}
+
+	var res ManageNameIDResponse
+	return sp.soapRequest(ctx, location, msg, req.ID, "ManageNameIDResponse", &res, func() (*Issuer, *xmlsec.Signature, *Status) {
+		return res.Issuer, res.Signature, res.Status
+	})
+}
+
+// MapNameID asks the IdP, with a NameIDMappingRequest sent with the SOAP
+// binding, for the identifier of the principal of nameID known to the SP
+// described by policy. The identifier is returned in the NameID or, usually,
+// the EncryptedID of the response.
+func (sp *ServiceProvider) MapNameID(ctx context.Context, nameID NameID, policy NameIDPolicy) (*NameIDMappingResponse, error) {
+	if nameID.Value == "" {
+		return nil, errors.New("missing NameID value")
+	}
+	meta, err := sp.GetIdPMetadataContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	location := ""
+	if meta.IDPSSODescriptor != nil {
+		location = soapLocation(meta.IDPSSODescriptor.NameIDMappingService)
+	}
+	if location == "" {
+		return nil, errors.New("the IdP has no NameIDMappingService with the SOAP binding")
+	}
+
+	req := &NameIDMappingRequest{
+		ID:           sp.newID(),
+		Version:      "2.0",
+		IssueInstant: sp.now(),
+		Destination:  location,
+		Issuer: Issuer{
+			Format: "urn:oasis:names:tc:SAML:2.0:nameid-format:entity",
+			Value:  sp.entityID(),
+		},
+		NameID:       &nameID,
+		NameIDPolicy: policy,
+	}
+	if req.EncryptedID, err = sp.encryptNameIDForIdP(ctx, meta, &nameID); err != nil {
+		return nil, err
+	}
+	if req.EncryptedID != nil {
+		req.NameID = nil
+	}
+	msg, err := sp.marshalSigned(req, &req.Signature)
+	if err != nil {
+		return nil, err
+	}
+
+	var res NameIDMappingResponse
+	err = sp.soapRequest(ctx, location, msg, req.ID, "NameIDMappingResponse", &res, func() (*Issuer, *xmlsec.Signature, *Status) {
+		return res.Issuer, res.Signature, res.Status
+	})
+	if err != nil {
+		return nil, err
+	}
+	if res.NameID == nil && res.EncryptedID == nil {
+		return nil, validationErrorf(ErrMalformedResponse, nil, "missing NameID")
+	}
+	return &res, nil
+}
+
+// encryptNameIDForIdP returns nameID encrypted for the IdP when its
+// metadata publishes an encryption key, as NewLogoutRequest.
+func (sp *ServiceProvider) encryptNameIDForIdP(ctx context.Context, meta *Metadata, nameID *NameID) (*EncryptedID, error) {
+	if !hasEncryptionKey(meta.IDPSSODescriptor) {
+		return nil, nil
+	}
+	certFile, err := sp.GetIdPCertFileContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return EncryptNameID(nameID, certFile)
+}
+
+// soapRequest sends the request msg to the IdP at location and decodes its
+// response, of element local, into res. fields returns the issuer,
+// signature and status of res, which are checked. The response signature is
+// verified when there is one: without, the response is trusted as received
+// from the endpoint of the IdP metadata.
+func (sp *ServiceProvider) soapRequest(ctx context.Context, location string, msg []byte, requestID, local string, res interface{}, fields func() (*Issuer, *xmlsec.Signature, *Status)) error {
+	buf, err := soapCall(ctx, sp.soapClient(), location, msg, sp.maxXMLSize())
+	if err != nil {
+		return errors.Wrapf(err, "request to %s failed", location)
+	}
+	if err := checkSignedStructure(buf, samlpNamespace, local); err != nil {
+		return validationErrorf(ErrMalformedResponse, err, "")
+	}
+	if err := xml.Unmarshal(buf, res); err != nil {
+		return validationErrorf(ErrMalformedResponse, err, "")
+	}
+	var inResponseTo, id string
+	var root struct {
+		ID           string `xml:",attr"`
+		InResponseTo string `xml:",attr"`
+	}
+	if err := xml.Unmarshal(buf, &root); err == nil {
+		id, inResponseTo = root.ID, root.InResponseTo
+	}
+	if inResponseTo != requestID {
+		return validationErrorf(ErrUnexpectedInResponseTo, nil, "expecting %q, got %q", requestID, inResponseTo)
+	}
+
+	meta, err := sp.GetIdPMetadataContext(ctx)
+	if err != nil {
+		return err
+	}
+	issuer, signature, status := fields()
+	if meta.EntityID != "" && (issuer == nil || issuer.Value != meta.EntityID) {
+		return validationErrorf(ErrIssuerMismatch, nil, "expected %q", meta.EntityID)
+	}
+	if signature != nil {
+		if err := validateSignedNode(signature, id); err != nil {
+			return validationErrorf(ErrInvalidSignature, err, "failed to validate %s + Signature", local)
+		}
+		if err := sp.verifySignature(ctx, buf, ""); err != nil {
+			return validationErrorf(ErrInvalidSignature, err, "unable to verify message signature")
+		}
+	}
+	switch {
+	case status == nil:
+		return validationErrorf(ErrStatusNotSuccess, nil, "missing %s > Status", local)
+	case status.StatusCode.Value != StatusSuccess:
+		return &ValidationError{Kind: ErrStatusNotSuccess, cause: newStatusError(status)}
+	}
+	return nil
+}
+
+// ManageNameIDHandler serves the ManageNameIDRequests sent by the IdP with
+// the SOAP binding, which must be signed, passing the change to
+// OnManageNameID. The requests are declined with StatusRequestUnsupported
+// when it is not set.
+func (sp *ServiceProvider) ManageNameIDHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	msg, err := readSOAPRequest(r, sp.maxXMLSize())
+	if err != nil {
+		sp.clientErr(w, r, err)
+		return
+	}
+	if err := checkSignedStructure(msg, samlpNamespace, "ManageNameIDRequest"); err != nil {
+		sp.clientErr(w, r, errors.Wrap(err, "invalid ManageNameIDRequest"))
+		return
+	}
+	var req ManageNameIDRequest
+	if err := xml.Unmarshal(msg, &req); err != nil {
+		sp.clientErr(w, r, errors.Wrap(err, "failed to unmarshal ManageNameIDRequest"))
+		return
+	}
+	meta, err := sp.GetIdPMetadataContext(ctx)
+	if err != nil {
+		sp.internalErr(w, r, err)
+		return
+	}
+	if meta.EntityID != "" && req.Issuer.Value != meta.EntityID {
+		sp.clientErr(w, r, validationErrorf(ErrIssuerMismatch, nil, "expected %q, got %q", meta.EntityID, req.Issuer.Value))
+		return
+	}
+	if req.Signature == nil {
+		sp.clientErr(w, r, ErrMissingSignature)
+		return
+	}
+	if err := validateSignedNode(req.Signature, req.ID); err != nil {
+		sp.clientErr(w, r, validationErrorf(ErrInvalidSignature, err, "failed to validate ManageNameIDRequest + Signature"))
+		return
+	}
+	if err := sp.verifySignature(ctx, msg, ""); err != nil {
+		sp.clientErr(w, r, validationErrorf(ErrInvalidSignature, err, "unable to verify request signature"))
+		return
+	}
+
+	status := &Status{StatusCode: StatusCode{Value: StatusSuccess}}
+	change, err := sp.nameIDChange(&req)
+	switch {
+	case err != nil:
+		sp.fail(r, ClientFailure, err)
+		status = &Status{StatusCode: StatusCode{Value: StatusRequester}}
+	case sp.OnManageNameID == nil:
+		status = &Status{StatusCode: StatusCode{
+			Value:      StatusResponder,
+			StatusCode: &StatusCode{Value: StatusRequestUnsupported},
+		}}
+	default:
+		if err := sp.OnManageNameID(ctx, change); err != nil {
+			sp.fail(r, InternalFailure, errors.Wrap(err, "OnManageNameID"))
+			status = errorStatus(err)
+		}
+	}
+
+	res := &ManageNameIDResponse{
+		ID:           sp.newID(),
+		InResponseTo: req.ID,
+		IssueInstant: sp.now(),
+		Version:      "2.0",
+		Issuer: &Issuer{
+			Format: "urn:oasis:names:tc:SAML:2.0:nameid-format:entity",
+			Value:  sp.entityID(),
+		},
+		Status: status,
+	}
+	buf, err := sp.marshalSigned(res, &res.Signature)
+	if err != nil {
+		sp.internalErr(w, r, errors.Wrap(err, "failed to build response"))
+		return
+	}
+	writeSOAP(w, buf)
+}
+
+// nameIDChange returns the change of the ManageNameIDRequest req, received
+// from the IdP, with its identifiers decrypted.
+func (sp *ServiceProvider) nameIDChange(req *ManageNameIDRequest) (*NameIDChange, error) {
+	change := &NameIDChange{
+		NewID:     req.NewID,
+		Terminate: req.Terminate != nil,
+	}
+	switch {
+	case req.NameID != nil:
+		change.NameID = *req.NameID
+	case req.EncryptedID != nil:
+		nameID, err := sp.DecryptNameID(req.EncryptedID)
+		if err != nil {
+			return nil, err
+		}
+		change.NameID = *nameID
+	default:
+		return nil, errors.New("missing NameID")
+	}
+	if req.NewEncryptedID != nil {
+		var decoded newIDElement
+		if err := sp.decryptXML(req.NewEncryptedID.EncryptedData, &decoded); err != nil {
+			return nil, errors.Wrap(err, "unable to decrypt NewID")
+		}
+		change.NewID = decoded.Value
+	}
+	if change.Terminate == (change.NewID != "") {
+		return nil, errors.New("expecting either NewID or Terminate")
+	}
+	return change, nil
+}
+
+// ManageNameIDHandler serves the ManageNameIDRequests sent by the SP with the
+// SOAP binding, which must be signed, passing the change to NameIDManager.
+func (idp *IdentityProvider) ManageNameIDHandler(w http.ResponseWriter, r *http.Request) {
+	if idp.NameIDManager == nil {
+		idp.internalErr(w, r, errors.New("no NameID manager"))
+		return
+	}
+	var req ManageNameIDRequest
+	if !idp.readSignedRequest(w, r, "ManageNameIDRequest", &req, &req.Signature) {
+		return
+	}
+
+	status := &Status{StatusCode: StatusCode{Value: StatusSuccess}}
+	change, err := idp.nameIDChange(&req)
+	if err != nil {
+		idp.fail(r, ClientFailure, err)
+		status = &Status{StatusCode: StatusCode{Value: StatusRequester}}
+	} else if err := idp.NameIDManager.ManageNameID(r.Context(), req.Issuer.Value, change); err != nil {
+		idp.fail(r, InternalFailure, errors.Wrap(err, "ManageNameID"))
+		status = errorStatus(err)
+	}
+
+	res := &ManageNameIDResponse{
+		ID:           idp.newID(),
+		InResponseTo: req.ID,
+		IssueInstant: idp.now(),
+		Version:      "2.0",
+		Issuer: &Issuer{
+			Format: "urn:oasis:names:tc:SAML:2.0:nameid-format:entity",
+			Value:  idp.MetadataURL,
+		},
+		Status: status,
+	}
+	buf, err := idp.marshalSigned(res, &res.Signature)
+	if err != nil {
+		idp.internalErr(w, r, errors.Wrap(err, "failed to build response"))
+		return
+	}
+	writeSOAP(w, buf)
+}
+
+// NameIDMappingHandler serves the NameIDMappingRequests sent by the SP with
+// the SOAP binding, which must be signed, answering them with the
+// identifier returned by NameIDManager.
+func (idp *IdentityProvider) NameIDMappingHandler(w http.ResponseWriter, r *http.Request) {
+	if idp.NameIDManager == nil {
+		idp.internalErr(w, r, errors.New("no NameID manager"))
+		return
+	}
+	var req NameIDMappingRequest
+	if !idp.readSignedRequest(w, r, "NameIDMappingRequest", &req, &req.Signature) {
+		return
+	}
+
+	res := &NameIDMappingResponse{
+		ID:           idp.newID(),
+		InResponseTo: req.ID,
+		IssueInstant: idp.now(),
+		Version:      "2.0",
+		Issuer: &Issuer{
+			Format: "urn:oasis:names:tc:SAML:2.0:nameid-format:entity",
+			Value:  idp.MetadataURL,
+		},
+		Status: &Status{StatusCode: StatusCode{Value: StatusSuccess}},
+	}
+	nameID, err := idp.requestNameID(req.NameID, req.EncryptedID)
+	if err != nil {
+		idp.fail(r, ClientFailure, err)
+		res.Status = &Status{StatusCode: StatusCode{Value: StatusRequester}}
+	} else if res.NameID, res.EncryptedID, err = idp.NameIDManager.MapNameID(r.Context(), req.Issuer.Value, nameID, &req.NameIDPolicy); err != nil {
+		idp.fail(r, InternalFailure, errors.Wrap(err, "MapNameID"))
+		res.Status = errorStatus(err)
+		res.NameID, res.EncryptedID = nil, nil
+	} else if res.NameID == nil && res.EncryptedID == nil {
+		res.Status = &Status{StatusCode: StatusCode{
+			Value:      StatusResponder,
+			StatusCode: &StatusCode{Value: StatusUnknownPrincipal},
+		}}
+	}
+
+	buf, err := idp.marshalSigned(res, &res.Signature)
+	if err != nil {
+		idp.internalErr(w, r, errors.Wrap(err, "failed to build response"))
+		return
+	}
+	writeSOAP(w, buf)
+}
+
+// readSignedRequest reads the SOAP request r, whose message of element local
+// is decoded into req, and checks that it is signed by the SP: signature
+// points to the Signature field of req. It answers the invalid requests
+// and returns false.
+func (idp *IdentityProvider) readSignedRequest(w http.ResponseWriter, r *http.Request, local string, req interface{}, signature **xmlsec.Signature) bool {
+	msg, err := readSOAPRequest(r, idp.maxXMLSize())
+	if err != nil {
+		idp.clientErr(w, r, err)
+		return false
+	}
+	if err := checkSignedStructure(msg, samlpNamespace, local); err != nil {
+		idp.clientErr(w, r, errors.Wrapf(err, "invalid %s", local))
+		return false
+	}
+	if err := xml.Unmarshal(msg, req); err != nil {
+		idp.clientErr(w, r, errors.Wrapf(err, "failed to unmarshal %s", local))
+		return false
+	}
+	var root struct {
+		ID     string `xml:",attr"`
+		Issuer Issuer `xml:"urn:oasis:names:tc:SAML:2.0:assertion Issuer"`
+	}
+	xml.Unmarshal(msg, &root)
+
+	meta, err := idp.GetSPMetadata()
+	if err != nil {
+		idp.internalErr(w, r, errors.Wrap(err, "failed to get SP metadata"))
+		return false
+	}
+	if meta.EntityID != "" && root.Issuer.Value != meta.EntityID {
+		idp.clientErr(w, r, validationErrorf(ErrIssuerMismatch, nil, "expected %q, got %q", meta.EntityID, root.Issuer.Value))
+		return false
+	}
+	if *signature == nil {
+		idp.clientErr(w, r, ErrMissingSignature)
+		return false
+	}
+	if err := validateSignedNode(*signature, root.ID); err != nil {
+		idp.clientErr(w, r, validationErrorf(ErrInvalidSignature, err, "failed to validate %s + Signature", local))
+		return false
+	}
+	certFile, err := idp.GetSPCertFile()
+	if err != nil {
+		idp.internalErr(w, r, errors.Wrap(err, "failed to get SP certificate"))
+		return false
+	}
+	err = xmlsec.Verify(msg, certFile, &xmlsec.ValidationOptions{
+		EnableIDAttrHack: true,
+	})
+	if err != nil && IsSecurityException(err, &idp.SecurityOpts) {
+		idp.clientErr(w, r, validationErrorf(ErrInvalidSignature, err, "unable to verify request signature"))
+		return false
+	}
+	return true
+}
+
+// nameIDChange returns the change of the ManageNameIDRequest req, received
+// from the SP, with its identifiers decrypted.
+func (idp *IdentityProvider) nameIDChange(req *ManageNameIDRequest) (*NameIDChange, error) {
+	nameID, err := idp.requestNameID(req.NameID, req.EncryptedID)
+	if err != nil {
+		return nil, err
+	}
+	change := &NameIDChange{
+		NameID:    *nameID,
+		NewID:     req.NewID,
+		Terminate: req.Terminate != nil,
+	}
+	if req.NewEncryptedID != nil {
+		var decoded newIDElement
+		if err := idp.decryptXML(req.NewEncryptedID.EncryptedData, &decoded); err != nil {
+			return nil, errors.Wrap(err, "unable to decrypt NewID")
+		}
+		change.NewID = decoded.Value
+	}
+	if change.Terminate == (change.NewID != "") {
+		return nil, errors.New("expecting either NewID or Terminate")
+	}
+	return change, nil
+}
+
+// requestNameID returns the NameID of a request received from the SP,
+// decrypting its EncryptedID if needed.
+func (idp *IdentityProvider) requestNameID(nameID *NameID, encryptedID *EncryptedID) (*NameID, error) {
+	switch {
+	case nameID != nil:
+		return nameID, nil
+	case encryptedID != nil:
+		var decoded nameIDElement
+		if err := idp.decryptXML(encryptedID.EncryptedData, &decoded); err != nil {
+			return nil, errors.Wrap(err, "unable to decrypt NameID")
+		}
+		return &decoded.NameID, nil
+	}
+	return nil, errors.New("missing NameID")
+}
+
+// decryptXML decrypts the EncryptedData element data with the IdP's private
+// key, and decodes the resulting element into v.
+func (idp *IdentityProvider) decryptXML(data []byte, v interface{}) error {
+	keyFile, err := idp.PrivkeyFile()
+	if err != nil {
+		return errors.Wrap(err, "failed to get private key")
+	}
+	plaintext, err := decrypt(data, keyFile, &idp.SecurityOpts)
+	if err != nil {
+		return err
+	}
+	return xml.Unmarshal(plaintext, v)
+}
@@ -0,0 +1,212 @@
+package saml
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestNameIDManagementIdP returns a server answering the SOAP requests of
+// sp with respond, and publishes it in the IdP metadata of sp.
+func newTestNameIDManagementIdP(t *testing.T, sp *ServiceProvider, respond func(msg []byte) interface{}) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		msg, err := readSOAPRequest(r, 1<<20)
+		if !assert.NoError(t, err) {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		buf, err := xml.Marshal(respond(msg))
+		assert.NoError(t, err)
+		writeSOAP(w, buf)
+	}))
+	sp.IdPMetadata.IDPSSODescriptor = &IDPSSODescriptor{
+		ManageNameIDService:  []Endpoint{{Binding: SOAPBinding, Location: server.URL}},
+		NameIDMappingService: []Endpoint{{Binding: SOAPBinding, Location: server.URL}},
+	}
+	return server
+}
+
+func TestManageNameID(t *testing.T) {
+	tearUp()
+
+	sp := newTestResponseSP()
+	status := StatusSuccess
+	var received ManageNameIDRequest
+	server := newTestNameIDManagementIdP(t, sp, func(msg []byte) interface{} {
+		received = ManageNameIDRequest{}
+		assert.NoError(t, xml.Unmarshal(msg, &received))
+		return &ManageNameIDResponse{
+			ID:           "id-response",
+			InResponseTo: received.ID,
+			Version:      "2.0",
+			Issuer:       &Issuer{Value: sp.IdPMetadata.EntityID},
+			Status:       &Status{StatusCode: StatusCode{Value: status}},
+		}
+	})
+	defer server.Close()
+
+	nameID := NameID{Format: NameIDFormatPersistent, Value: "abc"}
+	assert.NoError(t, sp.ChangeNameID(context.Background(), nameID, "jane"))
+	assert.Equal(t, "jane", received.NewID)
+	assert.Nil(t, received.Terminate)
+	assert.Equal(t, &nameID, received.NameID)
+	assert.Equal(t, sp.entityID(), received.Issuer.Value)
+
+	assert.NoError(t, sp.TerminateNameID(context.Background(), nameID))
+	assert.NotNil(t, received.Terminate)
+	assert.Empty(t, received.NewID)
+
+	status = StatusRequester
+	err := sp.TerminateNameID(context.Background(), nameID)
+	assert.True(t, errors.Is(err, ErrStatusNotSuccess), "%v", err)
+
+	assert.Error(t, sp.ChangeNameID(context.Background(), nameID, ""))
+}
+
+func TestMapNameID(t *testing.T) {
+	tearUp()
+
+	sp := newTestResponseSP()
+	inResponseTo := ""
+	server := newTestNameIDManagementIdP(t, sp, func(msg []byte) interface{} {
+		var req NameIDMappingRequest
+		assert.NoError(t, xml.Unmarshal(msg, &req))
+		assert.Equal(t, "https://other.example.org", req.NameIDPolicy.SPNameQualifier)
+		if inResponseTo == "" {
+			inResponseTo = req.ID
+		}
+		return &NameIDMappingResponse{
+			ID:           "id-response",
+			InResponseTo: inResponseTo,
+			Version:      "2.0",
+			Issuer:       &Issuer{Value: sp.IdPMetadata.EntityID},
+			Status:       &Status{StatusCode: StatusCode{Value: StatusSuccess}},
+			NameID:       &NameID{Format: NameIDFormatPersistent, Value: "xyz"},
+		}
+	})
+	defer server.Close()
+
+	nameID := NameID{Format: NameIDFormatPersistent, Value: "abc"}
+	policy := NameIDPolicy{Format: NameIDFormatPersistent, SPNameQualifier: "https://other.example.org"}
+	res, err := sp.MapNameID(context.Background(), nameID, policy)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "xyz", res.NameID.Value)
+	}
+
+	inResponseTo = "id-other"
+	_, err = sp.MapNameID(context.Background(), nameID, policy)
+	assert.True(t, errors.Is(err, ErrUnexpectedInResponseTo), "%v", err)
+}
+
+func TestManageNameIDHandler(t *testing.T) {
+	tearUp()
+
+	sp := newTestResponseSP()
+	sp.ManageNameIDURL = "http://localhost:1235/saml/nameid"
+	var failure error
+	sp.OnFailure = func(r *http.Request, level FailureLevel, err error) {
+		failure = err
+	}
+	serve := func(req *ManageNameIDRequest) int {
+		failure = nil
+		msg, err := xml.Marshal(req)
+		assert.NoError(t, err)
+		w := httptest.NewRecorder()
+		sp.ManageNameIDHandler(w, httptest.NewRequest("POST", sp.ManageNameIDURL, bytes.NewReader(wrapSOAP(msg))))
+		return w.Code
+	}
+
+	// The requests of the IdP must be signed.
+	req := &ManageNameIDRequest{
+		ID:        "id-request",
+		Version:   "2.0",
+		Issuer:    Issuer{Value: sp.IdPMetadata.EntityID},
+		NameID:    &NameID{Value: "abc"},
+		Terminate: &struct{}{},
+	}
+	assert.Equal(t, http.StatusBadRequest, serve(req))
+	assert.True(t, errors.Is(failure, ErrMissingSignature), "%v", failure)
+
+	req.Issuer.Value = "https://other.example.org"
+	assert.Equal(t, http.StatusBadRequest, serve(req))
+	assert.True(t, errors.Is(failure, ErrIssuerMismatch), "%v", failure)
+
+	change, err := sp.nameIDChange(&ManageNameIDRequest{NameID: &NameID{Value: "abc"}, NewID: "jane"})
+	if assert.NoError(t, err) {
+		assert.Equal(t, &NameIDChange{NameID: NameID{Value: "abc"}, NewID: "jane"}, change)
+	}
+	_, err = sp.nameIDChange(&ManageNameIDRequest{NameID: &NameID{Value: "abc"}, NewID: "jane", Terminate: &struct{}{}})
+	assert.Error(t, err)
+	_, err = sp.nameIDChange(&ManageNameIDRequest{Terminate: &struct{}{}})
+	assert.Error(t, err)
+
+	meta, err := (&ServiceProvider{
+		MetadataURL:     sp.MetadataURL,
+		PubkeyPEM:       testIdP.PubkeyPEM,
+		ManageNameIDURL: sp.ManageNameIDURL,
+	}).Metadata()
+	if assert.NoError(t, err) {
+		assert.Equal(t, []Endpoint{{Binding: SOAPBinding, Location: sp.ManageNameIDURL}}, meta.SPSSODescriptor.ManageNameIDService)
+	}
+}
+
+type testNameIDManager struct{}
+
+func (testNameIDManager) ManageNameID(ctx context.Context, spEntityID string, change *NameIDChange) error {
+	return &StatusError{Code: StatusRequester, SubCode: StatusUnknownPrincipal}
+}
+
+func (testNameIDManager) MapNameID(ctx context.Context, spEntityID string, nameID *NameID, policy *NameIDPolicy) (*NameID, *EncryptedID, error) {
+	return nil, nil, nil
+}
+
+func TestIdPNameIDHandlers(t *testing.T) {
+	tearUp()
+
+	idp := &IdentityProvider{
+		MetadataURL:   "http://localhost:1233/saml/service.xml",
+		SPMetadata:    &Metadata{EntityID: "http://localhost:1235/saml/service.xml"},
+		NameIDManager: testNameIDManager{},
+	}
+	var failure error
+	idp.OnFailure = func(r *http.Request, level FailureLevel, err error) {
+		failure = err
+	}
+
+	// The requests of the SP must be signed.
+	msg, err := xml.Marshal(&NameIDMappingRequest{
+		ID:      "id-request",
+		Version: "2.0",
+		Issuer:  Issuer{Value: idp.SPMetadata.EntityID},
+		NameID:  &NameID{Value: "abc"},
+	})
+	assert.NoError(t, err)
+	w := httptest.NewRecorder()
+	idp.NameIDMappingHandler(w, httptest.NewRequest("POST", "/saml/nameid-mapping", bytes.NewReader(wrapSOAP(msg))))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.True(t, errors.Is(failure, ErrMissingSignature), "%v", failure)
+
+	msg, err = xml.Marshal(&ManageNameIDRequest{
+		ID:        "id-request",
+		Version:   "2.0",
+		Issuer:    Issuer{Value: "https://other.example.org"},
+		NameID:    &NameID{Value: "abc"},
+		Terminate: &struct{}{},
+	})
+	assert.NoError(t, err)
+	w = httptest.NewRecorder()
+	idp.ManageNameIDHandler(w, httptest.NewRequest("POST", "/saml/nameid", bytes.NewReader(wrapSOAP(msg))))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.True(t, errors.Is(failure, ErrIssuerMismatch), "%v", failure)
+
+	status := errorStatus(testNameIDManager{}.ManageNameID(context.Background(), "", nil))
+	assert.Equal(t, StatusRequester, status.StatusCode.Value)
+	assert.Equal(t, StatusUnknownPrincipal, status.subCode())
+	assert.Equal(t, StatusResponder, errorStatus(errors.New("oops")).StatusCode.Value)
+}
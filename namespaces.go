@@ -0,0 +1,121 @@
+package saml
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+)
+
+// namespacePrefixes are the conventional prefixes of the namespaces found
+// in SAML messages, in the order they are declared.
+var namespacePrefixes = []struct {
+	prefix, space string
+}{
+	{"samlp", samlpNamespace},
+	{"saml", samlNamespace},
+	{"ds", dsigNamespace},
+	{"xsi", "http://www.w3.org/2001/XMLSchema-instance"},
+}
+
+// xmlNamespace is the namespace bound to the xml prefix, which needs no
+// declaration.
+const xmlNamespace = "http://www.w3.org/XML/1998/namespace"
+
+func namespacePrefix(space string) string {
+	if space == xmlNamespace {
+		return "xml"
+	}
+	for _, ns := range namespacePrefixes {
+		if ns.space == space {
+			return ns.prefix
+		}
+	}
+	return ""
+}
+
+// encodePrefixed writes to e the document data, as marshaled by
+// encoding/xml, with the conventional prefixes of the SAML namespaces
+// instead of the default namespace declarations repeated on every element
+// by encoding/xml. The prefixes used are declared on the root element.
+// Elements of other namespaces keep a default namespace declaration.
+func encodePrefixed(e *xml.Encoder, data []byte) error {
+	var tokens []xml.Token
+	used := map[string]bool{}
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if start, ok := tok.(xml.StartElement); ok {
+			used[start.Name.Space] = true
+			for _, attr := range start.Attr {
+				used[attr.Name.Space] = true
+			}
+		}
+		tokens = append(tokens, xml.CopyToken(tok))
+	}
+
+	// defaults is the stack of the default namespaces in scope.
+	defaults := []string{""}
+	for _, tok := range tokens {
+		switch tok := tok.(type) {
+		case xml.StartElement:
+			var attrs []xml.Attr
+			if len(defaults) == 1 {
+				for _, ns := range namespacePrefixes {
+					if used[ns.space] {
+						attrs = append(attrs, xml.Attr{Name: xml.Name{Local: "xmlns:" + ns.prefix}, Value: ns.space})
+					}
+				}
+			}
+
+			def := defaults[len(defaults)-1]
+			if namespacePrefix(tok.Name.Space) == "" && tok.Name.Space != def {
+				// No prefix is known for the namespace of the element.
+				def = tok.Name.Space
+				attrs = append(attrs, xml.Attr{Name: xml.Name{Local: "xmlns"}, Value: def})
+			}
+			defaults = append(defaults, def)
+
+			for _, attr := range tok.Attr {
+				if attr.Name.Space == "xmlns" || attr.Name.Space == "" && attr.Name.Local == "xmlns" {
+					continue
+				}
+				// encoding/xml declares a prefix for the unknown
+				// namespaces of the attributes by itself.
+				if namespacePrefix(attr.Name.Space) != "" {
+					attr.Name = prefixedName(attr.Name)
+				}
+				attrs = append(attrs, attr)
+			}
+			tok.Name, tok.Attr = prefixedName(tok.Name), attrs
+			if err := e.EncodeToken(tok); err != nil {
+				return err
+			}
+		case xml.EndElement:
+			defaults = defaults[:len(defaults)-1]
+			tok.Name = prefixedName(tok.Name)
+			if err := e.EncodeToken(tok); err != nil {
+				return err
+			}
+		default:
+			if err := e.EncodeToken(tok); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// prefixedName returns name with the prefix of its namespace, if known,
+// and without its namespace otherwise.
+func prefixedName(name xml.Name) xml.Name {
+	if prefix := namespacePrefix(name.Space); prefix != "" {
+		return xml.Name{Local: prefix + ":" + name.Local}
+	}
+	return xml.Name{Local: name.Local}
+}
@@ -0,0 +1,27 @@
+package saml
+
+import (
+	"bytes"
+	"encoding/xml"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEncodePrefixed(t *testing.T) {
+	in := `<Response xmlns="urn:oasis:names:tc:SAML:2.0:protocol" ID="r1">` +
+		`<Issuer xmlns="urn:oasis:names:tc:SAML:2.0:assertion">https://idp.example.com</Issuer>` +
+		`<Extensions><ext:Foo xmlns:ext="urn:example:ext" ext:bar="1"><Baz xmlns="urn:example:ext"></Baz><Qux xmlns=""></Qux></ext:Foo></Extensions>` +
+		`<AttributeValue xmlns="urn:oasis:names:tc:SAML:2.0:assertion" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" xsi:type="xs:string" xml:lang="en">x</AttributeValue>` +
+		`</Response>`
+
+	var buf bytes.Buffer
+	e := xml.NewEncoder(&buf)
+	assert.NoError(t, encodePrefixed(e, []byte(in)))
+	assert.NoError(t, e.Flush())
+	assert.Equal(t, `<samlp:Response xmlns:samlp="urn:oasis:names:tc:SAML:2.0:protocol" xmlns:saml="urn:oasis:names:tc:SAML:2.0:assertion" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" ID="r1">`+
+		`<saml:Issuer>https://idp.example.com</saml:Issuer>`+
+		`<samlp:Extensions><Foo xmlns="urn:example:ext" xmlns:_="urn:example:ext" _:bar="1"><Baz></Baz><Qux xmlns=""></Qux></Foo></samlp:Extensions>`+
+		`<saml:AttributeValue xsi:type="xs:string" xml:lang="en">x</saml:AttributeValue>`+
+		`</samlp:Response>`, buf.String())
+}
@@ -0,0 +1,43 @@
+package saml
+
+import (
+	"bytes"
+	"compress/flate"
+	"sync"
+)
+
+// maxPooledBuffer is the capacity above which the buffers are not returned
+// to their pool, so that a few large messages do not keep memory in use.
+const maxPooledBuffer = 1 << 20
+
+var (
+	// bufferPool holds the buffers used to encode the messages sent with
+	// the HTTP-Redirect binding.
+	bufferPool = sync.Pool{New: func() interface{} { return new(bytes.Buffer) }}
+
+	// flateWriterPool holds the flate writers of the HTTP-Redirect binding,
+	// which allocate hundreds of kilobytes each.
+	flateWriterPool = sync.Pool{New: func() interface{} {
+		w, _ := flate.NewWriter(nil, flate.DefaultCompression)
+		return w
+	}}
+
+	// readBufferPool holds the buffers readPostForm reads the request
+	// bodies with.
+	readBufferPool = sync.Pool{New: func() interface{} {
+		buf := make([]byte, 32<<10)
+		return &buf
+	}}
+)
+
+func getBuffer() *bytes.Buffer {
+	buf := bufferPool.Get().(*bytes.Buffer)
+	buf.Reset()
+	return buf
+}
+
+func putBuffer(buf *bytes.Buffer) {
+	if buf.Cap() <= maxPooledBuffer {
+		bufferPool.Put(buf)
+	}
+}
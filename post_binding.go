@@ -0,0 +1,342 @@
+package saml
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"html/template"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultPostFormTemplate is the page rendered by WritePostForm when no
+// template is given: a form that submits itself to the recipient of the
+// message, with a button for user agents without JavaScript. The inline
+// script carries the nonce of the form, so that it runs under a
+// Content-Security-Policy without 'unsafe-inline'.
+var DefaultPostFormTemplate = template.Must(template.New("saml-post").Parse(`<!DOCTYPE html>
+<html>
+	<head>
+		<meta charset="utf-8" />
+	</head>
+	<body>
+		<form id="saml-post" method="POST" action="{{.Action}}">
+			<input type="hidden" name="{{.Param}}" value="{{.Value}}" />
+			{{- if .RelayState}}
+			<input type="hidden" name="RelayState" value="{{.RelayState}}" />
+			{{- end}}
+			{{- if .Signature}}
+			<input type="hidden" name="SigAlg" value="{{.SigAlg}}" />
+			<input type="hidden" name="Signature" value="{{.Signature}}" />
+			{{- end}}
+			<noscript>
+				<input type="submit" value="Continue" />
+			</noscript>
+		</form>
+		<script nonce="{{.Nonce}}">
+			document.getElementById("saml-post").submit();
+		</script>
+	</body>
+</html>
+`))
+
+// PostForm is the data rendered by the template of WritePostForm to send a
+// SAML message with the HTTP-POST binding.
+//
+// See http://docs.oasis-open.org/security/saml/v2.0/saml-bindings-2.0-os.pdf section 3.5
+type PostForm struct {
+	// Action is the URL the form is posted to.
+	Action string
+
+	// Param is the form field carrying the message: SAMLRequest or
+	// SAMLResponse.
+	Param string
+
+	// Value is the base64 encoded message.
+	Value string
+
+	RelayState string
+
+	// SigAlg and Signature are set by SimpleSign for the
+	// HTTP-POST-SimpleSign binding. Signature is base64 encoded.
+	SigAlg    string
+	Signature string
+
+	// Nonce is the nonce of the inline script, allowed by the
+	// Content-Security-Policy of the page.
+	Nonce string
+}
+
+// NewPostForm returns the form sending message, a SAMLRequest or a
+// SAMLResponse depending on param, to action.
+func NewPostForm(action, param string, message []byte, relayState string) PostForm {
+	return PostForm{
+		Action:     action,
+		Param:      param,
+		Value:      base64.StdEncoding.EncodeToString(message),
+		RelayState: relayState,
+	}
+}
+
+// SimpleSign signs form with key for the HTTP-POST-SimpleSign binding, where
+// the signature covers the form values rather than the XML message. The
+// signature algorithm is form.SigAlg, which defaults to SigAlgRSASHA256 or
+// SigAlgECDSASHA256 depending on the key.
+//
+// See http://docs.oasis-open.org/security/saml/v2.0/saml-bindings-2.0-os.pdf section 3.6
+func (form *PostForm) SimpleSign(key crypto.Signer) error {
+	sigAlg := form.SigAlg
+	if sigAlg == "" {
+		sigAlg = defaultSigAlg(key)
+	}
+	sig, err := signRedirect(key, sigAlg, simpleSignInput(form.Param, form.Value, form.RelayState, sigAlg))
+	if err != nil {
+		return err
+	}
+	form.SigAlg = sigAlg
+	form.Signature = base64.StdEncoding.EncodeToString(sig)
+	return nil
+}
+
+// SimpleSignature is the signature of a message received with the
+// HTTP-POST-SimpleSign binding.
+type SimpleSignature struct {
+	// Param is the form field carrying the message: SAMLRequest or
+	// SAMLResponse.
+	Param string
+
+	SigAlg    string
+	Signature []byte
+
+	// signed is the octet string covered by the signature.
+	signed []byte
+}
+
+// DecodeSimpleSign returns the signature of the message posted in form with
+// the HTTP-POST-SimpleSign binding, or nil if form has no Signature field.
+// The signature is not verified: see SimpleSignature.Verify.
+func DecodeSimpleSign(form url.Values) (*SimpleSignature, error) {
+	if _, ok := form["Signature"]; !ok {
+		return nil, nil
+	}
+	for _, name := range []string{"SAMLRequest", "SAMLResponse", "RelayState", "SigAlg", "Signature"} {
+		if len(form[name]) > 1 {
+			return nil, errors.Errorf("duplicate %s field", name)
+		}
+	}
+
+	sig := &SimpleSignature{}
+	_, isRequest := form["SAMLRequest"]
+	_, isResponse := form["SAMLResponse"]
+	switch {
+	case isRequest && isResponse:
+		return nil, errors.New("both SAMLRequest and SAMLResponse fields")
+	case isRequest:
+		sig.Param = "SAMLRequest"
+	case isResponse:
+		sig.Param = "SAMLResponse"
+	default:
+		return nil, errors.New("missing SAMLRequest or SAMLResponse field")
+	}
+
+	sig.SigAlg = form.Get("SigAlg")
+	if sig.SigAlg == "" {
+		return nil, errors.New("missing SigAlg field")
+	}
+	var err error
+	if sig.Signature, err = base64.StdEncoding.DecodeString(form.Get("Signature")); err != nil {
+		return nil, errors.Wrap(err, "failed to decode Signature")
+	}
+	sig.signed = simpleSignInput(sig.Param, form.Get(sig.Param), form.Get("RelayState"), sig.SigAlg)
+	return sig, nil
+}
+
+// Verify checks sig with the public key of cert. The error matches
+// ErrInvalidSignature.
+func (sig *SimpleSignature) Verify(cert *x509.Certificate) error {
+	if err := verifyRedirect(cert.PublicKey, sig.SigAlg, sig.signed, sig.Signature); err != nil {
+		return validationErrorf(ErrInvalidSignature, err, "%s SimpleSign signature", sig.Param)
+	}
+	return nil
+}
+
+// simpleSignInput returns the octet string signed with the
+// HTTP-POST-SimpleSign binding: the form values, as they are posted and
+// without URL encoding, in the order of section 3.6.4.1.
+func simpleSignInput(param, value, relayState, sigAlg string) []byte {
+	input := param + "=" + value
+	if relayState != "" {
+		input += "&RelayState=" + relayState
+	}
+	return []byte(input + "&SigAlg=" + sigAlg)
+}
+
+// WritePostForm renders form with tmpl, or DefaultPostFormTemplate when tmpl
+// is nil, as the response w. When form.Nonce is empty, a random nonce is
+// generated and, unless w already has a Content-Security-Policy header, a
+// policy only allowing the script with this nonce is set. Nothing is written
+// to w if the rendering fails.
+func WritePostForm(w http.ResponseWriter, form PostForm, tmpl *template.Template) error {
+	if tmpl == nil {
+		tmpl = DefaultPostFormTemplate
+	}
+	csp := ""
+	if form.Nonce == "" {
+		nonce := make([]byte, 16)
+		if _, err := rand.Read(nonce); err != nil {
+			return errors.Wrap(err, "failed to generate nonce")
+		}
+		form.Nonce = base64.RawURLEncoding.EncodeToString(nonce)
+		csp = "default-src 'none'; script-src 'nonce-" + form.Nonce + "'"
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, form); err != nil {
+		return errors.Wrap(err, "failed to build form")
+	}
+
+	if csp != "" && w.Header().Get("Content-Security-Policy") == "" {
+		w.Header().Set("Content-Security-Policy", csp)
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	// The page carries a message meant to be consumed once.
+	w.Header().Set("Cache-Control", "no-cache, no-store")
+	w.Header().Set("Pragma", "no-cache")
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// maxPostFormField is the maximum size of the fields of the forms read by
+// readPostForm, other than the SAML message.
+const maxPostFormField = 16 << 10
+
+// readPostForm reads the application/x-www-form-urlencoded body of a message
+// received with the HTTP-POST binding. Unlike Request.ParseForm, which keeps
+// the body, a string copy of it and the unescaped values, it unescapes the
+// values in a single pass as the body is read, and stops as soon as the
+// value of param, the SAML message, is larger than maxMessage, or another
+// field larger than maxPostFormField. The value of param is preallocated
+// from sizeHint, such as the Content-Length of the request.
+func readPostForm(body io.Reader, param string, maxMessage, sizeHint int64) (url.Values, error) {
+	form := url.Values{}
+	var key, value strings.Builder
+	cur, limit := &key, int64(maxPostFormField)
+	// pending is the number of hexadecimal digits of the escape sequence
+	// being read, and escaped the byte they encode.
+	pending, escaped := 0, byte(0)
+
+	write := func(b ...byte) error {
+		if int64(cur.Len()+len(b)) > limit {
+			if cur == &value && key.String() == param {
+				return validationErrorf(ErrMessageTooLarge, nil, "%s is larger than %d bytes", param, limit)
+			}
+			return errors.Errorf("form field is larger than %d bytes", limit)
+		}
+		_, err := cur.Write(b)
+		return err
+	}
+	field := func() error {
+		if pending > 0 {
+			return errors.New("invalid URL escape")
+		}
+		if key.Len() > 0 {
+			name := key.String()
+			form[name] = append(form[name], value.String())
+		}
+		key.Reset()
+		value.Reset()
+		cur, limit = &key, maxPostFormField
+		return nil
+	}
+
+	bufp := readBufferPool.Get().(*[]byte)
+	defer readBufferPool.Put(bufp)
+	buf := *bufp
+	for {
+		n, err := body.Read(buf)
+		for i := 0; i < n; {
+			// Copy the runs of unescaped bytes at once.
+			j := i
+			for j < n && pending == 0 && !isFormSpecial(buf[j]) {
+				j++
+			}
+			if j > i {
+				if err := write(buf[i:j]...); err != nil {
+					return nil, err
+				}
+				i = j
+				continue
+			}
+
+			c := buf[i]
+			i++
+			var werr error
+			switch {
+			case pending > 0:
+				h := unhex(c)
+				if h < 0 {
+					return nil, errors.New("invalid URL escape")
+				}
+				escaped = escaped<<4 | byte(h)
+				if pending--; pending == 0 {
+					werr = write(escaped)
+				}
+			case c == '&':
+				werr = field()
+			case c == '=' && cur == &key:
+				cur = &value
+				if key.String() == param {
+					limit = maxMessage
+					if sizeHint > 0 && sizeHint < maxMessage {
+						value.Grow(int(sizeHint))
+					}
+				}
+			case c == '%':
+				pending, escaped = 2, 0
+			case c == '+':
+				werr = write(' ')
+			case c == ';':
+				// Like url.ParseQuery, since Go 1.17.
+				werr = errors.New("invalid semicolon separator in form")
+			default:
+				werr = write(c)
+			}
+			if werr != nil {
+				return nil, werr
+			}
+		}
+		if err == io.EOF {
+			if err := field(); err != nil {
+				return nil, err
+			}
+			return form, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// isFormSpecial returns whether c has a meaning in an URL-encoded form.
+func isFormSpecial(c byte) bool {
+	return c == '&' || c == '=' || c == '%' || c == '+' || c == ';'
+}
+
+// unhex returns the value of the hexadecimal digit c, or -1.
+func unhex(c byte) int {
+	switch {
+	case '0' <= c && c <= '9':
+		return int(c - '0')
+	case 'a' <= c && c <= 'f':
+		return int(c - 'a' + 10)
+	case 'A' <= c && c <= 'F':
+		return int(c - 'A' + 10)
+	}
+	return -1
+}
@@ -0,0 +1,264 @@
+package saml
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWritePostForm(t *testing.T) {
+	form := NewPostForm("https://sp.example.com/acs?a=1&b=2", "SAMLResponse", []byte("<Response/>"), `"><script>alert(1)</script>`)
+	assert.Equal(t, base64.StdEncoding.EncodeToString([]byte("<Response/>")), form.Value)
+
+	w := httptest.NewRecorder()
+	assert.NoError(t, WritePostForm(w, form, nil))
+	assert.Equal(t, "text/html; charset=utf-8", w.Header().Get("Content-Type"))
+	assert.Equal(t, "no-cache, no-store", w.Header().Get("Cache-Control"))
+
+	body := w.Body.String()
+	assert.Contains(t, body, `action="https://sp.example.com/acs?a=1&amp;b=2"`)
+	assert.Contains(t, body, `name="SAMLResponse" value="`+form.Value+`"`)
+	assert.NotContains(t, body, "<script>alert")
+
+	csp := w.Header().Get("Content-Security-Policy")
+	if assert.True(t, strings.HasPrefix(csp, "default-src 'none'; script-src 'nonce-"), csp) {
+		nonce := strings.TrimSuffix(strings.TrimPrefix(csp, "default-src 'none'; script-src 'nonce-"), "'")
+		assert.Contains(t, body, `<script nonce="`+nonce+`">`)
+	}
+
+	// A policy set by the application is kept.
+	w = httptest.NewRecorder()
+	w.Header().Set("Content-Security-Policy", "default-src 'self'")
+	assert.NoError(t, WritePostForm(w, form, nil))
+	assert.Equal(t, "default-src 'self'", w.Header().Get("Content-Security-Policy"))
+
+	// A nonce given by the application is used as is.
+	w = httptest.NewRecorder()
+	form.Nonce = "app-nonce"
+	assert.NoError(t, WritePostForm(w, form, nil))
+	assert.Empty(t, w.Header().Get("Content-Security-Policy"))
+	assert.Contains(t, w.Body.String(), `<script nonce="app-nonce">`)
+
+	w = httptest.NewRecorder()
+	tmpl := template.Must(template.New("").Parse(`{{.Param}} {{.Action}}`))
+	assert.NoError(t, WritePostForm(w, form, tmpl))
+	assert.Equal(t, "SAMLResponse https://sp.example.com/acs?a=1&amp;b=2", w.Body.String())
+
+	w = httptest.NewRecorder()
+	tmpl = template.Must(template.New("").Parse(`{{.Missing}}`))
+	assert.Error(t, WritePostForm(w, form, tmpl))
+	assert.Empty(t, w.Body.String())
+	assert.Empty(t, w.Header().Get("Content-Type"))
+}
+
+func TestAuthnRequestHandlerPost(t *testing.T) {
+	tearUp()
+
+	sp := newTestResponseSP()
+	sp.IdPMetadata.IDPSSODescriptor = &IDPSSODescriptor{
+		SingleSignOnService: []Endpoint{{
+			Binding:  HTTPPostBinding,
+			Location: "http://localhost:1233/saml/sso",
+		}},
+	}
+
+	w := httptest.NewRecorder()
+	sp.AuthnRequestHandler(w, httptest.NewRequest("GET", "/saml/login", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	body := w.Body.String()
+	assert.Contains(t, body, `action="http://localhost:1233/saml/sso"`)
+	assert.Contains(t, body, `name="SAMLRequest"`)
+
+	form, err := sp.AuthnRequestForm("/home")
+	assert.NoError(t, err)
+	assert.Equal(t, "SAMLRequest", form.Param)
+	assert.Equal(t, "/home", form.RelayState)
+	buf, err := base64.StdEncoding.DecodeString(form.Value)
+	assert.NoError(t, err)
+	assert.Contains(t, string(buf), `Destination="http://localhost:1233/saml/sso"`)
+
+	// The HTTP-Redirect binding is preferred.
+	sp.IdPMetadata.IDPSSODescriptor.SingleSignOnService = append(sp.IdPMetadata.IDPSSODescriptor.SingleSignOnService, Endpoint{
+		Binding:  HTTPRedirectBinding,
+		Location: "http://localhost:1233/saml/sso-redirect",
+	})
+	w = httptest.NewRecorder()
+	sp.AuthnRequestHandler(w, httptest.NewRequest("GET", "/saml/login", nil))
+	assert.Equal(t, http.StatusFound, w.Code)
+	assert.True(t, strings.HasPrefix(w.Header().Get("Location"), "http://localhost:1233/saml/sso-redirect?"))
+}
+
+func TestSimpleSign(t *testing.T) {
+	tearUp()
+
+	for _, keyType := range []KeyType{KeyTypeRSA, KeyTypeECDSA} {
+		keyPEM, certPEM, err := GenerateKeyPair(KeyPairOptions{Type: keyType})
+		if !assert.NoError(t, err) {
+			continue
+		}
+		key, err := parsePrivateKey([]byte(keyPEM))
+		assert.NoError(t, err)
+		block, _ := pem.Decode([]byte(certPEM))
+		cert, err := x509.ParseCertificate(block.Bytes)
+		assert.NoError(t, err)
+
+		form := NewPostForm("https://sp.example.com/acs", "SAMLResponse", []byte("<Response/>"), "state")
+		assert.NoError(t, form.SimpleSign(key), keyType)
+
+		values := url.Values{
+			"SAMLResponse": {form.Value},
+			"RelayState":   {form.RelayState},
+			"SigAlg":       {form.SigAlg},
+			"Signature":    {form.Signature},
+		}
+		sig, err := DecodeSimpleSign(values)
+		if !assert.NoError(t, err, keyType) {
+			continue
+		}
+		assert.Equal(t, "SAMLResponse", sig.Param)
+		assert.NoError(t, sig.Verify(cert), keyType)
+
+		values.Set("RelayState", "other")
+		sig, err = DecodeSimpleSign(values)
+		assert.NoError(t, err)
+		assert.True(t, errors.Is(sig.Verify(cert), ErrInvalidSignature), keyType)
+
+		w := httptest.NewRecorder()
+		assert.NoError(t, WritePostForm(w, form, nil))
+		assert.Contains(t, w.Body.String(), `name="SigAlg" value="`+form.SigAlg+`"`)
+	}
+
+	sig, err := DecodeSimpleSign(url.Values{"SAMLResponse": {"x"}})
+	assert.NoError(t, err)
+	assert.Nil(t, sig)
+
+	for _, values := range []url.Values{
+		{"Signature": {"x"}, "SigAlg": {SigAlgRSASHA256}},
+		{"SAMLResponse": {"x"}, "Signature": {"x"}},
+		{"SAMLResponse": {"x"}, "SigAlg": {SigAlgRSASHA256}, "Signature": {"%%%"}},
+		{"SAMLResponse": {"x", "y"}, "SigAlg": {SigAlgRSASHA256}, "Signature": {"x"}},
+		{"SAMLRequest": {"x"}, "SAMLResponse": {"x"}, "SigAlg": {SigAlgRSASHA256}, "Signature": {"x"}},
+	} {
+		_, err := DecodeSimpleSign(values)
+		assert.Error(t, err, values)
+	}
+}
+
+// newSimpleSignTestSP returns an SP accepting IdP-initiated responses, and a
+// function signing responses for it with the HTTP-POST-SimpleSign binding.
+// The simple signature lets the tests reach the checks following the
+// signature verification without xmlsec1. The signature covers the
+// RelayState, if given.
+func newSimpleSignTestSP(t *testing.T) (*ServiceProvider, func(samlResponse string, relayState ...string) *SimpleSignature) {
+	keyPEM, certPEM, err := GenerateKeyPair(KeyPairOptions{})
+	assert.NoError(t, err)
+	key, err := parsePrivateKey([]byte(keyPEM))
+	assert.NoError(t, err)
+	block, _ := pem.Decode([]byte(certPEM))
+
+	sp := newTestResponseSP()
+	sp.AllowIdpInitiated = true
+	sp.IdPMetadata.IDPSSODescriptor = &IDPSSODescriptor{
+		KeyDescriptor: []KeyDescriptor{{
+			Use:     "signing",
+			KeyInfo: KeyInfo{Certificate: base64.StdEncoding.EncodeToString(block.Bytes)},
+		}},
+	}
+
+	return sp, func(samlResponse string, relayState ...string) *SimpleSignature {
+		form := PostForm{Param: "SAMLResponse", Value: samlResponse}
+		values := url.Values{"SAMLResponse": {form.Value}}
+		if len(relayState) > 0 {
+			form.RelayState = relayState[0]
+			values.Set("RelayState", form.RelayState)
+		}
+		assert.NoError(t, form.SimpleSign(key))
+		values.Set("SigAlg", form.SigAlg)
+		values.Set("Signature", form.Signature)
+		sig, err := DecodeSimpleSign(values)
+		assert.NoError(t, err)
+		return sig
+	}
+}
+
+func TestValidateResponseSimpleSign(t *testing.T) {
+	tearUp()
+
+	sp, simpleSign := newSimpleSignTestSP(t)
+	now := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	res := &Response{
+		ID:          "id-response",
+		Destination: sp.AcsURL,
+		Issuer:      &Issuer{Value: sp.IdPMetadata.EntityID},
+		Status:      &Status{StatusCode: StatusCode{Value: StatusSuccess}},
+		Assertion:   &Assertion{ID: "id-assertion", Issuer: &Issuer{Value: sp.IdPMetadata.EntityID}},
+	}
+	samlResponse := encodeTestResponse(t, res)
+	sig := simpleSign(samlResponse)
+
+	result := sp.validateResponse(context.Background(), samlResponse, sig, []TrackedRequest{{}}, now, nil, nil, false)
+	assert.Contains(t, result.Passed, CheckSignature)
+
+	// Without the SimpleSign signature, the response is not signed.
+	result = sp.validateResponse(context.Background(), samlResponse, nil, []TrackedRequest{{}}, now, nil, nil, false)
+	assert.NotContains(t, result.Passed, CheckSignature)
+
+	sig.Signature[0] ^= 0xff
+	result = sp.validateResponse(context.Background(), samlResponse, sig, []TrackedRequest{{}}, now, nil, nil, false)
+	if assert.NotEmpty(t, result.Failures) {
+		last := result.Failures[len(result.Failures)-1]
+		assert.Equal(t, CheckSignature, last.Check)
+		assert.True(t, errors.Is(last.Err, ErrInvalidSignature))
+	}
+}
+
+func TestReadPostForm(t *testing.T) {
+	samlResponse := base64.StdEncoding.EncodeToString([]byte(strings.Repeat("<Response/>", 100)))
+	for _, body := range []string{
+		"",
+		"SAMLResponse=" + url.QueryEscape(samlResponse),
+		"SAMLResponse=" + url.QueryEscape(samlResponse) + "&RelayState=%2Fhome%3Fa%3D1+2&SigAlg=&Signature=a%2Bb",
+		"RelayState=x&RelayState=y&&flag&=empty&SAMLResponse=" + samlResponse,
+	} {
+		expected, err := url.ParseQuery(body)
+		assert.NoError(t, err)
+		form, err := readPostForm(iotest.OneByteReader(strings.NewReader(body)), "SAMLResponse", 1<<20, int64(len(body)))
+		if assert.NoError(t, err, body) {
+			delete(expected, "")
+			assert.Equal(t, expected, form, body)
+		}
+	}
+
+	_, err := readPostForm(strings.NewReader("SAMLResponse=abc%zz"), "SAMLResponse", 1<<20, 0)
+	assert.Error(t, err)
+	_, err = readPostForm(strings.NewReader("SAMLResponse=abc%4"), "SAMLResponse", 1<<20, 0)
+	assert.Error(t, err)
+
+	// The semicolons are rejected as by url.ParseQuery.
+	for _, body := range []string{"SAMLResponse=abc;RelayState=x", "RelayState=x;y&SAMLResponse=abc"} {
+		_, err = url.ParseQuery(body)
+		assert.Error(t, err, body)
+		_, err = readPostForm(strings.NewReader(body), "SAMLResponse", 1<<20, 0)
+		assert.Error(t, err, body)
+	}
+
+	// The reading stops at the limits.
+	_, err = readPostForm(strings.NewReader("SAMLResponse="+samlResponse), "SAMLResponse", 100, 0)
+	assert.True(t, errors.Is(err, ErrMessageTooLarge))
+	_, err = readPostForm(strings.NewReader("RelayState="+strings.Repeat("a", maxPostFormField+1)), "SAMLResponse", 1<<20, 0)
+	assert.Error(t, err)
+	assert.False(t, errors.Is(err, ErrMessageTooLarge))
+}
@@ -0,0 +1,31 @@
+package saml
+
+import "github.com/pkg/errors"
+
+// CheckReassertion returns an error if the restriction forbids issuing a new
+// assertion based on this one for the given audience.
+func (pr *ProxyRestriction) CheckReassertion(audience string) error {
+	if pr.Count != nil && *pr.Count <= 0 {
+		return errors.New("proxying is not allowed")
+	}
+	if len(pr.Audiences) == 0 {
+		return nil
+	}
+	for _, a := range pr.Audiences {
+		if a.Value == audience {
+			return nil
+		}
+	}
+	return errors.Errorf("proxying to %q is not allowed", audience)
+}
+
+// Next returns the restriction to set on an assertion issued based on this
+// one. The new assertion can't be proxied further than this one.
+func (pr *ProxyRestriction) Next() *ProxyRestriction {
+	next := &ProxyRestriction{Audiences: pr.Audiences}
+	if pr.Count != nil {
+		count := *pr.Count - 1
+		next.Count = &count
+	}
+	return next
+}
@@ -0,0 +1,40 @@
+package saml
+
+import (
+	"encoding/xml"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProxyRestriction(t *testing.T) {
+	var conditions Conditions
+	err := xml.Unmarshal([]byte(`<Conditions xmlns="urn:oasis:names:tc:SAML:2.0:assertion">
+		<ProxyRestriction Count="1">
+			<Audience>https://sp1.example.org</Audience>
+			<Audience>https://sp2.example.org</Audience>
+		</ProxyRestriction>
+	</Conditions>`), &conditions)
+	assert.NoError(t, err)
+
+	pr := conditions.ProxyRestriction
+	if assert.NotNil(t, pr) && assert.NotNil(t, pr.Count) {
+		assert.Equal(t, 1, *pr.Count)
+	}
+	assert.Len(t, pr.Audiences, 2)
+
+	assert.NoError(t, pr.CheckReassertion("https://sp2.example.org"))
+	assert.Error(t, pr.CheckReassertion("https://sp3.example.org"))
+
+	next := pr.Next()
+	assert.Equal(t, 0, *next.Count)
+	assert.Error(t, next.CheckReassertion("https://sp2.example.org"))
+
+	unlimited := &ProxyRestriction{}
+	assert.NoError(t, unlimited.CheckReassertion("https://sp3.example.org"))
+	assert.Nil(t, unlimited.Next().Count)
+
+	out, err := xml.Marshal(unlimited)
+	assert.NoError(t, err)
+	assert.Equal(t, `<ProxyRestriction></ProxyRestriction>`, string(out))
+}
@@ -0,0 +1,115 @@
+package saml
+
+import (
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// RateLimiter tells whether an event identified by key may happen now.
+// Implementations must be safe for concurrent use.
+type RateLimiter interface {
+	Allow(key string) bool
+}
+
+// RateLimit protects the ACS, which is unauthenticated and expensive to
+// serve since it verifies signatures. Requests over the limits are rejected
+// with 429 Too Many Requests before the response is decoded.
+type RateLimit struct {
+	// PerIP is called with the IP address of the user agent, see
+	// AddressCheck.ClientIP when the SP is behind proxies.
+	PerIP RateLimiter
+	// Global is called with an empty key for every request.
+	Global RateLimiter
+}
+
+// allow applies the limits to a request from the given IP address.
+func (rl *RateLimit) allow(ip string) bool {
+	if rl.PerIP != nil && !rl.PerIP.Allow(ip) {
+		return false
+	}
+	if rl.Global != nil && !rl.Global.Allow("") {
+		return false
+	}
+	return true
+}
+
+// TokenBucket is a RateLimiter with a token bucket per key: each key may
+// have up to Burst events at once, and Rate more per second afterwards.
+type TokenBucket struct {
+	rate  float64
+	burst float64
+	now   func() time.Time
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastPrune time.Time
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// NewTokenBucket returns a TokenBucket allowing rate events per second,
+// with bursts of burst events.
+func NewTokenBucket(rate float64, burst int) *TokenBucket {
+	return &TokenBucket{
+		rate:    rate,
+		burst:   float64(burst),
+		now:     time.Now,
+		buckets: map[string]*bucket{},
+	}
+}
+
+// Allow implements RateLimiter.
+func (tb *TokenBucket) Allow(key string) bool {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+
+	now := tb.now()
+	tb.prune(now)
+
+	b, ok := tb.buckets[key]
+	if !ok {
+		b = &bucket{tokens: tb.burst, last: now}
+		tb.buckets[key] = b
+	}
+	b.tokens += now.Sub(b.last).Seconds() * tb.rate
+	if b.tokens > tb.burst {
+		b.tokens = tb.burst
+	}
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// prune forgets the buckets that are full again, once a minute, so that the
+// memory used is bounded by the number of recent keys.
+func (tb *TokenBucket) prune(now time.Time) {
+	if now.Sub(tb.lastPrune) < time.Minute {
+		return
+	}
+	tb.lastPrune = now
+	for key, b := range tb.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*tb.rate >= tb.burst {
+			delete(tb.buckets, key)
+		}
+	}
+}
+
+// remoteIP returns the IP address of the user agent: clientIP if known,
+// otherwise the address the request comes from.
+func remoteIP(r *http.Request, clientIP net.IP) string {
+	if clientIP != nil {
+		return clientIP.String()
+	}
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
@@ -0,0 +1,62 @@
+package saml
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTokenBucket(t *testing.T) {
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	tb := NewTokenBucket(2, 3)
+	tb.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		assert.True(t, tb.Allow("a"), i)
+	}
+	assert.False(t, tb.Allow("a"))
+	assert.True(t, tb.Allow("b"))
+
+	now = now.Add(500 * time.Millisecond)
+	assert.True(t, tb.Allow("a"))
+	assert.False(t, tb.Allow("a"))
+
+	// Full buckets are forgotten.
+	now = now.Add(time.Hour)
+	tb.Allow("c")
+	assert.Len(t, tb.buckets, 1)
+}
+
+func TestAssertionMiddlewareRateLimit(t *testing.T) {
+	tearUp()
+
+	sp := newTestResponseSP()
+	sp.RateLimit = &RateLimit{PerIP: NewTokenBucket(0, 2)}
+
+	var failures []error
+	sp.OnFailure = func(r *http.Request, level FailureLevel, err error) {
+		failures = append(failures, err)
+	}
+
+	post := func(remoteAddr string) int {
+		r := httptest.NewRequest("POST", sp.AcsURL, nil)
+		r.RemoteAddr = remoteAddr
+		w := httptest.NewRecorder()
+		sp.AssertionMiddleware(http.NotFoundHandler()).ServeHTTP(w, r)
+		return w.Code
+	}
+
+	assert.Equal(t, http.StatusBadRequest, post("192.0.2.1:1000"))
+	assert.Equal(t, http.StatusBadRequest, post("192.0.2.1:1001"))
+	assert.Equal(t, http.StatusTooManyRequests, post("192.0.2.1:1002"))
+	assert.Equal(t, http.StatusBadRequest, post("192.0.2.2:1000"))
+	assert.True(t, errors.Is(failures[2], ErrRateLimited))
+
+	sp.RateLimit = &RateLimit{Global: NewTokenBucket(0, 1)}
+	assert.Equal(t, http.StatusBadRequest, post("192.0.2.3:1000"))
+	assert.Equal(t, http.StatusTooManyRequests, post("192.0.2.4:1000"))
+}
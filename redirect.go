@@ -0,0 +1,140 @@
+package saml
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// CheckRedirect returns an error unless target is a safe post-login redirect
+// target: a path on the SP's site, or an absolute http or https URL on the
+// origin of one of the ACS locations or in RedirectAllowlist. A RelayState
+// must pass this check before being used as a redirect target, otherwise the
+// SP is an open redirector. The error matches ErrRedirectNotAllowed.
+func (sp *ServiceProvider) CheckRedirect(target string) error {
+	if target == "" || strings.ContainsAny(target, "\\\r\n\t") {
+		return validationErrorf(ErrRedirectNotAllowed, nil, "%q", target)
+	}
+	u, err := url.Parse(target)
+	if err != nil {
+		return validationErrorf(ErrRedirectNotAllowed, err, "%q", target)
+	}
+
+	if u.Scheme == "" && u.Host == "" && u.User == nil && !strings.HasPrefix(target, "//") {
+		return nil
+	}
+	if (u.Scheme == "http" || u.Scheme == "https") && u.User == nil && sp.isAllowedOrigin(u) {
+		return nil
+	}
+	return validationErrorf(ErrRedirectNotAllowed, nil, "%q", target)
+}
+
+// isAllowedOrigin returns whether u is on the origin of an ACS location or
+// matches an entry of RedirectAllowlist.
+func (sp *ServiceProvider) isAllowedOrigin(u *url.URL) bool {
+	origin := urlOrigin(u)
+	for _, acs := range sp.assertionConsumerServices() {
+		if acsURL, err := url.Parse(acs.Location); err == nil && acsURL.Host != "" && urlOrigin(acsURL) == origin {
+			return true
+		}
+	}
+	for _, allowed := range sp.RedirectAllowlist {
+		if matchOrigin(allowed, u) {
+			return true
+		}
+	}
+	return false
+}
+
+// urlOrigin returns the normalized scheme://host[:port] of an URL.
+func urlOrigin(u *url.URL) string {
+	n, err := url.Parse(normalizeURL((&url.URL{Scheme: u.Scheme, Host: u.Host}).String()))
+	if err != nil {
+		return ""
+	}
+	return n.Scheme + "://" + n.Host
+}
+
+// matchOrigin returns whether u is on the origin given by pattern, which may
+// start its host with "*." to match any subdomain.
+func matchOrigin(pattern string, u *url.URL) bool {
+	p, err := url.Parse(strings.Replace(pattern, "*.", "wildcard.", 1))
+	if err != nil || p.Host == "" {
+		return false
+	}
+	wildcard := strings.Contains(pattern, "://*.")
+	origin, want := urlOrigin(u), urlOrigin(p)
+	if !wildcard {
+		return origin == want
+	}
+	scheme := p.Scheme + "://"
+	suffix := strings.TrimPrefix(want, scheme+"wildcard")
+	return strings.HasPrefix(origin, scheme) && strings.HasSuffix(origin, suffix) && len(origin) > len(scheme)+len(suffix)
+}
+
+// IdPInitiatedPolicy maps the RelayState of the unsolicited responses,
+// which is chosen by the IdP rather than by the SP, to application targets.
+// Many IdPs send a fixed RelayState configured in the application, or none:
+// instead of redirecting to whatever the IdP sent, the SP redirects to a
+// target it knows.
+//
+// With a policy, the RelayState of the unsolicited responses is not
+// verified with RelayStateKey, and GetRelayStateFromCtx returns the mapped
+// target, which RedirectAfterLogin still checks with CheckRedirect.
+type IdPInitiatedPolicy struct {
+	// Targets maps the RelayState values sent by the IdP to targets, such
+	// as "/app/dashboard".
+	Targets map[string]string
+
+	// DefaultTarget is the target of the responses whose RelayState is
+	// empty or unknown. When empty, RedirectAfterLogin redirects to its
+	// default URL.
+	DefaultTarget string
+
+	// AllowURLs accepts the RelayState values which are not in Targets but
+	// pass CheckRedirect, for the IdPs sending the URL to go to.
+	AllowURLs bool
+}
+
+// target returns the target of an unsolicited response with the given
+// RelayState.
+func (p *IdPInitiatedPolicy) target(sp *ServiceProvider, relayState string) string {
+	if relayState == "" {
+		return p.DefaultTarget
+	}
+	if target, ok := p.Targets[relayState]; ok {
+		return target
+	}
+	if p.AllowURLs && sp.CheckRedirect(relayState) == nil {
+		return relayState
+	}
+	sp.logger().Debug("unknown IdP-initiated RelayState", "relay_state", relayState)
+	return p.DefaultTarget
+}
+
+// RedirectAfterLogin redirects the user agent to the RelayState received by
+// AssertionMiddleware if it passes CheckRedirect, or to defaultURL. It is
+// meant to be called at the end of the ACS handler, once the application
+// session has been created.
+func (sp *ServiceProvider) RedirectAfterLogin(w http.ResponseWriter, r *http.Request, defaultURL string) {
+	target := defaultURL
+	if relayState := GetRelayStateFromCtx(r.Context()); relayState != "" {
+		if err := sp.CheckRedirect(relayState); err != nil {
+			sp.logger().Debug("ignored RelayState", "err", err)
+		} else {
+			target = relayState
+		}
+	}
+	http.Redirect(w, r, target, http.StatusFound)
+}
+
+// LoginRedirectHandler returns an ACS handler that only redirects the user
+// agent after login, see RedirectAfterLogin. Applications that don't need
+// to handle the assertion themselves can serve it as their ACS:
+//
+//	mux.Handle("/saml/acs", sp.AssertionMiddleware(sp.LoginRedirectHandler("/")))
+func (sp *ServiceProvider) LoginRedirectHandler(defaultURL string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sp.RedirectAfterLogin(w, r, defaultURL)
+	})
+}
@@ -0,0 +1,316 @@
+package saml
+
+import (
+	"bytes"
+	"compress/flate"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	_ "crypto/sha1"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/pem"
+	"math/big"
+	"net/url"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Signature algorithms of the HTTP-Redirect binding.
+//
+// See http://docs.oasis-open.org/security/saml/v2.0/saml-bindings-2.0-os.pdf section 3.4.4.1
+const (
+	SigAlgRSASHA1     = "http://www.w3.org/2000/09/xmldsig#rsa-sha1"
+	SigAlgRSASHA256   = "http://www.w3.org/2001/04/xmldsig-more#rsa-sha256"
+	SigAlgRSASHA512   = "http://www.w3.org/2001/04/xmldsig-more#rsa-sha512"
+	SigAlgECDSASHA256 = "http://www.w3.org/2001/04/xmldsig-more#ecdsa-sha256"
+)
+
+// RedirectMessage is a SAML message carried by the HTTP-Redirect binding.
+//
+// See http://docs.oasis-open.org/security/saml/v2.0/saml-bindings-2.0-os.pdf section 3.4
+type RedirectMessage struct {
+	// Param is the query parameter carrying the message: SAMLRequest or
+	// SAMLResponse.
+	Param string
+
+	// XML is the message itself.
+	XML []byte
+
+	RelayState string
+
+	// SigAlg and Signature are set when the query is signed.
+	SigAlg    string
+	Signature []byte
+
+	// signed is the part of the query covered by the signature, as it was
+	// received.
+	signed []byte
+}
+
+// EncodeRedirectURL returns location with the query parameters carrying msg
+// with the HTTP-Redirect binding: the message is deflated and base64
+// encoded. When key is not nil, the query is signed with msg.SigAlg, which
+// defaults to SigAlgRSASHA256 or SigAlgECDSASHA256 depending on the key.
+func EncodeRedirectURL(location string, msg RedirectMessage, key crypto.Signer) (string, error) {
+	buf := getBuffer()
+	defer putBuffer(buf)
+	w := flateWriterPool.Get().(*flate.Writer)
+	defer flateWriterPool.Put(w)
+	w.Reset(buf)
+	if _, err := w.Write(msg.XML); err != nil {
+		return "", errors.Wrap(err, "failed to write to flate writer")
+	}
+	if err := w.Close(); err != nil {
+		return "", errors.Wrap(err, "failed to close flate writer")
+	}
+
+	encoded := getBuffer()
+	defer putBuffer(encoded)
+	encoded.Grow(base64.StdEncoding.EncodedLen(buf.Len()))
+	value := encoded.Bytes()[:base64.StdEncoding.EncodedLen(buf.Len())]
+	base64.StdEncoding.Encode(value, buf.Bytes())
+
+	// The parameters are in the order the signature requires.
+	var query strings.Builder
+	query.Grow(len(location) + len(msg.Param) + len(value)*3/2 + len(msg.RelayState) + 512)
+	query.WriteString(msg.Param)
+	query.WriteByte('=')
+	writeBase64QueryEscaped(&query, value)
+	if msg.RelayState != "" {
+		query.WriteString("&RelayState=")
+		query.WriteString(url.QueryEscape(msg.RelayState))
+	}
+	if key != nil {
+		sigAlg := msg.SigAlg
+		if sigAlg == "" {
+			sigAlg = defaultSigAlg(key)
+		}
+		query.WriteString("&SigAlg=")
+		query.WriteString(url.QueryEscape(sigAlg))
+		sig, err := signRedirect(key, sigAlg, []byte(query.String()))
+		if err != nil {
+			return "", err
+		}
+		query.WriteString("&Signature=")
+		query.WriteString(url.QueryEscape(base64.StdEncoding.EncodeToString(sig)))
+	}
+
+	if strings.Contains(location, "?") {
+		return location + "&" + query.String(), nil
+	}
+	return location + "?" + query.String(), nil
+}
+
+// writeBase64QueryEscaped writes the base64 text value to b, escaped as
+// url.QueryEscape would, without its copies: only '+', '/' and '=' need to be
+// escaped.
+func writeBase64QueryEscaped(b *strings.Builder, value []byte) {
+	for len(value) > 0 {
+		i := bytes.IndexAny(value, "+/=")
+		if i < 0 {
+			b.Write(value)
+			return
+		}
+		b.Write(value[:i])
+		switch value[i] {
+		case '+':
+			b.WriteString("%2B")
+		case '/':
+			b.WriteString("%2F")
+		case '=':
+			b.WriteString("%3D")
+		}
+		value = value[i+1:]
+	}
+}
+
+// DecodeRedirect decodes the SAML message carried by rawQuery, the query
+// string of a request sent with the HTTP-Redirect binding. The signature, if
+// any, is not verified: see RedirectMessage.Verify.
+func DecodeRedirect(rawQuery string, limits SizeLimits) (*RedirectMessage, error) {
+	// The signature covers the parameters as they were encoded by the
+	// sender, so the raw values are kept.
+	raw := map[string]string{}
+	for _, param := range strings.Split(rawQuery, "&") {
+		name, value := param, ""
+		if i := strings.IndexByte(param, '='); i >= 0 {
+			name, value = param[:i], param[i+1:]
+		}
+		switch name {
+		case "SAMLRequest", "SAMLResponse", "RelayState", "SigAlg", "Signature":
+			if _, ok := raw[name]; ok {
+				return nil, errors.Errorf("duplicate %s parameter", name)
+			}
+			raw[name] = value
+		}
+	}
+
+	msg := &RedirectMessage{}
+	_, isRequest := raw["SAMLRequest"]
+	_, isResponse := raw["SAMLResponse"]
+	switch {
+	case isRequest && isResponse:
+		return nil, errors.New("both SAMLRequest and SAMLResponse parameters")
+	case isRequest:
+		msg.Param = "SAMLRequest"
+	case isResponse:
+		msg.Param = "SAMLResponse"
+	default:
+		return nil, errors.New("missing SAMLRequest or SAMLResponse parameter")
+	}
+
+	values := map[string]string{}
+	for name, value := range raw {
+		unescaped, err := url.QueryUnescape(value)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid %s parameter", name)
+		}
+		values[name] = unescaped
+	}
+
+	var err error
+	if msg.XML, err = limits.decodeRedirect(msg.Param, values[msg.Param]); err != nil {
+		return nil, err
+	}
+	msg.RelayState = values["RelayState"]
+
+	if _, ok := raw["Signature"]; !ok {
+		return msg, nil
+	}
+	msg.SigAlg = values["SigAlg"]
+	if msg.SigAlg == "" {
+		return nil, errors.New("missing SigAlg parameter")
+	}
+	if msg.Signature, err = base64.StdEncoding.DecodeString(values["Signature"]); err != nil {
+		return nil, errors.Wrap(err, "failed to decode Signature")
+	}
+	signed := msg.Param + "=" + raw[msg.Param]
+	if _, ok := raw["RelayState"]; ok {
+		signed += "&RelayState=" + raw["RelayState"]
+	}
+	signed += "&SigAlg=" + raw["SigAlg"]
+	msg.signed = []byte(signed)
+	return msg, nil
+}
+
+// Verify checks the signature of a message decoded by DecodeRedirect with
+// the public key of cert. The error matches ErrMissingSignature or
+// ErrInvalidSignature.
+func (msg *RedirectMessage) Verify(cert *x509.Certificate) error {
+	if msg.Signature == nil {
+		return validationErrorf(ErrMissingSignature, nil, "unsigned %s", msg.Param)
+	}
+	if err := verifyRedirect(cert.PublicKey, msg.SigAlg, msg.signed, msg.Signature); err != nil {
+		return validationErrorf(ErrInvalidSignature, err, "%s", msg.Param)
+	}
+	return nil
+}
+
+// defaultSigAlg returns the signature algorithm used with key when none is
+// given.
+func defaultSigAlg(key crypto.Signer) string {
+	if _, ok := key.Public().(*ecdsa.PublicKey); ok {
+		return SigAlgECDSASHA256
+	}
+	return SigAlgRSASHA256
+}
+
+func redirectSigHash(sigAlg string) (crypto.Hash, error) {
+	switch sigAlg {
+	case SigAlgRSASHA1:
+		return crypto.SHA1, nil
+	case SigAlgRSASHA256, SigAlgECDSASHA256:
+		return crypto.SHA256, nil
+	case SigAlgRSASHA512:
+		return crypto.SHA512, nil
+	}
+	return 0, errors.Errorf("unsupported signature algorithm %q", sigAlg)
+}
+
+func signRedirect(key crypto.Signer, sigAlg string, data []byte) ([]byte, error) {
+	hash, err := redirectSigHash(sigAlg)
+	if err != nil {
+		return nil, err
+	}
+	h := hash.New()
+	h.Write(data)
+	sig, err := key.Sign(rand.Reader, h.Sum(nil), hash)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to sign")
+	}
+	if _, ok := key.Public().(*ecdsa.PublicKey); ok {
+		// XML signatures encode ECDSA signatures as r || s rather than
+		// in DER.
+		var rs struct{ R, S *big.Int }
+		if _, err := asn1.Unmarshal(sig, &rs); err != nil {
+			return nil, errors.Wrap(err, "failed to sign")
+		}
+		size := (key.Public().(*ecdsa.PublicKey).Curve.Params().BitSize + 7) / 8
+		sig = make([]byte, 2*size)
+		rs.R.FillBytes(sig[:size])
+		rs.S.FillBytes(sig[size:])
+	}
+	return sig, nil
+}
+
+func verifyRedirect(pub crypto.PublicKey, sigAlg string, data, sig []byte) error {
+	hash, err := redirectSigHash(sigAlg)
+	if err != nil {
+		return err
+	}
+	h := hash.New()
+	h.Write(data)
+	digest := h.Sum(nil)
+
+	switch pub := pub.(type) {
+	case *rsa.PublicKey:
+		if sigAlg == SigAlgECDSASHA256 {
+			return errors.Errorf("%s signature with an RSA key", sigAlg)
+		}
+		return rsa.VerifyPKCS1v15(pub, hash, digest, sig)
+	case *ecdsa.PublicKey:
+		if sigAlg != SigAlgECDSASHA256 {
+			return errors.Errorf("%s signature with an ECDSA key", sigAlg)
+		}
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		if len(sig) != 2*size {
+			return errors.New("invalid ECDSA signature length")
+		}
+		r := new(big.Int).SetBytes(sig[:size])
+		s := new(big.Int).SetBytes(sig[size:])
+		if !ecdsa.Verify(pub, digest, r, s) {
+			return errors.New("ECDSA verification failure")
+		}
+		return nil
+	}
+	return errors.Errorf("unsupported public key type %T", pub)
+}
+
+// parsePrivateKey parses a PEM encoded PKCS #1, PKCS #8 or SEC 1 private key.
+func parsePrivateKey(data []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("failed to decode private key")
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParseECPrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse private key")
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, errors.Errorf("unsupported private key type %T", key)
+	}
+	return signer, nil
+}
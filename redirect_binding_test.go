@@ -0,0 +1,138 @@
+package saml
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRedirectBinding(t *testing.T) {
+	msg := RedirectMessage{
+		Param:      "SAMLRequest",
+		XML:        []byte(`<samlp:AuthnRequest xmlns:samlp="urn:oasis:names:tc:SAML:2.0:protocol" ID="id-1"></samlp:AuthnRequest>`),
+		RelayState: "/home?a=b&c=d",
+	}
+
+	redirectURL, err := EncodeRedirectURL("https://idp.example.com/sso?tenant=1", msg, nil)
+	assert.NoError(t, err)
+	u, err := url.Parse(redirectURL)
+	assert.NoError(t, err)
+	assert.Equal(t, "1", u.Query().Get("tenant"))
+
+	decoded, err := DecodeRedirect(u.RawQuery, SizeLimits{})
+	assert.NoError(t, err)
+	assert.Equal(t, msg.Param, decoded.Param)
+	assert.Equal(t, msg.XML, decoded.XML)
+	assert.Equal(t, msg.RelayState, decoded.RelayState)
+	assert.Empty(t, decoded.SigAlg)
+	assert.True(t, errors.Is(decoded.Verify(&x509.Certificate{}), ErrMissingSignature))
+
+	for _, query := range []string{
+		"",
+		"RelayState=x",
+		"SAMLRequest=a&SAMLResponse=b",
+		"SAMLRequest=a&SAMLRequest=b",
+		"SAMLRequest=%%%",
+	} {
+		_, err := DecodeRedirect(query, SizeLimits{})
+		assert.Error(t, err, query)
+	}
+}
+
+func TestRedirectBindingSignature(t *testing.T) {
+	tearUp()
+
+	for _, keyType := range []KeyType{KeyTypeRSA, KeyTypeECDSA} {
+		keyPEM, certPEM, err := GenerateKeyPair(KeyPairOptions{Type: keyType})
+		if !assert.NoError(t, err) {
+			continue
+		}
+		key, err := parsePrivateKey([]byte(keyPEM))
+		assert.NoError(t, err)
+		block, _ := pem.Decode([]byte(certPEM))
+		cert, err := x509.ParseCertificate(block.Bytes)
+		assert.NoError(t, err)
+
+		msg := RedirectMessage{
+			Param:      "SAMLResponse",
+			XML:        []byte(`<samlp:LogoutResponse xmlns:samlp="urn:oasis:names:tc:SAML:2.0:protocol"></samlp:LogoutResponse>`),
+			RelayState: "state",
+		}
+		redirectURL, err := EncodeRedirectURL("https://sp.example.com/slo", msg, key)
+		assert.NoError(t, err)
+		rawQuery := redirectURL[strings.IndexByte(redirectURL, '?')+1:]
+
+		decoded, err := DecodeRedirect(rawQuery, SizeLimits{})
+		if !assert.NoError(t, err, keyType) {
+			continue
+		}
+		if keyType == KeyTypeECDSA {
+			assert.Equal(t, SigAlgECDSASHA256, decoded.SigAlg)
+		} else {
+			assert.Equal(t, SigAlgRSASHA256, decoded.SigAlg)
+		}
+		assert.NoError(t, decoded.Verify(cert), keyType)
+
+		tampered, err := DecodeRedirect(strings.Replace(rawQuery, "RelayState=state", "RelayState=other", 1), SizeLimits{})
+		assert.NoError(t, err)
+		assert.True(t, errors.Is(tampered.Verify(cert), ErrInvalidSignature), keyType)
+	}
+}
+
+func TestAuthnRequestURLSigned(t *testing.T) {
+	tearUp()
+
+	keyPEM, certPEM, err := GenerateKeyPair(KeyPairOptions{})
+	assert.NoError(t, err)
+	sp := newTestResponseSP()
+	sp.PrivkeyPEM, sp.PubkeyPEM = keyPEM, certPEM
+	sp.SignAuthnRequests = true
+	sp.IdPMetadata.IDPSSODescriptor = &IDPSSODescriptor{
+		SingleSignOnService: []Endpoint{{
+			Binding:  HTTPRedirectBinding,
+			Location: "http://localhost:1233/saml/sso",
+		}},
+	}
+
+	redirectURL, err := sp.AuthnRequestURL("/home")
+	assert.NoError(t, err)
+	u, err := url.Parse(redirectURL)
+	assert.NoError(t, err)
+	msg, err := DecodeRedirect(u.RawQuery, SizeLimits{})
+	assert.NoError(t, err)
+	assert.Equal(t, "/home", msg.RelayState)
+
+	block, _ := pem.Decode([]byte(certPEM))
+	cert, err := x509.ParseCertificate(block.Bytes)
+	assert.NoError(t, err)
+	assert.NoError(t, msg.Verify(cert))
+
+	metadata, err := sp.Metadata()
+	assert.NoError(t, err)
+	assert.True(t, metadata.SPSSODescriptor.AuthnRequestsSigned)
+}
+
+func TestWriteBase64QueryEscaped(t *testing.T) {
+	for _, value := range []string{"", "abc", "a+b/c==", "+/=", "AAAA+/+/"} {
+		var b strings.Builder
+		writeBase64QueryEscaped(&b, []byte(value))
+		assert.Equal(t, url.QueryEscape(value), b.String(), value)
+	}
+
+	// The pooled buffers are reused across messages.
+	for _, size := range []int{10, 100000, 10} {
+		msg := RedirectMessage{Param: "SAMLRequest", XML: []byte("<a>" + strings.Repeat("x", size) + "</a>")}
+		u, err := EncodeRedirectURL("https://idp.example.com/sso", msg, nil)
+		if assert.NoError(t, err) {
+			decoded, err := DecodeRedirect(u[strings.IndexByte(u, '?')+1:], SizeLimits{})
+			if assert.NoError(t, err) {
+				assert.Equal(t, msg.XML, decoded.XML)
+			}
+		}
+	}
+}
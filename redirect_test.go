@@ -0,0 +1,131 @@
+package saml
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckRedirect(t *testing.T) {
+	sp := &ServiceProvider{
+		AcsURL:            "https://sp.example.com/saml/acs",
+		RedirectAllowlist: []string{"https://app.example.org", "https://*.example.net:8443"},
+	}
+
+	for _, target := range []string{
+		"/",
+		"/home?x=1#top",
+		"home",
+		"https://sp.example.com/home",
+		"HTTPS://SP.EXAMPLE.COM:443/home",
+		"https://app.example.org/",
+		"https://a.example.net:8443/",
+		"https://a.b.example.net:8443/x",
+	} {
+		assert.NoError(t, sp.CheckRedirect(target), target)
+	}
+
+	for _, target := range []string{
+		"",
+		"//evil.example.com/",
+		"/\\evil.example.com/",
+		"\\\\evil.example.com",
+		"http://sp.example.com/home",
+		"https://sp.example.com.evil.com/",
+		"https://user@sp.example.com/",
+		"https://evil.example.org/",
+		"https://example.net:8443/",
+		"https://a.example.net/",
+		"https://aexample.net:8443/",
+		"javascript:alert(1)",
+		"data:text/html,x",
+		"/home\r\nSet-Cookie: x=1",
+	} {
+		err := sp.CheckRedirect(target)
+		assert.True(t, errors.Is(err, ErrRedirectNotAllowed), target)
+	}
+}
+
+func TestRedirectAfterLogin(t *testing.T) {
+	sp := &ServiceProvider{AcsURL: "https://sp.example.com/saml/acs"}
+
+	for relayState, want := range map[string]string{
+		"":                            "/welcome",
+		"/home":                       "/home",
+		"https://sp.example.com/home": "https://sp.example.com/home",
+		"https://evil.example.com/":   "/welcome",
+		"//evil.example.com/":         "/welcome",
+	} {
+		r := httptest.NewRequest("POST", sp.AcsURL, nil)
+		r = r.WithContext(context.WithValue(r.Context(), relayStateContextKey, relayState))
+		w := httptest.NewRecorder()
+		sp.LoginRedirectHandler("/welcome").ServeHTTP(w, r)
+		assert.Equal(t, http.StatusFound, w.Code)
+		assert.Equal(t, want, w.Header().Get("Location"), relayState)
+	}
+}
+
+func TestIdPInitiatedPolicy(t *testing.T) {
+	tearUp()
+
+	sp, simpleSign := newSimpleSignTestSP(t)
+	sp.RelayStateKey = []byte("secret")
+	sp.IdPInitiated = &IdPInitiatedPolicy{
+		Targets:       map[string]string{"reports": "/app/reports"},
+		DefaultTarget: "/app",
+	}
+
+	n := 0
+	serve := func(relayState string) *httptest.ResponseRecorder {
+		// A new response every time, which is not a replay.
+		n++
+		res := newTestAssertionResponse(sp, Now())
+		res.ID = fmt.Sprintf("id-response-%d", n)
+		res.Assertion.ID = fmt.Sprintf("id-assertion-%d", n)
+		samlResponse := encodeTestResponse(t, res)
+		sig := simpleSign(samlResponse, relayState)
+		values := url.Values{
+			"SAMLResponse": {samlResponse},
+			"SigAlg":       {sig.SigAlg},
+			"Signature":    {base64.StdEncoding.EncodeToString(sig.Signature)},
+			"RelayState":   {relayState},
+		}
+		r := httptest.NewRequest("POST", sp.AcsURL, strings.NewReader(values.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		sp.AssertionMiddleware(sp.LoginRedirectHandler("/")).ServeHTTP(w, r)
+		return w
+	}
+
+	for relayState, target := range map[string]string{
+		"reports":                         "/app/reports",
+		"":                                "/app",
+		"unknown":                         "/app",
+		"https://evil.example.com/":       "/app",
+		sp.signRelayState("/account", ""): "/app",
+	} {
+		w := serve(relayState)
+		if assert.Equal(t, http.StatusFound, w.Code, relayState) {
+			assert.Equal(t, target, w.Header().Get("Location"), relayState)
+		}
+	}
+
+	sp.IdPInitiated.AllowURLs = true
+	w := serve("/account")
+	assert.Equal(t, "/account", w.Header().Get("Location"))
+	w = serve("https://evil.example.com/")
+	assert.Equal(t, "/app", w.Header().Get("Location"))
+
+	// Without policy, the RelayState must carry the signature of the SP.
+	sp.IdPInitiated = nil
+	w = serve("reports")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
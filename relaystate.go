@@ -0,0 +1,56 @@
+package saml
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"strings"
+)
+
+// relayStateMACSize is the size of the truncated HMAC-SHA256 signing a
+// RelayState.
+const relayStateMACSize = 16
+
+// signRelayState appends a signature to relayState, if the SP has a
+// RelayStateKey. The signature binds relayState to the ID of the AuthnRequest
+// it is sent with, or to an empty ID on the way to the discovery service.
+func (sp *ServiceProvider) signRelayState(relayState, requestID string) string {
+	if len(sp.RelayStateKey) == 0 {
+		return relayState
+	}
+	return relayState + "." + base64.RawURLEncoding.EncodeToString(relayStateMAC(sp.RelayStateKey, requestID, relayState))
+}
+
+// verifyRelayState checks and removes the signature of a RelayState, if the
+// SP has a RelayStateKey. The RelayState must be bound to one of requestIDs.
+func (sp *ServiceProvider) verifyRelayState(signed string, requestIDs ...string) (string, error) {
+	if len(sp.RelayStateKey) == 0 {
+		return signed, nil
+	}
+	if signed == "" {
+		return "", nil
+	}
+	i := strings.LastIndexByte(signed, '.')
+	if i < 0 {
+		return "", validationErrorf(ErrInvalidRelayState, nil, "missing signature")
+	}
+	relayState := signed[:i]
+	mac, err := base64.RawURLEncoding.DecodeString(signed[i+1:])
+	if err != nil {
+		return "", validationErrorf(ErrInvalidRelayState, nil, "wrong signature")
+	}
+	for _, id := range requestIDs {
+		if hmac.Equal(mac, relayStateMAC(sp.RelayStateKey, id, relayState)) {
+			return relayState, nil
+		}
+	}
+	return "", validationErrorf(ErrInvalidRelayState, nil, "wrong signature")
+}
+
+func relayStateMAC(key []byte, requestID, relayState string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(requestID))
+	mac.Write([]byte{'.'})
+	mac.Write([]byte(relayState))
+	return mac.Sum(nil)[:relayStateMACSize]
+}
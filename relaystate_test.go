@@ -0,0 +1,84 @@
+package saml
+
+import (
+	"encoding/xml"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRelayStateSignature(t *testing.T) {
+	sp := &ServiceProvider{}
+	assert.Equal(t, "/home", sp.signRelayState("/home", "id-req"))
+	relayState, err := sp.verifyRelayState("/home", "id-req")
+	assert.NoError(t, err)
+	assert.Equal(t, "/home", relayState)
+
+	sp.RelayStateKey = []byte("secret")
+	signed := sp.signRelayState("/home.html", "id-req")
+	assert.True(t, strings.HasPrefix(signed, "/home.html."))
+	assert.True(t, len(signed) <= len("/home.html")+23)
+
+	relayState, err = sp.verifyRelayState(signed, "id-other", "id-req")
+	assert.NoError(t, err)
+	assert.Equal(t, "/home.html", relayState)
+
+	relayState, err = sp.verifyRelayState("", "id-req")
+	assert.NoError(t, err)
+	assert.Equal(t, "", relayState)
+
+	for _, forged := range []string{
+		"/home.html",
+		"/admin" + signed[len("/home.html"):],
+		signed + "x",
+		(&ServiceProvider{RelayStateKey: []byte("other")}).signRelayState("/home.html", "id-req"),
+	} {
+		_, err := sp.verifyRelayState(forged, "id-req")
+		assert.True(t, errors.Is(err, ErrInvalidRelayState), forged)
+	}
+
+	// The RelayState is bound to the request it was sent with.
+	_, err = sp.verifyRelayState(signed, "id-other")
+	assert.True(t, errors.Is(err, ErrInvalidRelayState), "%v", err)
+	_, err = sp.verifyRelayState(signed)
+	assert.True(t, errors.Is(err, ErrInvalidRelayState), "%v", err)
+}
+
+func TestAuthnRequestURLRelayState(t *testing.T) {
+	sp := newTestResponseSP()
+	sp.IdPMetadata.IDPSSODescriptor = &IDPSSODescriptor{
+		SingleSignOnService: []Endpoint{{
+			Binding:  HTTPRedirectBinding,
+			Location: "http://localhost:1233/saml/sso",
+		}},
+	}
+	sp.RelayStateKey = []byte("secret")
+
+	redirectURL, err := sp.AuthnRequestURL("/home")
+	assert.NoError(t, err)
+	u, err := url.Parse(redirectURL)
+	assert.NoError(t, err)
+	msg, err := DecodeRedirect(u.RawQuery, SizeLimits{})
+	assert.NoError(t, err)
+	var req AuthnRequest
+	assert.NoError(t, xml.Unmarshal(msg.XML, &req))
+	relayState, err := sp.verifyRelayState(msg.RelayState, req.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, "/home", relayState)
+
+	// A tampered RelayState is rejected before the response is processed.
+	r := httptest.NewRequest("POST", sp.AcsURL, strings.NewReader(url.Values{
+		"SAMLResponse": {"PFJlc3BvbnNlLz4="},
+		"RelayState":   {"/admin"},
+	}.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	sp.AssertionMiddleware(http.NotFoundHandler()).ServeHTTP(w, r)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "invalid RelayState")
+}
@@ -0,0 +1,293 @@
+package saml
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// RequestTracker keeps the AuthnRequests sent to a user agent, so that
+// AssertionMiddleware only accepts the responses answering them: the
+// InResponseTo of a response must be the ID of a request tracked for the
+// user agent posting it, and the responses without InResponseTo are only
+// accepted with AllowIdpInitiated. Implementations must be safe for
+// concurrent use.
+type RequestTracker interface {
+	// TrackRequest records that the given AuthnRequest is sent to the user
+	// agent of r.
+	TrackRequest(w http.ResponseWriter, r *http.Request, req TrackedRequest) error
+
+	// TrackedRequests returns the AuthnRequests tracked for the user agent
+	// of r.
+	TrackedRequests(r *http.Request) []TrackedRequest
+
+	// StopTrackingRequest forgets the AuthnRequest with the given ID, once
+	// its response was accepted.
+	StopTrackingRequest(w http.ResponseWriter, r *http.Request, id string) error
+}
+
+// TrackedRequest is an AuthnRequest tracked by a RequestTracker, with what
+// its response must satisfy.
+type TrackedRequest struct {
+	ID string `json:"-"`
+
+	// IdP is the entity ID of the IdP the request was sent to, which must
+	// have issued the response.
+	IdP string `json:"idp,omitempty"`
+
+	// Subject is the subject the request asked the IdP to authenticate,
+	// see WithSubject.
+	Subject *NameID `json:"sub,omitempty"`
+
+	// AuthnContext is the authentication context class the user must be
+	// authenticated with, at least, see StepUp.
+	AuthnContext string `json:"acr,omitempty"`
+}
+
+// RequestCookiePrefix prefixes the names of the cookies of
+// CookieRequestTracker, which are followed by the ID of the request.
+const RequestCookiePrefix = "saml_request_"
+
+// DefaultRequestMaxAge is how long the AuthnRequests are tracked by default,
+// which is the time the users have to log in at the IdP.
+const DefaultRequestMaxAge = 10 * time.Minute
+
+// CookieRequestTracker is a RequestTracker keeping a cookie per request,
+// signed with Key, in the user agent.
+//
+// The responses being posted to the ACS by the IdP, the cookies must be sent
+// with cross-site requests: they are set with SameSite=None when Secure,
+// which browsers require for such cookies. Over plain HTTP, they are only
+// sent by the browsers which do not default to SameSite=Lax.
+type CookieRequestTracker struct {
+	// Key signs the cookies. It must be random and at least 32 bytes long,
+	// and shared by the instances of the SP.
+	Key []byte
+
+	// Path scopes the cookies, which only need to be sent to the ACS. It
+	// defaults to "/".
+	Path string
+
+	// MaxAge defaults to DefaultRequestMaxAge.
+	MaxAge time.Duration
+
+	// Secure sets the Secure and SameSite=None attributes of the cookies.
+	Secure bool
+}
+
+// TrackRequest sets the cookie of the request.
+func (t *CookieRequestTracker) TrackRequest(w http.ResponseWriter, r *http.Request, req TrackedRequest) error {
+	if len(t.Key) == 0 {
+		return errors.New("missing request tracking key")
+	}
+	maxAge := t.MaxAge
+	if maxAge <= 0 {
+		maxAge = DefaultRequestMaxAge
+	}
+	data, err := json.Marshal(req)
+	if err != nil {
+		return errors.Wrapf(err, "cannot track request %q", req.ID)
+	}
+	payload := base64.RawURLEncoding.EncodeToString(data)
+	value := payload + "." + base64.RawURLEncoding.EncodeToString(requestMAC(t.Key, req.ID, payload))
+	cookie := t.cookie(req.ID, value, int(maxAge/time.Second))
+	if err := cookie.Valid(); err != nil {
+		return errors.Wrapf(err, "cannot track request %q", req.ID)
+	}
+	http.SetCookie(w, cookie)
+	return nil
+}
+
+// TrackedRequests returns the requests whose cookie carries a valid
+// signature.
+func (t *CookieRequestTracker) TrackedRequests(r *http.Request) []TrackedRequest {
+	var requests []TrackedRequest
+	for _, cookie := range r.Cookies() {
+		if !strings.HasPrefix(cookie.Name, RequestCookiePrefix) || len(t.Key) == 0 {
+			continue
+		}
+		id := strings.TrimPrefix(cookie.Name, RequestCookiePrefix)
+		i := strings.IndexByte(cookie.Value, '.')
+		if i < 0 {
+			continue
+		}
+		payload := cookie.Value[:i]
+		mac, err := base64.RawURLEncoding.DecodeString(cookie.Value[i+1:])
+		if err != nil || !hmac.Equal(mac, requestMAC(t.Key, id, payload)) {
+			continue
+		}
+		data, err := base64.RawURLEncoding.DecodeString(payload)
+		if err != nil {
+			continue
+		}
+		var req TrackedRequest
+		if err := json.Unmarshal(data, &req); err != nil {
+			continue
+		}
+		req.ID = id
+		requests = append(requests, req)
+	}
+	return requests
+}
+
+// StopTrackingRequest deletes the cookie of the request.
+func (t *CookieRequestTracker) StopTrackingRequest(w http.ResponseWriter, r *http.Request, id string) error {
+	http.SetCookie(w, t.cookie(id, "", -1))
+	return nil
+}
+
+func (t *CookieRequestTracker) cookie(id, value string, maxAge int) *http.Cookie {
+	path := t.Path
+	if path == "" {
+		path = "/"
+	}
+	cookie := &http.Cookie{
+		Name:     RequestCookiePrefix + id,
+		Value:    value,
+		Path:     path,
+		MaxAge:   maxAge,
+		Secure:   t.Secure,
+		HttpOnly: true,
+	}
+	if t.Secure {
+		cookie.SameSite = http.SameSiteNoneMode
+	}
+	return cookie
+}
+
+// requestMAC signs the ID of a request with the payload of its cookie.
+func requestMAC(key []byte, id, payload string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(id))
+	mac.Write([]byte{'.'})
+	mac.Write([]byte(payload))
+	return mac.Sum(nil)
+}
+
+// fallbackRequestKey is the key of the default request tracker of the SPs
+// without private key.
+var (
+	fallbackRequestKey     []byte
+	fallbackRequestKeyOnce sync.Once
+)
+
+// requestKeyCache keeps the request tracking key derived from the private
+// key of the SP, so that the key file is read once.
+type requestKeyCache struct {
+	v atomic.Value // of derivedRequestKey
+}
+
+// derivedRequestKey is the key derived from the private key identified by
+// source, the KeyFile or the PrivkeyPEM of the SP.
+type derivedRequestKey struct {
+	source string
+	key    []byte
+}
+
+// requestTracker returns the RequestTracker of the SP. By default, the
+// requests are tracked with a CookieRequestTracker scoped to the ACS, whose
+// key is derived from the private key of the SP, or else from a random key
+// of the process.
+func (sp *ServiceProvider) requestTracker() (RequestTracker, error) {
+	if sp.RequestTracker != nil {
+		return sp.RequestTracker, nil
+	}
+	key, err := sp.requestKey.get(sp.KeyFile, sp.PrivkeyPEM)
+	if err != nil {
+		return nil, err
+	}
+	return &CookieRequestTracker{
+		Key:    key,
+		Path:   urlPath(sp.AcsURL, "/"),
+		Secure: strings.HasPrefix(sp.AcsURL, "https:"),
+	}, nil
+}
+
+// get returns the key derived from the private key in keyFile, or else
+// keyPEM, deriving it again only when they change.
+func (c *requestKeyCache) get(keyFile, keyPEM string) ([]byte, error) {
+	source := keyFile
+	if source == "" {
+		source = keyPEM
+	}
+	if k, ok := c.v.Load().(derivedRequestKey); ok && k.source == source {
+		return k.key, nil
+	}
+
+	data := []byte(keyPEM)
+	if keyFile != "" {
+		var err error
+		if data, err = ioutil.ReadFile(keyFile); err != nil {
+			return nil, errors.Wrap(err, "failed to read private key")
+		}
+	} else if keyPEM == "" {
+		fallbackRequestKeyOnce.Do(func() {
+			fallbackRequestKey = make([]byte, 32)
+			if _, err := rand.Read(fallbackRequestKey); err != nil {
+				panic(err)
+			}
+		})
+		data = fallbackRequestKey
+	}
+	mac := hmac.New(sha256.New, data)
+	mac.Write([]byte("saml request tracking"))
+	key := mac.Sum(nil)
+	c.v.Store(derivedRequestKey{source: source, key: key})
+	return key, nil
+}
+
+// possibleResponses returns the requests whose responses are accepted from
+// the user agent of r: the requests tracked for it and, with
+// AllowIdpInitiated, a request without ID, for the responses without
+// InResponseTo. r may be nil outside of a request.
+func (sp *ServiceProvider) possibleResponses(r *http.Request) []TrackedRequest {
+	var requests []TrackedRequest
+	if r != nil {
+		if tracker, err := sp.requestTracker(); err == nil {
+			requests = tracker.TrackedRequests(r)
+		}
+	}
+	if sp.AllowIdpInitiated {
+		requests = append(requests, TrackedRequest{})
+	}
+	return requests
+}
+
+// untrackedRequests returns the requests with the given IDs, which are only
+// known by their IDs.
+func untrackedRequests(ids []string) []TrackedRequest {
+	requests := make([]TrackedRequest, len(ids))
+	for i, id := range ids {
+		requests[i].ID = id
+	}
+	return requests
+}
+
+// requestIDs returns the IDs of requests.
+func requestIDs(requests []TrackedRequest) []string {
+	ids := make([]string, len(requests))
+	for i := range requests {
+		ids[i] = requests[i].ID
+	}
+	return ids
+}
+
+// findRequest returns the request with the given ID among requests, or nil.
+func findRequest(requests []TrackedRequest, id string) *TrackedRequest {
+	for i := range requests {
+		if requests[i].ID == id {
+			return &requests[i]
+		}
+	}
+	return nil
+}
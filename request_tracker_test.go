@@ -0,0 +1,208 @@
+package saml
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequestTracking(t *testing.T) {
+	tearUp()
+
+	sp, simpleSign := newSimpleSignTestSP(t)
+	sp.AllowIdpInitiated = false
+	sp.IdPMetadata.IDPSSODescriptor.SingleSignOnService = []Endpoint{{
+		Binding:  HTTPRedirectBinding,
+		Location: "http://localhost:1233/saml/sso",
+	}}
+
+	w := httptest.NewRecorder()
+	sp.AuthnRequestHandler(w, httptest.NewRequest("GET", "/saml/login", nil))
+	assert.Equal(t, http.StatusFound, w.Code)
+	cookies := w.Result().Cookies()
+	if !assert.Len(t, cookies, 1) {
+		return
+	}
+	cookie := cookies[0]
+	assert.Equal(t, RequestCookiePrefix+"id-MOCKID", cookie.Name)
+	assert.Equal(t, "/saml/acs", cookie.Path)
+	assert.True(t, cookie.HttpOnly)
+
+	var failure error
+	sp.ErrorHandler = func(w http.ResponseWriter, r *http.Request, status int, err error) {
+		failure = err
+		w.WriteHeader(status)
+	}
+	serve := func(inResponseTo string, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+		failure = nil
+		res := newTestAssertionResponse(sp, Now())
+		res.InResponseTo = inResponseTo
+		res.Assertion.Subject.SubjectConfirmations[0].SubjectConfirmationData.InResponseTo = inResponseTo
+		samlResponse := encodeTestResponse(t, res)
+		sig := simpleSign(samlResponse)
+		values := url.Values{
+			"SAMLResponse": {samlResponse},
+			"SigAlg":       {sig.SigAlg},
+			"Signature":    {base64.StdEncoding.EncodeToString(sig.Signature)},
+		}
+		r := httptest.NewRequest("POST", sp.AcsURL, strings.NewReader(values.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		for _, cookie := range cookies {
+			r.AddCookie(cookie)
+		}
+		w := httptest.NewRecorder()
+		sp.AssertionMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).ServeHTTP(w, r)
+		return w
+	}
+
+	// AssertResponse only accepts the responses to the tracked requests.
+	assertResponse := func(inResponseTo string, cookies ...*http.Cookie) error {
+		res := newTestAssertionResponse(sp, Now())
+		res.InResponseTo = inResponseTo
+		res.Assertion.Subject.SubjectConfirmations[0].SubjectConfirmationData.InResponseTo = inResponseTo
+		r := httptest.NewRequest("POST", sp.AcsURL, nil)
+		for _, cookie := range cookies {
+			r.AddCookie(cookie)
+		}
+		_, err := sp.AssertResponse(r, encodeTestResponse(t, res))
+		return err
+	}
+	err := assertResponse("id-other", cookie)
+	assert.True(t, errors.Is(err, ErrUnexpectedInResponseTo), "%v", err)
+	err = assertResponse("id-MOCKID")
+	assert.True(t, errors.Is(err, ErrUnexpectedInResponseTo), "%v", err)
+
+	// The response to the tracked request is accepted, once.
+	w = serve("id-MOCKID", cookie)
+	assert.Equal(t, http.StatusOK, w.Code, "%v", failure)
+	if cookies := w.Result().Cookies(); assert.Len(t, cookies, 1) {
+		assert.Equal(t, cookie.Name, cookies[0].Name)
+		assert.True(t, cookies[0].MaxAge < 0)
+	}
+
+	// The responses to the requests of other user agents are rejected.
+	w = serve("id-MOCKID")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.True(t, errors.Is(failure, ErrUnexpectedInResponseTo), "%v", failure)
+
+	forged := *cookie
+	forged.Name = RequestCookiePrefix + "id-other"
+	w = serve("id-other", &forged)
+	assert.True(t, errors.Is(failure, ErrUnexpectedInResponseTo), "%v", failure)
+
+	// Unsolicited responses are rejected, unless AllowIdpInitiated.
+	w = serve("", cookie)
+	assert.True(t, errors.Is(failure, ErrUnexpectedInResponseTo), "%v", failure)
+	sp.AllowIdpInitiated = true
+	w = serve("", cookie)
+	assert.Equal(t, http.StatusOK, w.Code, "%v", failure)
+	assert.Empty(t, w.Result().Cookies())
+}
+
+func TestCookieRequestTracker(t *testing.T) {
+	tracker := &CookieRequestTracker{Key: []byte("0123456789abcdef0123456789abcdef"), Secure: true}
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/", nil)
+	assert.NoError(t, tracker.TrackRequest(w, r, TrackedRequest{ID: "id-1", IdP: "https://idp.example.com", Subject: &NameID{Value: "jane", Format: NameIDFormatEmailAddress}}))
+	assert.Error(t, tracker.TrackRequest(w, r, TrackedRequest{ID: "id 2"}))
+	cookies := w.Result().Cookies()
+	if assert.Len(t, cookies, 1) {
+		assert.True(t, cookies[0].Secure)
+		assert.Equal(t, http.SameSiteNoneMode, cookies[0].SameSite)
+		assert.Equal(t, int(DefaultRequestMaxAge.Seconds()), cookies[0].MaxAge)
+		r.AddCookie(cookies[0])
+	}
+	r.AddCookie(&http.Cookie{Name: "other", Value: "1"})
+	assert.Equal(t, []TrackedRequest{{ID: "id-1", IdP: "https://idp.example.com", Subject: &NameID{Value: "jane", Format: NameIDFormatEmailAddress}}}, tracker.TrackedRequests(r))
+
+	other := &CookieRequestTracker{Key: []byte("another key, for another SP.....")}
+	assert.Empty(t, other.TrackedRequests(r))
+
+	// The requests cannot be tampered with.
+	forged := httptest.NewRequest("GET", "/", nil)
+	payload := base64.RawURLEncoding.EncodeToString([]byte(`{"idp":"https://other.example.com"}`))
+	mac := strings.SplitN(r.Cookies()[0].Value, ".", 2)[1]
+	forged.AddCookie(&http.Cookie{Name: RequestCookiePrefix + "id-1", Value: payload + "." + mac})
+	assert.Empty(t, tracker.TrackedRequests(forged))
+}
+
+func TestRequestTrackerKey(t *testing.T) {
+	keyPEM, _, err := GenerateKeyPair(KeyPairOptions{Type: KeyTypeECDSA})
+	assert.NoError(t, err)
+	keyFile := filepath.Join(t.TempDir(), "sp.key")
+	assert.NoError(t, ioutil.WriteFile(keyFile, []byte(keyPEM), 0600))
+
+	// The key is derived once from the key file.
+	sp := &ServiceProvider{KeyFile: keyFile}
+	tracker, err := sp.requestTracker()
+	assert.NoError(t, err)
+	assert.NoError(t, os.Remove(keyFile))
+	again, err := sp.requestTracker()
+	if assert.NoError(t, err) {
+		assert.Equal(t, tracker.(*CookieRequestTracker).Key, again.(*CookieRequestTracker).Key)
+	}
+
+	// It is derived again when the key changes.
+	sp.KeyFile = ""
+	sp.PrivkeyPEM = keyPEM
+	fromPEM, err := sp.requestTracker()
+	if assert.NoError(t, err) {
+		assert.Equal(t, tracker.(*CookieRequestTracker).Key, fromPEM.(*CookieRequestTracker).Key)
+	}
+	sp.KeyFile = keyFile + ".missing"
+	_, err = sp.requestTracker()
+	assert.Error(t, err)
+}
+
+func TestIsExpectedResponseID(t *testing.T) {
+	assert.True(t, isExpectedResponseID([]string{"id-1"}, "id-1"))
+	assert.False(t, isExpectedResponseID([]string{"id-1"}, "id-2"))
+	assert.False(t, isExpectedResponseID(nil, "id-1"))
+	assert.False(t, isExpectedResponseID(nil, ""))
+
+	assert.True(t, isExpectedResponseID([]string{"id-1", ""}, ""))
+	assert.False(t, isExpectedResponseID([]string{"*"}, "id-1"))
+}
+
+func TestTrackedRequestSubject(t *testing.T) {
+	tearUp()
+
+	sp, simpleSign := newSimpleSignTestSP(t)
+	now := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+	res := newTestAssertionResponse(sp, now)
+	res.InResponseTo = "id-req"
+	res.Assertion.Subject.SubjectConfirmations[0].SubjectConfirmationData.InResponseTo = "id-req"
+	samlResponse := encodeTestResponse(t, res)
+
+	validate := func(subject *NameID) *ValidationResult {
+		requests := []TrackedRequest{{ID: "id-req", Subject: subject}}
+		return sp.validateResponse(context.Background(), samlResponse, simpleSign(samlResponse), requests, now, nil, nil, true)
+	}
+
+	result := validate(&NameID{Value: "jane"})
+	assert.NoError(t, result.Err())
+	assert.Contains(t, result.Passed, CheckSubject)
+
+	// The IdP authenticated another user than the requested one.
+	result = validate(&NameID{Value: "john"})
+	if assert.Len(t, result.Failures, 1) {
+		assert.Equal(t, CheckSubject, result.Failures[0].Check)
+		assert.True(t, errors.Is(result.Failures[0].Err, ErrWrongSubject))
+	}
+
+	result = validate(nil)
+	assert.NoError(t, result.Err())
+	assert.NotContains(t, result.Passed, CheckSubject)
+}
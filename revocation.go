@@ -0,0 +1,284 @@
+package saml
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/pem"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ocsp"
+)
+
+// DefaultRevocationCacheDuration is the default of
+// RevocationCheck.CacheDuration.
+const DefaultRevocationCacheDuration = time.Hour
+
+// revocationClockSkew is how far in the future the revocation data may be
+// issued, for the clock of its issuer being ahead.
+const revocationClockSkew = 5 * time.Minute
+
+// maxRevocationDataSize bounds the size of the OCSP responses, of the CRLs
+// and of the issuer certificates downloaded.
+const maxRevocationDataSize = 1 << 20
+
+// ErrCertificateRevoked is returned, wrapped, for the IdP certificates
+// revoked by their CA.
+var ErrCertificateRevoked = errors.New("certificate revoked")
+
+// RevocationCheck enables the verification that the IdP certificates found
+// in the metadata are not revoked, with OCSP, or else with the CRLs of their
+// CA. The self-signed certificates, which most IdPs use, can't be revoked
+// and are accepted as is.
+//
+// The OCSP responses and the CRLs are cached until their next update, for
+// at most CacheDuration. Those past their next update, or without one and
+// issued more than CacheDuration ago, are not current: the status is then
+// unknown. A RevocationCheck must not be copied after first use.
+type RevocationCheck struct {
+	// Issuers are the certificates of the CAs of the IdP certificates. When
+	// the CA of a certificate is not one of them, it is downloaded from the
+	// Authority Information Access extension of the certificate.
+	Issuers []*x509.Certificate
+
+	// Client queries the OCSP responders and downloads the CRLs. It
+	// defaults to http.DefaultClient.
+	Client *http.Client
+
+	// CacheDuration bounds how long the revocation status of a certificate
+	// is cached, DefaultRevocationCacheDuration if zero.
+	CacheDuration time.Duration
+
+	// SoftFail accepts the certificates whose revocation status can't be
+	// known, e.g. when the OCSP responder is unreachable. They are rejected
+	// by default.
+	SoftFail bool
+
+	mu    sync.Mutex
+	cache map[string]revocationStatus
+}
+
+// revocationStatus is a cached revocation status: err is nil for the
+// certificates known to be valid until expires.
+type revocationStatus struct {
+	err     error
+	expires time.Time
+}
+
+func (c *RevocationCheck) client() *http.Client {
+	if c.Client == nil {
+		return http.DefaultClient
+	}
+	return c.Client
+}
+
+func (c *RevocationCheck) cacheDuration() time.Duration {
+	if c.CacheDuration == 0 {
+		return DefaultRevocationCacheDuration
+	}
+	return c.CacheDuration
+}
+
+// check verifies that cert is not revoked at now.
+func (c *RevocationCheck) check(ctx context.Context, cert *x509.Certificate, now time.Time) error {
+	if bytes.Equal(cert.RawIssuer, cert.RawSubject) && cert.CheckSignature(cert.SignatureAlgorithm, cert.RawTBSCertificate, cert.Signature) == nil {
+		return nil
+	}
+	key := revocationCacheKey(cert.Raw)
+	c.mu.Lock()
+	status, ok := c.cache[key]
+	c.mu.Unlock()
+	if ok && now.Before(status.expires) {
+		return status.err
+	}
+
+	issuer, err := c.issuer(ctx, cert)
+	if err == nil {
+		status, err = c.status(ctx, cert, issuer, now)
+	}
+	if err != nil {
+		// The failures to get the status are not cached.
+		if c.SoftFail {
+			return nil
+		}
+		return errors.Wrap(err, "unknown revocation status")
+	}
+	c.mu.Lock()
+	if c.cache == nil {
+		c.cache = map[string]revocationStatus{}
+	}
+	c.cache[key] = status
+	c.mu.Unlock()
+	return status.err
+}
+
+// status queries the revocation status of cert, with OCSP if the
+// certificate has a responder, and else with its CRLs.
+func (c *RevocationCheck) status(ctx context.Context, cert, issuer *x509.Certificate, now time.Time) (revocationStatus, error) {
+	expires := now.Add(c.cacheDuration())
+	if len(cert.OCSPServer) > 0 {
+		res, err := c.queryOCSP(ctx, cert, issuer)
+		if err != nil {
+			return revocationStatus{}, err
+		}
+		if err := c.checkCurrent("OCSP response", res.ThisUpdate, res.NextUpdate, now); err != nil {
+			return revocationStatus{}, err
+		}
+		if !res.NextUpdate.IsZero() && res.NextUpdate.Before(expires) {
+			expires = res.NextUpdate
+		}
+		switch res.Status {
+		case ocsp.Good:
+			return revocationStatus{expires: expires}, nil
+		case ocsp.Revoked:
+			return revocationStatus{
+				err:     errors.Wrapf(ErrCertificateRevoked, "%q revoked on %v", cert.Subject, res.RevokedAt),
+				expires: expires,
+			}, nil
+		}
+		return revocationStatus{}, errors.New("OCSP responder doesn't know the certificate")
+	}
+
+	if len(cert.CRLDistributionPoints) == 0 {
+		return revocationStatus{}, errors.New("no OCSP responder nor CRL distribution point")
+	}
+	crl, err := c.fetchCRL(ctx, cert.CRLDistributionPoints[0], issuer)
+	if err != nil {
+		return revocationStatus{}, err
+	}
+	if err := c.checkCurrent("CRL", crl.ThisUpdate, crl.NextUpdate, now); err != nil {
+		return revocationStatus{}, err
+	}
+	if !crl.NextUpdate.IsZero() && crl.NextUpdate.Before(expires) {
+		expires = crl.NextUpdate
+	}
+	for _, entry := range crl.RevokedCertificateEntries {
+		if entry.SerialNumber.Cmp(cert.SerialNumber) == 0 {
+			return revocationStatus{
+				err:     errors.Wrapf(ErrCertificateRevoked, "%q revoked on %v", cert.Subject, entry.RevocationTime),
+				expires: expires,
+			}, nil
+		}
+	}
+	return revocationStatus{expires: expires}, nil
+}
+
+// checkCurrent checks that the revocation data issued at thisUpdate, and to
+// be updated at nextUpdate if set, is current at now.
+func (c *RevocationCheck) checkCurrent(kind string, thisUpdate, nextUpdate, now time.Time) error {
+	if thisUpdate.After(now.Add(revocationClockSkew)) {
+		return errors.Errorf("%s issued in the future (thisUpdate=%v)", kind, thisUpdate)
+	}
+	if nextUpdate.IsZero() {
+		if now.Sub(thisUpdate) > c.cacheDuration() {
+			return errors.Errorf("stale %s (thisUpdate=%v)", kind, thisUpdate)
+		}
+	} else if !now.Before(nextUpdate) {
+		return errors.Errorf("stale %s (nextUpdate=%v)", kind, nextUpdate)
+	}
+	return nil
+}
+
+// issuer returns the certificate of the CA that issued cert.
+func (c *RevocationCheck) issuer(ctx context.Context, cert *x509.Certificate) (*x509.Certificate, error) {
+	for _, issuer := range c.Issuers {
+		if cert.CheckSignatureFrom(issuer) == nil {
+			return issuer, nil
+		}
+	}
+	for _, url := range cert.IssuingCertificateURL {
+		data, err := c.get(ctx, url)
+		if err != nil {
+			continue
+		}
+		if block, _ := pem.Decode(data); block != nil {
+			data = block.Bytes
+		}
+		issuer, err := x509.ParseCertificate(data)
+		if err == nil && cert.CheckSignatureFrom(issuer) == nil {
+			return issuer, nil
+		}
+	}
+	return nil, errors.Errorf("unknown issuer %q", cert.Issuer)
+}
+
+// queryOCSP asks the OCSP responder of cert for its status.
+func (c *RevocationCheck) queryOCSP(ctx context.Context, cert, issuer *x509.Certificate) (*ocsp.Response, error) {
+	req, err := ocsp.CreateRequest(cert, issuer, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create OCSP request")
+	}
+	httpReq, err := http.NewRequest("POST", cert.OCSPServer[0], bytes.NewReader(req))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/ocsp-request")
+	data, err := c.do(httpReq.WithContext(ctx))
+	if err != nil {
+		return nil, errors.Wrap(err, "OCSP request failed")
+	}
+	res, err := ocsp.ParseResponseForCert(data, cert, issuer)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid OCSP response")
+	}
+	return res, nil
+}
+
+// fetchCRL downloads the CRL at url and verifies its signature.
+func (c *RevocationCheck) fetchCRL(ctx context.Context, url string, issuer *x509.Certificate) (*x509.RevocationList, error) {
+	data, err := c.get(ctx, url)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to download CRL")
+	}
+	if block, _ := pem.Decode(data); block != nil {
+		data = block.Bytes
+	}
+	crl, err := x509.ParseRevocationList(data)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid CRL")
+	}
+	if err := crl.CheckSignatureFrom(issuer); err != nil {
+		return nil, errors.Wrap(err, "invalid CRL signature")
+	}
+	return crl, nil
+}
+
+func (c *RevocationCheck) get(ctx context.Context, url string) ([]byte, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	return c.do(req.WithContext(ctx))
+}
+
+// do sends req and returns the body of the response, of at most
+// maxRevocationDataSize bytes.
+func (c *RevocationCheck) do(req *http.Request) ([]byte, error) {
+	res, err := c.client().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("%s: %s", req.URL, res.Status)
+	}
+	buf, err := ioutil.ReadAll(io.LimitReader(res.Body, maxRevocationDataSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(buf) > maxRevocationDataSize {
+		return nil, errors.Errorf("%s: response is larger than %d bytes", req.URL, maxRevocationDataSize)
+	}
+	return buf, nil
+}
+
+func revocationCacheKey(der []byte) string {
+	sum := sha256.Sum256(der)
+	return string(sum[:])
+}
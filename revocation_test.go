@@ -0,0 +1,218 @@
+package saml
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"errors"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ocsp"
+)
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newTestCA(t *testing.T) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "CA"},
+		NotBefore:             Now().Add(-time.Hour),
+		NotAfter:              Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature,
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return &testCA{cert: cert, key: key}
+}
+
+func (ca *testCA) issue(t *testing.T, serial int64, template *x509.Certificate) *x509.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	template.SerialNumber = big.NewInt(serial)
+	template.Subject = pkix.Name{CommonName: "idp"}
+	template.NotBefore = Now().Add(-time.Hour)
+	template.NotAfter = Now().Add(time.Hour)
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, key.Public(), ca.key)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return cert
+}
+
+func TestRevocationCheckOCSP(t *testing.T) {
+	tearUp()
+
+	ca := newTestCA(t)
+	requests := 0
+	thisUpdate, nextUpdate := Now(), Now().Add(time.Minute)
+	responder := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		body, _ := io.ReadAll(r.Body)
+		req, err := ocsp.ParseRequest(body)
+		if !assert.NoError(t, err) {
+			return
+		}
+		template := ocsp.Response{
+			Status:       ocsp.Good,
+			SerialNumber: req.SerialNumber,
+			ThisUpdate:   thisUpdate,
+			NextUpdate:   nextUpdate,
+		}
+		if req.SerialNumber.Int64() == 3 {
+			template.Status = ocsp.Revoked
+			template.RevokedAt = Now().Add(-time.Minute)
+		}
+		res, err := ocsp.CreateResponse(ca.cert, ca.cert, template, ca.key)
+		assert.NoError(t, err)
+		w.Write(res)
+	}))
+	defer responder.Close()
+
+	good := ca.issue(t, 2, &x509.Certificate{OCSPServer: []string{responder.URL}})
+	revoked := ca.issue(t, 3, &x509.Certificate{OCSPServer: []string{responder.URL}})
+	check := &RevocationCheck{Issuers: []*x509.Certificate{ca.cert}}
+
+	assert.NoError(t, check.check(context.Background(), good, Now()))
+	assert.NoError(t, check.check(context.Background(), good, Now()))
+	assert.Equal(t, 1, requests)
+	// The responses are cached until their next update.
+	thisUpdate, nextUpdate = Now().Add(2*time.Minute), Now().Add(3*time.Minute)
+	assert.NoError(t, check.check(context.Background(), good, Now().Add(2*time.Minute)))
+	assert.Equal(t, 2, requests)
+
+	// The responses that are not current give an unknown status.
+	for _, update := range [][2]time.Time{
+		{Now().Add(-2 * time.Minute), Now().Add(-time.Minute)},
+		{Now().Add(-2 * time.Hour), time.Time{}},
+		{Now().Add(time.Hour), Now().Add(2 * time.Hour)},
+	} {
+		thisUpdate, nextUpdate = update[0], update[1]
+		stale := &RevocationCheck{Issuers: []*x509.Certificate{ca.cert}}
+		err := stale.check(context.Background(), good, Now())
+		if assert.Error(t, err) {
+			assert.Contains(t, err.Error(), "unknown revocation status")
+		}
+		stale.SoftFail = true
+		assert.NoError(t, stale.check(context.Background(), good, Now()))
+	}
+	thisUpdate, nextUpdate = Now(), Now().Add(time.Minute)
+
+	err := check.check(context.Background(), revoked, Now())
+	assert.True(t, errors.Is(err, ErrCertificateRevoked), "%v", err)
+
+	// The CA must be known.
+	err = (&RevocationCheck{}).check(context.Background(), good, Now())
+	assert.Error(t, err)
+	assert.NoError(t, (&RevocationCheck{SoftFail: true}).check(context.Background(), good, Now()))
+
+	// The self-signed certificates are not checked.
+	_, _, selfSigned := newTestKeyPair(t, KeyPairOptions{Type: KeyTypeECDSA})
+	assert.NoError(t, (&RevocationCheck{}).check(context.Background(), selfSigned, Now()))
+
+	sp := &ServiceProvider{
+		RevocationCheck: check,
+		IdPMetadata: &Metadata{
+			EntityID: "https://idp.example.com",
+			IDPSSODescriptor: &IDPSSODescriptor{
+				KeyDescriptor: []KeyDescriptor{
+					{Use: "signing", KeyInfo: KeyInfo{Certificate: base64.StdEncoding.EncodeToString(revoked.Raw)}},
+				},
+			},
+		},
+	}
+	_, err = sp.GetIdPCertFileContext(context.Background())
+	assert.True(t, errors.Is(err, ErrCertificateRevoked), "%v", err)
+}
+
+func TestRevocationCheckCRL(t *testing.T) {
+	tearUp()
+
+	ca := newTestCA(t)
+	var crl []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/ca.crt":
+			w.Write(ca.cert.Raw)
+		case "/ca.crl":
+			w.Write(crl)
+		case "/large.crl":
+			w.Write(make([]byte, maxRevocationDataSize+1))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	var err error
+	crl, err = x509.CreateRevocationList(rand.Reader, &x509.RevocationList{
+		Number:     big.NewInt(1),
+		ThisUpdate: Now(),
+		NextUpdate: Now().Add(time.Hour),
+		RevokedCertificateEntries: []x509.RevocationListEntry{
+			{SerialNumber: big.NewInt(3), RevocationTime: Now().Add(-time.Minute)},
+		},
+	}, ca.cert, ca.key)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	template := func() *x509.Certificate {
+		return &x509.Certificate{
+			IssuingCertificateURL: []string{server.URL + "/ca.crt"},
+			CRLDistributionPoints: []string{server.URL + "/ca.crl"},
+		}
+	}
+	good := ca.issue(t, 2, template())
+	revoked := ca.issue(t, 3, template())
+	check := &RevocationCheck{}
+	assert.NoError(t, check.check(context.Background(), good, Now()))
+	err = check.check(context.Background(), revoked, Now())
+	assert.True(t, errors.Is(err, ErrCertificateRevoked), "%v", err)
+
+	// Without revocation information, the status is unknown.
+	unknown := ca.issue(t, 4, &x509.Certificate{IssuingCertificateURL: []string{server.URL + "/ca.crt"}})
+	assert.Error(t, check.check(context.Background(), unknown, Now()))
+	check.SoftFail = true
+	assert.NoError(t, check.check(context.Background(), unknown, Now()))
+
+	// Past its next update, the CRL gives an unknown status.
+	other := ca.issue(t, 5, template())
+	err = (&RevocationCheck{}).check(context.Background(), other, Now().Add(2*time.Hour))
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "stale CRL")
+	}
+
+	large := ca.issue(t, 6, &x509.Certificate{
+		IssuingCertificateURL: []string{server.URL + "/ca.crt"},
+		CRLDistributionPoints: []string{server.URL + "/large.crl"},
+	})
+	err = (&RevocationCheck{}).check(context.Background(), large, Now())
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "response is larger than 1048576 bytes")
+	}
+}
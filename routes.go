@@ -0,0 +1,150 @@
+package saml
+
+import (
+	"net/http"
+	"net/url"
+
+	"github.com/pkg/errors"
+)
+
+// Default paths of the routes mounted by ServiceProvider.Routes when they
+// can't be derived from the SP's URLs.
+const (
+	DefaultMetadataPath = "/saml/metadata"
+	DefaultACSPath      = "/saml/acs"
+	DefaultLoginPath    = "/saml/login"
+	DefaultSLOPath      = "/saml/slo"
+
+	DefaultDiscoveryResponsePath = "/saml/disco"
+)
+
+// routes holds the configuration of ServiceProvider.Routes.
+type routes struct {
+	metadataPath, acsPath, loginPath, sloPath string
+	discoveryResponsePath                     string
+	acsHandler, sloHandler                    http.Handler
+}
+
+// route is an endpoint mounted by ServiceProvider.Routes.
+type route struct {
+	name, path string
+	handler    http.Handler
+}
+
+// RoutesOption customizes the routes mounted by ServiceProvider.Routes.
+type RoutesOption func(*routes)
+
+// WithMetadataPath sets the path of the metadata, which defaults to the path
+// of MetadataURL.
+func WithMetadataPath(path string) RoutesOption {
+	return func(r *routes) { r.metadataPath = path }
+}
+
+// WithACSPath sets the path of the ACS, which defaults to the path of
+// AcsURL.
+func WithACSPath(path string) RoutesOption {
+	return func(r *routes) { r.acsPath = path }
+}
+
+// WithLoginPath sets the path starting an SP-initiated login, which defaults
+// to DefaultLoginPath.
+func WithLoginPath(path string) RoutesOption {
+	return func(r *routes) { r.loginPath = path }
+}
+
+// WithSLOPath sets the path of the logout handler, which defaults to the
+// path of SloURL.
+func WithSLOPath(path string) RoutesOption {
+	return func(r *routes) { r.sloPath = path }
+}
+
+// WithDiscoveryResponsePath sets the path of the discovery response, which
+// defaults to the path of DiscoveryResponseURL.
+func WithDiscoveryResponsePath(path string) RoutesOption {
+	return func(r *routes) { r.discoveryResponsePath = path }
+}
+
+// WithACSHandler sets the handler called by the ACS with the validated
+// assertion, see GetAssertionFromCtx. It defaults to LoginRedirectHandler("/").
+func WithACSHandler(h http.Handler) RoutesOption {
+	return func(r *routes) { r.acsHandler = h }
+}
+
+// WithSLOHandler sets the handler of the logout path, which is only mounted
+// when one is given. The SP does not process SAML logout messages itself:
+// the handler is expected to end the application session.
+func WithSLOHandler(h http.Handler) RoutesOption {
+	return func(r *routes) { r.sloHandler = h }
+}
+
+// Routes returns a handler serving the SP endpoints: the metadata, the ACS,
+// the SP-initiated login, optionally the logout, and the discovery response
+// when the SP has a DiscoveryResponseURL. It can be mounted as is
+// on http.ServeMux or chi:
+//
+//	routes, err := sp.Routes(saml.WithACSHandler(login))
+//	if err != nil {
+//		...
+//	}
+//	mux.Handle("/saml/", routes)
+//
+// An error is returned when the paths of two endpoints collide.
+func (sp *ServiceProvider) Routes(opts ...RoutesOption) (http.Handler, error) {
+	r := routes{
+		metadataPath: urlPath(sp.MetadataURL, DefaultMetadataPath),
+		acsPath:      urlPath(sp.AcsURL, DefaultACSPath),
+		loginPath:    DefaultLoginPath,
+		sloPath:      urlPath(sp.SloURL, DefaultSLOPath),
+		acsHandler:   sp.LoginRedirectHandler("/"),
+
+		discoveryResponsePath: urlPath(sp.DiscoveryResponseURL, DefaultDiscoveryResponsePath),
+	}
+	for _, opt := range opts {
+		opt(&r)
+	}
+
+	mux := http.NewServeMux()
+	handlers := []route{
+		{"metadata", r.metadataPath, http.HandlerFunc(sp.MetadataHandler)},
+		{"ACS", r.acsPath, sp.AssertionMiddleware(r.acsHandler)},
+		{"login", r.loginPath, http.HandlerFunc(sp.AuthnRequestHandler)},
+	}
+	if r.sloHandler != nil {
+		handlers = append(handlers, route{"logout", r.sloPath, r.sloHandler})
+	}
+	if sp.DiscoveryResponseURL != "" {
+		handlers = append(handlers, route{"discovery response", r.discoveryResponsePath, http.HandlerFunc(sp.DiscoveryResponseHandler)})
+	}
+	paths := map[string]string{}
+	for _, h := range handlers {
+		if other, ok := paths[h.path]; ok {
+			return nil, errors.Errorf("the %s and %s routes have the same path %q", other, h.name, h.path)
+		}
+		paths[h.path] = h.name
+		if err := handle(mux, h.path, h.handler); err != nil {
+			return nil, errors.Wrapf(err, "cannot mount the %s route", h.name)
+		}
+	}
+	return mux, nil
+}
+
+// handle registers h on mux, turning the panics of mux on invalid or
+// conflicting patterns into errors.
+func handle(mux *http.ServeMux, pattern string, h http.Handler) (err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			err = errors.Errorf("%v", v)
+		}
+	}()
+	mux.Handle(pattern, h)
+	return nil
+}
+
+// urlPath returns the path of location, or def if it has none.
+func urlPath(location, def string) string {
+	u, err := url.Parse(location)
+	if err != nil || u.Path == "" {
+		return def
+	}
+	return u.Path
+}
@@ -0,0 +1,82 @@
+package saml
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRoutes(t *testing.T) {
+	tearUp()
+
+	sp := newTestResponseSP()
+	sp.IdPMetadata.IDPSSODescriptor = &IDPSSODescriptor{
+		SingleSignOnService: []Endpoint{{
+			Binding:  HTTPRedirectBinding,
+			Location: "http://localhost:1233/saml/sso",
+		}},
+	}
+
+	get := func(h http.Handler, method, path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(method, path, nil))
+		return w
+	}
+
+	h, err := sp.Routes()
+	assert.NoError(t, err)
+	w := get(h, "GET", "/saml/login")
+	assert.Equal(t, http.StatusFound, w.Code)
+	assert.True(t, strings.HasPrefix(w.Header().Get("Location"), "http://localhost:1233/saml/sso?"))
+
+	w = get(h, "POST", "/saml/acs")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "missing SAMLResponse")
+
+	assert.Equal(t, http.StatusNotFound, get(h, "GET", "/saml/slo").Code)
+
+	logout := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	h, err = sp.Routes(
+		WithLoginPath("/auth/start"),
+		WithACSPath("/auth/callback"),
+		WithSLOHandler(logout),
+	)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusFound, get(h, "GET", "/auth/start").Code)
+	assert.Equal(t, http.StatusBadRequest, get(h, "POST", "/auth/callback").Code)
+	assert.Equal(t, http.StatusNotFound, get(h, "GET", "/saml/login").Code)
+	assert.Equal(t, http.StatusNoContent, get(h, "GET", "/saml/slo").Code)
+}
+
+func TestRoutesSloURL(t *testing.T) {
+	tearUp()
+
+	sp := *testSP
+	sp.SloURL = "https://sp.example.com/auth/logout"
+	logout := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	h, err := sp.Routes(WithSLOHandler(logout))
+	assert.NoError(t, err)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/auth/logout", nil))
+	assert.Equal(t, http.StatusNoContent, w.Code)
+}
+
+func TestRoutesCollision(t *testing.T) {
+	tearUp()
+
+	// The paths of the endpoints must differ, instead of making the mux
+	// panic.
+	_, err := testSP.Routes(WithLoginPath("/saml/acs"))
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "ACS and login")
+	}
+	_, err = testSP.Routes(WithLoginPath("/saml/{"))
+	assert.Error(t, err)
+}
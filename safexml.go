@@ -0,0 +1,73 @@
+package saml
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+// checkXML makes sure that data is a well-formed XML document, with a single
+// root element and without any document type declaration. SAML messages
+// must not contain a DTD (see section 1.3 of saml-bindings-2.0-os), and
+// rejecting them before the document reaches xmlsec1 prevents XXE and entity
+// expansion attacks. encoding/xml alone accepts content after the root
+// element.
+func checkXML(data []byte) error {
+	return scanXML(data, nil)
+}
+
+// structureError is returned by scanXML for the errors of its visitor.
+type structureError struct {
+	err error
+}
+
+func (e *structureError) Error() string { return e.err.Error() }
+
+// scanXML checks data as checkXML does, and calls visit, if not nil, with
+// every token of the document. The errors returned by visit are wrapped in a
+// *structureError to tell them from malformed documents. Doing both in a
+// single pass saves a parsing of the messages received.
+func scanXML(data []byte, visit func(tok xml.Token) error) error {
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	dec.Strict = true
+	dec.Entity = nil
+
+	root := false
+	depth := 0
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		switch tok := tok.(type) {
+		case xml.Directive:
+			return errors.Errorf("XML directives are not allowed: <!%.20s", tok)
+		case xml.StartElement:
+			if depth == 0 && root {
+				return errors.Errorf("unexpected element %s after the root element", tok.Name.Local)
+			}
+			root = true
+			depth++
+		case xml.EndElement:
+			depth--
+		case xml.CharData:
+			if depth == 0 && len(bytes.TrimSpace(tok)) > 0 {
+				return errors.New("unexpected text outside of the root element")
+			}
+		}
+		if visit != nil {
+			if err := visit(tok); err != nil {
+				return &structureError{err}
+			}
+		}
+	}
+	if !root {
+		return errors.New("empty XML document")
+	}
+	return nil
+}
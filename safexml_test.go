@@ -0,0 +1,40 @@
+package saml
+
+import (
+	"encoding/base64"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckXML(t *testing.T) {
+	assert.NoError(t, checkXML([]byte(`<?xml version="1.0"?><samlp:Response xmlns:samlp="urn:oasis:names:tc:SAML:2.0:protocol">&amp;</samlp:Response>`)))
+
+	invalid := []string{
+		``,
+		`<Response>`,
+		`<!DOCTYPE Response [<!ENTITY xxe SYSTEM "file:///etc/passwd">]><Response>&xxe;</Response>`,
+		`<!DOCTYPE Response SYSTEM "http://example.org/saml.dtd"><Response/>`,
+		`<Response>&lt;&unknown;</Response>`,
+		`<Response/><Response/>`,
+		`<Response></Response><Assertion/>`,
+		`<Response/>trailing`,
+		`leading<Response/>`,
+	}
+	for _, doc := range invalid {
+		assert.Error(t, checkXML([]byte(doc)), doc)
+	}
+}
+
+func TestParseResponseRejectsDTD(t *testing.T) {
+	tearUp()
+
+	sp := newTestResponseSP()
+	now := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	doc := `<!DOCTYPE Response [<!ENTITY lol "lol">]><Response xmlns="urn:oasis:names:tc:SAML:2.0:protocol">&lol;</Response>`
+	_, err := sp.ParseResponse(base64.StdEncoding.EncodeToString([]byte(doc)), nil, now)
+	assert.True(t, errors.Is(err, ErrMalformedResponse))
+}
@@ -9,6 +9,7 @@ import (
 
 	"github.com/gofrs/uuid"
 	"github.com/goware/saml/xmlsec"
+	"github.com/pkg/errors"
 )
 
 const defaultValidDuration = time.Hour * 24 * 2
@@ -21,10 +22,27 @@ const IssueLifetime = time.Second * 90
 // tolerance to assertion's NotBefore and NotOnOrAfter
 var ClockDriftTolerance = time.Duration(0)
 
+// ClockDrift sets the tolerance applied to the time conditions of an
+// assertion. NotBefore is subtracted from the NotBefore conditions and
+// NotOnOrAfter is added to the NotOnOrAfter conditions.
+type ClockDrift struct {
+	NotBefore    time.Duration
+	NotOnOrAfter time.Duration
+}
+
 // Now is a function that returns the current time. This value can be
 // overwritten during tests.
 var Now = time.Now
 
+// Clock tells the current time. It is satisfied by the clocks of
+// github.com/jonboulle/clockwork.
+type Clock interface {
+	Now() time.Time
+}
+
+// IDGenerator returns unique identifiers for the SAML messages.
+type IDGenerator func() string
+
 // NewID is a function that returns a unique identifier. This value can be
 // overwritten during tests.
 var NewID = func() string {
@@ -55,6 +73,105 @@ func GetMetadata(metadataURL string) (*Metadata, error) {
 	return &metadata, nil
 }
 
+// FailureLevel classifies the failures reported to a FailureFunc.
+type FailureLevel int
+
+const (
+	// ClientFailure is caused by the request of the user agent, e.g. a
+	// malformed or invalid SAML message. These failures are expected and
+	// their content is attacker-controlled.
+	ClientFailure FailureLevel = iota
+	// InternalFailure is caused by the configuration or the environment,
+	// e.g. unreachable metadata or a missing key.
+	InternalFailure
+)
+
+func (level FailureLevel) String() string {
+	switch level {
+	case ClientFailure:
+		return "client"
+	case InternalFailure:
+		return "internal"
+	}
+	return fmt.Sprintf("FailureLevel(%d)", int(level))
+}
+
+// FailureFunc is called for every HTTP request that could not be served.
+// It replaces the logging of the failure.
+type FailureFunc func(r *http.Request, level FailureLevel, err error)
+
+// DestinationPolicy tells when the Destination of a SAML response is
+// required.
+type DestinationPolicy int
+
+const (
+	// DestinationRequired requires every response to have a Destination
+	// matching an ACS location.
+	DestinationRequired DestinationPolicy = iota
+	// DestinationRequiredIfSigned accepts unsigned responses without a
+	// Destination, as permitted by the HTTP-POST binding. A Destination,
+	// when present, must still match an ACS location.
+	DestinationRequiredIfSigned
+)
+
+// SigningPolicy tells which parts of a SAML response must be signed.
+type SigningPolicy int
+
+const (
+	// SigningPolicyEither requires a signed response or a signed assertion.
+	SigningPolicyEither SigningPolicy = iota
+	// SigningPolicyResponse requires a signed response.
+	SigningPolicyResponse
+	// SigningPolicyAssertion requires a signed assertion.
+	SigningPolicyAssertion
+	// SigningPolicyBoth requires both a signed response and a signed
+	// assertion.
+	SigningPolicyBoth
+)
+
+func (p SigningPolicy) String() string {
+	switch p {
+	case SigningPolicyEither:
+		return "either"
+	case SigningPolicyResponse:
+		return "response"
+	case SigningPolicyAssertion:
+		return "assertion"
+	case SigningPolicyBoth:
+		return "both"
+	}
+	return fmt.Sprintf("SigningPolicy(%d)", int(p))
+}
+
+func (p SigningPolicy) requiresAssertion() bool {
+	return p == SigningPolicyAssertion || p == SigningPolicyBoth
+}
+
+// check returns an error if the signatures found don't satisfy the policy.
+func (p SigningPolicy) check(responseSigned, assertionSigned bool) error {
+	switch p {
+	case SigningPolicyEither:
+		if !responseSigned && !assertionSigned {
+			return errors.New("no signed node found")
+		}
+	case SigningPolicyResponse:
+		if !responseSigned {
+			return errors.New("response signature required")
+		}
+	case SigningPolicyAssertion:
+		if !assertionSigned {
+			return errors.New("assertion signature required")
+		}
+	case SigningPolicyBoth:
+		if !responseSigned || !assertionSigned {
+			return errors.New("response and assertion signatures required")
+		}
+	default:
+		return errors.Errorf("unknown signing policy %v", p)
+	}
+	return nil
+}
+
 // SecurityOpts allows to bypass some security checks.
 type SecurityOpts struct {
 	AllowSelfSignedCert   bool
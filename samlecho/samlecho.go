@@ -0,0 +1,65 @@
+// Package samlecho adapts the handlers of a saml.ServiceProvider to the Echo
+// web framework.
+//
+//	e := echo.New()
+//	samlecho.Register(e.Group("/saml"), sp, func(c echo.Context) error {
+//		assertion := samlecho.Assertion(c)
+//		// Create the application session...
+//		return c.Redirect(http.StatusFound, "/")
+//	})
+package samlecho
+
+import (
+	"net/http"
+
+	"github.com/goware/saml"
+	"github.com/labstack/echo/v4"
+)
+
+// assertionKey is the key of the assertion in the echo context.
+const assertionKey = "saml.Assertion"
+
+// Router is implemented by *echo.Echo and *echo.Group.
+type Router interface {
+	GET(path string, h echo.HandlerFunc, m ...echo.MiddlewareFunc) *echo.Route
+	POST(path string, h echo.HandlerFunc, m ...echo.MiddlewareFunc) *echo.Route
+}
+
+// Register adds the SP routes to r: the metadata at /metadata, the
+// SP-initiated login at /login, and the ACS at /acs, where login is called
+// with the validated assertion.
+func Register(r Router, sp *saml.ServiceProvider, login echo.HandlerFunc) {
+	r.GET("/metadata", echo.WrapHandler(http.HandlerFunc(sp.MetadataHandler)))
+	r.GET("/login", echo.WrapHandler(http.HandlerFunc(sp.AuthnRequestHandler)))
+	r.POST("/acs", login, AssertionMiddleware(sp))
+}
+
+// AssertionMiddleware validates the SAML response posted to the ACS, see
+// saml.ServiceProvider.AssertionMiddleware. On success, the next handler
+// gets the assertion with Assertion; otherwise the error is rendered by the
+// SP and the next handler is not called.
+func AssertionMiddleware(sp *saml.ServiceProvider) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			var err error
+			sp.AssertionMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				c.SetRequest(r)
+				c.Set(assertionKey, saml.GetAssertionFromCtx(r.Context()))
+				err = next(c)
+			})).ServeHTTP(c.Response(), c.Request())
+			return err
+		}
+	}
+}
+
+// Assertion returns the assertion validated by AssertionMiddleware, or nil.
+func Assertion(c echo.Context) *saml.Assertion {
+	assertion, _ := c.Get(assertionKey).(*saml.Assertion)
+	return assertion
+}
+
+// RelayState returns the RelayState received with the assertion, see
+// saml.GetRelayStateFromCtx.
+func RelayState(c echo.Context) string {
+	return saml.GetRelayStateFromCtx(c.Request().Context())
+}
@@ -0,0 +1,64 @@
+// Package samlgin adapts the handlers of a saml.ServiceProvider to the gin
+// web framework.
+//
+//	r := gin.Default()
+//	r.GET("/saml/metadata", samlgin.Metadata(sp))
+//	r.GET("/saml/login", samlgin.AuthnRequest(sp))
+//	r.POST("/saml/acs", samlgin.AssertionMiddleware(sp), func(c *gin.Context) {
+//		assertion := samlgin.Assertion(c)
+//		// Create the application session...
+//	})
+package samlgin
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/goware/saml"
+)
+
+// assertionKey is the key of the assertion in the gin context.
+const assertionKey = "saml.Assertion"
+
+// Metadata serves the SP metadata, see saml.ServiceProvider.MetadataHandler.
+func Metadata(sp *saml.ServiceProvider) gin.HandlerFunc {
+	return gin.WrapF(sp.MetadataHandler)
+}
+
+// AuthnRequest redirects the user agent to the IdP, see
+// saml.ServiceProvider.AuthnRequestHandler.
+func AuthnRequest(sp *saml.ServiceProvider) gin.HandlerFunc {
+	return gin.WrapF(sp.AuthnRequestHandler)
+}
+
+// AssertionMiddleware validates the SAML response posted to the ACS, see
+// saml.ServiceProvider.AssertionMiddleware. On success, the next handlers get
+// the assertion with Assertion; otherwise the error has been rendered and
+// the chain is aborted.
+func AssertionMiddleware(sp *saml.ServiceProvider) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		valid := false
+		sp.AssertionMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			valid = true
+			c.Request = r
+			c.Set(assertionKey, saml.GetAssertionFromCtx(r.Context()))
+		})).ServeHTTP(c.Writer, c.Request)
+		if !valid {
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// Assertion returns the assertion validated by AssertionMiddleware, or nil.
+func Assertion(c *gin.Context) *saml.Assertion {
+	assertion, _ := c.Value(assertionKey).(*saml.Assertion)
+	return assertion
+}
+
+// RelayState returns the RelayState received with the assertion, see
+// saml.GetRelayStateFromCtx.
+func RelayState(c *gin.Context) string {
+	return saml.GetRelayStateFromCtx(c.Request.Context())
+}
@@ -0,0 +1,64 @@
+package samlgin
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/goware/saml"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestSP() *saml.ServiceProvider {
+	return &saml.ServiceProvider{
+		MetadataURL: "http://localhost:1235/saml/metadata",
+		AcsURL:      "http://localhost:1235/saml/acs",
+		IdPMetadata: &saml.Metadata{
+			EntityID: "http://localhost:1233/saml/metadata",
+			IDPSSODescriptor: &saml.IDPSSODescriptor{
+				SingleSignOnService: []saml.Endpoint{{
+					Binding:  saml.HTTPRedirectBinding,
+					Location: "http://localhost:1233/saml/sso",
+				}},
+			},
+		},
+	}
+}
+
+func TestAuthnRequest(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/saml/login", AuthnRequest(newTestSP()))
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/saml/login", nil))
+	assert.Equal(t, http.StatusFound, w.Code)
+	assert.True(t, strings.HasPrefix(w.Header().Get("Location"), "http://localhost:1233/saml/sso?"))
+}
+
+func TestAssertionMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/saml/acs", AssertionMiddleware(newTestSP()), func(c *gin.Context) {
+		t.Fatal("unexpected call to the next handler")
+	})
+
+	req := httptest.NewRequest("POST", "/saml/acs", strings.NewReader(url.Values{}.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "missing SAMLResponse")
+}
+
+func TestAssertion(t *testing.T) {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	assert.Nil(t, Assertion(c))
+
+	assertion := &saml.Assertion{ID: "_a1"}
+	c.Set(assertionKey, assertion)
+	assert.Equal(t, assertion, Assertion(c))
+}
@@ -0,0 +1,175 @@
+// Package samljwt converts validated SAML assertions into signed JSON Web
+// Tokens, so that the services behind a SAML service provider can consume
+// standard bearer tokens instead of SAML XML.
+package samljwt
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"time"
+
+	"github.com/goware/saml"
+	"github.com/pkg/errors"
+)
+
+// DefaultLifetime is the lifetime of the tokens when Bridge.Lifetime is
+// zero.
+const DefaultLifetime = time.Hour
+
+// Signer signs the tokens.
+type Signer interface {
+	// Algorithm returns the JWS "alg" header value.
+	Algorithm() string
+	Sign(signingInput []byte) ([]byte, error)
+}
+
+// HS256 returns a Signer using HMAC with SHA-256.
+func HS256(key []byte) Signer {
+	return hs256{key}
+}
+
+type hs256 struct {
+	key []byte
+}
+
+func (s hs256) Algorithm() string {
+	return "HS256"
+}
+
+func (s hs256) Sign(signingInput []byte) ([]byte, error) {
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write(signingInput)
+	return mac.Sum(nil), nil
+}
+
+// RS256 returns a Signer using RSASSA-PKCS1-v1_5 with SHA-256.
+func RS256(key *rsa.PrivateKey) Signer {
+	return rs256{key}
+}
+
+type rs256 struct {
+	key *rsa.PrivateKey
+}
+
+func (s rs256) Algorithm() string {
+	return "RS256"
+}
+
+func (s rs256) Sign(signingInput []byte) ([]byte, error) {
+	digest := sha256.Sum256(signingInput)
+	return rsa.SignPKCS1v15(rand.Reader, s.key, crypto.SHA256, digest[:])
+}
+
+// Bridge converts assertions into JWTs.
+type Bridge struct {
+	Signer Signer
+	// KeyID is set as the "kid" header when not empty.
+	KeyID string
+
+	// Issuer and Audience are set as the "iss" and "aud" claims when not
+	// empty.
+	Issuer   string
+	Audience string
+
+	// Lifetime of the tokens. It is clamped to the SessionNotOnOrAfter of
+	// the assertion.
+	Lifetime time.Duration
+
+	// Claims maps claim names to attribute names, by OID or by friendly
+	// name. Single-valued attributes are set as strings, the others as
+	// arrays of strings.
+	Claims map[string]string
+
+	// Now returns the current time. When nil, saml.Now is used.
+	Now func() time.Time
+}
+
+// Token returns a signed JWT for the given validated assertion. The subject
+// of the token is the NameID of the assertion.
+func (b *Bridge) Token(assertion *saml.Assertion) (string, error) {
+	if b.Signer == nil {
+		return "", errors.New("samljwt: missing Signer")
+	}
+	claims, err := b.claims(assertion)
+	if err != nil {
+		return "", err
+	}
+
+	header := map[string]string{
+		"alg": b.Signer.Algorithm(),
+		"typ": "JWT",
+	}
+	if b.KeyID != "" {
+		header["kid"] = b.KeyID
+	}
+
+	headerJSON, err := json.Marshal(header)
+	if err != nil {
+		return "", err
+	}
+	claimsJSON, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	enc := base64.RawURLEncoding
+	signingInput := enc.EncodeToString(headerJSON) + "." + enc.EncodeToString(claimsJSON)
+	signature, err := b.Signer.Sign([]byte(signingInput))
+	if err != nil {
+		return "", errors.Wrap(err, "samljwt: failed to sign token")
+	}
+	return signingInput + "." + enc.EncodeToString(signature), nil
+}
+
+func (b *Bridge) claims(assertion *saml.Assertion) (map[string]interface{}, error) {
+	if assertion == nil || assertion.Subject == nil || assertion.Subject.NameID == nil {
+		return nil, errors.New("samljwt: missing Assertion > Subject > NameID")
+	}
+
+	now := saml.Now()
+	if b.Now != nil {
+		now = b.Now()
+	}
+	lifetime := b.Lifetime
+	if lifetime <= 0 {
+		lifetime = DefaultLifetime
+	}
+	exp := now.Add(lifetime)
+	if stmt := assertion.AuthnStatement; stmt != nil && stmt.SessionNotOnOrAfter != nil {
+		if !stmt.SessionNotOnOrAfter.After(now) {
+			return nil, errors.New("samljwt: the SAML session has expired")
+		}
+		if stmt.SessionNotOnOrAfter.Before(exp) {
+			exp = *stmt.SessionNotOnOrAfter
+		}
+	}
+
+	claims := map[string]interface{}{}
+	attrs := saml.NewAttributesMap(assertion)
+	for claim, attr := range b.Claims {
+		values := attrs.Values(attr)
+		switch len(values) {
+		case 0:
+		case 1:
+			claims[claim] = values[0]
+		default:
+			claims[claim] = values
+		}
+	}
+
+	claims["sub"] = assertion.Subject.NameID.Value
+	claims["iat"] = now.Unix()
+	claims["exp"] = exp.Unix()
+	if b.Issuer != "" {
+		claims["iss"] = b.Issuer
+	}
+	if b.Audience != "" {
+		claims["aud"] = b.Audience
+	}
+	return claims, nil
+}
@@ -0,0 +1,117 @@
+package samljwt
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/goware/saml"
+	"github.com/stretchr/testify/assert"
+)
+
+func testAssertion() *saml.Assertion {
+	return &saml.Assertion{
+		Subject: &saml.Subject{NameID: &saml.NameID{Value: "jane@example.org"}},
+		AttributeStatement: &saml.AttributeStatement{
+			Attributes: []saml.Attribute{
+				{Name: "urn:oid:0.9.2342.19200300.100.1.3", Values: []saml.AttributeValue{{Value: "jane@example.org"}}},
+				{Name: "groups", Values: []saml.AttributeValue{{Value: "staff"}, {Value: "admins"}}},
+				{Name: "sub", Values: []saml.AttributeValue{{Value: "admin"}}},
+			},
+		},
+	}
+}
+
+func decodePart(t *testing.T, part string, v interface{}) {
+	buf, err := base64.RawURLEncoding.DecodeString(part)
+	assert.NoError(t, err)
+	assert.NoError(t, json.Unmarshal(buf, v))
+}
+
+func TestTokenHS256(t *testing.T) {
+	now := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+	b := &Bridge{
+		Signer:   HS256([]byte("secret")),
+		KeyID:    "key-1",
+		Issuer:   "https://sp.example.org",
+		Audience: "api",
+		Claims: map[string]string{
+			"email":  "mail",
+			"groups": "groups",
+			"sub":    "sub",
+		},
+		Now: func() time.Time { return now },
+	}
+
+	token, err := b.Token(testAssertion())
+	assert.NoError(t, err)
+	parts := strings.Split(token, ".")
+	assert.Len(t, parts, 3)
+
+	var header map[string]string
+	decodePart(t, parts[0], &header)
+	assert.Equal(t, map[string]string{"alg": "HS256", "typ": "JWT", "kid": "key-1"}, header)
+
+	var claims map[string]interface{}
+	decodePart(t, parts[1], &claims)
+	assert.Equal(t, map[string]interface{}{
+		"sub":    "jane@example.org",
+		"email":  "jane@example.org",
+		"groups": []interface{}{"staff", "admins"},
+		"iss":    "https://sp.example.org",
+		"aud":    "api",
+		"iat":    float64(now.Unix()),
+		"exp":    float64(now.Add(DefaultLifetime).Unix()),
+	}, claims)
+
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write([]byte(parts[0] + "." + parts[1]))
+	assert.Equal(t, base64.RawURLEncoding.EncodeToString(mac.Sum(nil)), parts[2])
+}
+
+func TestTokenSessionLifetime(t *testing.T) {
+	now := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+	b := &Bridge{
+		Signer: HS256([]byte("secret")),
+		Now:    func() time.Time { return now },
+	}
+
+	assertion := testAssertion()
+	sessionEnd := now.Add(10 * time.Minute)
+	assertion.AuthnStatement = &saml.AuthnStatement{SessionNotOnOrAfter: &sessionEnd}
+
+	token, err := b.Token(assertion)
+	assert.NoError(t, err)
+	var claims map[string]interface{}
+	decodePart(t, strings.Split(token, ".")[1], &claims)
+	assert.Equal(t, float64(sessionEnd.Unix()), claims["exp"])
+
+	sessionEnd = now
+	_, err = b.Token(assertion)
+	assert.Error(t, err)
+
+	_, err = b.Token(&saml.Assertion{})
+	assert.Error(t, err)
+}
+
+func TestTokenRS256(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+
+	b := &Bridge{Signer: RS256(key)}
+	token, err := b.Token(testAssertion())
+	assert.NoError(t, err)
+
+	parts := strings.Split(token, ".")
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	assert.NoError(t, err)
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	assert.NoError(t, rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA256, digest[:], signature))
+}
@@ -0,0 +1,51 @@
+// Package samlotel traces the SSO flow of a saml.ServiceProvider with
+// OpenTelemetry.
+//
+//	sp.Tracer = samlotel.NewTracer(otel.GetTracerProvider())
+package samlotel
+
+import (
+	"context"
+
+	"github.com/goware/saml"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// instrumentationName identifies the tracer.
+const instrumentationName = "github.com/goware/saml"
+
+// NewTracer returns a saml.Tracer creating its spans with tp.
+func NewTracer(tp trace.TracerProvider) saml.Tracer {
+	return tracer{tp.Tracer(instrumentationName)}
+}
+
+type tracer struct {
+	t trace.Tracer
+}
+
+func (t tracer) Start(ctx context.Context, name string) (context.Context, saml.Span) {
+	ctx, s := t.t.Start(ctx, name)
+	return ctx, span{s}
+}
+
+type span struct {
+	s trace.Span
+}
+
+func (s span) SetAttribute(key, value string) {
+	if value != "" {
+		s.s.SetAttributes(attribute.String(key, value))
+	}
+}
+
+// End records err and sets the status of the span to Error, with the
+// saml.FailureReason of err as description.
+func (s span) End(err error) {
+	if err != nil {
+		s.s.RecordError(err)
+		s.s.SetStatus(codes.Error, saml.FailureReason(err))
+	}
+	s.s.End()
+}
@@ -0,0 +1,53 @@
+package samlotel
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/goware/saml"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestTracer(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+	sp := &saml.ServiceProvider{
+		MetadataURL: "http://localhost:1235/saml/metadata",
+		AcsURL:      "http://localhost:1235/saml/acs",
+		IdPMetadata: &saml.Metadata{
+			EntityID: "http://localhost:1233/saml/metadata",
+			IDPSSODescriptor: &saml.IDPSSODescriptor{
+				SingleSignOnService: []saml.Endpoint{{
+					Binding:  saml.HTTPRedirectBinding,
+					Location: "http://localhost:1233/saml/sso",
+				}},
+			},
+		},
+		Tracer:      NewTracer(tp),
+		IDGenerator: func() string { return "_req1" },
+	}
+
+	_, err := sp.AuthnRequestURL("")
+	assert.NoError(t, err)
+
+	_, err = sp.AssertResponse(httptest.NewRequest("POST", sp.AcsURL, nil), "not base64")
+	assert.Error(t, err)
+
+	spans := recorder.Ended()
+	if !assert.Len(t, spans, 2) {
+		return
+	}
+
+	assert.Equal(t, saml.SpanAuthnRequest, spans[0].Name())
+	assert.Contains(t, spans[0].Attributes(), attribute.String(saml.AttrRequestID, "_req1"))
+	assert.Equal(t, codes.Unset, spans[0].Status().Code)
+
+	assert.Equal(t, saml.SpanAssertResponse, spans[1].Name())
+	assert.Equal(t, codes.Error, spans[1].Status().Code)
+	assert.Equal(t, "malformed_response", spans[1].Status().Description)
+}
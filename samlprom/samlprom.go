@@ -0,0 +1,102 @@
+// Package samlprom exports the metrics of a saml.ServiceProvider to
+// Prometheus.
+//
+//	sp.Metrics = samlprom.NewCollector(prometheus.DefaultRegisterer)
+package samlprom
+
+import (
+	"time"
+
+	"github.com/goware/saml"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Collector implements saml.MetricsCollector with the following metrics:
+//
+//	saml_sp_authn_requests_total                                             counter
+//	saml_sp_responses_total{result}                                          counter
+//	saml_sp_metadata_refreshes_total{result}                                 counter
+//	saml_sp_signature_verification_seconds{result}                           histogram
+//	saml_sp_certificate_expiry_timestamp_seconds{entity_id,use,fingerprint}  gauge
+//
+// The result label is "success" or the saml.FailureReason of the error. The
+// expiry dates of the certificates of the SP and of its IdPs are set by
+// ServiceProvider.CheckCertificateExpiry. During a rotation, both
+// certificates of an entity are listed; alert on the minimum by entity_id
+// and use.
+type Collector struct {
+	authnRequests         prometheus.Counter
+	responses             *prometheus.CounterVec
+	metadataRefreshes     *prometheus.CounterVec
+	signatureVerification *prometheus.HistogramVec
+	certificateExpiry     *prometheus.GaugeVec
+}
+
+// NewCollector creates a Collector and registers its metrics with reg.
+func NewCollector(reg prometheus.Registerer) *Collector {
+	c := &Collector{
+		authnRequests: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "saml",
+			Subsystem: "sp",
+			Name:      "authn_requests_total",
+			Help:      "Number of AuthnRequests sent to the IdP.",
+		}),
+		responses: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "saml",
+			Subsystem: "sp",
+			Name:      "responses_total",
+			Help:      "Number of SAML responses validated, by result.",
+		}, []string{"result"}),
+		metadataRefreshes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "saml",
+			Subsystem: "sp",
+			Name:      "metadata_refreshes_total",
+			Help:      "Number of IdP metadata downloads, by result.",
+		}, []string{"result"}),
+		signatureVerification: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "saml",
+			Subsystem: "sp",
+			Name:      "signature_verification_seconds",
+			Help:      "Duration of the signature verifications, by result.",
+			Buckets:   []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+		}, []string{"result"}),
+		certificateExpiry: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "saml",
+			Subsystem: "sp",
+			Name:      "certificate_expiry_timestamp_seconds",
+			Help:      "Expiry date of the certificates of the SP and of its IdPs, by entity ID, use and SHA-256 fingerprint.",
+		}, []string{"entity_id", "use", "fingerprint"}),
+	}
+	reg.MustRegister(c.authnRequests, c.responses, c.metadataRefreshes, c.signatureVerification, c.certificateExpiry)
+	return c
+}
+
+// AuthnRequestIssued implements saml.MetricsCollector.
+func (c *Collector) AuthnRequestIssued() {
+	c.authnRequests.Inc()
+}
+
+// ResponseValidated implements saml.MetricsCollector.
+func (c *Collector) ResponseValidated(err error) {
+	c.responses.WithLabelValues(saml.FailureReason(err)).Inc()
+}
+
+// MetadataRefreshed implements saml.MetricsCollector.
+func (c *Collector) MetadataRefreshed(err error) {
+	c.metadataRefreshes.WithLabelValues(saml.FailureReason(err)).Inc()
+}
+
+// SignatureVerified implements saml.MetricsCollector.
+func (c *Collector) SignatureVerified(duration time.Duration, err error) {
+	c.signatureVerification.WithLabelValues(saml.FailureReason(err)).Observe(duration.Seconds())
+}
+
+// CertificatesChecked implements saml.CertificateExpiryCollector. The
+// certificates of the previous check are dropped, so that those rotated out
+// or of the removed IdPs don't linger.
+func (c *Collector) CertificatesChecked(certs []saml.CertificateExpiry) {
+	c.certificateExpiry.Reset()
+	for _, cert := range certs {
+		c.certificateExpiry.WithLabelValues(cert.EntityID, cert.Use, cert.Fingerprint).Set(float64(cert.NotAfter.Unix()))
+	}
+}
@@ -0,0 +1,47 @@
+package samlprom
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/goware/saml"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCollector(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	c := NewCollector(reg)
+
+	var _ saml.MetricsCollector = c
+	var _ saml.CertificateExpiryCollector = c
+
+	c.AuthnRequestIssued()
+	c.AuthnRequestIssued()
+	c.ResponseValidated(nil)
+	c.ResponseValidated(&saml.ValidationError{Kind: saml.ErrWrongDestination})
+	c.ResponseValidated(&saml.ValidationError{Kind: saml.ErrWrongDestination})
+	c.MetadataRefreshed(errors.New("connection refused"))
+	c.SignatureVerified(10*time.Millisecond, nil)
+	c.CertificatesChecked([]saml.CertificateExpiry{
+		{EntityID: "https://idp.example.com", Use: "signing", NotAfter: time.Unix(1700000000, 0), Fingerprint: "aa"},
+		{EntityID: "https://idp.example.com", Use: "signing", NotAfter: time.Unix(1800000000, 0), Fingerprint: "bb"},
+		{EntityID: "https://idp2.example.com", Use: "signing", NotAfter: time.Unix(1700000000, 0), Fingerprint: "cc"},
+	})
+
+	assert.Equal(t, 2.0, testutil.ToFloat64(c.authnRequests))
+	assert.Equal(t, 1.0, testutil.ToFloat64(c.responses.WithLabelValues("success")))
+	assert.Equal(t, 2.0, testutil.ToFloat64(c.responses.WithLabelValues("wrong_destination")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(c.metadataRefreshes.WithLabelValues("error")))
+	assert.Equal(t, 1, testutil.CollectAndCount(c.signatureVerification))
+	assert.Equal(t, 1700000000.0, testutil.ToFloat64(c.certificateExpiry.WithLabelValues("https://idp.example.com", "signing", "aa")))
+	assert.Equal(t, 1800000000.0, testutil.ToFloat64(c.certificateExpiry.WithLabelValues("https://idp.example.com", "signing", "bb")))
+
+	// The rotated out certificates and the removed IdPs are dropped.
+	c.CertificatesChecked([]saml.CertificateExpiry{
+		{EntityID: "https://idp.example.com", Use: "signing", NotAfter: time.Unix(1800000000, 0), Fingerprint: "bb"},
+	})
+	assert.Equal(t, 1, testutil.CollectAndCount(c.certificateExpiry))
+}
@@ -0,0 +1,81 @@
+package samlsp
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+
+	"github.com/pkg/errors"
+)
+
+// minKeySize is the minimum size of the keys of the session cookies.
+const minKeySize = 32
+
+// CookieStore is a SessionStore keeping the sessions in the cookies
+// themselves, encrypted and authenticated with AES-GCM. It needs no storage,
+// but sessions can't be revoked: Delete only lets the Middleware clear the
+// cookie.
+type CookieStore struct {
+	// Key must be random and at least 32 bytes long.
+	Key []byte
+}
+
+// Put returns the sealed session.
+func (c *CookieStore) Put(ctx context.Context, s *Session) (string, error) {
+	aead, err := c.aead()
+	if err != nil {
+		return "", err
+	}
+	buf, err := json.Marshal(s)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(aead.Seal(nonce, nonce, buf, nil)), nil
+}
+
+// Get opens a sealed session.
+func (c *CookieStore) Get(ctx context.Context, token string) (*Session, error) {
+	aead, err := c.aead()
+	if err != nil {
+		return nil, err
+	}
+	buf, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil || len(buf) < aead.NonceSize() {
+		return nil, ErrNoSession
+	}
+	nonce, sealed := buf[:aead.NonceSize()], buf[aead.NonceSize():]
+	buf, err = aead.Open(nil, nonce, sealed, nil)
+	if err != nil {
+		return nil, ErrNoSession
+	}
+	var s Session
+	if err := json.Unmarshal(buf, &s); err != nil {
+		return nil, errors.Wrap(err, "malformed session cookie")
+	}
+	return &s, nil
+}
+
+// Delete does nothing.
+func (c *CookieStore) Delete(ctx context.Context, token string) error {
+	return nil
+}
+
+func (c *CookieStore) aead() (cipher.AEAD, error) {
+	if len(c.Key) < minKeySize {
+		return nil, errors.Errorf("samlsp: the session Key must be at least %d bytes long", minKeySize)
+	}
+	key := sha256.Sum256(c.Key)
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
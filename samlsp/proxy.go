@@ -0,0 +1,220 @@
+package samlsp
+
+import (
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/goware/saml"
+)
+
+// Default headers set by a Proxy.
+const (
+	DefaultUserHeader      = "Remote-User"
+	DefaultIssuerHeader    = "Remote-User-Issuer"
+	DefaultAttributePrefix = "X-Saml-"
+)
+
+// Proxy is an authenticating reverse proxy: it serves the SP metadata and
+// ACS, sends the users without a session to the IdP, and forwards the other
+// requests to Upstream with the identity of the user in request headers.
+//
+// Headers by the same names sent by the user agent are removed, whatever
+// their case and with underscores in place of dashes, so the upstream can
+// trust them as long as it is only reachable through the proxy. The session
+// cookie and the cookies of saml.CookieRequestTracker are not forwarded
+// either: the upstream has no use for them, and must not be able to replay
+// the session.
+type Proxy struct {
+	Middleware *Middleware
+	Upstream   *url.URL
+
+	// UserHeader receives the NameID of the user. It defaults to
+	// DefaultUserHeader.
+	UserHeader string
+
+	// IssuerHeader receives the entity ID of the IdP of the user, which
+	// qualifies the NameID when the SP has several IdPs. It defaults to
+	// DefaultIssuerHeader.
+	IssuerHeader string
+
+	// Headers maps attribute names, or OIDs, to the headers receiving their
+	// values. When nil, every attribute is forwarded in a header made of
+	// AttributePrefix and its friendly name, e.g. X-Saml-Mail.
+	Headers map[string]string
+
+	// AttributePrefix defaults to DefaultAttributePrefix.
+	AttributePrefix string
+
+	// Transport is used to reach the upstream, http.DefaultTransport if nil.
+	Transport http.RoundTripper
+
+	// The handler forwarding the requests is built on first use: the
+	// Middleware, Upstream and Transport must not be changed afterwards.
+	once    sync.Once
+	handler http.Handler
+}
+
+// NewProxy returns a Proxy forwarding the authenticated requests to
+// upstream.
+func NewProxy(m *Middleware, upstream *url.URL) *Proxy {
+	return &Proxy{Middleware: m, Upstream: upstream}
+}
+
+// ServeHTTP implements http.Handler.
+func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	sp := p.Middleware.SP
+	switch r.URL.Path {
+	case urlPath(sp.MetadataURL):
+		sp.MetadataHandler(w, r)
+	case urlPath(sp.AcsURL):
+		p.Middleware.ServeACS(w, r)
+	default:
+		p.once.Do(func() {
+			p.handler = p.Middleware.RequireAccount(p.reverseProxy())
+		})
+		p.handler.ServeHTTP(w, r)
+	}
+}
+
+func (p *Proxy) reverseProxy() http.Handler {
+	proxy := httputil.NewSingleHostReverseProxy(p.Upstream)
+	proxy.Transport = p.Transport
+	director := proxy.Director
+	proxy.Director = func(r *http.Request) {
+		director(r)
+		p.removeCookies(r.Header)
+		p.setHeaders(r.Header, SessionFromContext(r.Context()))
+	}
+	return proxy
+}
+
+// setHeaders replaces the identity headers of a request with those of s.
+func (p *Proxy) setHeaders(h http.Header, s *Session) {
+	userHeader := p.UserHeader
+	if userHeader == "" {
+		userHeader = DefaultUserHeader
+	}
+	issuerHeader := p.IssuerHeader
+	if issuerHeader == "" {
+		issuerHeader = DefaultIssuerHeader
+	}
+	prefix := http.CanonicalHeaderKey(p.AttributePrefix)
+	if prefix == "" {
+		prefix = DefaultAttributePrefix
+	}
+
+	// Servers such as nginx or CGI map both - and _ to _ in header
+	// names: X_Saml_Mail must be removed as well as X-Saml-Mail.
+	managed := func(name string) bool {
+		name = normalizeHeader(name)
+		if name == normalizeHeader(userHeader) || name == normalizeHeader(issuerHeader) || strings.HasPrefix(name, normalizeHeader(prefix)) {
+			return true
+		}
+		for _, header := range p.Headers {
+			if name == normalizeHeader(header) {
+				return true
+			}
+		}
+		return false
+	}
+	for name := range h {
+		if managed(name) {
+			delete(h, name)
+		}
+	}
+
+	if s == nil {
+		return
+	}
+	setHeaderValue(h, userHeader, s.NameID)
+	setHeaderValue(h, issuerHeader, s.Issuer)
+
+	if p.Headers != nil {
+		for name, header := range p.Headers {
+			for _, value := range s.Values(name) {
+				setHeaderValue(h, header, value)
+			}
+		}
+		return
+	}
+	for name, values := range s.Attributes {
+		friendly := saml.AttributeFriendlyName(name)
+		if friendly == "" {
+			friendly = name
+		}
+		if !isHeaderToken(friendly) {
+			continue
+		}
+		for _, value := range values {
+			setHeaderValue(h, prefix+friendly, value)
+		}
+	}
+}
+
+// removeCookies removes the cookies of the Middleware from the Cookie
+// headers of a request, keeping the others as sent.
+func (p *Proxy) removeCookies(h http.Header) {
+	sessionCookie := p.Middleware.cookieName()
+	var headers []string
+	for _, line := range h["Cookie"] {
+		var kept []string
+		for _, pair := range strings.Split(line, ";") {
+			name := strings.TrimSpace(pair)
+			if i := strings.IndexByte(name, '='); i >= 0 {
+				name = name[:i]
+			}
+			if name == sessionCookie || strings.HasPrefix(name, saml.RequestCookiePrefix) {
+				continue
+			}
+			kept = append(kept, strings.TrimSpace(pair))
+		}
+		if len(kept) > 0 {
+			headers = append(headers, strings.Join(kept, "; "))
+		}
+	}
+	if headers == nil {
+		h.Del("Cookie")
+		return
+	}
+	h["Cookie"] = headers
+}
+
+// setHeaderValue adds a value to a header, unless it can't be represented
+// in a header.
+func setHeaderValue(h http.Header, name, value string) {
+	if value == "" || strings.ContainsAny(value, "\r\n\x00") {
+		return
+	}
+	h.Add(name, value)
+}
+
+// normalizeHeader returns the lower case form of a header name, with the
+// underscores replaced by dashes.
+func normalizeHeader(name string) string {
+	return strings.ToLower(strings.ReplaceAll(name, "_", "-"))
+}
+
+// isHeaderToken returns whether name can be used in a header name.
+func isHeaderToken(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, c := range name {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '.') {
+			return false
+		}
+	}
+	return true
+}
+
+// urlPath returns the path of location, or "" if it is invalid.
+func urlPath(location string) string {
+	u, err := url.Parse(location)
+	if err != nil {
+		return ""
+	}
+	return u.Path
+}
@@ -0,0 +1,78 @@
+package samlsp
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/goware/saml"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProxy(t *testing.T) {
+	var upstreamHeaders http.Header
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamHeaders = r.Header
+		w.Write([]byte("upstream " + r.URL.Path))
+	}))
+	defer upstream.Close()
+	upstreamURL, _ := url.Parse(upstream.URL)
+
+	m := newTestMiddleware()
+	p := NewProxy(m, upstreamURL)
+
+	// No session: the user agent is sent to the IdP.
+	w := httptest.NewRecorder()
+	p.ServeHTTP(w, httptest.NewRequest("GET", "https://sp.example.com/app", nil))
+	assert.Equal(t, http.StatusFound, w.Code)
+
+	// The ACS is served by the proxy.
+	w = httptest.NewRecorder()
+	p.ServeHTTP(w, httptest.NewRequest("POST", "https://sp.example.com/saml/acs", nil))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	token, err := m.store().Put(context.Background(), NewSession(testAssertion(), saml.Now(), time.Hour))
+	assert.NoError(t, err)
+	newRequest := func() *http.Request {
+		r := httptest.NewRequest("GET", "https://sp.example.com/app", nil)
+		r.AddCookie(&http.Cookie{Name: "lang", Value: "en"})
+		r.AddCookie(&http.Cookie{Name: DefaultCookieName, Value: token})
+		r.AddCookie(&http.Cookie{Name: saml.RequestCookiePrefix + "id-req", Value: "x"})
+		r.Header.Set("Remote-User", "admin")
+		r.Header.Set("Remote-User-Issuer", "https://evil.example.com")
+		r.Header.Set("X-Saml-Role", "admin")
+		// Spellings that some servers map to the same variable.
+		r.Header["Remote_User"] = []string{"admin"}
+		r.Header["x_saml_group"] = []string{"admin"}
+		return r
+	}
+
+	w = httptest.NewRecorder()
+	p.ServeHTTP(w, newRequest())
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "upstream /app", w.Body.String())
+	assert.Equal(t, "jdoe", upstreamHeaders.Get("Remote-User"))
+	assert.Equal(t, "https://idp.example.com", upstreamHeaders.Get("Remote-User-Issuer"))
+	assert.Equal(t, "jdoe@example.com", upstreamHeaders.Get("X-Saml-Mail"))
+	assert.Empty(t, upstreamHeaders.Values("X-Saml-Role"))
+	assert.Empty(t, upstreamHeaders.Values("Remote_User"))
+	assert.Empty(t, upstreamHeaders.Values("X_Saml_Group"))
+	// The cookies of the SP are not forwarded.
+	assert.Equal(t, []string{"lang=en"}, upstreamHeaders.Values("Cookie"))
+
+	p.UserHeader = "X-User"
+	p.Headers = map[string]string{"mail": "X-Email"}
+	w = httptest.NewRecorder()
+	p.ServeHTTP(w, newRequest())
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "jdoe", upstreamHeaders.Get("X-User"))
+	assert.Equal(t, "jdoe@example.com", upstreamHeaders.Get("X-Email"))
+	assert.Empty(t, upstreamHeaders.Values("X-Saml-Mail"))
+	assert.Empty(t, upstreamHeaders.Values("X-Saml-Role"))
+	assert.Empty(t, upstreamHeaders.Values("X_Saml_Group"))
+	// Remote-User is not a header of the proxy anymore, so it is passed as is.
+	assert.Equal(t, "admin", upstreamHeaders.Get("Remote-User"))
+}
@@ -0,0 +1,102 @@
+package samlsp
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/goware/saml"
+	"github.com/pkg/errors"
+)
+
+// RedisClient is the subset of a Redis client used by RedisStore. Get must
+// return ErrNoSession for a missing key. With go-redis, the adapter is:
+//
+//	type redisClient struct{ *redis.Client }
+//
+//	func (c redisClient) Get(ctx context.Context, key string) ([]byte, error) {
+//		buf, err := c.Client.Get(ctx, key).Bytes()
+//		if err == redis.Nil {
+//			return nil, samlsp.ErrNoSession
+//		}
+//		return buf, err
+//	}
+//
+//	func (c redisClient) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+//		return c.Client.Set(ctx, key, value, ttl).Err()
+//	}
+//
+//	func (c redisClient) Del(ctx context.Context, key string) error {
+//		return c.Client.Del(ctx, key).Err()
+//	}
+type RedisClient interface {
+	Get(ctx context.Context, key string) ([]byte, error)
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	Del(ctx context.Context, key string) error
+}
+
+// DefaultRedisPrefix is the default prefix of the keys of a RedisStore.
+const DefaultRedisPrefix = "samlsp:session:"
+
+// RedisStore is a SessionStore holding the sessions in Redis, so that they
+// are shared by all the instances of an SP. Each session expires from Redis
+// with the session itself.
+type RedisStore struct {
+	Client RedisClient
+	// Prefix of the keys, DefaultRedisPrefix if empty.
+	Prefix string
+	// Clock tells when the sessions expire. Set it to the Clock of the SP,
+	// if any: saml.Now is used when nil.
+	Clock saml.Clock
+}
+
+// Put stores s under a new random token.
+func (r *RedisStore) Put(ctx context.Context, s *Session) (string, error) {
+	id, err := newSessionID()
+	if err != nil {
+		return "", errors.Wrap(err, "failed to generate session ID")
+	}
+	buf, err := json.Marshal(s)
+	if err != nil {
+		return "", err
+	}
+	ttl := sessionTTL(r.Clock, s)
+	if ttl <= 0 {
+		return "", errors.New("session expired")
+	}
+	if err := r.Client.Set(ctx, r.key(id), buf, ttl); err != nil {
+		return "", errors.Wrap(err, "failed to store session")
+	}
+	return id, nil
+}
+
+// Get returns the session stored under token.
+func (r *RedisStore) Get(ctx context.Context, token string) (*Session, error) {
+	buf, err := r.Client.Get(ctx, r.key(token))
+	if err != nil {
+		if err == ErrNoSession {
+			return nil, err
+		}
+		return nil, errors.Wrap(err, "failed to load session")
+	}
+	var s Session
+	if err := json.Unmarshal(buf, &s); err != nil {
+		return nil, errors.Wrap(err, "malformed session")
+	}
+	return &s, nil
+}
+
+// Delete revokes the session stored under token.
+func (r *RedisStore) Delete(ctx context.Context, token string) error {
+	if err := r.Client.Del(ctx, r.key(token)); err != nil {
+		return errors.Wrap(err, "failed to delete session")
+	}
+	return nil
+}
+
+func (r *RedisStore) key(token string) string {
+	if r.Prefix == "" {
+		return DefaultRedisPrefix + token
+	}
+	return r.Prefix + token
+}
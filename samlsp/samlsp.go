@@ -0,0 +1,211 @@
+// Package samlsp provides a login session layer on top of a
+// saml.ServiceProvider: once an assertion is accepted at the ACS, the user
+// agent gets a session cookie, and the protected handlers are served
+// directly as long as the session is valid.
+package samlsp
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/goware/saml"
+	"github.com/pkg/errors"
+)
+
+// Default settings of a Middleware.
+const (
+	DefaultCookieName = "saml_session"
+	DefaultLifetime   = 8 * time.Hour
+)
+
+// Middleware authenticates the users of an application with SAML.
+//
+//	m := &samlsp.Middleware{SP: sp, Key: key}
+//	mux.Handle("/saml/acs", http.HandlerFunc(m.ServeACS))
+//	mux.Handle("/", m.RequireAccount(app))
+type Middleware struct {
+	SP *saml.ServiceProvider
+
+	// Store keeps the sessions. When nil, they are kept in the cookies,
+	// encrypted with Key; see CookieStore. The Clock of a MemoryStore or
+	// RedisStore should be the one of SP.
+	Store SessionStore
+
+	// Key encrypts the session cookies when Store is nil. It must be random
+	// and at least 32 bytes long.
+	Key []byte
+
+	// CookieName defaults to DefaultCookieName.
+	CookieName string
+	// CookieDomain and CookiePath scope the session cookie. CookiePath
+	// defaults to "/".
+	CookieDomain string
+	CookiePath   string
+
+	// Lifetime of the sessions, clamped to the SessionNotOnOrAfter of the
+	// assertions. It defaults to DefaultLifetime.
+	Lifetime time.Duration
+
+	// BindToClientCertificate binds the sessions to the TLS client
+	// certificate of the connection to the ACS: they are then only accepted
+	// from connections with the same certificate, so that a stolen session
+	// cookie can't be used from another channel. The certificate is found
+	// as by SP.HolderOfKeyCheck, e.g. in the header set by a reverse proxy,
+	// and the logins without one are rejected.
+	BindToClientCertificate bool
+}
+
+// ServeACS validates the SAML response posted by the IdP, creates the
+// session and redirects the user agent to the RelayState if it passes
+// SP.CheckRedirect, or to "/".
+func (m *Middleware) ServeACS(w http.ResponseWriter, r *http.Request) {
+	m.SP.AssertionMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		m.login(w, r, saml.GetAssertionFromCtx(r.Context()))
+	})).ServeHTTP(w, r)
+}
+
+func (m *Middleware) login(w http.ResponseWriter, r *http.Request, assertion *saml.Assertion) {
+	now := m.now()
+	s := NewSession(assertion, now, m.lifetime())
+	if !now.Before(s.ExpiresAt) {
+		m.error(w, r, http.StatusForbidden, errors.New("the SAML session has expired"))
+		return
+	}
+	if m.BindToClientCertificate {
+		if s.ClientCertificate = m.clientCertificate(r); s.ClientCertificate == "" {
+			m.error(w, r, http.StatusForbidden, errors.New("no TLS client certificate to bind the session to"))
+			return
+		}
+	}
+
+	if err := m.setCookie(w, r, s); err != nil {
+		m.error(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	m.SP.RedirectAfterLogin(w, r, "/")
+}
+
+// RequireAccount serves next if the request carries a valid session, which
+// is then available with SessionFromContext. Other requests are sent to the
+// IdP with an AuthnRequest tracked by SP.SendAuthnRequest, and come back to
+// the requested URL after login.
+func (m *Middleware) RequireAccount(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s := m.Session(r); s != nil {
+			next.ServeHTTP(w, r.WithContext(ContextWithSession(r.Context(), s)))
+			return
+		}
+
+		m.SP.SendAuthnRequest(w, r, r.URL.RequestURI())
+	})
+}
+
+// Session returns the valid session carried by r, or nil.
+func (m *Middleware) Session(r *http.Request) *Session {
+	cookie, err := r.Cookie(m.cookieName())
+	if err != nil {
+		return nil
+	}
+	s, err := m.store().Get(r.Context(), cookie.Value)
+	if err != nil || !m.now().Before(s.ExpiresAt) {
+		return nil
+	}
+	if (m.BindToClientCertificate || s.ClientCertificate != "") && s.ClientCertificate != m.clientCertificate(r) {
+		return nil
+	}
+	return s
+}
+
+// clientCertificate returns the SHA-256 thumbprint of the TLS client
+// certificate of r, or an empty string.
+func (m *Middleware) clientCertificate(r *http.Request) string {
+	cert := m.SP.HolderOfKeyCheck.ClientCertificate(r)
+	if cert == nil {
+		return ""
+	}
+	sum := sha256.Sum256(cert.Raw)
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// Logout revokes the session, if the Store allows it, and deletes the
+// session cookie.
+func (m *Middleware) Logout(w http.ResponseWriter, r *http.Request) error {
+	if cookie, err := r.Cookie(m.cookieName()); err == nil {
+		if err := m.store().Delete(r.Context(), cookie.Value); err != nil {
+			return errors.Wrap(err, "samlsp: failed to delete session")
+		}
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     m.cookieName(),
+		Domain:   m.CookieDomain,
+		Path:     m.cookiePath(),
+		MaxAge:   -1,
+		HttpOnly: true,
+	})
+	return nil
+}
+
+func (m *Middleware) setCookie(w http.ResponseWriter, r *http.Request, s *Session) error {
+	value, err := m.store().Put(r.Context(), s)
+	if err != nil {
+		return errors.Wrap(err, "samlsp: failed to store session")
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     m.cookieName(),
+		Value:    value,
+		Domain:   m.CookieDomain,
+		Path:     m.cookiePath(),
+		Expires:  s.ExpiresAt,
+		HttpOnly: true,
+		Secure:   r.TLS != nil || strings.HasPrefix(m.SP.AcsURL, "https:"),
+		SameSite: http.SameSiteLaxMode,
+	})
+	return nil
+}
+
+func (m *Middleware) error(w http.ResponseWriter, r *http.Request, status int, err error) {
+	errorHandler := m.SP.ErrorHandler
+	if errorHandler == nil {
+		errorHandler = saml.DefaultErrorHandler
+	}
+	errorHandler(w, r, status, err)
+}
+
+func (m *Middleware) store() SessionStore {
+	if m.Store == nil {
+		return &CookieStore{Key: m.Key}
+	}
+	return m.Store
+}
+
+func (m *Middleware) now() time.Time {
+	if m.SP.Clock != nil {
+		return m.SP.Clock.Now()
+	}
+	return saml.Now()
+}
+
+func (m *Middleware) cookieName() string {
+	if m.CookieName == "" {
+		return DefaultCookieName
+	}
+	return m.CookieName
+}
+
+func (m *Middleware) cookiePath() string {
+	if m.CookiePath == "" {
+		return "/"
+	}
+	return m.CookiePath
+}
+
+func (m *Middleware) lifetime() time.Duration {
+	if m.Lifetime <= 0 {
+		return DefaultLifetime
+	}
+	return m.Lifetime
+}
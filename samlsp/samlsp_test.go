@@ -0,0 +1,351 @@
+package samlsp
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"encoding/xml"
+	"io/ioutil"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/goware/saml"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestMiddleware() *Middleware {
+	return &Middleware{
+		SP: &saml.ServiceProvider{
+			MetadataURL: "https://sp.example.com/saml/metadata",
+			AcsURL:      "https://sp.example.com/saml/acs",
+			IdPMetadata: &saml.Metadata{
+				EntityID: "https://idp.example.com/metadata",
+				IDPSSODescriptor: &saml.IDPSSODescriptor{
+					SingleSignOnService: []saml.Endpoint{{
+						Binding:  "urn:oasis:names:tc:SAML:2.0:bindings:HTTP-Redirect",
+						Location: "https://idp.example.com/sso",
+					}},
+				},
+			},
+		},
+		Key: []byte("0123456789abcdef0123456789abcdef"),
+	}
+}
+
+func testAssertion() *saml.Assertion {
+	return &saml.Assertion{
+		Issuer: &saml.Issuer{Value: "https://idp.example.com"},
+		Subject: &saml.Subject{
+			NameID: &saml.NameID{Value: "jdoe", Format: saml.NameIDFormatPersistent},
+		},
+		AttributeStatement: &saml.AttributeStatement{
+			Attributes: []saml.Attribute{{
+				Name:         "urn:oid:0.9.2342.19200300.100.1.3",
+				FriendlyName: "mail",
+				Values:       []saml.AttributeValue{{Value: "jdoe@example.com"}},
+			}},
+		},
+	}
+}
+
+func TestNewSession(t *testing.T) {
+	now := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	assertion := testAssertion()
+	assertion.Subject.NameID.SPNameQualifier = "https://sp.example.com"
+	s := NewSession(assertion, now, time.Hour)
+	assert.Equal(t, "jdoe", s.NameID)
+	assert.Equal(t, saml.NameIDFormatPersistent, s.NameIDFormat)
+	assert.Equal(t, "https://idp.example.com", s.Issuer)
+	assert.Equal(t, "https://idp.example.com!jdoe", s.UserKey())
+	assert.Equal(t, *assertion.Subject.NameID, s.SubjectNameID())
+	assert.Equal(t, "jdoe@example.com", s.Get("mail"))
+	assert.Equal(t, now.Add(time.Hour), s.ExpiresAt)
+
+	end := now.Add(10 * time.Minute)
+	assertion.AuthnStatement = &saml.AuthnStatement{
+		SessionIndex:        "_s1",
+		SessionNotOnOrAfter: &end,
+		AuthnContext: saml.AuthnContext{
+			AuthnContextClassRef: &saml.AuthnContextClassRef{Value: saml.AuthnContextPasswordProtectedTransport},
+		},
+	}
+	s = NewSession(assertion, now, time.Hour)
+	assert.Equal(t, "_s1", s.SessionIndex)
+	assert.Equal(t, saml.AuthnContextPasswordProtectedTransport, s.AuthnContextClassRef)
+	assert.Equal(t, end, s.ExpiresAt)
+}
+
+func TestRequireAccount(t *testing.T) {
+	m := newTestMiddleware()
+
+	var session *Session
+	app := m.RequireAccount(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		session = SessionFromContext(r.Context())
+	}))
+
+	// No session: the user agent is sent to the IdP.
+	w := httptest.NewRecorder()
+	app.ServeHTTP(w, httptest.NewRequest("GET", "https://sp.example.com/private?x=1", nil))
+	assert.Equal(t, http.StatusFound, w.Code)
+	location, err := url.Parse(w.Header().Get("Location"))
+	assert.NoError(t, err)
+	assert.Equal(t, "idp.example.com", location.Host)
+	assert.Equal(t, "/private?x=1", location.Query().Get("RelayState"))
+	assert.NotEmpty(t, location.Query().Get("SAMLRequest"))
+	assert.Nil(t, session)
+
+	// Valid session.
+	value, err := m.store().Put(context.Background(), NewSession(testAssertion(), saml.Now(), time.Hour))
+	assert.NoError(t, err)
+	r := httptest.NewRequest("GET", "https://sp.example.com/private", nil)
+	r.AddCookie(&http.Cookie{Name: DefaultCookieName, Value: value})
+	w = httptest.NewRecorder()
+	app.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+	if assert.NotNil(t, session) {
+		assert.Equal(t, "jdoe", session.NameID)
+	}
+
+	// Forged session.
+	session = nil
+	r = httptest.NewRequest("GET", "https://sp.example.com/private", nil)
+	r.AddCookie(&http.Cookie{Name: DefaultCookieName, Value: value + "x"})
+	w = httptest.NewRecorder()
+	app.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusFound, w.Code)
+	assert.Nil(t, session)
+}
+
+func TestLogin(t *testing.T) {
+	m := newTestMiddleware()
+
+	r := httptest.NewRequest("POST", m.SP.AcsURL, nil)
+	w := httptest.NewRecorder()
+	m.login(w, r, testAssertion())
+	assert.Equal(t, http.StatusFound, w.Code)
+	assert.Equal(t, "/", w.Header().Get("Location"))
+
+	cookies := w.Result().Cookies()
+	if assert.Len(t, cookies, 1) {
+		c := cookies[0]
+		assert.Equal(t, DefaultCookieName, c.Name)
+		assert.True(t, c.HttpOnly)
+		assert.True(t, c.Secure)
+		assert.Equal(t, http.SameSiteLaxMode, c.SameSite)
+
+		r = httptest.NewRequest("GET", "https://sp.example.com/", nil)
+		r.AddCookie(c)
+		s := m.Session(r)
+		if assert.NotNil(t, s) {
+			assert.Equal(t, "jdoe", s.NameID)
+		}
+	}
+}
+
+func TestLogout(t *testing.T) {
+	m := newTestMiddleware()
+	m.Store = NewMemoryStore()
+
+	token, err := m.Store.Put(context.Background(), NewSession(testAssertion(), saml.Now(), time.Hour))
+	assert.NoError(t, err)
+
+	r := httptest.NewRequest("GET", "https://sp.example.com/logout", nil)
+	r.AddCookie(&http.Cookie{Name: DefaultCookieName, Value: token})
+	assert.NotNil(t, m.Session(r))
+
+	w := httptest.NewRecorder()
+	assert.NoError(t, m.Logout(w, r))
+	if cookies := w.Result().Cookies(); assert.Len(t, cookies, 1) {
+		assert.True(t, cookies[0].MaxAge < 0)
+	}
+
+	// The session is revoked even if the user agent keeps the cookie.
+	assert.Nil(t, m.Session(r))
+}
+
+func testClientCert(t *testing.T) *x509.Certificate {
+	_, certPEM, err := saml.GenerateKeyPair(saml.KeyPairOptions{Type: saml.KeyTypeECDSA})
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	block, _ := pem.Decode([]byte(certPEM))
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	return cert
+}
+
+func TestBindToClientCertificate(t *testing.T) {
+	m := newTestMiddleware()
+	m.BindToClientCertificate = true
+	cert, other := testClientCert(t), testClientCert(t)
+	withCert := func(r *http.Request, cert *x509.Certificate) *http.Request {
+		r.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}
+		return r
+	}
+
+	// The logins without client certificate are rejected.
+	w := httptest.NewRecorder()
+	m.login(w, httptest.NewRequest("POST", m.SP.AcsURL, nil), testAssertion())
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	w = httptest.NewRecorder()
+	m.login(w, withCert(httptest.NewRequest("POST", m.SP.AcsURL, nil), cert), testAssertion())
+	assert.Equal(t, http.StatusFound, w.Code)
+	cookies := w.Result().Cookies()
+	if !assert.Len(t, cookies, 1) {
+		return
+	}
+
+	// The session is only accepted with the same certificate.
+	r := withCert(httptest.NewRequest("GET", "https://sp.example.com/", nil), cert)
+	r.AddCookie(cookies[0])
+	assert.NotNil(t, m.Session(r))
+	r = withCert(httptest.NewRequest("GET", "https://sp.example.com/", nil), other)
+	r.AddCookie(cookies[0])
+	assert.Nil(t, m.Session(r))
+	r = httptest.NewRequest("GET", "https://sp.example.com/", nil)
+	r.AddCookie(cookies[0])
+	assert.Nil(t, m.Session(r))
+
+	// The certificate may be passed by a reverse proxy.
+	m.SP.HolderOfKeyCheck = &saml.HolderOfKeyCheck{CertificateHeader: "X-Client-Cert"}
+	r.Header.Set("X-Client-Cert", base64.StdEncoding.EncodeToString(cert.Raw))
+	assert.NotNil(t, m.Session(r))
+
+	// The sessions created without binding are rejected once enabled.
+	value, err := m.store().Put(context.Background(), NewSession(testAssertion(), saml.Now(), time.Hour))
+	assert.NoError(t, err)
+	r = withCert(httptest.NewRequest("GET", "https://sp.example.com/", nil), cert)
+	r.AddCookie(&http.Cookie{Name: DefaultCookieName, Value: value})
+	assert.Nil(t, m.Session(r))
+}
+
+// TestSPInitiatedLogin runs a login from RequireAccount to the ACS, with an
+// IdP answering with the HTTP-POST-SimpleSign binding, which does not need
+// xmlsec1.
+func TestSPInitiatedLogin(t *testing.T) {
+	keyPEM, certPEM, err := saml.GenerateKeyPair(saml.KeyPairOptions{Type: saml.KeyTypeECDSA})
+	if !assert.NoError(t, err) {
+		return
+	}
+	block, _ := pem.Decode([]byte(keyPEM))
+	idpKey, err := x509.ParseECPrivateKey(block.Bytes)
+	if !assert.NoError(t, err) {
+		return
+	}
+	block, _ = pem.Decode([]byte(certPEM))
+
+	mux := http.NewServeMux()
+	app := httptest.NewServer(mux)
+	defer app.Close()
+	m := newTestMiddleware()
+	m.SP.MetadataURL = app.URL + "/saml/metadata"
+	m.SP.AcsURL = app.URL + "/saml/acs"
+	m.SP.IdPMetadata.IDPSSODescriptor.KeyDescriptor = []saml.KeyDescriptor{{
+		Use:     "signing",
+		KeyInfo: saml.KeyInfo{Certificate: base64.StdEncoding.EncodeToString(block.Bytes)},
+	}}
+	mux.HandleFunc("/saml/acs", m.ServeACS)
+	mux.Handle("/private", m.RequireAccount(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(SessionFromContext(r.Context()).NameID))
+	})))
+
+	jar, err := cookiejar.New(nil)
+	assert.NoError(t, err)
+	client := &http.Client{
+		Jar: jar,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if req.URL.Host != app.Listener.Addr().String() {
+				return http.ErrUseLastResponse
+			}
+			return nil
+		},
+	}
+
+	// The user agent is sent to the IdP.
+	res, err := client.Get(app.URL + "/private")
+	if !assert.NoError(t, err) {
+		return
+	}
+	res.Body.Close()
+	if !assert.Equal(t, http.StatusFound, res.StatusCode) {
+		return
+	}
+	location, err := url.Parse(res.Header.Get("Location"))
+	assert.NoError(t, err)
+	msg, err := saml.DecodeRedirect(location.RawQuery, saml.SizeLimits{})
+	if !assert.NoError(t, err) {
+		return
+	}
+	var req saml.AuthnRequest
+	assert.NoError(t, xml.Unmarshal(msg.XML, &req))
+
+	// The IdP answers the request.
+	now := saml.Now()
+	buf, err := xml.Marshal(&saml.Response{
+		ID:           saml.NewID(),
+		InResponseTo: req.ID,
+		Destination:  m.SP.AcsURL,
+		Issuer:       &saml.Issuer{Value: m.SP.IdPMetadata.EntityID},
+		Status:       &saml.Status{StatusCode: saml.StatusCode{Value: saml.StatusSuccess}},
+		Assertion: &saml.Assertion{
+			ID:     saml.NewID(),
+			Issuer: &saml.Issuer{Value: m.SP.IdPMetadata.EntityID},
+			Subject: &saml.Subject{
+				NameID: &saml.NameID{Value: "jdoe"},
+				SubjectConfirmations: []saml.SubjectConfirmation{{
+					Method: saml.SubjectConfirmationMethodBearer,
+					SubjectConfirmationData: saml.SubjectConfirmationData{
+						InResponseTo: req.ID,
+						NotOnOrAfter: now.Add(5 * time.Minute),
+						Recipient:    m.SP.AcsURL,
+					},
+				}},
+			},
+			Conditions: &saml.Conditions{
+				NotBefore:            now.Add(-time.Minute),
+				NotOnOrAfter:         now.Add(5 * time.Minute),
+				AudienceRestrictions: []saml.AudienceRestriction{{Audiences: []saml.Audience{{Value: m.SP.MetadataURL}}}},
+			},
+			AuthnStatement: &saml.AuthnStatement{AuthnInstant: now, SessionIndex: "_s1"},
+		},
+	})
+	assert.NoError(t, err)
+	form := saml.NewPostForm(m.SP.AcsURL, "SAMLResponse", buf, location.Query().Get("RelayState"))
+	assert.NoError(t, form.SimpleSign(idpKey))
+	values := url.Values{
+		"SAMLResponse": {form.Value},
+		"RelayState":   {form.RelayState},
+		"SigAlg":       {form.SigAlg},
+		"Signature":    {form.Signature},
+	}
+
+	// The response is accepted at the ACS, which sends the user agent
+	// back to the requested page.
+	res, err = client.PostForm(m.SP.AcsURL, values)
+	if !assert.NoError(t, err) {
+		return
+	}
+	body, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode, "%s", body)
+	assert.Equal(t, "jdoe", string(body))
+	assert.Equal(t, "/private", res.Request.URL.Path)
+
+	// The request is answered once.
+	res, err = client.PostForm(m.SP.AcsURL, values)
+	if assert.NoError(t, err) {
+		res.Body.Close()
+		assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+	}
+}
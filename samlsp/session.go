@@ -0,0 +1,122 @@
+package samlsp
+
+import (
+	"context"
+	"time"
+
+	"github.com/goware/saml"
+)
+
+// Session is the login session created after a successful assertion.
+type Session struct {
+	// Issuer is the entity ID of the IdP that authenticated the user. The
+	// NameIDs are only unique per IdP: with several IdPs, the users are
+	// identified by UserKey.
+	Issuer string `json:"iss,omitempty"`
+
+	NameID       string `json:"sub"`
+	NameIDFormat string `json:"fmt,omitempty"`
+
+	// NameQualifier, SPNameQualifier and SPProvidedID qualify the NameID,
+	// and are needed to log the user out.
+	NameQualifier   string `json:"nq,omitempty"`
+	SPNameQualifier string `json:"spnq,omitempty"`
+	SPProvidedID    string `json:"spid,omitempty"`
+
+	SessionIndex string              `json:"sid,omitempty"`
+	Attributes   map[string][]string `json:"attrs,omitempty"`
+	ExpiresAt    time.Time           `json:"exp"`
+
+	// AuthnContextClassRef is the class of the authentication of the user,
+	// to compare with the class required by a resource, see
+	// saml.ServiceProvider.NeedsStepUp.
+	AuthnContextClassRef string `json:"acr,omitempty"`
+
+	// ClientCertificate is the SHA-256 thumbprint of the TLS client
+	// certificate the session is bound to, see Middleware.BindToClientCertificate.
+	ClientCertificate string `json:"x5t#S256,omitempty"`
+}
+
+// NewSession creates the session of the subject of a validated assertion.
+// The session ends after lifetime, or at the SessionNotOnOrAfter of the
+// assertion if it comes first.
+func NewSession(assertion *saml.Assertion, now time.Time, lifetime time.Duration) *Session {
+	s := &Session{
+		Attributes: map[string][]string{},
+		ExpiresAt:  now.Add(lifetime),
+	}
+	if assertion.Issuer != nil {
+		s.Issuer = assertion.Issuer.Value
+	}
+	if assertion.Subject != nil && assertion.Subject.NameID != nil {
+		s.NameID = assertion.Subject.NameID.Value
+		s.NameIDFormat = assertion.Subject.NameID.Format
+		s.NameQualifier = assertion.Subject.NameID.NameQualifier
+		s.SPNameQualifier = assertion.Subject.NameID.SPNameQualifier
+		s.SPProvidedID = assertion.Subject.NameID.SPProvidedID
+	}
+	if stmt := assertion.AuthnStatement; stmt != nil {
+		s.SessionIndex = stmt.SessionIndex
+		s.AuthnContextClassRef = stmt.AuthnContext.ClassRef()
+		if stmt.SessionNotOnOrAfter != nil && stmt.SessionNotOnOrAfter.Before(s.ExpiresAt) {
+			s.ExpiresAt = *stmt.SessionNotOnOrAfter
+		}
+	}
+	if assertion.AttributeStatement != nil {
+		for _, attr := range assertion.AttributeStatement.Attributes {
+			name := attr.Name
+			if name == "" {
+				name = attr.FriendlyName
+			}
+			for _, value := range attr.Values {
+				s.Attributes[name] = append(s.Attributes[name], value.Value)
+			}
+		}
+	}
+	return s
+}
+
+// SubjectNameID returns the NameID of the subject of the session, with its
+// qualifiers, e.g. for saml.ServiceProvider.NewLogoutRequest.
+func (s *Session) SubjectNameID() saml.NameID {
+	return saml.NameID{
+		Format:          s.NameIDFormat,
+		NameQualifier:   s.NameQualifier,
+		SPNameQualifier: s.SPNameQualifier,
+		SPProvidedID:    s.SPProvidedID,
+		Value:           s.NameID,
+	}
+}
+
+// UserKey identifies the user of the session by the NameID qualified with
+// the Issuer, as two IdPs may give the same NameID to different users.
+func (s *Session) UserKey() string {
+	return s.Issuer + "!" + s.NameID
+}
+
+// Get returns the first value of the given attribute. Known attributes can
+// be given by OID or friendly name, see saml.AttributeOID.
+func (s *Session) Get(name string) string {
+	attrs := saml.AttributesMap(s.Attributes)
+	return attrs.Get(name)
+}
+
+// Values returns all the values of the given attribute.
+func (s *Session) Values(name string) []string {
+	attrs := saml.AttributesMap(s.Attributes)
+	return attrs.Values(name)
+}
+
+type contextKey struct{}
+
+// SessionFromContext returns the session set by Middleware.RequireAccount,
+// or nil.
+func SessionFromContext(ctx context.Context) *Session {
+	s, _ := ctx.Value(contextKey{}).(*Session)
+	return s
+}
+
+// ContextWithSession returns a copy of ctx carrying the given session.
+func ContextWithSession(ctx context.Context, s *Session) context.Context {
+	return context.WithValue(ctx, contextKey{}, s)
+}
@@ -0,0 +1,112 @@
+package samlsp
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"sync"
+	"time"
+
+	"github.com/goware/saml"
+	"github.com/pkg/errors"
+)
+
+// ErrNoSession is returned by a SessionStore when a token does not match
+// any live session.
+var ErrNoSession = errors.New("samlsp: no such session")
+
+// SessionStore keeps the sessions created by a Middleware. Put returns the
+// token to store in the session cookie; Get and Delete take it back.
+//
+// CookieStore keeps the whole session in the cookie. MemoryStore and
+// RedisStore keep it on the server, so that it can be revoked on logout, and
+// with RedisStore shared between the instances of an SP.
+type SessionStore interface {
+	Put(ctx context.Context, s *Session) (token string, err error)
+	Get(ctx context.Context, token string) (*Session, error)
+	Delete(ctx context.Context, token string) error
+}
+
+// newSessionID returns a random session identifier.
+func newSessionID() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// MemoryStore is a SessionStore holding the sessions in memory. It does not
+// share the sessions between processes, nor keep them across restarts.
+type MemoryStore struct {
+	// Clock tells when the sessions expire. Set it to the Clock of the SP,
+	// if any: saml.Now is used when nil.
+	Clock saml.Clock
+
+	mu       sync.Mutex
+	sessions map[string]*Session
+	puts     int
+}
+
+// NewMemoryStore returns an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{sessions: map[string]*Session{}}
+}
+
+// Put stores s under a new random token.
+func (m *MemoryStore) Put(ctx context.Context, s *Session) (string, error) {
+	id, err := newSessionID()
+	if err != nil {
+		return "", errors.Wrap(err, "failed to generate session ID")
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.sessions == nil {
+		m.sessions = map[string]*Session{}
+	}
+	// Expired sessions are pruned from time to time.
+	m.puts++
+	if m.puts%100 == 0 {
+		now := storeNow(m.Clock)
+		for id, s := range m.sessions {
+			if !now.Before(s.ExpiresAt) {
+				delete(m.sessions, id)
+			}
+		}
+	}
+	m.sessions[id] = s
+	return id, nil
+}
+
+// Get returns the session stored under token.
+func (m *MemoryStore) Get(ctx context.Context, token string) (*Session, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.sessions[token]
+	if !ok {
+		return nil, ErrNoSession
+	}
+	return s, nil
+}
+
+// Delete revokes the session stored under token.
+func (m *MemoryStore) Delete(ctx context.Context, token string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.sessions, token)
+	return nil
+}
+
+// sessionTTL returns how long a session must be kept in a store, according
+// to clock.
+func sessionTTL(clock saml.Clock, s *Session) time.Duration {
+	return s.ExpiresAt.Sub(storeNow(clock))
+}
+
+func storeNow(clock saml.Clock) time.Time {
+	if clock == nil {
+		return saml.Now()
+	}
+	return clock.Now()
+}
@@ -0,0 +1,145 @@
+package samlsp
+
+import (
+	"context"
+	"encoding/base64"
+	"testing"
+	"time"
+
+	"github.com/goware/saml"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeRedis struct {
+	values map[string][]byte
+	ttls   map[string]time.Duration
+}
+
+func (f *fakeRedis) Get(ctx context.Context, key string) ([]byte, error) {
+	value, ok := f.values[key]
+	if !ok {
+		return nil, ErrNoSession
+	}
+	return value, nil
+}
+
+func (f *fakeRedis) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	f.values[key] = value
+	f.ttls[key] = ttl
+	return nil
+}
+
+func (f *fakeRedis) Del(ctx context.Context, key string) error {
+	delete(f.values, key)
+	return nil
+}
+
+func TestSessionStores(t *testing.T) {
+	redis := &fakeRedis{values: map[string][]byte{}, ttls: map[string]time.Duration{}}
+
+	stores := map[string]SessionStore{
+		"cookie": &CookieStore{Key: []byte("0123456789abcdef0123456789abcdef")},
+		"memory": NewMemoryStore(),
+		"redis":  &RedisStore{Client: redis},
+	}
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			s := NewSession(testAssertion(), saml.Now(), time.Hour)
+
+			token, err := store.Put(ctx, s)
+			assert.NoError(t, err)
+
+			got, err := store.Get(ctx, token)
+			assert.NoError(t, err)
+			if assert.NotNil(t, got) {
+				assert.Equal(t, s.NameID, got.NameID)
+				assert.Equal(t, s.Attributes, got.Attributes)
+				assert.True(t, s.ExpiresAt.Equal(got.ExpiresAt))
+			}
+
+			_, err = store.Get(ctx, token+"x")
+			assert.Equal(t, ErrNoSession, err)
+
+			assert.NoError(t, store.Delete(ctx, token))
+		})
+	}
+
+	ctx := context.Background()
+
+	// Server-side stores revoke the sessions.
+	for _, store := range []SessionStore{stores["memory"], stores["redis"]} {
+		token, err := store.Put(ctx, NewSession(testAssertion(), saml.Now(), time.Hour))
+		assert.NoError(t, err)
+		assert.NoError(t, store.Delete(ctx, token))
+		_, err = store.Get(ctx, token)
+		assert.Equal(t, ErrNoSession, err)
+	}
+
+	// Redis keys expire with the session.
+	token, err := stores["redis"].Put(ctx, NewSession(testAssertion(), saml.Now(), time.Hour))
+	assert.NoError(t, err)
+	ttl := redis.ttls[DefaultRedisPrefix+token]
+	assert.True(t, ttl > 59*time.Minute && ttl <= time.Hour, ttl)
+}
+
+func TestCookieStore(t *testing.T) {
+	ctx := context.Background()
+	store := &CookieStore{Key: []byte("0123456789abcdef0123456789abcdef")}
+
+	token, err := store.Put(ctx, NewSession(testAssertion(), saml.Now(), time.Hour))
+	assert.NoError(t, err)
+
+	// The content of the session is not readable.
+	buf, err := base64.RawURLEncoding.DecodeString(token)
+	assert.NoError(t, err)
+	assert.NotContains(t, string(buf), "jdoe")
+
+	_, err = (&CookieStore{Key: []byte("another key, for another SP.....")}).Get(ctx, token)
+	assert.Equal(t, ErrNoSession, err)
+
+	buf[len(buf)-1] ^= 1
+	_, err = store.Get(ctx, base64.RawURLEncoding.EncodeToString(buf))
+	assert.Equal(t, ErrNoSession, err)
+
+	_, err = store.Get(ctx, "garbage")
+	assert.Equal(t, ErrNoSession, err)
+
+	_, err = (&CookieStore{}).Put(ctx, &Session{})
+	assert.Error(t, err)
+	_, err = (&CookieStore{Key: []byte("short key")}).Put(ctx, &Session{})
+	assert.Error(t, err)
+}
+
+type fixedClock time.Time
+
+func (c fixedClock) Now() time.Time {
+	return time.Time(c)
+}
+
+func TestStoreClock(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	// The sessions expire according to the clock of the store, rather
+	// than saml.Now.
+	redis := &fakeRedis{values: map[string][]byte{}, ttls: map[string]time.Duration{}}
+	store := &RedisStore{Client: redis, Clock: fixedClock(now)}
+	token, err := store.Put(ctx, NewSession(testAssertion(), now, time.Hour))
+	assert.NoError(t, err)
+	assert.Equal(t, time.Hour, redis.ttls[DefaultRedisPrefix+token])
+
+	memory := &MemoryStore{Clock: fixedClock(now)}
+	expired, err := memory.Put(ctx, NewSession(testAssertion(), now, time.Minute))
+	assert.NoError(t, err)
+	memory.Clock = fixedClock(now.Add(time.Hour))
+	var live string
+	for i := 0; i < 99; i++ {
+		live, err = memory.Put(ctx, NewSession(testAssertion(), now, 2*time.Hour))
+		assert.NoError(t, err)
+	}
+	_, err = memory.Get(ctx, expired)
+	assert.Equal(t, ErrNoSession, err)
+	_, err = memory.Get(ctx, live)
+	assert.NoError(t, err)
+}
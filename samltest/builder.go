@@ -0,0 +1,349 @@
+package samltest
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/xml"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/goware/saml"
+	"github.com/goware/saml/xmlsec"
+	"github.com/pkg/errors"
+)
+
+// ResponseBuilder builds SAML responses, valid or deliberately broken, to
+// test the error handling of an ACS:
+//
+//	samlResponse, err := samltest.NewResponse(sp).
+//		WithNameID("jdoe").
+//		WithAttribute("mail", "jdoe@example.com").
+//		Expired().
+//		Signed(idpKeyPEM, idpCertPEM).
+//		Encode()
+//
+// Unless Signed is called, the responses are not signed and are rejected by
+// the signature checks of the SP. Signed and Encrypted need xmlsec1.
+//
+// Once the assertion is removed, by WithoutAssertion or WithStatus, the
+// methods setting the response only set the response, and those setting
+// only the assertion make XML and Encode fail.
+type ResponseBuilder struct {
+	Response  *saml.Response
+	Assertion *saml.Assertion
+
+	keyPEM, certPEM string
+	encryptCertPEM  string
+
+	// err is the first misuse of the builder, reported by XML.
+	err error
+}
+
+// NewResponse returns a builder of a successful response to an IdP-initiated
+// login, valid for sp: the Destination, Recipient and Audience are set to
+// its ACS and entity ID, and the Issuer to the entity ID of its IdP, if
+// known.
+func NewResponse(sp *saml.ServiceProvider) *ResponseBuilder {
+	now := saml.Now()
+	issuer := ""
+	if sp.IdPMetadata != nil {
+		issuer = sp.IdPMetadata.EntityID
+	}
+	audience := sp.EntityID
+	if audience == "" {
+		audience = sp.MetadataURL
+	}
+
+	b := &ResponseBuilder{
+		Response: &saml.Response{
+			ID:           saml.NewID(),
+			Destination:  sp.AcsURL,
+			IssueInstant: now,
+			Version:      "2.0",
+			Issuer:       &saml.Issuer{Value: issuer},
+			Status:       &saml.Status{StatusCode: saml.StatusCode{Value: saml.StatusSuccess}},
+		},
+		Assertion: &saml.Assertion{
+			ID:           saml.NewID(),
+			IssueInstant: now,
+			Version:      "2.0",
+			Issuer:       &saml.Issuer{Value: issuer},
+			Subject: &saml.Subject{
+				NameID: &saml.NameID{Format: saml.NameIDFormatTransient, Value: "test-user"},
+				SubjectConfirmations: []saml.SubjectConfirmation{{
+					Method: saml.SubjectConfirmationMethodBearer,
+					SubjectConfirmationData: saml.SubjectConfirmationData{
+						NotOnOrAfter: now.Add(saml.IssueLifetime),
+						Recipient:    sp.AcsURL,
+					},
+				}},
+			},
+			Conditions: &saml.Conditions{
+				NotBefore:            now,
+				NotOnOrAfter:         now.Add(saml.IssueLifetime),
+				AudienceRestrictions: []saml.AudienceRestriction{{Audiences: []saml.Audience{{Value: audience}}}},
+			},
+			AuthnStatement: &saml.AuthnStatement{
+				AuthnInstant: now,
+				SessionIndex: saml.NewID(),
+			},
+			AttributeStatement: &saml.AttributeStatement{},
+		},
+	}
+	return b
+}
+
+// WithIssuer sets the issuer of the response and of the assertion.
+func (b *ResponseBuilder) WithIssuer(entityID string) *ResponseBuilder {
+	b.Response.Issuer = &saml.Issuer{Value: entityID}
+	if b.Assertion != nil {
+		b.Assertion.Issuer = &saml.Issuer{Value: entityID}
+	}
+	return b
+}
+
+// WithDestination sets the Destination of the response.
+func (b *ResponseBuilder) WithDestination(destination string) *ResponseBuilder {
+	b.Response.Destination = destination
+	return b
+}
+
+// WithRecipient sets the Recipient of the subject confirmation.
+func (b *ResponseBuilder) WithRecipient(recipient string) *ResponseBuilder {
+	if b.needAssertion("WithRecipient") {
+		b.confirmationData().Recipient = recipient
+	}
+	return b
+}
+
+// WithAudience sets the audience of the assertion.
+func (b *ResponseBuilder) WithAudience(audience string) *ResponseBuilder {
+	if b.needAssertion("WithAudience") {
+		b.Assertion.Conditions.AudienceRestrictions = []saml.AudienceRestriction{{Audiences: []saml.Audience{{Value: audience}}}}
+	}
+	return b
+}
+
+// WithInResponseTo makes the response answer the AuthnRequest with the given
+// ID.
+func (b *ResponseBuilder) WithInResponseTo(requestID string) *ResponseBuilder {
+	b.Response.InResponseTo = requestID
+	if b.Assertion != nil {
+		b.confirmationData().InResponseTo = requestID
+	}
+	return b
+}
+
+// WithNameID sets the NameID of the subject.
+func (b *ResponseBuilder) WithNameID(nameID string) *ResponseBuilder {
+	if b.needAssertion("WithNameID") {
+		b.Assertion.Subject.NameID.Value = nameID
+	}
+	return b
+}
+
+// WithAttribute adds an attribute. name is a friendly name, such as "mail",
+// or an attribute name; known friendly names are sent with their OID, see
+// saml.AttributeOID.
+func (b *ResponseBuilder) WithAttribute(name string, values ...string) *ResponseBuilder {
+	if !b.needAssertion("WithAttribute") {
+		return b
+	}
+	attr := saml.Attribute{Name: name}
+	if oid := saml.AttributeOID(name); oid != "" {
+		attr = saml.Attribute{
+			FriendlyName: name,
+			Name:         oid,
+			NameFormat:   "urn:oasis:names:tc:SAML:2.0:attrname-format:uri",
+		}
+	}
+	for _, value := range values {
+		attr.Values = append(attr.Values, saml.AttributeValue{Type: "xs:string", Value: value})
+	}
+	statement := b.Assertion.AttributeStatement
+	statement.Attributes = append(statement.Attributes, attr)
+	return b
+}
+
+// WithAttributes adds attributes, see WithAttribute.
+func (b *ResponseBuilder) WithAttributes(attrs map[string][]string) *ResponseBuilder {
+	for name, values := range attrs {
+		b.WithAttribute(name, values...)
+	}
+	return b
+}
+
+// WithStatus sets the status code of the response, e.g.
+// "urn:oasis:names:tc:SAML:2.0:status:Requester", and removes the assertion unless the status is
+// saml.StatusSuccess.
+func (b *ResponseBuilder) WithStatus(code string) *ResponseBuilder {
+	b.Response.Status = &saml.Status{StatusCode: saml.StatusCode{Value: code}}
+	if code != saml.StatusSuccess {
+		b.Assertion = nil
+	}
+	return b
+}
+
+// ValidAt moves the validity window of the assertion so that it starts at
+// the given time.
+func (b *ResponseBuilder) ValidAt(t time.Time) *ResponseBuilder {
+	b.Response.IssueInstant = t
+	if b.Assertion == nil {
+		return b
+	}
+	b.Assertion.IssueInstant = t
+	b.Assertion.Conditions.NotBefore = t
+	b.Assertion.Conditions.NotOnOrAfter = t.Add(saml.IssueLifetime)
+	b.confirmationData().NotOnOrAfter = t.Add(saml.IssueLifetime)
+	b.Assertion.AuthnStatement.AuthnInstant = t
+	return b
+}
+
+// Expired makes the assertion expired.
+func (b *ResponseBuilder) Expired() *ResponseBuilder {
+	return b.ValidAt(saml.Now().Add(-time.Hour))
+}
+
+// NotYetValid makes the assertion valid in the future only.
+func (b *ResponseBuilder) NotYetValid() *ResponseBuilder {
+	return b.ValidAt(saml.Now().Add(time.Hour))
+}
+
+// WithoutAssertion removes the assertion from the response.
+func (b *ResponseBuilder) WithoutAssertion() *ResponseBuilder {
+	b.Assertion = nil
+	return b
+}
+
+// Mutate calls fn with the assertion, for the changes not covered by the
+// other methods.
+func (b *ResponseBuilder) Mutate(fn func(*saml.Assertion)) *ResponseBuilder {
+	if b.needAssertion("Mutate") {
+		fn(b.Assertion)
+	}
+	return b
+}
+
+// Signed signs the assertion with the given PEM-encoded key and
+// certificate.
+func (b *ResponseBuilder) Signed(keyPEM, certPEM string) *ResponseBuilder {
+	b.keyPEM, b.certPEM = keyPEM, certPEM
+	return b
+}
+
+// Encrypted encrypts the assertion for the given PEM-encoded certificate.
+func (b *ResponseBuilder) Encrypted(certPEM string) *ResponseBuilder {
+	b.encryptCertPEM = certPEM
+	return b
+}
+
+// needAssertion reports whether the response has an assertion for method to
+// set, and records the misuse otherwise.
+func (b *ResponseBuilder) needAssertion(method string) bool {
+	if b.Assertion != nil {
+		return true
+	}
+	if b.err == nil {
+		b.err = errors.Errorf("samltest: %s called on a response without assertion", method)
+	}
+	return false
+}
+
+func (b *ResponseBuilder) confirmationData() *saml.SubjectConfirmationData {
+	return &b.Assertion.Subject.SubjectConfirmations[0].SubjectConfirmationData
+}
+
+// XML returns the response document.
+func (b *ResponseBuilder) XML() ([]byte, error) {
+	if b.err != nil {
+		return nil, b.err
+	}
+	res := *b.Response
+	res.Assertion = nil
+	res.EncryptedAssertion = nil
+	buf, err := xml.Marshal(&res)
+	if err != nil {
+		return nil, err
+	}
+	if b.Assertion == nil {
+		return buf, nil
+	}
+
+	assertion, err := b.assertionXML()
+	if err != nil {
+		return nil, err
+	}
+	// The assertion is inserted as is, since marshaling it again would
+	// break its signature.
+	end := bytes.LastIndex(buf, []byte("</Response>"))
+	return append(buf[:end:end], append(assertion, buf[end:]...)...), nil
+}
+
+// Encode returns the base64-encoded response, as posted to the ACS.
+func (b *ResponseBuilder) Encode() (string, error) {
+	buf, err := b.XML()
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(buf), nil
+}
+
+// assertionXML returns the assertion, signed and encrypted as requested.
+func (b *ResponseBuilder) assertionXML() ([]byte, error) {
+	assertion := *b.Assertion
+	if b.keyPEM != "" {
+		signature := xmlsec.DefaultSignature([]byte(b.certPEM))
+		assertion.Signature = &signature
+	}
+	buf, err := xml.Marshal(&assertion)
+	if err != nil {
+		return nil, err
+	}
+
+	if b.keyPEM != "" {
+		keyFile, err := writeTemp(b.keyPEM)
+		if err != nil {
+			return nil, err
+		}
+		defer os.Remove(keyFile)
+		buf, err = xmlsec.Sign(buf, keyFile, &xmlsec.ValidationOptions{EnableIDAttrHack: true})
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to sign assertion")
+		}
+		buf = bytes.TrimSpace(bytes.TrimPrefix(buf, []byte(`<?xml version="1.0"?>`)))
+	}
+
+	if b.encryptCertPEM != "" {
+		certFile, err := writeTemp(b.encryptCertPEM)
+		if err != nil {
+			return nil, err
+		}
+		defer os.Remove(certFile)
+		tpl := xmlsec.NewEncryptedDataTemplate(
+			"http://www.w3.org/2001/04/xmlenc#aes128-cbc",
+			"http://www.w3.org/2001/04/xmlenc#rsa-oaep-mgf1p",
+		)
+		buf, err = xmlsec.Encrypt(tpl, buf, certFile, "aes-128-cbc")
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to encrypt assertion")
+		}
+		buf = bytes.TrimSpace(bytes.TrimPrefix(buf, []byte(`<?xml version="1.0"?>`)))
+		buf = append(append([]byte(`<EncryptedAssertion xmlns="urn:oasis:names:tc:SAML:2.0:assertion">`), buf...), "</EncryptedAssertion>"...)
+	}
+	return buf, nil
+}
+
+// writeTemp writes a PEM block to a temporary file for xmlsec1.
+func writeTemp(data string) (string, error) {
+	f, err := ioutil.TempFile("", "samltest")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	if _, err := f.WriteString(data); err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
@@ -0,0 +1,118 @@
+package samltest
+
+import (
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"os/exec"
+	"testing"
+
+	"github.com/goware/saml"
+	"github.com/stretchr/testify/assert"
+)
+
+func newBuilderTestSP() *saml.ServiceProvider {
+	return &saml.ServiceProvider{
+		MetadataURL: "http://sp.example.com/saml/metadata",
+		AcsURL:      "http://sp.example.com/saml/acs",
+		IdPMetadata: &saml.Metadata{EntityID: "http://idp.example.com/metadata"},
+	}
+}
+
+// failed returns whether a validation result has a failure of the given kind.
+func failed(result *saml.ValidationResult, kind error) bool {
+	for _, failure := range result.Failures {
+		if errors.Is(failure.Err, kind) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestResponseBuilder(t *testing.T) {
+	sp := newBuilderTestSP()
+
+	buf, err := NewResponse(sp).
+		WithNameID("jdoe").
+		WithAttribute("mail", "jdoe@example.com").
+		WithAttribute("custom", "a", "b").
+		XML()
+	assert.NoError(t, err)
+
+	var res saml.Response
+	assert.NoError(t, xml.Unmarshal(buf, &res))
+	assert.Equal(t, sp.AcsURL, res.Destination)
+	assert.Equal(t, "http://idp.example.com/metadata", res.Issuer.Value)
+	if assert.NotNil(t, res.Assertion) {
+		assert.Equal(t, "jdoe", res.Assertion.Subject.NameID.Value)
+		assert.Equal(t, sp.MetadataURL, res.Assertion.Conditions.AudienceRestrictions[0].Audiences[0].Value)
+		attrs := res.Assertion.AttributeStatement.Attributes
+		if assert.Len(t, attrs, 2) {
+			assert.Equal(t, "urn:oid:0.9.2342.19200300.100.1.3", attrs[0].Name)
+			assert.Equal(t, "mail", attrs[0].FriendlyName)
+			assert.Len(t, attrs[1].Values, 2)
+		}
+	}
+
+	// The checks that come before the signature pass.
+	samlResponse, err := NewResponse(sp).Encode()
+	assert.NoError(t, err)
+	result := sp.ValidateResponse(samlResponse, []string{""}, saml.Now())
+	assert.Subset(t, result.Passed, []string{"decode", "destination", "issuer", "status"}, result.String())
+}
+
+func TestResponseBuilderBroken(t *testing.T) {
+	sp := newBuilderTestSP()
+
+	for name, test := range map[string]struct {
+		b    *ResponseBuilder
+		kind error
+	}{
+		"destination": {NewResponse(sp).WithDestination("http://evil.example.com/acs"), saml.ErrWrongDestination},
+		"issuer":      {NewResponse(sp).WithIssuer("http://evil.example.com"), saml.ErrIssuerMismatch},
+		"status":      {NewResponse(sp).WithStatus("urn:oasis:names:tc:SAML:2.0:status:Requester"), saml.ErrStatusNotSuccess},
+	} {
+		samlResponse, err := test.b.Encode()
+		assert.NoError(t, err, name)
+		result := sp.ValidateResponse(samlResponse, []string{""}, saml.Now())
+		assert.True(t, failed(result, test.kind), "%s:\n%s", name, result)
+	}
+
+	// The other defects are caught after the signature verification.
+	now := saml.Now()
+	b := NewResponse(sp).Expired()
+	assert.True(t, b.Assertion.Conditions.NotOnOrAfter.Before(now))
+	b = NewResponse(sp).NotYetValid()
+	assert.True(t, b.Assertion.Conditions.NotBefore.After(now))
+	b = NewResponse(sp).WithoutAssertion()
+	assert.Nil(t, b.Assertion)
+
+	// The response is still set without assertion, but not the assertion.
+	b = NewResponse(sp).WithoutAssertion().WithIssuer("http://evil.example.com").WithInResponseTo("_r1").Expired()
+	_, err := b.XML()
+	assert.NoError(t, err)
+	assert.Equal(t, "_r1", b.Response.InResponseTo)
+	_, err = NewResponse(sp).WithStatus("urn:oasis:names:tc:SAML:2.0:status:Requester").WithNameID("jdoe").Encode()
+	assert.Error(t, err)
+	_, err = NewResponse(sp).WithoutAssertion().Mutate(func(*saml.Assertion) {}).XML()
+	assert.Error(t, err)
+
+	b = NewResponse(sp).WithInResponseTo("_r1")
+	assert.Equal(t, "_r1", b.Response.InResponseTo)
+	assert.Equal(t, "_r1", b.Assertion.Subject.SubjectConfirmations[0].SubjectConfirmationData.InResponseTo)
+}
+
+func TestResponseBuilderSigned(t *testing.T) {
+	if _, err := exec.LookPath("xmlsec1"); err != nil {
+		t.Skip("xmlsec1 is not installed")
+	}
+
+	keyPEM, certPEM, err := saml.GenerateKeyPair(saml.KeyPairOptions{CommonName: "idp"})
+	assert.NoError(t, err)
+
+	samlResponse, err := NewResponse(newBuilderTestSP()).Signed(keyPEM, certPEM).Encode()
+	assert.NoError(t, err)
+	buf, err := base64.StdEncoding.DecodeString(samlResponse)
+	assert.NoError(t, err)
+	assert.Contains(t, string(buf), "SignatureValue")
+}
@@ -0,0 +1,141 @@
+// Package samltest provides an in-process IdP to write end-to-end tests of
+// SAML service providers.
+//
+//	func TestLogin(t *testing.T) {
+//		sp := &saml.ServiceProvider{MetadataURL: ..., AcsURL: app.URL + "/saml/acs"}
+//		idp := samltest.NewIdP(t, sp)
+//		idp.User.NameID = "jdoe"
+//
+//		res, err := idp.Login(client, app.URL+"/saml/login")
+//		...
+//	}
+//
+// The responses are built by saml.IdentityProvider, so the assertions are
+// signed and encrypted for the SP, and the xmlsec1 binary is needed.
+package samltest
+
+import (
+	"html"
+	"io/ioutil"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"testing"
+
+	"github.com/goware/saml"
+	"github.com/pkg/errors"
+)
+
+// IdP is a SAML identity provider served by an httptest.Server. It
+// authenticates every AuthnRequest as User.
+type IdP struct {
+	IdentityProvider *saml.IdentityProvider
+	Server           *httptest.Server
+
+	// User is the session of the authenticated user.
+	User saml.Session
+}
+
+// NewIdP starts an IdP trusted by sp: the SP gets the IdP metadata and the
+// IdP gets the SP metadata. The SP and IdP keys are generated when missing.
+// The server is closed at the end of the test.
+func NewIdP(t testing.TB, sp *saml.ServiceProvider) *IdP {
+	t.Helper()
+
+	if sp.PrivkeyPEM == "" && sp.KeyFile == "" {
+		keyPEM, certPEM, err := saml.GenerateKeyPair(saml.KeyPairOptions{CommonName: "sp"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		sp.PrivkeyPEM, sp.PubkeyPEM = keyPEM, certPEM
+	}
+	keyPEM, certPEM, err := saml.GenerateKeyPair(saml.KeyPairOptions{CommonName: "idp"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	idp := &IdP{
+		IdentityProvider: &saml.IdentityProvider{
+			PrivkeyPEM: keyPEM,
+			PubkeyPEM:  certPEM,
+		},
+		User: saml.Session{
+			ID:     saml.NewID(),
+			Index:  saml.NewID(),
+			NameID: "test-user",
+		},
+	}
+
+	mux := http.NewServeMux()
+	idp.Server = httptest.NewServer(mux)
+	t.Cleanup(idp.Server.Close)
+
+	idp.IdentityProvider.MetadataURL = idp.Server.URL + "/metadata"
+	idp.IdentityProvider.SSOURL = idp.Server.URL + "/sso"
+	mux.HandleFunc("/metadata", idp.IdentityProvider.MetadataHandler)
+	mux.HandleFunc("/sso", idp.IdentityProvider.ServeSSO(idp.authenticate))
+
+	spMetadata, err := sp.Metadata()
+	if err != nil {
+		t.Fatal(err)
+	}
+	idp.IdentityProvider.SPMetadata = spMetadata
+
+	idpMetadata, err := idp.IdentityProvider.Metadata()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sp.IdPMetadata = idpMetadata
+
+	return idp
+}
+
+func (idp *IdP) authenticate(w http.ResponseWriter, r *http.Request) (*saml.Session, error) {
+	s := idp.User
+	s.CreateTime = saml.Now()
+	return &s, nil
+}
+
+var formInput = regexp.MustCompile(`name="(RelayState|SAMLResponse)" value="([^"]*)"`)
+var formAction = regexp.MustCompile(`<form [^>]*action="([^"]*)"`)
+
+// Login sends the user agent to loginURL, the SP URL sending it to the IdP,
+// such as one served by saml.ServiceProvider.AuthnRequestHandler, and posts
+// the response of the IdP to the SP, like the browser would. It returns the
+// response of the SP. The cookie tracking the AuthnRequest is kept in the
+// jar of client, or of a copy of client with a new jar if it has none.
+func (idp *IdP) Login(client *http.Client, loginURL string) (*http.Response, error) {
+	if client.Jar == nil {
+		jar, err := cookiejar.New(nil)
+		if err != nil {
+			return nil, err
+		}
+		c := *client
+		c.Jar = jar
+		client = &c
+	}
+	res, err := client.Get(loginURL)
+	if err != nil {
+		return nil, err
+	}
+	body, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("IdP returned %s: %s", res.Status, body)
+	}
+
+	action := formAction.FindSubmatch(body)
+	if action == nil {
+		return nil, errors.New("no form in the IdP response")
+	}
+	values := url.Values{}
+	for _, m := range formInput.FindAllSubmatch(body, -1) {
+		values.Set(string(m[1]), html.UnescapeString(string(m[2])))
+	}
+	return client.PostForm(html.UnescapeString(string(action[1])), values)
+}
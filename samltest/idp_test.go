@@ -0,0 +1,69 @@
+package samltest
+
+import (
+	"encoding/xml"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os/exec"
+	"testing"
+
+	"github.com/goware/saml"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewIdP(t *testing.T) {
+	sp := &saml.ServiceProvider{
+		MetadataURL: "http://sp.example.com/saml/metadata",
+		AcsURL:      "http://sp.example.com/saml/acs",
+	}
+	idp := NewIdP(t, sp)
+
+	assert.NotEmpty(t, sp.PrivkeyPEM)
+	if assert.NotNil(t, sp.IdPMetadata) {
+		assert.Equal(t, idp.Server.URL+"/metadata", sp.IdPMetadata.EntityID)
+	}
+	ssoURL, err := sp.GetIdPAuthResource()
+	assert.NoError(t, err)
+	assert.Equal(t, idp.Server.URL+"/sso", ssoURL)
+
+	res, err := http.Get(idp.Server.URL + "/metadata")
+	assert.NoError(t, err)
+	defer res.Body.Close()
+	buf, err := ioutil.ReadAll(res.Body)
+	assert.NoError(t, err)
+	var metadata saml.Metadata
+	assert.NoError(t, xml.Unmarshal(buf, &metadata))
+	assert.Equal(t, idp.Server.URL+"/metadata", metadata.EntityID)
+}
+
+func TestLogin(t *testing.T) {
+	if _, err := exec.LookPath("xmlsec1"); err != nil {
+		t.Skip("xmlsec1 is not installed")
+	}
+
+	var assertion *saml.Assertion
+	mux := http.NewServeMux()
+	app := httptest.NewServer(mux)
+	defer app.Close()
+
+	sp := &saml.ServiceProvider{
+		MetadataURL: app.URL + "/saml/metadata",
+		AcsURL:      app.URL + "/saml/acs",
+	}
+	mux.HandleFunc("/saml/login", sp.AuthnRequestHandler)
+	mux.Handle("/saml/acs", sp.AssertionMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assertion = saml.GetAssertionFromCtx(r.Context())
+	})))
+
+	idp := NewIdP(t, sp)
+	idp.User.NameID = "jdoe"
+
+	res, err := idp.Login(http.DefaultClient, app.URL+"/saml/login")
+	assert.NoError(t, err)
+	res.Body.Close()
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	if assert.NotNil(t, assertion) {
+		assert.Equal(t, "jdoe", assertion.Subject.NameID.Value)
+	}
+}
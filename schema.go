@@ -26,6 +26,7 @@ package saml
 
 import (
 	"encoding/xml"
+	"strings"
 	"time"
 
 	"github.com/goware/saml/xmlsec"
@@ -36,18 +37,89 @@ import (
 //
 // See http://docs.oasis-open.org/security/saml/v2.0/saml-core-2.0-os.pdf
 type AuthnRequest struct {
-	XMLName                     xml.Name          `xml:"urn:oasis:names:tc:SAML:2.0:protocol AuthnRequest"`
-	AssertionConsumerServiceURL string            `xml:",attr"`
-	Destination                 string            `xml:",attr"`
-	ID                          string            `xml:",attr"`
-	IssueInstant                time.Time         `xml:",attr"`
-	ProtocolBinding             string            `xml:",attr"`
-	Version                     string            `xml:",attr"`
-	Issuer                      Issuer            `xml:"urn:oasis:names:tc:SAML:2.0:assertion Issuer"`
-	Signature                   *xmlsec.Signature `xml:"http://www.w3.org/2000/09/xmldsig# Signature"`
-	NameIDPolicy                NameIDPolicy      `xml:"urn:oasis:names:tc:SAML:2.0:protocol NameIDPolicy"`
+	XMLName                       xml.Name          `xml:"urn:oasis:names:tc:SAML:2.0:protocol AuthnRequest"`
+	AssertionConsumerServiceURL   string            `xml:",attr,omitempty"`
+	AssertionConsumerServiceIndex *int              `xml:",attr,omitempty"`
+	Destination                   string            `xml:",attr"`
+	ID                            string            `xml:",attr"`
+	IssueInstant                  time.Time         `xml:",attr"`
+	ProtocolBinding               string            `xml:",attr,omitempty"`
+	Version                       string            `xml:",attr"`
+	ProviderName                  string            `xml:",attr,omitempty"`
+	Consent                       string            `xml:",attr,omitempty"`
+	ForceAuthn                    bool              `xml:",attr,omitempty"`
+	IsPassive                     bool              `xml:",attr,omitempty"`
+	Issuer                        Issuer            `xml:"urn:oasis:names:tc:SAML:2.0:assertion Issuer"`
+	Signature                     *xmlsec.Signature `xml:"http://www.w3.org/2000/09/xmldsig# Signature"`
+	Subject                       *Subject          `xml:"urn:oasis:names:tc:SAML:2.0:assertion Subject,omitempty"`
+	NameIDPolicy                  NameIDPolicy      `xml:"urn:oasis:names:tc:SAML:2.0:protocol NameIDPolicy"`
+	RequestedAuthnContext         *RequestedAuthnContext
 }
 
+// RequestedAuthnContext represents the SAML object of the same name: the
+// authentication context classes the IdP is asked to authenticate the user
+// with. See the AuthnContext* constants.
+//
+// See http://docs.oasis-open.org/security/saml/v2.0/saml-core-2.0-os.pdf section 3.3.2.2.1
+type RequestedAuthnContext struct {
+	XMLName               xml.Name `xml:"urn:oasis:names:tc:SAML:2.0:protocol RequestedAuthnContext"`
+	Comparison            string   `xml:",attr,omitempty"`
+	AuthnContextClassRefs []string `xml:"urn:oasis:names:tc:SAML:2.0:assertion AuthnContextClassRef"`
+}
+
+// MarshalXML implements xml.Marshaler. The request is written with the
+// samlp: and saml: prefixes, as expected by some IdPs.
+func (req AuthnRequest) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	type authnRequest AuthnRequest
+	buf, err := xml.Marshal(authnRequest(req))
+	if err != nil {
+		return err
+	}
+	return encodePrefixed(e, buf)
+}
+
+// LogoutRequest represents the SAML object of the same name.
+//
+// See http://docs.oasis-open.org/security/saml/v2.0/saml-core-2.0-os.pdf section 3.7.1
+type LogoutRequest struct {
+	XMLName      xml.Name          `xml:"urn:oasis:names:tc:SAML:2.0:protocol LogoutRequest"`
+	ID           string            `xml:",attr"`
+	Version      string            `xml:",attr"`
+	IssueInstant time.Time         `xml:",attr"`
+	Destination  string            `xml:",attr,omitempty"`
+	NotOnOrAfter *time.Time        `xml:",attr,omitempty"`
+	Reason       string            `xml:",attr,omitempty"`
+	Issuer       Issuer            `xml:"urn:oasis:names:tc:SAML:2.0:assertion Issuer"`
+	Signature    *xmlsec.Signature `xml:"http://www.w3.org/2000/09/xmldsig# Signature"`
+	NameID       *NameID           `xml:"urn:oasis:names:tc:SAML:2.0:assertion NameID"`
+	EncryptedID  *EncryptedID      `xml:"urn:oasis:names:tc:SAML:2.0:assertion EncryptedID"`
+	SessionIndex []string          `xml:"urn:oasis:names:tc:SAML:2.0:protocol SessionIndex"`
+}
+
+// MarshalXML implements xml.Marshaler. The request is written with the
+// samlp: and saml: prefixes, as AuthnRequest.
+func (req LogoutRequest) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	type logoutRequest LogoutRequest
+	buf, err := xml.Marshal(logoutRequest(req))
+	if err != nil {
+		return err
+	}
+	return encodePrefixed(e, buf)
+}
+
+// Consent values that can be set on the Consent attribute of a request.
+//
+// See http://docs.oasis-open.org/security/saml/v2.0/saml-core-2.0-os.pdf section 8.4
+const (
+	ConsentUnspecified  = "urn:oasis:names:tc:SAML:2.0:consent:unspecified"
+	ConsentObtained     = "urn:oasis:names:tc:SAML:2.0:consent:obtained"
+	ConsentPrior        = "urn:oasis:names:tc:SAML:2.0:consent:prior"
+	ConsentImplicit     = "urn:oasis:names:tc:SAML:2.0:consent:current-implicit"
+	ConsentExplicit     = "urn:oasis:names:tc:SAML:2.0:consent:current-explicit"
+	ConsentUnavailable  = "urn:oasis:names:tc:SAML:2.0:consent:unavailable"
+	ConsentInapplicable = "urn:oasis:names:tc:SAML:2.0:consent:inapplicable"
+)
+
 // Issuer represents the SAML object of the same name.
 //
 // See http://docs.oasis-open.org/security/saml/v2.0/saml-core-2.0-os.pdf
@@ -61,11 +133,27 @@ type Issuer struct {
 //
 // See http://docs.oasis-open.org/security/saml/v2.0/saml-core-2.0-os.pdf
 type NameIDPolicy struct {
-	XMLName     xml.Name `xml:"urn:oasis:names:tc:SAML:2.0:protocol NameIDPolicy"`
-	AllowCreate bool     `xml:",attr"`
-	Format      string   `xml:",chardata"`
+	XMLName xml.Name `xml:"urn:oasis:names:tc:SAML:2.0:protocol NameIDPolicy"`
+
+	// AllowCreate allows the IdP to create a new identifier for the user.
+	// Unlike the default policy of the SP, the zero value doesn't.
+	AllowCreate bool `xml:",attr"`
+
+	Format          string `xml:",attr,omitempty"`
+	SPNameQualifier string `xml:",attr,omitempty"`
 }
 
+// NameID formats defined by the spec.
+//
+// See http://docs.oasis-open.org/security/saml/v2.0/saml-core-2.0-os.pdf section 8.3
+const (
+	NameIDFormatUnspecified  = "urn:oasis:names:tc:SAML:1.1:nameid-format:unspecified"
+	NameIDFormatEmailAddress = "urn:oasis:names:tc:SAML:1.1:nameid-format:emailAddress"
+	NameIDFormatPersistent   = "urn:oasis:names:tc:SAML:2.0:nameid-format:persistent"
+	NameIDFormatTransient    = "urn:oasis:names:tc:SAML:2.0:nameid-format:transient"
+	NameIDFormatEntity       = "urn:oasis:names:tc:SAML:2.0:nameid-format:entity"
+)
+
 // Response represents the SAML object of the same name.
 //
 // See http://docs.oasis-open.org/security/saml/v2.0/saml-core-2.0-os.pdf
@@ -87,22 +175,79 @@ type Response struct {
 //
 // See http://docs.oasis-open.org/security/saml/v2.0/saml-core-2.0-os.pdf
 type Status struct {
-	XMLName    xml.Name `xml:"urn:oasis:names:tc:SAML:2.0:protocol Status"`
-	StatusCode StatusCode
+	XMLName       xml.Name `xml:"urn:oasis:names:tc:SAML:2.0:protocol Status"`
+	StatusCode    StatusCode
+	StatusMessage string        `xml:"urn:oasis:names:tc:SAML:2.0:protocol StatusMessage,omitempty"`
+	StatusDetail  *StatusDetail `xml:"urn:oasis:names:tc:SAML:2.0:protocol StatusDetail"`
 }
 
-// StatusCode represents the SAML object of the same name.
+// StatusCode represents the SAML object of the same name. A non-success
+// top-level code may be refined by a nested StatusCode.
 //
 // See http://docs.oasis-open.org/security/saml/v2.0/saml-core-2.0-os.pdf
 type StatusCode struct {
-	XMLName xml.Name `xml:"urn:oasis:names:tc:SAML:2.0:protocol StatusCode"`
-	Value   string   `xml:",attr"`
+	XMLName    xml.Name `xml:"urn:oasis:names:tc:SAML:2.0:protocol StatusCode"`
+	Value      string   `xml:",attr"`
+	StatusCode *StatusCode
+}
+
+// StatusDetail represents the SAML object of the same name, whose content is
+// not defined by the specification.
+//
+// See http://docs.oasis-open.org/security/saml/v2.0/saml-core-2.0-os.pdf
+type StatusDetail struct {
+	XMLName xml.Name `xml:"urn:oasis:names:tc:SAML:2.0:protocol StatusDetail"`
+	Content []byte   `xml:",innerxml"`
 }
 
 // StatusSuccess is the value of a StatusCode element when the authentication succeeds.
 // (nominally a constant, except for testing)
 var StatusSuccess = "urn:oasis:names:tc:SAML:2.0:status:Success"
 
+// Top-level status codes of failed requests.
+//
+// See http://docs.oasis-open.org/security/saml/v2.0/saml-core-2.0-os.pdf section 3.2.2.2
+const (
+	StatusRequester       = "urn:oasis:names:tc:SAML:2.0:status:Requester"
+	StatusResponder       = "urn:oasis:names:tc:SAML:2.0:status:Responder"
+	StatusVersionMismatch = "urn:oasis:names:tc:SAML:2.0:status:VersionMismatch"
+)
+
+// Second-level status codes, found in the StatusCode nested in a top-level
+// one.
+//
+// See http://docs.oasis-open.org/security/saml/v2.0/saml-core-2.0-os.pdf section 3.2.2.2
+const (
+	StatusAuthnFailed              = "urn:oasis:names:tc:SAML:2.0:status:AuthnFailed"
+	StatusInvalidAttrNameOrValue   = "urn:oasis:names:tc:SAML:2.0:status:InvalidAttrNameOrValue"
+	StatusInvalidNameIDPolicy      = "urn:oasis:names:tc:SAML:2.0:status:InvalidNameIDPolicy"
+	StatusNoAuthnContext           = "urn:oasis:names:tc:SAML:2.0:status:NoAuthnContext"
+	StatusNoAvailableIDP           = "urn:oasis:names:tc:SAML:2.0:status:NoAvailableIDP"
+	StatusNoPassive                = "urn:oasis:names:tc:SAML:2.0:status:NoPassive"
+	StatusNoSupportedIDP           = "urn:oasis:names:tc:SAML:2.0:status:NoSupportedIDP"
+	StatusPartialLogout            = "urn:oasis:names:tc:SAML:2.0:status:PartialLogout"
+	StatusProxyCountExceeded       = "urn:oasis:names:tc:SAML:2.0:status:ProxyCountExceeded"
+	StatusRequestDenied            = "urn:oasis:names:tc:SAML:2.0:status:RequestDenied"
+	StatusRequestUnsupported       = "urn:oasis:names:tc:SAML:2.0:status:RequestUnsupported"
+	StatusRequestVersionDeprecated = "urn:oasis:names:tc:SAML:2.0:status:RequestVersionDeprecated"
+	StatusRequestVersionTooHigh    = "urn:oasis:names:tc:SAML:2.0:status:RequestVersionTooHigh"
+	StatusRequestVersionTooLow     = "urn:oasis:names:tc:SAML:2.0:status:RequestVersionTooLow"
+	StatusResourceNotRecognized    = "urn:oasis:names:tc:SAML:2.0:status:ResourceNotRecognized"
+	StatusTooManyResponses         = "urn:oasis:names:tc:SAML:2.0:status:TooManyResponses"
+	StatusUnknownAttrProfile       = "urn:oasis:names:tc:SAML:2.0:status:UnknownAttrProfile"
+	StatusUnknownPrincipal         = "urn:oasis:names:tc:SAML:2.0:status:UnknownPrincipal"
+	StatusUnsupportedBinding       = "urn:oasis:names:tc:SAML:2.0:status:UnsupportedBinding"
+)
+
+// subCode returns the value of the StatusCode nested in the top-level one,
+// if any.
+func (s *Status) subCode() string {
+	if s.StatusCode.StatusCode == nil {
+		return ""
+	}
+	return s.StatusCode.StatusCode.Value
+}
+
 // EncryptedAssertion represents the SAML object of the same name.
 //
 // See http://docs.oasis-open.org/security/saml/v2.0/saml-core-2.0-os.pdf
@@ -131,21 +276,70 @@ type Assertion struct {
 //
 // See http://docs.oasis-open.org/security/saml/v2.0/saml-core-2.0-os.pdf
 type Subject struct {
-	XMLName             xml.Name `xml:"urn:oasis:names:tc:SAML:2.0:assertion Subject"`
-	NameID              *NameID
-	SubjectConfirmation *SubjectConfirmation
+	XMLName              xml.Name `xml:"urn:oasis:names:tc:SAML:2.0:assertion Subject"`
+	NameID               *NameID
+	EncryptedID          *EncryptedID          `xml:"urn:oasis:names:tc:SAML:2.0:assertion EncryptedID"`
+	SubjectConfirmations []SubjectConfirmation `xml:"SubjectConfirmation"`
+
+	// SubjectConfirmation is the first of SubjectConfirmations when the
+	// subject is unmarshaled. It is marshaled when SubjectConfirmations is
+	// empty.
+	//
+	// Deprecated: a subject may have several confirmations, use
+	// SubjectConfirmations.
+	SubjectConfirmation *SubjectConfirmation `xml:"-"`
+}
+
+// UnmarshalXML implements xml.Unmarshaler, setting the deprecated
+// SubjectConfirmation field.
+func (s *Subject) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	type subject Subject
+	if err := d.DecodeElement((*subject)(s), &start); err != nil {
+		return err
+	}
+	s.SubjectConfirmation = nil
+	if len(s.SubjectConfirmations) > 0 {
+		s.SubjectConfirmation = &s.SubjectConfirmations[0]
+	}
+	return nil
 }
 
-// NameID represents the SAML object of the same name.
+// MarshalXML implements xml.Marshaler, writing the deprecated
+// SubjectConfirmation field when SubjectConfirmations is empty.
+func (s Subject) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	type subject Subject
+	if len(s.SubjectConfirmations) == 0 && s.SubjectConfirmation != nil {
+		s.SubjectConfirmations = []SubjectConfirmation{*s.SubjectConfirmation}
+	}
+	start.Name = xml.Name{Space: "urn:oasis:names:tc:SAML:2.0:assertion", Local: "Subject"}
+	return e.EncodeElement(subject(s), start)
+}
+
+// NameID represents the SAML object of the same name. Persistent
+// identifiers are only meaningful with their qualifiers, which must be kept
+// along with the value.
 //
 // See http://docs.oasis-open.org/security/saml/v2.0/saml-core-2.0-os.pdf
 type NameID struct {
 	Format          string `xml:",attr"`
-	NameQualifier   string `xml:",attr"`
-	SPNameQualifier string `xml:",attr"`
+	NameQualifier   string `xml:",attr,omitempty"`
+	SPNameQualifier string `xml:",attr,omitempty"`
+	SPProvidedID    string `xml:",attr,omitempty"`
 	Value           string `xml:",chardata"`
 }
 
+// EncryptedID represents the SAML object of the same name: an encrypted
+// NameID, see ServiceProvider.DecryptNameID.
+//
+// See http://docs.oasis-open.org/security/saml/v2.0/saml-core-2.0-os.pdf section 2.2.4
+type EncryptedID struct {
+	EncryptedData []byte `xml:",innerxml"`
+}
+
+// SubjectConfirmationMethodBearer is the method of the subject confirmations
+// used by the Web Browser SSO profile.
+const SubjectConfirmationMethodBearer = "urn:oasis:names:tc:SAML:2.0:cm:bearer"
+
 // SubjectConfirmation represents the SAML object of the same name.
 //
 // See http://docs.oasis-open.org/security/saml/v2.0/saml-core-2.0-os.pdf
@@ -158,26 +352,66 @@ type SubjectConfirmation struct {
 //
 // See http://docs.oasis-open.org/security/saml/v2.0/saml-core-2.0-os.pdf
 type SubjectConfirmationData struct {
-	Address      string    `xml:",attr"`
-	InResponseTo string    `xml:",attr"`
-	NotOnOrAfter time.Time `xml:",attr"`
-	Recipient    string    `xml:",attr"`
+	Address      string     `xml:",attr"`
+	InResponseTo string     `xml:",attr"`
+	NotBefore    *time.Time `xml:",attr,omitempty"`
+	NotOnOrAfter time.Time  `xml:",attr"`
+	Recipient    string     `xml:",attr"`
+
+	// KeyInfos holds the keys of the holder-of-key confirmations.
+	KeyInfos []KeyInfo `xml:"http://www.w3.org/2000/09/xmldsig# KeyInfo"`
 }
 
 // Conditions represents the SAML object of the same name.
 //
 // See http://docs.oasis-open.org/security/saml/v2.0/saml-core-2.0-os.pdf
 type Conditions struct {
-	NotBefore           time.Time `xml:",attr"`
-	NotOnOrAfter        time.Time `xml:",attr"`
-	AudienceRestriction *AudienceRestriction
+	NotBefore    time.Time `xml:",attr"`
+	NotOnOrAfter time.Time `xml:",attr"`
+
+	// AudienceRestrictions must all be satisfied: the assertion is
+	// addressed to the audiences listed by each of them.
+	AudienceRestrictions []AudienceRestriction `xml:"AudienceRestriction"`
+
+	ProxyRestriction *ProxyRestriction
+
+	// Extensions holds the conditions that are not modeled above, e.g.
+	// Shibboleth's Delegation condition.
+	Extensions []ConditionExtension `xml:",any"`
+}
+
+// ConditionExtension is a condition element kept as raw XML.
+type ConditionExtension struct {
+	XMLName  xml.Name
+	Attrs    []xml.Attr `xml:",any,attr"`
+	InnerXML string     `xml:",innerxml"`
 }
 
 // AudienceRestriction represents the SAML object of the same name.
 //
 // See http://docs.oasis-open.org/security/saml/v2.0/saml-core-2.0-os.pdf
 type AudienceRestriction struct {
-	Audience *Audience
+	Audiences []Audience `xml:"Audience"`
+}
+
+// includes returns whether audience is among the audiences of ar.
+func (ar *AudienceRestriction) includes(audience string) bool {
+	for _, a := range ar.Audiences {
+		if a.Value == audience {
+			return true
+		}
+	}
+	return false
+}
+
+// ProxyRestriction represents the SAML object of the same name. It limits the
+// number of indirections through which the assertion may be re-asserted, and
+// the audiences of the new assertions.
+//
+// See http://docs.oasis-open.org/security/saml/v2.0/saml-core-2.0-os.pdf
+type ProxyRestriction struct {
+	Count     *int       `xml:",attr,omitempty"`
+	Audiences []Audience `xml:"Audience"`
 }
 
 // Audience represents the SAML object of the same name.
@@ -187,28 +421,56 @@ type Audience struct {
 	Value string `xml:",chardata"`
 }
 
-// AuthnStatement represents the SAML object of the same name.
+// AuthnStatement represents the SAML object of the same name: how and when
+// the subject was authenticated by the IdP.
 //
-// See http://docs.oasis-open.org/security/saml/v2.0/saml-core-2.0-os.pdf
+// See http://docs.oasis-open.org/security/saml/v2.0/saml-core-2.0-os.pdf section 2.7.2
 type AuthnStatement struct {
-	AuthnInstant    time.Time `xml:",attr"`
-	SessionIndex    string    `xml:",attr"`
-	SubjectLocality SubjectLocality
-	AuthnContext    AuthnContext
+	AuthnInstant        time.Time  `xml:",attr"`
+	SessionIndex        string     `xml:",attr"`
+	SessionNotOnOrAfter *time.Time `xml:",attr,omitempty"`
+	SubjectLocality     SubjectLocality
+	AuthnContext        AuthnContext
 }
 
-// SubjectLocality represents the SAML object of the same name.
+// SubjectLocality represents the SAML object of the same name: the address
+// of the authenticated user agent, as seen by the IdP. It is the zero value
+// when the AuthnStatement has none, and is then omitted.
 //
-// See http://docs.oasis-open.org/security/saml/v2.0/saml-core-2.0-os.pdf
+// See http://docs.oasis-open.org/security/saml/v2.0/saml-core-2.0-os.pdf section 2.7.2.1
 type SubjectLocality struct {
-	Address string `xml:",attr"`
+	Address string `xml:",attr,omitempty"`
+	DNSName string `xml:",attr,omitempty"`
+}
+
+// MarshalXML implements xml.Marshaler, omitting the empty SubjectLocality.
+func (l SubjectLocality) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	if l == (SubjectLocality{}) {
+		return nil
+	}
+	type subjectLocality SubjectLocality
+	return e.EncodeElement(subjectLocality(l), start)
 }
 
-// AuthnContext represents the SAML object of the same name.
+// AuthnContext represents the SAML object of the same name. It holds a
+// class reference, a declaration or a declaration reference, and the
+// authorities involved in the authentication.
 //
-// See http://docs.oasis-open.org/security/saml/v2.0/saml-core-2.0-os.pdf
+// See http://docs.oasis-open.org/security/saml/v2.0/saml-core-2.0-os.pdf section 2.7.2.2
 type AuthnContext struct {
-	AuthnContextClassRef *AuthnContextClassRef
+	AuthnContextClassRef    *AuthnContextClassRef
+	AuthnContextDecl        *AuthnContextDecl
+	AuthnContextDeclRef     string   `xml:",omitempty"`
+	AuthenticatingAuthority []string `xml:"AuthenticatingAuthority"`
+}
+
+// ClassRef returns the authentication context class of the statement, or
+// an empty string.
+func (c AuthnContext) ClassRef() string {
+	if c.AuthnContextClassRef == nil {
+		return ""
+	}
+	return strings.TrimSpace(c.AuthnContextClassRef.Value)
 }
 
 // AuthnContextClassRef represents the SAML object of the same name.
@@ -218,13 +480,43 @@ type AuthnContextClassRef struct {
 	Value string `xml:",chardata"`
 }
 
+// AuthnContextDecl represents the SAML object of the same name, whose
+// content is an authentication context declaration schema instance, kept
+// as raw XML.
+//
+// See http://docs.oasis-open.org/security/saml/v2.0/saml-core-2.0-os.pdf
+type AuthnContextDecl struct {
+	Content []byte `xml:",innerxml"`
+}
+
 // AttributeStatement represents the SAML object of the same name.
 //
 // See http://docs.oasis-open.org/security/saml/v2.0/saml-core-2.0-os.pdf
 type AttributeStatement struct {
 	Attributes []Attribute `xml:"Attribute"`
+
+	// EncryptedAttributes are decrypted by the SP and appended to
+	// Attributes when the assertion is validated.
+	EncryptedAttributes []EncryptedAttribute `xml:"urn:oasis:names:tc:SAML:2.0:assertion EncryptedAttribute"`
 }
 
+// EncryptedAttribute represents the SAML object of the same name, see
+// ServiceProvider.DecryptAttribute.
+//
+// See http://docs.oasis-open.org/security/saml/v2.0/saml-core-2.0-os.pdf section 2.7.3.2
+type EncryptedAttribute struct {
+	EncryptedData []byte `xml:",innerxml"`
+}
+
+// Attribute name formats defined by the spec.
+//
+// See http://docs.oasis-open.org/security/saml/v2.0/saml-core-2.0-os.pdf section 8.2
+const (
+	AttributeNameFormatUnspecified = "urn:oasis:names:tc:SAML:2.0:attrname-format:unspecified"
+	AttributeNameFormatURI         = "urn:oasis:names:tc:SAML:2.0:attrname-format:uri"
+	AttributeNameFormatBasic       = "urn:oasis:names:tc:SAML:2.0:attrname-format:basic"
+)
+
 // Attribute represents the SAML object of the same name.
 //
 // See http://docs.oasis-open.org/security/saml/v2.0/saml-core-2.0-os.pdf
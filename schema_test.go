@@ -0,0 +1,65 @@
+package saml
+
+import (
+	"encoding/xml"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuthnStatement(t *testing.T) {
+	var assertion Assertion
+	err := xml.Unmarshal([]byte(`<saml:Assertion xmlns:saml="urn:oasis:names:tc:SAML:2.0:assertion" ID="id-assertion">
+	<saml:AuthnStatement AuthnInstant="2020-01-01T12:00:00Z" SessionIndex="_s1" SessionNotOnOrAfter="2020-01-01T20:00:00Z">
+		<saml:SubjectLocality Address="192.0.2.1" DNSName="client.example.org"/>
+		<saml:AuthnContext>
+			<saml:AuthnContextClassRef>
+				urn:oasis:names:tc:SAML:2.0:ac:classes:MobileTwoFactorContract
+			</saml:AuthnContextClassRef>
+			<saml:AuthnContextDeclRef>https://idp.example.org/decl/mfa</saml:AuthnContextDeclRef>
+			<saml:AuthenticatingAuthority>https://upstream.example.org</saml:AuthenticatingAuthority>
+			<saml:AuthenticatingAuthority>https://idp.example.org</saml:AuthenticatingAuthority>
+		</saml:AuthnContext>
+	</saml:AuthnStatement>
+</saml:Assertion>`), &assertion)
+	assert.NoError(t, err)
+
+	stmt := assertion.AuthnStatement
+	if !assert.NotNil(t, stmt) {
+		return
+	}
+	assert.Equal(t, time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC), stmt.AuthnInstant)
+	assert.Equal(t, "_s1", stmt.SessionIndex)
+	if assert.NotNil(t, stmt.SessionNotOnOrAfter) {
+		assert.Equal(t, time.Date(2020, 1, 1, 20, 0, 0, 0, time.UTC), *stmt.SessionNotOnOrAfter)
+	}
+	assert.Equal(t, SubjectLocality{Address: "192.0.2.1", DNSName: "client.example.org"}, stmt.SubjectLocality)
+	assert.Equal(t, "urn:oasis:names:tc:SAML:2.0:ac:classes:MobileTwoFactorContract", stmt.AuthnContext.ClassRef())
+	assert.Nil(t, stmt.AuthnContext.AuthnContextDecl)
+	assert.Equal(t, "https://idp.example.org/decl/mfa", stmt.AuthnContext.AuthnContextDeclRef)
+	assert.Equal(t, []string{"https://upstream.example.org", "https://idp.example.org"}, stmt.AuthnContext.AuthenticatingAuthority)
+
+	// Optional elements are omitted.
+	out, err := xml.Marshal(&AuthnStatement{AuthnInstant: stmt.AuthnInstant})
+	assert.NoError(t, err)
+	assert.Equal(t, `<AuthnStatement AuthnInstant="2020-01-01T12:00:00Z" SessionIndex=""><AuthnContext></AuthnContext></AuthnStatement>`, string(out))
+	assert.Equal(t, "", AuthnContext{}.ClassRef())
+}
+
+func TestSubjectConfirmation(t *testing.T) {
+	var subject Subject
+	err := xml.Unmarshal([]byte(`<saml:Subject xmlns:saml="urn:oasis:names:tc:SAML:2.0:assertion">
+	<saml:SubjectConfirmation Method="urn:oasis:names:tc:SAML:2.0:cm:holder-of-key"/>
+	<saml:SubjectConfirmation Method="urn:oasis:names:tc:SAML:2.0:cm:bearer"/>
+</saml:Subject>`), &subject)
+	assert.NoError(t, err)
+	if assert.Len(t, subject.SubjectConfirmations, 2) {
+		assert.True(t, subject.SubjectConfirmation == &subject.SubjectConfirmations[0])
+	}
+
+	// The deprecated field is marshaled on its own.
+	out, err := xml.Marshal(&Subject{SubjectConfirmation: &SubjectConfirmation{Method: "urn:oasis:names:tc:SAML:2.0:cm:bearer"}})
+	assert.NoError(t, err)
+	assert.Contains(t, string(out), `<Subject xmlns="urn:oasis:names:tc:SAML:2.0:assertion"><SubjectConfirmation Method="urn:oasis:names:tc:SAML:2.0:cm:bearer">`)
+}
@@ -0,0 +1,147 @@
+package saml
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"io"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/pkg/errors"
+)
+
+// SOAPBinding is the official URN for the SOAP binding (transport), used for
+// the synchronous requests between providers.
+//
+// See http://docs.oasis-open.org/security/saml/v2.0/saml-bindings-2.0-os.pdf section 3.2
+const SOAPBinding = "urn:oasis:names:tc:SAML:2.0:bindings:SOAP"
+
+const soapNamespace = "http://schemas.xmlsoap.org/soap/envelope/"
+
+// soapAction is the SOAPAction header of the SAML requests, see section
+// 3.2.2.3 of saml-bindings-2.0-os.
+const soapAction = "http://www.oasis-open.org/committees/security"
+
+// soapEnvelope is a SOAP 1.1 envelope carrying a SAML message in its body.
+type soapEnvelope struct {
+	XMLName xml.Name `xml:"http://schemas.xmlsoap.org/soap/envelope/ Envelope"`
+	Body    soapBody
+}
+
+type soapBody struct {
+	XMLName xml.Name   `xml:"http://schemas.xmlsoap.org/soap/envelope/ Body"`
+	Fault   *soapFault `xml:"http://schemas.xmlsoap.org/soap/envelope/ Fault"`
+	Content []byte     `xml:",innerxml"`
+}
+
+// soapFault is a SOAP 1.1 fault, returned instead of a SAML message when the
+// request could not be processed at all.
+type soapFault struct {
+	XMLName     xml.Name `xml:"http://schemas.xmlsoap.org/soap/envelope/ Fault"`
+	FaultCode   string   `xml:"faultcode"`
+	FaultString string   `xml:"faultstring"`
+}
+
+// wrapSOAP returns the SOAP envelope of the SAML message msg.
+func wrapSOAP(msg []byte) []byte {
+	var buf bytes.Buffer
+	buf.WriteString(`<soap11:Envelope xmlns:soap11="` + soapNamespace + `"><soap11:Body>`)
+	buf.Write(msg)
+	buf.WriteString(`</soap11:Body></soap11:Envelope>`)
+	return buf.Bytes()
+}
+
+// unwrapSOAP returns the SAML message in the body of a SOAP envelope, or the
+// SOAP fault it carries as an error. The message is returned as is: it must
+// declare the namespaces it uses.
+func unwrapSOAP(data []byte) ([]byte, error) {
+	if err := checkXML(data); err != nil {
+		return nil, errors.Wrap(err, "invalid SOAP message")
+	}
+	var envelope soapEnvelope
+	if err := xml.Unmarshal(data, &envelope); err != nil {
+		return nil, errors.Wrap(err, "invalid SOAP message")
+	}
+	if fault := envelope.Body.Fault; fault != nil {
+		return nil, errors.Errorf("SOAP fault %s: %s", fault.FaultCode, fault.FaultString)
+	}
+	msg := bytes.TrimSpace(envelope.Body.Content)
+	if len(msg) == 0 {
+		return nil, errors.New("empty SOAP body")
+	}
+	return msg, nil
+}
+
+// soapLocation returns the location of the first endpoint with the SOAP
+// binding, or an empty string.
+func soapLocation(endpoints []Endpoint) string {
+	for _, endpoint := range endpoints {
+		if endpoint.Binding == SOAPBinding {
+			return endpoint.Location
+		}
+	}
+	return ""
+}
+
+// soapCall sends the SAML message msg to url with the SOAP binding and
+// returns the SAML message of the response, of at most maxSize bytes.
+func soapCall(ctx context.Context, client *http.Client, url string, msg []byte, maxSize int64) ([]byte, error) {
+	req, err := http.NewRequest("POST", url, bytes.NewReader(wrapSOAP(msg)))
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "text/xml; charset=utf-8")
+	req.Header.Set("SOAPAction", soapAction)
+
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	buf, err := ioutil.ReadAll(io.LimitReader(res.Body, maxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(buf)) > maxSize {
+		return nil, ErrMessageTooLarge
+	}
+	// SOAP faults come with 500 Internal Server Error.
+	if res.StatusCode != http.StatusOK && res.StatusCode != http.StatusInternalServerError {
+		return nil, errors.Errorf("unexpected HTTP status %q from SOAP endpoint", res.Status)
+	}
+	return unwrapSOAP(buf)
+}
+
+// readSOAPRequest returns the SAML message of the SOAP request r, of at most
+// maxSize bytes.
+func readSOAPRequest(r *http.Request, maxSize int64) ([]byte, error) {
+	if r.Method != http.MethodPost {
+		return nil, errors.Errorf("unexpected %s request, SOAP requests are posted", r.Method)
+	}
+	buf, err := ioutil.ReadAll(io.LimitReader(r.Body, maxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(buf)) > maxSize {
+		return nil, ErrMessageTooLarge
+	}
+	return unwrapSOAP(buf)
+}
+
+// writeSOAP writes the SAML message msg as a SOAP response.
+func writeSOAP(w http.ResponseWriter, msg []byte) {
+	w.Header().Set("Content-Type", "text/xml; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache, no-store")
+	w.Write(wrapSOAP(msg))
+}
+
+// soapClient returns the client of the SOAP requests of the SP.
+func (sp *ServiceProvider) soapClient() *http.Client {
+	if sp.SOAPClient == nil {
+		return http.DefaultClient
+	}
+	return sp.SOAPClient
+}
@@ -1,14 +1,24 @@
 package saml
 
 import (
+	"context"
+	"crypto"
+	"crypto/x509"
 	"encoding/base64"
 	"encoding/pem"
 	"encoding/xml"
-	"errors"
+	"fmt"
+	"html/template"
 	"io/ioutil"
 	"net/http"
+	"net/url"
 	"os"
+	"strings"
+	"sync"
 	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
 )
 
 // ServiceProvider represents a service provider.
@@ -17,22 +27,375 @@ type ServiceProvider struct {
 	IdPMetadataXML []byte
 	IdPMetadata    *Metadata
 
+	// IdPs, when set, lists the IdPs the users may log in with, in place
+	// of IdPMetadata: the IdP is chosen with the discovery service at
+	// DiscoveryURL, or else on the selection page of IdPSelectionHandler,
+	// and the responses are validated against the IdP that issued them.
+	// See ForIdP.
+	IdPs *IdPSet
+
+	// IdPHint, when set, returns the entity ID of the IdP of IdPs to log in
+	// with for a request to AuthnRequestHandler, e.g. from its host or
+	// path, or an empty string to fall back to the IdPHintParam parameter
+	// and to the choice of the user.
+	IdPHint func(r *http.Request) string
+
+	// DiscoveryURL is the location of the discovery service to which the
+	// users choose an IdP of IdPs, with the Identity Provider Discovery
+	// Protocol. See DiscoveryRequestURL.
+	DiscoveryURL string
+
+	// DiscoveryResponseURL is the location of the SP endpoint to which the
+	// discovery service returns the chosen IdP, served by
+	// DiscoveryResponseHandler. When set, it is published in the SP
+	// metadata.
+	DiscoveryResponseURL string
+
+	// IdPSelectionTemplate renders the IdP selection page, see
+	// IdPSelectionHandler. When nil, DefaultIdPSelectionTemplate is used.
+	IdPSelectionTemplate *template.Template
+
+	// RememberIdP, when set, lets the users ask to remember the IdP chosen
+	// on the selection page for that long, in the IdPCookieName cookie.
+	// AuthnRequestHandler then logs them in with this IdP directly.
+	RememberIdP time.Duration
+
+	// CommonDomain, when set, is the common domain of the federation of the
+	// SP, such as ".example.org", in which the IdPs the users authenticate
+	// with are kept in the CommonDomainCookieName cookie: AssertionMiddleware
+	// adds the IdP of the responses it accepts, and the IdP selection page
+	// lists the IdPs of the cookie first. The host of the SP must be in the
+	// common domain.
+	CommonDomain string
+
+	// IdPEntityID is the entity ID of the IdP. When IdPMetadataURL is
+	// empty and IdPEntityID is an http(s) URL, the metadata is downloaded
+	// from it, as per the well-known location profile (section 4.1 of
+	// saml-metadata-2.0-os). The entity ID of the metadata must match.
+	IdPEntityID string
+
+	// IdPMetadataTLS, when set, restricts the TLS certificates accepted
+	// for the download of IdPMetadataURL.
+	IdPMetadataTLS *MetadataTLS
+
+	// SOAPClient sends the requests of the SOAP binding to the IdP, such as
+	// RequestAssertion. It defaults to http.DefaultClient.
+	SOAPClient *http.Client
+
 	KeyFile  string
 	CertFile string
 
 	PrivkeyPEM string
 	PubkeyPEM  string
 
+	// KeyPolicy is the policy of the keys of the SP and of the certificates
+	// of the IdPs. When nil, they are not checked.
+	KeyPolicy *KeyPolicy
+
+	// RevocationCheck, when set, rejects the IdP certificates revoked by
+	// their CA.
+	RevocationCheck *RevocationCheck
+
+	// TrustModel tells how the certificates of the IdP signatures are
+	// trusted: pinned in the IdP metadata, the default, or validated against
+	// the CAs of TrustRoots, or both. TrustIntermediates are the
+	// intermediate CAs, if any.
+	TrustModel         TrustModel
+	TrustRoots         *x509.CertPool
+	TrustIntermediates *x509.CertPool
+
+	// EntityID identifies the SP in its metadata, in the Issuer of its
+	// requests, and in the audience of the assertions it accepts. It
+	// defaults to MetadataURL.
+	EntityID string
+
 	MetadataURL string
 	AcsURL      string
 
+	// AssertionConsumerServices lists the ACS endpoints published in the SP
+	// metadata. When empty, AcsURL is published with the HTTP-POST binding
+	// and index 1. Responses are only accepted at one of these locations.
+	AssertionConsumerServices []IndexedEndpoint
+
+	// SloURL is the location of the single logout endpoint of the SP,
+	// served by the handler given to Routes with WithSLOHandler. When set,
+	// it is published in the SP metadata with the HTTP-Redirect and
+	// HTTP-POST bindings. Logout responses are sent to SloResponseURL when
+	// it is set, and to SloURL otherwise.
+	SloURL         string
+	SloResponseURL string
+
+	// SingleLogoutServices, when not empty, lists the logout endpoints
+	// published in the SP metadata in place of those derived from SloURL.
+	SingleLogoutServices []Endpoint
+
+	// ManageNameIDURL, when set, is the location of ManageNameIDHandler,
+	// published in the SP metadata with the SOAP binding.
+	ManageNameIDURL string
+
+	// OnManageNameID is called by ManageNameIDHandler with the changes of
+	// persistent identifiers asked by the IdP. An error of type *StatusError
+	// is returned to the IdP with its status codes.
+	OnManageNameID func(ctx context.Context, change *NameIDChange) error
+
+	// DestinationPolicy tells whether the Destination of the responses may
+	// be omitted.
+	DestinationPolicy DestinationPolicy
+
+	// NormalizeURLs enables the comparison of the response Destination and
+	// assertion Recipient with the ACS locations after normalization of
+	// their scheme, host and port.
+	NormalizeURLs bool
+
+	// AcceptRecipientPlaceholder accepts, with a warning, the responses
+	// whose Destination or Recipient is "{recipient}": OneLogin sends this
+	// placeholder when the Recipient of the application is left blank in
+	// its configuration. Setting the Recipient is safer, as the responses
+	// meant for other SPs are then only rejected by their audience.
+	AcceptRecipientPlaceholder bool
+
+	// RateLimit, when set, limits the rate of the requests served by
+	// AssertionMiddleware.
+	RateLimit *RateLimit
+
+	// AddressCheck enables the verification of the Address of the subject
+	// confirmations against the IP of the user agent by AssertionMiddleware.
+	AddressCheck *AddressCheck
+
+	// HolderOfKeyCheck, when set, enables the holder-of-key subject
+	// confirmations, verified against the TLS client certificate of the
+	// user agent by AssertionMiddleware.
+	HolderOfKeyCheck *HolderOfKeyCheck
+
+	// CheckProxyRestriction is called with the ProxyRestriction of the
+	// assertions that have one. SPs acting as SAML proxies can use it to
+	// reject the assertions they are not allowed to re-assert, e.g. with
+	// ProxyRestriction.CheckReassertion.
+	CheckProxyRestriction func(pr *ProxyRestriction) error
+
+	// ProtocolBinding is the binding the IdP is asked to use to send the
+	// response, e.g. HTTPPostBinding. It is omitted from requests when empty.
+	ProtocolBinding string
+
+	// DTDFile is an optional DTD passed to xmlsec1 to identify the ID
+	// attributes of the signed nodes. When empty, the ID attributes of the
+	// SAML protocol and assertion elements are declared on the command line.
 	DTDFile string
 
+	// SchemaFile, when set, is the XML schema the messages received are
+	// validated against with xmllint before they are processed, such as
+	// the SAML 2.0 protocol schema written by WriteSchemas, which must be
+	// next to the schemas it imports. It is meant for test environments,
+	// and for rejecting malformed messages early: xmllint is run for every
+	// message. The responses are validated by ValidateResponse; the other
+	// messages, such as the logout requests handled by the application,
+	// with ValidateSchema.
+	SchemaFile string
+
+	// AllowIdpInitiated accepts the responses without InResponseTo, sent
+	// by the IdP without an AuthnRequest of the SP. The other responses
+	// must answer an AuthnRequest tracked by RequestTracker for the user
+	// agent posting them.
 	AllowIdpInitiated bool
 
+	// IdPInitiated, when set, maps the RelayState of the responses without
+	// InResponseTo, which is chosen by the IdP, to the targets of the
+	// application. See IdPInitiatedPolicy.
+	IdPInitiated *IdPInitiatedPolicy
+
+	// RequestTracker tracks the AuthnRequests sent by AuthnRequestHandler.
+	// When nil, they are tracked with a CookieRequestTracker scoped to the
+	// ACS, whose key is derived from the private key of the SP. Without
+	// private key, the key is random: it does not survive restarts, nor is
+	// it shared by the instances of the SP.
+	RequestTracker RequestTracker
+
+	// MaxSSOAge, when set, is the maximum time elapsed since the user
+	// authenticated at the IdP: the assertions whose AuthnInstant is older,
+	// because the IdP reused an older session, are rejected with
+	// ErrAuthnTooOld, and those without an AuthnStatement with
+	// ErrMissingAuthnStatement.
+	MaxSSOAge time.Duration
+
+	// ForceAuthnOnMaxSSOAge makes AssertionMiddleware answer the assertions
+	// rejected because of MaxSSOAge with a new AuthnRequest with ForceAuthn
+	// set, instead of an error, so that the user authenticates again. The
+	// IdP must honor ForceAuthn, otherwise the user agent would be sent back
+	// and forth. The assertions without an AuthnStatement, which a new
+	// authentication would not fix, are still rejected.
+	ForceAuthnOnMaxSSOAge bool
+
+	// AuthnContextLevels ranks the authentication context classes for
+	// step-up authentication, see StepUp.
+	AuthnContextLevels AuthnContextLevels
+
+	// RelayStateKey, when set, is used to sign the RelayState sent to the
+	// IdP, and AssertionMiddleware rejects the responses whose RelayState
+	// does not carry a valid signature. The signature binds the RelayState
+	// to the AuthnRequest it is sent with, so that it is not accepted with
+	// the response to another request. It takes 23 bytes of the 80 bytes
+	// allowed for the RelayState. See GetRelayStateFromCtx.
+	RelayStateKey []byte
+
+	// RedirectAllowlist lists the origins, such as "https://app.example.com",
+	// that CheckRedirect accepts as post-login redirect targets in addition
+	// to the origins of the ACS locations. A "*." prefix on the host matches
+	// all its subdomains: "https://*.example.com".
+	RedirectAllowlist []string
+
+	// MetadataValidDuration is the validity of the SP metadata, from the
+	// time it is generated. It defaults to 48 hours; a negative value omits
+	// validUntil from the metadata.
+	MetadataValidDuration time.Duration
+
+	// MetadataCacheDuration, when set, is published as the cacheDuration of
+	// the SP metadata: how long the IdPs may cache it before a refresh.
+	MetadataCacheDuration time.Duration
+
+	// UIInfo, when set, is published in the SP metadata for the login and
+	// discovery pages of the IdPs.
+	UIInfo *UIInfo
+
+	// Organization and ContactPersons are published in the SP metadata.
+	// Many federations require an organization and a technical contact.
+	Organization   *Organization
+	ContactPersons []ContactPerson
+
+	// AttributeConsumingServices are published in the SP metadata, to let
+	// the IdPs which release attributes by metadata (such as Shibboleth)
+	// know which attributes the SP needs. An Index of zero is replaced by
+	// the position of the service in the list, starting at 1.
+	AttributeConsumingServices []AttributeConsumingService
+
+	// AttributeNames renames the attributes of the assertions received:
+	// the attributes whose Name is a key of the map, such as the claim URIs
+	// of ADFS, get the value as Name. See ApplyProfile.
+	AttributeNames map[string]string
+
+	// NameIDPolicy is sent with every AuthnRequest. When nil, a transient
+	// NameID is requested with AllowCreate set. A NameIDPolicy given here,
+	// or with WithNameIDPolicy, is sent as is: its AllowCreate is false
+	// unless set, which forbids the IdP to create a new identifier for the
+	// user. The IdP then answers with StatusInvalidNameIDPolicy for the
+	// users without a persistent NameID for the SP yet.
+	NameIDPolicy *NameIDPolicy
+
+	// NameIDFormats lists the NameID formats published in the SP metadata,
+	// by order of preference. When empty, the format of the NameIDPolicy is
+	// published, unless it is unspecified.
+	NameIDFormats []string
+
 	SecurityOpts
 
+	// SignAuthnRequests enables the signature of the AuthnRequests sent
+	// with the HTTP-Redirect binding, with the SP's private key.
+	SignAuthnRequests bool
+
+	// PostFormTemplate renders the form posting the AuthnRequests to IdPs
+	// only supporting the HTTP-POST binding, see WritePostForm. When nil,
+	// DefaultPostFormTemplate is used.
+	PostFormTemplate *template.Template
+
+	// SigningPolicy tells which parts of the responses must be signed by the
+	// IdP. The default accepts a signed response or a signed assertion.
+	SigningPolicy SigningPolicy
+
+	// SizeLimits bounds the size of the messages received by the SP.
+	SizeLimits
+
+	// ErrorHandler renders the errors of the SP's HTTP handlers. When nil,
+	// DefaultErrorHandler is used.
+	ErrorHandler ErrorHandler
+
+	// Clock is used for all the time comparisons and the timestamps of the
+	// messages generated by the SP. When nil, the package-level Now is used.
+	Clock Clock
+
+	// ClockDrift overrides ClockDriftTolerance for this SP.
+	ClockDrift *ClockDrift
+
+	// IDGenerator generates the IDs of the messages created by the SP. When
+	// nil, the package-level NewID is used.
+	IDGenerator IDGenerator
+
+	// Logger receives the SP's log messages. When nil, messages are written
+	// to the standard logger and debug messages are dropped.
+	Logger Logger
+
+	// LogPayloads enables the logging of the full SAML responses received,
+	// at the debug level. They contain the user's personal data and
+	// credentials, so this should only be set while debugging. When false,
+	// only the IDs, issuer and status of the responses are logged.
+	LogPayloads bool
+
+	// Metrics receives the events of the SP worth counting. It is optional.
+	Metrics MetricsCollector
+
+	// CertificateExpiryWarning is how long before their expiry
+	// CheckCertificateExpiry reports the certificates of the SP and of its
+	// IdPs, DefaultCertificateExpiryWarning if zero.
+	CertificateExpiryWarning time.Duration
+
+	// OnCertificateExpiry is called by CheckCertificateExpiry for every
+	// certificate expiring within CertificateExpiryWarning, or expired.
+	OnCertificateExpiry func(CertificateExpiry)
+
+	// Tracer, when set, traces the SSO flow.
+	Tracer Tracer
+
+	// AuditSink, when set, receives a record of every response received by
+	// AssertionMiddleware.
+	AuditSink AuditSink
+
+	// AuditKey is the key of the HMAC identifying the subject of the
+	// responses in the audit records, see HashNameID. Without it, the
+	// records do not identify the subject.
+	AuditKey []byte
+
+	// OnFailure is called for every request the SP's HTTP handlers fail to
+	// serve. When nil, internal failures are logged as errors and client
+	// failures as debug messages.
+	OnFailure FailureFunc
+
 	pemCert atomic.Value
+
+	// idpMetadataValidators are the cache validators of the metadata
+	// fetched from IdPMetadataURL, guarded by metadataMu.
+	idpMetadataValidators cacheValidators
+
+	// Files written for xmlsec1.
+	idpCertFile fileCache
+	privkeyFile fileCache
+	requestKey  requestKeyCache
+
+	// idpSPs are the copies of the SP returned by ForIdP, by entity ID,
+	// guarded by metadataMu. parent is the SP a copy was made of.
+	idpSPs map[string]*ServiceProvider
+	parent *ServiceProvider
+}
+
+func (sp *ServiceProvider) newID() string {
+	if sp.IDGenerator == nil {
+		return NewID()
+	}
+	return sp.IDGenerator()
+}
+
+func (sp *ServiceProvider) now() time.Time {
+	if sp.Clock == nil {
+		return Now()
+	}
+	return sp.Clock.Now()
+}
+
+func (sp *ServiceProvider) clockDrift() ClockDrift {
+	if sp.ClockDrift == nil {
+		return ClockDrift{
+			NotBefore:    ClockDriftTolerance,
+			NotOnOrAfter: ClockDriftTolerance,
+		}
+	}
+	return *sp.ClockDrift
 }
 
 // PrivkeyFile returns a physical path where the SP's key can be accessed.
@@ -41,26 +404,54 @@ func (sp *ServiceProvider) PrivkeyFile() (string, error) {
 		return sp.KeyFile, nil
 	}
 	if sp.PrivkeyPEM != "" {
-		return writeFile([]byte(sp.PrivkeyPEM))
+		return sp.privkeyFile.get(sp.PrivkeyPEM, func() []byte {
+			return []byte(sp.PrivkeyPEM)
+		})
 	}
 	return "", errors.New("No private key given.")
 }
 
+// signingKey returns the SP's private key.
+func (sp *ServiceProvider) signingKey() (crypto.Signer, error) {
+	data := []byte(sp.PrivkeyPEM)
+	if sp.KeyFile != "" {
+		var err error
+		if data, err = ioutil.ReadFile(sp.KeyFile); err != nil {
+			return nil, errors.Wrap(err, "failed to read private key")
+		}
+	} else if sp.PrivkeyPEM == "" {
+		return nil, errors.New("No private key given.")
+	}
+	return parsePrivateKey(data)
+}
+
 // PubkeyFile returns a physical path where the SP's public certificate can be
 // accessed.
 func (sp *ServiceProvider) PubkeyFile() (string, error) {
 	if sp.CertFile != "" {
-		return validateKeyFile(sp.CertFile, nil)
+		return validateKeyFile(sp.CertFile, sp.now())
 	}
 	if sp.PubkeyPEM != "" {
-		return validateKeyFile(writeFile([]byte(sp.PubkeyPEM)))
+		file, err := writeFile([]byte(sp.PubkeyPEM))
+		if err != nil {
+			return "", err
+		}
+		return validateKeyFile(file, sp.now())
 	}
 	return "", errors.New("No public key given.")
 }
 
 // GetIdPAuthResource returns the authentication URL for the SP.
+//
+// Deprecated: use GetIdPAuthResourceContext.
 func (sp *ServiceProvider) GetIdPAuthResource() (string, error) {
-	meta, err := sp.GetIdPMetadata()
+	return sp.GetIdPAuthResourceContext(context.Background())
+}
+
+// GetIdPAuthResourceContext returns the authentication URL for the SP. ctx
+// bounds the download of the IdP metadata, if needed.
+func (sp *ServiceProvider) GetIdPAuthResourceContext(ctx context.Context) (string, error) {
+	meta, err := sp.GetIdPMetadataContext(ctx)
 	if err != nil {
 		return "", err
 	}
@@ -81,18 +472,77 @@ func (sp *ServiceProvider) GetIdPAuthResource() (string, error) {
 	return "", errors.New("could not find SingleSignOnService")
 }
 
+// idpSSOLocation returns the location of the IdP's SingleSignOnService with
+// the given binding.
+func (sp *ServiceProvider) idpSSOLocation(ctx context.Context, binding string) (string, error) {
+	meta, err := sp.GetIdPMetadataContext(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	if meta.IDPSSODescriptor == nil {
+		return "", errors.New("could not find IDPSSODescriptor")
+	}
+
+	for _, endpoint := range meta.IDPSSODescriptor.SingleSignOnService {
+		if endpoint.Binding == binding {
+			return endpoint.Location, nil
+		}
+	}
+
+	return "", fmt.Errorf("could not find SingleSignOnService with binding %s", binding)
+}
+
+// IdPLogoutURL returns the location of the IdP's SingleLogoutService with
+// the HTTP-Redirect binding, for NewLogoutRequest. It returns an empty
+// string when the IdP does not support single logout, as Google Workspace:
+// the SP can then only end its own session. ctx bounds the download of the
+// IdP metadata, if needed.
+func (sp *ServiceProvider) IdPLogoutURL(ctx context.Context) (string, error) {
+	meta, err := sp.GetIdPMetadataContext(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	if meta.IDPSSODescriptor == nil {
+		return "", errors.New("could not find IDPSSODescriptor")
+	}
+
+	for _, endpoint := range meta.IDPSSODescriptor.SingleLogoutService {
+		if endpoint.Binding == HTTPRedirectBinding {
+			return endpoint.Location, nil
+		}
+	}
+	return "", nil
+}
+
 // GetIdPCertFile returns a physical path where the IdP certificate can be
 // accessed.
+//
+// Deprecated: use GetIdPCertFileContext.
 func (sp *ServiceProvider) GetIdPCertFile() (string, error) {
-	meta, err := sp.GetIdPMetadata()
+	return sp.GetIdPCertFileContext(context.Background())
+}
+
+// GetIdPCertFileContext returns a physical path where the IdP certificate
+// can be accessed. ctx bounds the download of the IdP metadata, if needed.
+func (sp *ServiceProvider) GetIdPCertFileContext(ctx context.Context) (string, error) {
+	if err := sp.checkTrustModel(); err != nil {
+		return "", err
+	}
+	meta, err := sp.GetIdPMetadataContext(ctx)
 	if err != nil {
 		return "", err
 	}
 
-	cert := ""
+	if meta.IDPSSODescriptor == nil {
+		return "", errors.New("could not find IDPSSODescriptor")
+	}
+
+	cert, use := "", ""
 	for _, keyDescriptor := range meta.IDPSSODescriptor.KeyDescriptor {
 		if keyDescriptor.Use == "encryption" {
-			cert = keyDescriptor.KeyInfo.Certificate
+			cert, use = keyDescriptor.KeyInfo.Certificate, keyDescriptor.Use
 			break
 		}
 	}
@@ -100,7 +550,7 @@ func (sp *ServiceProvider) GetIdPCertFile() (string, error) {
 	if cert == "" {
 		for _, keyDescriptor := range meta.IDPSSODescriptor.KeyDescriptor {
 			if keyDescriptor.KeyInfo.Certificate != "" {
-				cert = keyDescriptor.KeyInfo.Certificate
+				cert, use = keyDescriptor.KeyInfo.Certificate, keyDescriptor.Use
 				break
 			}
 		}
@@ -110,41 +560,147 @@ func (sp *ServiceProvider) GetIdPCertFile() (string, error) {
 		return "", errors.New("Missing certificate data.")
 	}
 
-	certBytes, _ := base64.StdEncoding.DecodeString(cert)
+	c, err := parseKeyInfoCertificate(cert)
+	if err == nil {
+		err = sp.KeyPolicy.checkCertificate(c, use, sp.now(), true)
+	}
+	if err == nil && sp.TrustModel != TrustMetadata {
+		err = sp.verifyChain(c)
+	}
+	if err == nil && sp.RevocationCheck != nil {
+		err = sp.RevocationCheck.check(ctx, c, sp.now())
+	}
+	if err != nil {
+		return "", errors.Wrap(err, "invalid IdP certificate")
+	}
 
-	certBytes = pem.EncodeToMemory(&pem.Block{
-		Type:  "CERTIFICATE",
-		Bytes: certBytes,
+	return sp.idpCertFile.get(cert, func() []byte {
+		certBytes, _ := base64.StdEncoding.DecodeString(cert)
+		return pem.EncodeToMemory(&pem.Block{
+			Type:  "CERTIFICATE",
+			Bytes: certBytes,
+		})
 	})
+}
 
-	return writeFile(certBytes)
+// cacheValidators are the HTTP cache validators of a metadata document,
+// sent back on the next download to skip it if the document didn't change.
+type cacheValidators struct {
+	ETag         string
+	LastModified string
+}
+
+// errNotModified is returned by fetchMetadata when the document didn't
+// change since it was downloaded with the given validators.
+var errNotModified = errors.New("metadata not modified")
+
+// fetchMetadata downloads a metadata document. It is a conditional GET if
+// prev is not empty.
+func fetchMetadata(ctx context.Context, client *http.Client, metadataURL string, prev cacheValidators) ([]byte, cacheValidators, error) {
+	req, err := http.NewRequest("GET", metadataURL, nil)
+	if err != nil {
+		return nil, cacheValidators{}, err
+	}
+	req = req.WithContext(ctx)
+	if prev.ETag != "" {
+		req.Header.Set("If-None-Match", prev.ETag)
+	}
+	if prev.LastModified != "" {
+		req.Header.Set("If-Modified-Since", prev.LastModified)
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, cacheValidators{}, err
+	}
+	defer res.Body.Close()
+
+	switch res.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotModified:
+		return nil, prev, errNotModified
+	default:
+		return nil, cacheValidators{}, fmt.Errorf("unexpected HTTP status %q fetching metadata", res.Status)
+	}
+
+	buf, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, cacheValidators{}, err
+	}
+	return buf, cacheValidators{
+		ETag:         res.Header.Get("ETag"),
+		LastModified: res.Header.Get("Last-Modified"),
+	}, nil
 }
 
 // GetIdPMetadata returns the IdP metadata value.
+//
+// Deprecated: use GetIdPMetadataContext.
 func (sp *ServiceProvider) GetIdPMetadata() (*Metadata, error) {
+	return sp.GetIdPMetadataContext(context.Background())
+}
+
+// GetIdPMetadataContext returns the IdP metadata value. When it has to be
+// fetched from IdPMetadataURL or IdPEntityID, concurrent callers share a
+// single fetch, which is bounded by the context of the caller that started
+// it. A caller whose ctx is done stops waiting for the fetch; the fetch is
+// started again for the other callers if it was cancelled that way.
+func (sp *ServiceProvider) GetIdPMetadataContext(ctx context.Context) (*Metadata, error) {
+	metadataMu.Lock()
 	if sp.IdPMetadata != nil {
 		m := *(sp.IdPMetadata)
+		metadataMu.Unlock()
 		return &m, nil
 	}
 
 	if len(sp.IdPMetadataXML) == 0 {
-		if sp.IdPMetadataURL == "" {
+		if sp.idpMetadataURL() == "" {
+			metadataMu.Unlock()
 			return nil, errors.New("Missing metadata URL.")
 		}
 
-		res, err := http.Get(sp.IdPMetadataURL)
-		if err != nil {
-			return nil, err
-		}
-		defer res.Body.Close()
+		for {
+			call := metadataCalls[sp]
+			if call == nil {
+				call = &metadataCall{done: make(chan struct{})}
+				metadataCalls[sp] = call
+				metadataMu.Unlock()
 
-		buf, err := ioutil.ReadAll(res.Body)
-		if err != nil {
-			return nil, err
-		}
+				buf, metadata, validators, err := sp.fetchIdPMetadata(ctx, cacheValidators{})
+
+				metadataMu.Lock()
+				if err == nil {
+					sp.IdPMetadataXML = buf
+					sp.IdPMetadata = metadata
+					sp.idpMetadataValidators = validators
+				}
+				call.metadata, call.err = metadata, err
+				call.canceled = ctx.Err() != nil
+				delete(metadataCalls, sp)
+				metadataMu.Unlock()
+				close(call.done)
+			} else {
+				metadataMu.Unlock()
+				select {
+				case <-call.done:
+				case <-ctx.Done():
+					return nil, ctx.Err()
+				}
+				if call.canceled && ctx.Err() == nil {
+					// The caller that started the fetch went away.
+					metadataMu.Lock()
+					continue
+				}
+			}
 
-		sp.IdPMetadataXML = buf
+			if call.err != nil {
+				return nil, call.err
+			}
+			m := *call.metadata
+			return &m, nil
+		}
 	}
+	defer metadataMu.Unlock()
 
 	var metadata Metadata
 	err := xml.Unmarshal(sp.IdPMetadataXML, &metadata)
@@ -153,7 +709,75 @@ func (sp *ServiceProvider) GetIdPMetadata() (*Metadata, error) {
 	}
 
 	sp.IdPMetadata = &metadata
-	return &metadata, nil
+	m := metadata
+	return &m, nil
+}
+
+// entityID returns the entity ID of the SP: EntityID, or else MetadataURL.
+func (sp *ServiceProvider) entityID() string {
+	if sp.EntityID != "" {
+		return sp.EntityID
+	}
+	return sp.MetadataURL
+}
+
+// idpMetadataURL returns the URL of the IdP metadata: IdPMetadataURL, or
+// else IdPEntityID if it is a URL.
+func (sp *ServiceProvider) idpMetadataURL() string {
+	if sp.IdPMetadataURL != "" {
+		return sp.IdPMetadataURL
+	}
+	if strings.HasPrefix(sp.IdPEntityID, "https://") || strings.HasPrefix(sp.IdPEntityID, "http://") {
+		return sp.IdPEntityID
+	}
+	return ""
+}
+
+// metadataMu guards the IdP metadata fields of the ServiceProviders in
+// GetIdPMetadataContext, and metadataCalls. It is not held during the fetches.
+// Being global, it doesn't prevent the copy of ServiceProvider values.
+var metadataMu sync.Mutex
+
+// metadataCalls are the fetches of IdP metadata in progress.
+var metadataCalls = map[*ServiceProvider]*metadataCall{}
+
+// metadataCall is a fetch of the IdP metadata shared by concurrent callers
+// of GetIdPMetadataContext. canceled tells whether the context of the fetch
+// was done.
+type metadataCall struct {
+	done     chan struct{}
+	metadata *Metadata
+	err      error
+	canceled bool
+}
+
+func (sp *ServiceProvider) fetchIdPMetadata(ctx context.Context, prev cacheValidators) ([]byte, *Metadata, cacheValidators, error) {
+	ctx, span := sp.tracer().Start(ctx, SpanFetchMetadata)
+	metadataURL := sp.idpMetadataURL()
+	span.SetAttribute(AttrMetadataURL, metadataURL)
+	client := sp.IdPMetadataTLS.client()
+	if client != http.DefaultClient {
+		defer client.CloseIdleConnections()
+	}
+	buf, validators, err := fetchMetadata(ctx, client, metadataURL, prev)
+	var metadata Metadata
+	if err == nil {
+		err = xml.Unmarshal(buf, &metadata)
+	}
+	if err == nil && sp.IdPEntityID != "" && metadata.EntityID != sp.IdPEntityID {
+		err = fmt.Errorf("metadata of entity %q found at %s, expected %q", metadata.EntityID, metadataURL, sp.IdPEntityID)
+	}
+	if err == errNotModified {
+		sp.metrics().MetadataRefreshed(nil)
+		span.End(nil)
+	} else {
+		sp.metrics().MetadataRefreshed(err)
+		span.End(err)
+	}
+	if err != nil {
+		return nil, nil, validators, err
+	}
+	return buf, &metadata, validators, nil
 }
 
 // Cert returns a *pem.Block value that corresponds to the SP's certificate.
@@ -182,6 +806,13 @@ func (sp *ServiceProvider) Cert() (*pem.Block, error) {
 	if cert == nil {
 		return nil, errors.New("Invalid certificate.")
 	}
+	c, err := x509.ParseCertificate(cert.Bytes)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid certificate")
+	}
+	if err := sp.KeyPolicy.checkCertificate(c, "", sp.now(), false); err != nil {
+		return nil, errors.Wrap(err, "invalid certificate")
+	}
 
 	sp.pemCert.Store(cert)
 
@@ -197,11 +828,13 @@ func (sp *ServiceProvider) Metadata() (*Metadata, error) {
 	certStr := base64.StdEncoding.EncodeToString(cert.Bytes)
 
 	metadata := &Metadata{
-		EntityID:   sp.MetadataURL,
-		ValidUntil: Now().Add(defaultValidDuration),
+		EntityID:       sp.entityID(),
+		ValidUntil:     sp.metadataValidUntil(),
+		Organization:   sp.Organization,
+		ContactPersons: sp.ContactPersons,
 		SPSSODescriptor: &SPSSODescriptor{
-			AuthnRequestsSigned:        false,
-			WantAssertionsSigned:       true,
+			AuthnRequestsSigned:        sp.SignAuthnRequests,
+			WantAssertionsSigned:       sp.SigningPolicy.requiresAssertion(),
 			ProtocolSupportEnumeration: "urn:oasis:names:tc:SAML:2.0:protocol",
 			KeyDescriptor: []KeyDescriptor{
 				KeyDescriptor{
@@ -223,36 +856,343 @@ func (sp *ServiceProvider) Metadata() (*Metadata, error) {
 					},
 				},
 			},
-			AssertionConsumerService: []IndexedEndpoint{{
-				Binding:  HTTPPostBinding,
-				Location: sp.AcsURL,
-				Index:    1,
-			}},
+			SingleLogoutService:       sp.singleLogoutServices(),
+			ManageNameIDService:       sp.manageNameIDServices(),
+			NameIDFormat:              sp.nameIDFormats(),
+			AssertionConsumerService:  sp.assertionConsumerServices(),
+			AttributeConsumingService: sp.attributeConsumingServices(),
 		},
 	}
+	if sp.MetadataCacheDuration != 0 {
+		metadata.CacheDuration = NewCacheDuration(sp.MetadataCacheDuration)
+	}
+	if sp.UIInfo != nil || sp.DiscoveryResponseURL != "" {
+		metadata.SPSSODescriptor.Extensions = &Extensions{
+			UIInfo:             sp.UIInfo,
+			DiscoveryResponses: sp.discoveryResponses(),
+		}
+	}
 
 	return metadata, nil
 }
 
+// AuthnRequestOption customizes an AuthnRequest built by NewAuthnRequest.
+type AuthnRequestOption func(req *AuthnRequest)
+
+// WithProviderName sets the human readable name of the SP. Some IdPs display
+// it on their login page.
+func WithProviderName(name string) AuthnRequestOption {
+	return func(req *AuthnRequest) {
+		req.ProviderName = name
+	}
+}
+
+// WithConsent sets the Consent attribute of the request. See the Consent*
+// constants for the values defined by the spec.
+func WithConsent(consent string) AuthnRequestOption {
+	return func(req *AuthnRequest) {
+		req.Consent = consent
+	}
+}
+
+// WithSubject asks the IdP to authenticate the given user. The IdP may use it
+// to pre-select or enforce the account. Use AuthnRequest.ValidateSubject to
+// check that the returned assertion is about the same user.
+func WithSubject(nameID *NameID) AuthnRequestOption {
+	return func(req *AuthnRequest) {
+		req.Subject = &Subject{
+			NameID: nameID,
+		}
+	}
+}
+
+// WithIsPassive asks the IdP not to interact with the user: the IdP answers
+// with the StatusNoPassive status if the user is not already logged in.
+// AssertionMiddleware then starts an interactive login.
+func WithIsPassive() AuthnRequestOption {
+	return func(req *AuthnRequest) {
+		req.IsPassive = true
+	}
+}
+
+// WithForceAuthn asks the IdP to authenticate the user again, rather than
+// relying on a previous session.
+func WithForceAuthn() AuthnRequestOption {
+	return func(req *AuthnRequest) {
+		req.ForceAuthn = true
+	}
+}
+
+// WithRequestedAuthnContext asks the IdP to authenticate the user with one
+// of the classRefs authentication context classes, compared with the
+// classes of the authentication according to comparison, one of the
+// AuthnContextComparison* constants. An empty comparison means exact.
+func WithRequestedAuthnContext(comparison string, classRefs ...string) AuthnRequestOption {
+	return func(req *AuthnRequest) {
+		req.RequestedAuthnContext = &RequestedAuthnContext{
+			Comparison:            comparison,
+			AuthnContextClassRefs: classRefs,
+		}
+	}
+}
+
+// WithNameIDPolicy overrides the SP's NameIDPolicy for a single request.
+func WithNameIDPolicy(policy NameIDPolicy) AuthnRequestOption {
+	return func(req *AuthnRequest) {
+		req.NameIDPolicy = policy
+	}
+}
+
+func (sp *ServiceProvider) nameIDPolicy() NameIDPolicy {
+	if sp.NameIDPolicy != nil {
+		return *sp.NameIDPolicy
+	}
+	return NameIDPolicy{
+		AllowCreate: true,
+		Format:      NameIDFormatTransient,
+	}
+}
+
+func (sp *ServiceProvider) nameIDFormats() []string {
+	if len(sp.NameIDFormats) > 0 {
+		return sp.NameIDFormats
+	}
+	format := sp.nameIDPolicy().Format
+	if format == "" || format == NameIDFormatUnspecified {
+		return nil
+	}
+	return []string{format}
+}
+
+// WithAssertionConsumerServiceIndex asks the IdP to send the response to the
+// ACS endpoint published with the given index in the SP metadata, instead of
+// AcsURL with the ProtocolBinding of the SP. It can't be combined with
+// WithProtocolBinding, as the endpoint defines the binding.
+func WithAssertionConsumerServiceIndex(index int) AuthnRequestOption {
+	return func(req *AuthnRequest) {
+		req.AssertionConsumerServiceIndex = &index
+	}
+}
+
+// WithProtocolBinding overrides the SP's ProtocolBinding for a single request.
+// It can't be combined with WithAssertionConsumerServiceIndex.
+func WithProtocolBinding(binding string) AuthnRequestOption {
+	return func(req *AuthnRequest) {
+		req.ProtocolBinding = binding
+	}
+}
+
+func (sp *ServiceProvider) assertionConsumerServices() []IndexedEndpoint {
+	if len(sp.AssertionConsumerServices) > 0 {
+		return sp.AssertionConsumerServices
+	}
+	return []IndexedEndpoint{{
+		Binding:  HTTPPostBinding,
+		Location: sp.AcsURL,
+		Index:    1,
+	}}
+}
+
+func (sp *ServiceProvider) metadataValidUntil() time.Time {
+	switch {
+	case sp.MetadataValidDuration < 0:
+		return time.Time{}
+	case sp.MetadataValidDuration > 0:
+		return sp.now().Add(sp.MetadataValidDuration)
+	}
+	return sp.now().Add(defaultValidDuration)
+}
+
+func (sp *ServiceProvider) manageNameIDServices() []Endpoint {
+	if sp.ManageNameIDURL == "" {
+		return nil
+	}
+	return []Endpoint{{Binding: SOAPBinding, Location: sp.ManageNameIDURL}}
+}
+
+func (sp *ServiceProvider) singleLogoutServices() []Endpoint {
+	if len(sp.SingleLogoutServices) > 0 || sp.SloURL == "" {
+		return sp.SingleLogoutServices
+	}
+	var endpoints []Endpoint
+	for _, binding := range []string{HTTPRedirectBinding, HTTPPostBinding} {
+		endpoints = append(endpoints, Endpoint{
+			Binding:          binding,
+			Location:         sp.SloURL,
+			ResponseLocation: sp.SloResponseURL,
+		})
+	}
+	return endpoints
+}
+
+func (sp *ServiceProvider) attributeConsumingServices() []AttributeConsumingService {
+	if len(sp.AttributeConsumingServices) == 0 {
+		return nil
+	}
+	services := make([]AttributeConsumingService, len(sp.AttributeConsumingServices))
+	for i, service := range sp.AttributeConsumingServices {
+		if service.Index == 0 {
+			service.Index = i + 1
+		}
+		services[i] = service
+	}
+	return services
+}
+
+// isAcsIndex returns whether index is the index of one of the SP's ACS
+// endpoints.
+func (sp *ServiceProvider) isAcsIndex(index int) bool {
+	for _, acs := range sp.assertionConsumerServices() {
+		if acs.Index == index {
+			return true
+		}
+	}
+	return false
+}
+
+// isAcsURL returns whether the given URL is one of the SP's ACS locations.
+func (sp *ServiceProvider) isAcsURL(location string) bool {
+	if location == "" {
+		return false
+	}
+	equal := func(a, b string) bool { return a == b }
+	if sp.NormalizeURLs {
+		location = normalizeURL(location)
+		equal = func(a, b string) bool { return a == normalizeURL(b) }
+	}
+	if equal(location, sp.AcsURL) {
+		return true
+	}
+	for _, acs := range sp.AssertionConsumerServices {
+		if equal(location, acs.Location) {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizeURL returns the normal form of an URL: lowercase scheme and host,
+// without the default port of the scheme.
+func normalizeURL(location string) string {
+	u, err := url.Parse(location)
+	if err != nil || u.Host == "" {
+		return location
+	}
+	u.Scheme = strings.ToLower(u.Scheme)
+	host, port := u.Hostname(), u.Port()
+	if (u.Scheme == "http" && port == "80") || (u.Scheme == "https" && port == "443") {
+		port = ""
+	}
+	u.Host = strings.ToLower(host)
+	if strings.Contains(host, ":") {
+		u.Host = "[" + u.Host + "]"
+	}
+	if port != "" {
+		u.Host += ":" + port
+	}
+	if u.Path == "" {
+		u.Path = "/"
+	}
+	return u.String()
+}
+
+// NewLogoutRequest creates a LogoutRequest for the given IdP URL, ending the
+// sessions of the user identified by nameID. nameID must be the NameID of
+// the assertion, with its qualifiers, and sessionIndexes the SessionIndex of
+// its AuthnStatement, if any. When the IdP metadata publishes an encryption
+// key, the NameID is sent encrypted.
+func (sp *ServiceProvider) NewLogoutRequest(idpURL string, nameID NameID, sessionIndexes ...string) (*LogoutRequest, error) {
+	if nameID.Value == "" {
+		return nil, errors.New("missing NameID value")
+	}
+	req := &LogoutRequest{
+		ID:           sp.newID(),
+		Version:      "2.0",
+		IssueInstant: sp.now(),
+		Destination:  idpURL,
+		Issuer: Issuer{
+			Format: "urn:oasis:names:tc:SAML:2.0:nameid-format:entity",
+			Value:  sp.entityID(),
+		},
+		NameID:       &nameID,
+		SessionIndex: sessionIndexes,
+	}
+
+	meta, err := sp.GetIdPMetadataContext(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	if hasEncryptionKey(meta.IDPSSODescriptor) {
+		certFile, err := sp.GetIdPCertFileContext(context.Background())
+		if err != nil {
+			return nil, err
+		}
+		if req.EncryptedID, err = EncryptNameID(&nameID, certFile); err != nil {
+			return nil, err
+		}
+		req.NameID = nil
+	}
+	return req, nil
+}
+
+// hasEncryptionKey returns whether the IdP publishes a key for encryption in
+// its metadata, which means that it expects encrypted identifiers.
+func hasEncryptionKey(desc *IDPSSODescriptor) bool {
+	if desc == nil {
+		return false
+	}
+	for _, keyDescriptor := range desc.KeyDescriptor {
+		if keyDescriptor.Use == "encryption" && keyDescriptor.KeyInfo.Certificate != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// LogoutRequestNameID returns the NameID of a LogoutRequest received from
+// the IdP, decrypting its EncryptedID if needed.
+func (sp *ServiceProvider) LogoutRequestNameID(req *LogoutRequest) (*NameID, error) {
+	switch {
+	case req.NameID != nil:
+		return req.NameID, nil
+	case req.EncryptedID != nil:
+		return sp.DecryptNameID(req.EncryptedID)
+	}
+	return nil, errors.New("missing NameID")
+}
+
 // NewAuthnRequest creates a new AuthnRequest object for the given IdP URL.
-func (sp *ServiceProvider) NewAuthnRequest(idpURL string) (*AuthnRequest, error) {
+// It fails when opts are conflicting.
+func (sp *ServiceProvider) NewAuthnRequest(idpURL string, opts ...AuthnRequestOption) (*AuthnRequest, error) {
 	req := AuthnRequest{
-		AssertionConsumerServiceURL: sp.AcsURL,
-		Destination:                 idpURL,
-		ID:                          NewID(),
-		IssueInstant:                Now(),
-		Version:                     "2.0",
+		Destination:  idpURL,
+		ID:           sp.newID(),
+		IssueInstant: sp.now(),
+		Version:      "2.0",
 		Issuer: Issuer{
 			Format: "urn:oasis:names:tc:SAML:2.0:nameid-format:entity",
-			Value:  sp.MetadataURL,
-		},
-		NameIDPolicy: NameIDPolicy{
-			AllowCreate: true,
-			// TODO(ross): figure out exactly policy we need
-			// urn:mace:shibboleth:1.0:nameIdentifier
-			// urn:oasis:names:tc:SAML:2.0:nameid-format:transient
-			Format: "urn:oasis:names:tc:SAML:2.0:nameid-format:transient",
+			Value:  sp.entityID(),
 		},
+		NameIDPolicy: sp.nameIDPolicy(),
+	}
+	for _, opt := range opts {
+		opt(&req)
+	}
+
+	// The ACS is designated either by its index, or by its URL and
+	// binding (section 3.4.1 of saml-core-2.0-os).
+	if index := req.AssertionConsumerServiceIndex; index != nil {
+		if req.ProtocolBinding != "" {
+			return nil, errors.New("WithAssertionConsumerServiceIndex and WithProtocolBinding are mutually exclusive")
+		}
+		if !sp.isAcsIndex(*index) {
+			return nil, errors.Errorf("no AssertionConsumerService with index %d", *index)
+		}
+		return &req, nil
+	}
+	req.AssertionConsumerServiceURL = sp.AcsURL
+	if req.ProtocolBinding == "" {
+		req.ProtocolBinding = sp.ProtocolBinding
 	}
 	return &req, nil
 }
@@ -3,13 +3,18 @@
 package saml
 
 import (
-	"bytes"
-	"compress/flate"
+	"context"
+	"crypto"
+	"crypto/x509"
 	"encoding/base64"
 	"encoding/xml"
 	"fmt"
+	"mime"
+	"net"
+	"net/http"
 	"net/url"
 	"strings"
+	"time"
 
 	"github.com/goware/saml/xmlsec"
 	"github.com/pkg/errors"
@@ -21,38 +26,100 @@ import (
 // the value is base64 encoded and deflate-compressed <AuthnRequest>
 // XML element. The final redirect destination that will be invoked
 // on successful login is passed using ?RelayState query parameter.
-func (sp *ServiceProvider) AuthnRequestURL(relayState string) (string, error) {
-	destination, err := sp.GetIdPAuthResource()
+// Options are passed to NewAuthnRequest.
+//
+// The request is not tracked, lacking the response to the user agent:
+// AssertionMiddleware rejects its response. Use SendAuthnRequest to send an
+// AuthnRequest answered at the ACS.
+func (sp *ServiceProvider) AuthnRequestURL(relayState string, opts ...AuthnRequestOption) (string, error) {
+	return sp.authnRequestURL(context.Background(), relayState, opts...)
+}
+
+func (sp *ServiceProvider) authnRequestURL(ctx context.Context, relayState string, opts ...AuthnRequestOption) (string, error) {
+	ctx, span := sp.tracer().Start(ctx, SpanAuthnRequest)
+	redirectURL, err := sp.buildAuthnRequestURL(ctx, span, relayState, opts...)
+	span.End(err)
+	return redirectURL, err
+}
+
+func (sp *ServiceProvider) buildAuthnRequestURL(ctx context.Context, span Span, relayState string, opts ...AuthnRequestOption) (string, error) {
+	destination, err := sp.idpSSOLocation(ctx, HTTPRedirectBinding)
+	if err != nil {
+		destination, err = sp.GetIdPAuthResourceContext(ctx)
+	}
 	if err != nil {
 		return "", errors.Wrap(err, "failed to get IdP destination")
 	}
 
-	authnRequest, err := sp.NewAuthnRequest(destination)
+	id, buf, err := sp.marshalAuthnRequest(span, destination, opts...)
 	if err != nil {
-		return "", errors.Wrapf(err, "failed to make auth request to %v", destination)
+		return "", err
 	}
 
-	buf, err := xml.MarshalIndent(authnRequest, "", "\t")
+	var key crypto.Signer
+	if sp.SignAuthnRequests {
+		if key, err = sp.signingKey(); err != nil {
+			return "", err
+		}
+	}
+	redirectURL, err := EncodeRedirectURL(destination, RedirectMessage{
+		Param:      "SAMLRequest",
+		XML:        buf,
+		RelayState: sp.signRelayState(relayState, id),
+	}, key)
 	if err != nil {
-		return "", errors.Wrap(err, "Failed to marshal auth request")
+		return "", err
 	}
 
-	flateBuf := bytes.NewBuffer(nil)
-	flateWriter, err := flate.NewWriter(flateBuf, flate.DefaultCompression)
+	sp.metrics().AuthnRequestIssued()
+	return redirectURL, nil
+}
+
+// AuthnRequestForm creates the form sending an AuthnRequest to the IdP's
+// SingleSignOnService with the HTTP-POST binding, to be rendered by
+// WritePostForm. Options are passed to NewAuthnRequest.
+func (sp *ServiceProvider) AuthnRequestForm(relayState string, opts ...AuthnRequestOption) (*PostForm, error) {
+	return sp.authnRequestForm(context.Background(), relayState, opts...)
+}
+
+func (sp *ServiceProvider) authnRequestForm(ctx context.Context, relayState string, opts ...AuthnRequestOption) (*PostForm, error) {
+	ctx, span := sp.tracer().Start(ctx, SpanAuthnRequest)
+	form, err := sp.buildAuthnRequestForm(ctx, span, relayState, opts...)
+	span.End(err)
+	return form, err
+}
+
+func (sp *ServiceProvider) buildAuthnRequestForm(ctx context.Context, span Span, relayState string, opts ...AuthnRequestOption) (*PostForm, error) {
+	destination, err := sp.idpSSOLocation(ctx, HTTPPostBinding)
 	if err != nil {
-		return "", errors.Wrap(err, "failed to create flate writer")
+		return nil, errors.Wrap(err, "failed to get IdP destination")
 	}
 
-	_, err = flateWriter.Write(buf)
+	id, buf, err := sp.marshalAuthnRequest(span, destination, opts...)
 	if err != nil {
-		return "", errors.Wrap(err, "failed to write to flate writer")
+		return nil, err
 	}
-	flateWriter.Close()
-	message := base64.StdEncoding.EncodeToString(flateBuf.Bytes())
 
-	redirectURL := destination + fmt.Sprintf(`?RelayState=%s&SAMLRequest=%s`, url.QueryEscape(relayState), url.QueryEscape(message))
+	form := NewPostForm(destination, "SAMLRequest", buf, sp.signRelayState(relayState, id))
+	sp.metrics().AuthnRequestIssued()
+	return &form, nil
+}
 
-	return redirectURL, nil
+// marshalAuthnRequest returns the ID of a new AuthnRequest and its XML.
+func (sp *ServiceProvider) marshalAuthnRequest(span Span, destination string, opts ...AuthnRequestOption) (string, []byte, error) {
+	authnRequest, err := sp.NewAuthnRequest(destination, opts...)
+	if err != nil {
+		return "", nil, errors.Wrapf(err, "failed to make auth request to %v", destination)
+	}
+	span.SetAttribute(AttrRequestID, authnRequest.ID)
+	span.SetAttribute(AttrDestination, destination)
+
+	// The request is not indented, to keep the redirect URL short.
+	buf, err := xml.Marshal(authnRequest)
+	if err != nil {
+		return "", nil, errors.Wrap(err, "Failed to marshal auth request")
+	}
+	return authnRequest.ID, buf, nil
 }
 
 // MetadataXML returns SAML 2.0 Service Provider metadata XML.
@@ -70,23 +137,452 @@ func (sp *ServiceProvider) MetadataXML() ([]byte, error) {
 	return out, nil
 }
 
-func (sp *ServiceProvider) possibleResponseIDs() []string {
-	responseIDs := []string{}
-	if sp.AllowIdpInitiated {
-		responseIDs = append(responseIDs, "")
+// ErrorHandler renders an error that occurred while serving an HTTP request.
+// status is the HTTP status code the package would have used.
+type ErrorHandler func(w http.ResponseWriter, r *http.Request, status int, err error)
+
+// DefaultErrorHandler writes the error message as a text/plain body with the
+// given status code. The failure statuses returned by the IdP are written
+// with the UserMessage of the StatusError. It is used when
+// ServiceProvider.ErrorHandler is nil.
+func DefaultErrorHandler(w http.ResponseWriter, r *http.Request, status int, err error) {
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		http.Error(w, statusErr.UserMessage(), status)
+		return
+	}
+	http.Error(w, err.Error(), status)
+}
+
+func (sp *ServiceProvider) writeErr(w http.ResponseWriter, r *http.Request, status int, err error) {
+	errorHandler := sp.ErrorHandler
+	if errorHandler == nil {
+		errorHandler = DefaultErrorHandler
+	}
+	errorHandler(w, r, status, err)
+}
+
+func (sp *ServiceProvider) logger() Logger {
+	if sp.Logger == nil {
+		return defaultLogger
+	}
+	return sp.Logger
+}
+
+func (sp *ServiceProvider) fail(r *http.Request, level FailureLevel, err error) {
+	if sp.OnFailure != nil {
+		sp.OnFailure(r, level, err)
+		return
+	}
+	logFailure(sp.logger(), r, level, err)
+}
+
+// clientErr reports an error caused by the request sent by the user agent.
+func (sp *ServiceProvider) clientErr(w http.ResponseWriter, r *http.Request, err error) {
+	sp.fail(r, ClientFailure, err)
+	sp.writeErr(w, r, http.StatusBadRequest, err)
+}
+
+// internalErr reports an error caused by the SP configuration or environment.
+func (sp *ServiceProvider) internalErr(w http.ResponseWriter, r *http.Request, err error) {
+	sp.fail(r, InternalFailure, err)
+	sp.writeErr(w, r, http.StatusInternalServerError, err)
+}
+
+type contextKey string
+
+const (
+	assertionContextKey  = contextKey("saml.Assertion")
+	relayStateContextKey = contextKey("saml.RelayState")
+)
+
+// GetAssertionFromCtx returns the assertion validated by AssertionMiddleware,
+// or nil.
+func GetAssertionFromCtx(ctx context.Context) *Assertion {
+	assertion, _ := ctx.Value(assertionContextKey).(*Assertion)
+	return assertion
+}
+
+// GetRelayStateFromCtx returns the RelayState received by
+// AssertionMiddleware, once its signature has been verified and removed if
+// the SP has a RelayStateKey. For the unsolicited responses, it is the
+// target given by the IdPInitiated policy of the SP, if any.
+func GetRelayStateFromCtx(ctx context.Context) string {
+	relayState, _ := ctx.Value(relayStateContextKey).(string)
+	return relayState
+}
+
+// MetadataHandler serves the SP's metadata.xml file.
+func (sp *ServiceProvider) MetadataHandler(w http.ResponseWriter, r *http.Request) {
+	out, err := sp.MetadataXML()
+	if err != nil {
+		sp.internalErr(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/xml; charset=utf8")
+	w.Write([]byte("<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n"))
+	w.Write(out)
+}
+
+// AuthnRequestHandler redirects the user agent to the IdP in order to start
+// an SP-initiated login. The RelayState is read from the "saml.RelayState"
+// context value, if any. When the IdP does not support the HTTP-Redirect
+// binding but supports the HTTP-POST binding, the AuthnRequest is posted
+// with a form rendered by WritePostForm instead. When the SP has IdPs, the
+// IdP is the one named by the IdPHint of the SP or by the IdPHintParam
+// parameter, such as "/saml/login?idp=https%3A%2F%2Fidp.example.org", if
+// any. Otherwise, the user chooses the IdP first, with the discovery
+// service or on the IdP selection page, unless the choice was remembered.
+func (sp *ServiceProvider) AuthnRequestHandler(w http.ResponseWriter, r *http.Request) {
+	relayState, _ := r.Context().Value("saml.RelayState").(string)
+	if sp.IdPs != nil {
+		if entityID := sp.idpHint(r); entityID != "" {
+			idpSP, err := sp.ForIdP(entityID)
+			if err != nil {
+				sp.clientErr(w, r, err)
+				return
+			}
+			idpSP.SendAuthnRequest(w, r, relayState)
+			return
+		}
+		if idpSP := sp.rememberedIdP(r); idpSP != nil {
+			idpSP.SendAuthnRequest(w, r, relayState)
+			return
+		}
+		if sp.DiscoveryURL == "" {
+			sp.serveIdPSelection(w, r, relayState)
+			return
+		}
+		discoveryURL, err := sp.DiscoveryRequestURL(relayState)
+		if err != nil {
+			sp.internalErr(w, r, err)
+			return
+		}
+		http.Redirect(w, r, discoveryURL, http.StatusFound)
+		return
 	}
-	return responseIDs
+	sp.SendAuthnRequest(w, r, relayState)
 }
 
-func (sp *ServiceProvider) verifySignature(plaintextMessage []byte) error {
-	idpCertFile, err := sp.GetIdPCertFile()
+// SendAuthnRequest sends an AuthnRequest to the IdP through the user agent,
+// redirected or with a posted form like AuthnRequestHandler, and tracks it
+// with the RequestTracker of the SP, so that AssertionMiddleware accepts its
+// response. Options are passed to NewAuthnRequest.
+func (sp *ServiceProvider) SendAuthnRequest(w http.ResponseWriter, r *http.Request, relayState string, opts ...AuthnRequestOption) {
+	sp.sendAuthnRequest(w, r, relayState, TrackedRequest{}, opts...)
+}
+
+// sendAuthnRequest is SendAuthnRequest, tracking the request with the
+// requirements of tracked on its response.
+func (sp *ServiceProvider) sendAuthnRequest(w http.ResponseWriter, r *http.Request, relayState string, tracked TrackedRequest, opts ...AuthnRequestOption) {
+	tracker, err := sp.requestTracker()
+	if err != nil {
+		sp.internalErr(w, r, err)
+		return
+	}
+	idpMetadata, err := sp.GetIdPMetadataContext(r.Context())
+	if err != nil {
+		sp.internalErr(w, r, err)
+		return
+	}
+	tracked.IdP = idpMetadata.EntityID
+	opts = append(opts, func(req *AuthnRequest) {
+		tracked.ID = req.ID
+		if req.Subject != nil {
+			tracked.Subject = req.Subject.NameID
+		}
+	})
+
+	if _, err := sp.idpSSOLocation(r.Context(), HTTPRedirectBinding); err != nil {
+		if _, err := sp.idpSSOLocation(r.Context(), HTTPPostBinding); err == nil {
+			form, err := sp.authnRequestForm(r.Context(), relayState, opts...)
+			if err != nil {
+				sp.internalErr(w, r, err)
+				return
+			}
+			if err := tracker.TrackRequest(w, r, tracked); err != nil {
+				sp.internalErr(w, r, err)
+				return
+			}
+			if err := WritePostForm(w, *form, sp.PostFormTemplate); err != nil {
+				sp.internalErr(w, r, err)
+			}
+			return
+		}
+	}
+
+	redirectURL, err := sp.authnRequestURL(r.Context(), relayState, opts...)
+	if err != nil {
+		sp.internalErr(w, r, err)
+		return
+	}
+	if err := tracker.TrackRequest(w, r, tracked); err != nil {
+		sp.internalErr(w, r, err)
+		return
+	}
+
+	http.Redirect(w, r, redirectURL, http.StatusFound)
+}
+
+// AssertionMiddleware validates the SAMLResponse posted to the ACS and passes
+// the resulting assertion to next in the request context, see
+// GetAssertionFromCtx.
+func (sp *ServiceProvider) AssertionMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		clientIP, clientCert := sp.client(r)
+
+		// The requests rejected before their response is validated are
+		// audited as well.
+		reject := func(err error) {
+			sp.audit(r, &ValidationResult{}, err, clientIP)
+			sp.clientErr(w, r, err)
+		}
+
+		if sp.RateLimit != nil && !sp.RateLimit.allow(remoteIP(r, clientIP)) {
+			sp.audit(r, &ValidationResult{}, ErrRateLimited, clientIP)
+			sp.fail(r, ClientFailure, ErrRateLimited)
+			w.Header().Set("Retry-After", "1")
+			sp.writeErr(w, r, http.StatusTooManyRequests, ErrRateLimited)
+			return
+		}
+
+		// Leave some room for the RelayState and the form encoding.
+		r.Body = http.MaxBytesReader(w, r.Body, 2*sp.maxMessageSize())
+		if err := sp.parsePostForm(r); err != nil {
+			if _, ok := err.(*ValidationError); !ok {
+				err = validationErrorf(ErrMalformedResponse, err, "failed to parse form")
+			}
+			reject(err)
+			return
+		}
+
+		samlResponse := r.PostForm.Get("SAMLResponse")
+		if samlResponse == "" {
+			reject(validationErrorf(ErrMalformedResponse, nil, "missing SAMLResponse"))
+			return
+		}
+
+		// Responses sent with the HTTP-POST-SimpleSign binding carry a
+		// signature of the form values.
+		simpleSig, err := DecodeSimpleSign(r.PostForm)
+		if err != nil {
+			reject(validationErrorf(ErrMalformedResponse, err, "invalid SimpleSign signature"))
+			return
+		}
+
+		// The RelayState is bound to the request the response answers,
+		// one of those tracked for the user agent. The RelayState of the
+		// unsolicited responses is set by the IdP: it is mapped by the
+		// IdPInitiated policy once the response is known to be
+		// unsolicited.
+		requests := sp.possibleResponses(r)
+		rawRelayState := r.PostForm.Get("RelayState")
+		relayState, relayStateErr := sp.verifyRelayState(rawRelayState, requestIDs(requests)...)
+		if relayStateErr != nil && (sp.IdPInitiated == nil || !sp.AllowIdpInitiated) {
+			reject(relayStateErr)
+			return
+		}
+
+		result := sp.assertResponse(r.Context(), samlResponse, simpleSig, requests, clientIP, clientCert)
+		assertion, err := result.Assertion, result.Err()
+		if relayStateErr == nil && result.Response != nil {
+			relayState, relayStateErr = sp.verifyRelayState(rawRelayState, result.Response.InResponseTo)
+		}
+		auditErr := err
+		if err == nil && result.Response.InResponseTo != "" && relayStateErr != nil {
+			// The solicited responses are rejected below when their
+			// RelayState is invalid.
+			auditErr = relayStateErr
+		}
+		sp.audit(r, result, auditErr, clientIP)
+		if err != nil {
+			var statusErr *StatusError
+			if errors.As(err, &statusErr) {
+				sp.handleStatus(w, r, err, statusErr, relayState)
+				return
+			}
+			if sp.ForceAuthnOnMaxSSOAge && errors.Is(err, ErrAuthnTooOld) {
+				sp.logger().Debug("authentication is too old, asking the IdP to authenticate again", "error", err)
+				sp.SendAuthnRequest(w, r, relayState, WithForceAuthn())
+				return
+			}
+			if _, ok := err.(*ValidationError); ok {
+				sp.clientErr(w, r, err)
+				return
+			}
+			sp.internalErr(w, r, err)
+			return
+		}
+
+		if inResponseTo := result.Response.InResponseTo; inResponseTo != "" {
+			if relayStateErr != nil {
+				sp.clientErr(w, r, relayStateErr)
+				return
+			}
+			// The request is answered: its response must not be accepted
+			// again.
+			if tracker, err := sp.requestTracker(); err == nil {
+				if err := tracker.StopTrackingRequest(w, r, inResponseTo); err != nil {
+					sp.logger().Error("failed to stop tracking request", "id", inResponseTo, "err", err)
+				}
+			}
+		} else if sp.IdPInitiated != nil {
+			relayState = sp.IdPInitiated.target(sp, rawRelayState)
+		}
+
+		if sp.CommonDomain != "" && assertion.Issuer != nil {
+			WriteCommonDomainCookie(w, r, sp.CommonDomain, assertion.Issuer.Value)
+		}
+
+		ctx := context.WithValue(r.Context(), assertionContextKey, assertion)
+		ctx = context.WithValue(ctx, relayStateContextKey, relayState)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// client returns the IP address and the TLS client certificate of the user
+// agent of r, when the AddressCheck and the HolderOfKeyCheck need them.
+func (sp *ServiceProvider) client(r *http.Request) (net.IP, *x509.Certificate) {
+	var clientIP net.IP
+	if sp.AddressCheck != nil {
+		clientIP = sp.AddressCheck.ClientIP(r)
+	}
+	var clientCert *x509.Certificate
+	if sp.HolderOfKeyCheck != nil {
+		clientCert = sp.HolderOfKeyCheck.ClientCertificate(r)
+	}
+	return clientIP, clientCert
+}
+
+// parsePostForm sets r.PostForm, unless it was already parsed, to the form
+// posted to the ACS, read with readPostForm to avoid the copies of the
+// SAMLResponse made by Request.ParseForm.
+func (sp *ServiceProvider) parsePostForm(r *http.Request) error {
+	if r.PostForm != nil {
+		return nil
+	}
+	r.PostForm = url.Values{}
+	ct := r.Header.Get("Content-Type")
+	if r.Method != http.MethodPost || ct == "" {
+		return nil
+	}
+	mediaType, _, err := mime.ParseMediaType(ct)
 	if err != nil {
 		return err
 	}
+	if mediaType != "application/x-www-form-urlencoded" {
+		return nil
+	}
+	form, err := readPostForm(r.Body, "SAMLResponse", sp.maxMessageSize(), r.ContentLength)
+	if err != nil {
+		return err
+	}
+	r.PostForm = form
+	return nil
+}
+
+// handleStatus serves a response whose status is not a success:
+//   - NoPassive: the user is not logged in at the IdP, which was asked not to
+//     interact with them; if the response is signed, an interactive login is
+//     started, with the same RelayState, otherwise it is reported with the
+//     400 status;
+//   - AuthnFailed and RequestDenied: the IdP refused to log the user in, which
+//     is reported with the 403 status;
+//   - other statuses are reported with the 400 status.
+//
+// The error handler may render the StatusError, e.g. with its UserMessage.
+func (sp *ServiceProvider) handleStatus(w http.ResponseWriter, r *http.Request, err error, statusErr *StatusError, relayState string) {
+	switch statusErr.SubCode {
+	case StatusNoPassive:
+		if !statusErr.Signed {
+			sp.clientErr(w, r, err)
+			return
+		}
+		sp.logger().Debug("passive login failed, starting an interactive login", "status", statusErr.Code)
+		sp.SendAuthnRequest(w, r, relayState)
+	case StatusAuthnFailed, StatusRequestDenied:
+		sp.fail(r, ClientFailure, err)
+		sp.writeErr(w, r, http.StatusForbidden, err)
+	default:
+		sp.clientErr(w, r, err)
+	}
+}
+
+// verifyStatusSignature verifies the signature of a response whose status
+// is not a success, and reports whether it is signed. The signatures of the
+// assertions, which such a response should not carry, are left to the
+// checks of the successful responses.
+func (sp *ServiceProvider) verifyStatusSignature(ctx context.Context, res *Response, samlResponseXML []byte, simpleSig *SimpleSignature) (bool, error) {
+	switch {
+	case simpleSig != nil:
+		if err := sp.verifySimpleSign(ctx, simpleSig); err != nil {
+			return false, err
+		}
+		return true, nil
+	case res.Signature != nil:
+		if err := validateSignedNode(res.Signature, res.ID); err != nil {
+			return false, validationErrorf(ErrInvalidSignature, err, "failed to validate Response + Signature")
+		}
+		// The structure checks guarantee that the response signature is
+		// the first one of the document.
+		if err := sp.verifySignature(ctx, samlResponseXML, ""); err != nil {
+			return false, validationErrorf(ErrInvalidSignature, err, "unable to verify message signature")
+		}
+		return true, nil
+	}
+	return false, nil
+}
+
+// responseIssuer returns the issuer of res, or else of its assertion.
+func responseIssuer(res *Response) string {
+	switch {
+	case res.Issuer != nil:
+		return res.Issuer.Value
+	case res.Assertion != nil && res.Assertion.Issuer != nil:
+		return res.Assertion.Issuer.Value
+	}
+	return ""
+}
 
+// responseLogFields returns the fields of a response that are safe to log:
+// they identify the message without revealing the user's attributes.
+func responseLogFields(res *Response) []interface{} {
+	issuer, status, subStatus := "", "", ""
+	if res.Issuer != nil {
+		issuer = res.Issuer.Value
+	}
+	if res.Status != nil {
+		status, subStatus = res.Status.StatusCode.Value, res.Status.subCode()
+	}
+	fields := []interface{}{
+		"id", res.ID,
+		"in_response_to", res.InResponseTo,
+		"issuer", issuer,
+		"destination", res.Destination,
+		"status", status,
+	}
+	if subStatus != "" {
+		fields = append(fields, "sub_status", subStatus)
+	}
+	return fields
+}
+
+// verifySignature verifies the first signature of the node with the given ID,
+// or of the whole document if nodeID is empty.
+func (sp *ServiceProvider) verifySignature(ctx context.Context, plaintextMessage []byte, nodeID string) error {
+	idpCertFile, err := sp.signatureCertFile(ctx, plaintextMessage, nodeID)
+	if err != nil {
+		return err
+	}
+
+	start := time.Now()
 	err = xmlsec.Verify(plaintextMessage, idpCertFile, &xmlsec.ValidationOptions{
 		DTDFile: sp.DTDFile,
+		// Without a DTD, xmlsec1 must be told which attributes are IDs.
+		EnableIDAttrHack: sp.DTDFile == "",
+		NodeID:           nodeID,
 	})
+	sp.metrics().SignatureVerified(time.Since(start), err)
 	if err == nil {
 		// No error, this message is OK
 		return nil
@@ -102,65 +598,236 @@ func (sp *ServiceProvider) verifySignature(plaintextMessage []byte) error {
 	return err
 }
 
-func (sp *ServiceProvider) AssertResponse(samlResponse string) (*Assertion, error) {
-	now := Now()
+// verifySimpleSign verifies the signature of a message received with the
+// HTTP-POST-SimpleSign binding with the IdP certificate.
+func (sp *ServiceProvider) verifySimpleSign(ctx context.Context, sig *SimpleSignature) error {
+	idpCertFile, err := sp.GetIdPCertFileContext(ctx)
+	if err != nil {
+		return err
+	}
+	cert, err := retriveCertificate(idpCertFile)
+	if err != nil {
+		return errors.Wrap(err, "failed to read IdP certificate")
+	}
+	start := time.Now()
+	err = sig.Verify(cert)
+	sp.metrics().SignatureVerified(time.Since(start), err)
+	return err
+}
 
+// AssertResponse validates a base64-encoded SAML response posted to the ACS
+// with r and returns its assertion. Like AssertionMiddleware, it only
+// accepts the responses answering an AuthnRequest tracked for the user agent
+// of r, and those without InResponseTo with AllowIdpInitiated. Unlike
+// AssertionMiddleware, it does not stop tracking the answered request.
+func (sp *ServiceProvider) AssertResponse(r *http.Request, samlResponse string) (*Assertion, error) {
+	clientIP, clientCert := sp.client(r)
+	result := sp.assertResponse(r.Context(), samlResponse, nil, sp.possibleResponses(r), clientIP, clientCert)
+	if err := result.Err(); err != nil {
+		return nil, err
+	}
+	return result.Assertion, nil
+}
+
+// assertResponse is AssertResponse with the context of the request, for
+// tracing and the retrieval of the IdP metadata, and the IP address and TLS
+// client certificate of the user agent that posted the response, for the
+// AddressCheck and the HolderOfKeyCheck.
+func (sp *ServiceProvider) assertResponse(ctx context.Context, samlResponse string, simpleSig *SimpleSignature, requests []TrackedRequest, clientIP net.IP, clientCert *x509.Certificate) *ValidationResult {
+	ctx, span := sp.tracer().Start(ctx, SpanAssertResponse)
+	result := sp.validateResponse(ctx, samlResponse, simpleSig, requests, sp.now(), clientIP, clientCert, true)
+	if result.Response != nil {
+		setResponseAttributes(span, result.Response)
+	}
+	span.End(result.Err())
+	sp.metrics().ResponseValidated(result.Err())
+	return result
+}
+
+// ParseResponse decodes and validates a base64-encoded SAML response at the
+// given time and returns its assertion. possibleRequestIDs lists the IDs of
+// the AuthnRequests the response may answer; an empty string in the list
+// accepts IdP-initiated responses. It does not depend on net/http and can be
+// used outside of an HTTP handler.
+func (sp *ServiceProvider) ParseResponse(samlResponse string, possibleRequestIDs []string, now time.Time) (*Assertion, error) {
+	result := sp.validateResponse(context.Background(), samlResponse, nil, untrackedRequests(possibleRequestIDs), now, nil, nil, true)
+	sp.metrics().ResponseValidated(result.Err())
+	if err := result.Err(); err != nil {
+		return nil, err
+	}
+	return result.Assertion, nil
+}
+
+// ValidateResponse runs every check ParseResponse would run, without stopping
+// at the first failure, and reports the outcome of each of them. It is meant
+// to help debugging IdP misconfigurations: the returned assertion must not be
+// trusted unless the result has no failures.
+func (sp *ServiceProvider) ValidateResponse(samlResponse string, possibleRequestIDs []string, now time.Time) *ValidationResult {
+	return sp.validateResponse(context.Background(), samlResponse, nil, untrackedRequests(possibleRequestIDs), now, nil, nil, false)
+}
+
+// validateResponse validates samlResponse. simpleSig is the signature of the
+// response if it was received with the HTTP-POST-SimpleSign binding. ctx
+// bounds the retrieval of the IdP metadata. requests are the requests the
+// response may answer, a request without ID accepting the responses without
+// InResponseTo.
+func (sp *ServiceProvider) validateResponse(ctx context.Context, samlResponse string, simpleSig *SimpleSignature, requests []TrackedRequest, now time.Time, clientIP net.IP, clientCert *x509.Certificate, failFast bool) *ValidationResult {
+	possibleRequestIDs := requestIDs(requests)
+	v := &validator{
+		failFast: failFast,
+		result:   &ValidationResult{},
+	}
+
+	if max := sp.maxMessageSize(); int64(len(samlResponse)) > max {
+		v.fatal(CheckDecode, validationErrorf(ErrMessageTooLarge, nil, "SAML response is larger than %d bytes", max))
+		return v.result
+	}
+
+	// The response is decoded once, but parsed twice, by
+	// checkSignedStructure and by xml.Unmarshal: encoding/xml only fills the
+	// innerxml fields, such as EncryptedData, when decoding bytes rather
+	// than checked tokens. xmlsec1 also reads it from a temporary file.
 	samlResponseXML, err := base64.StdEncoding.DecodeString(samlResponse)
 	if err != nil {
-		return nil, errors.Wrapf(err, "failed to base64-decode SAML response")
+		v.fatal(CheckDecode, validationErrorf(ErrMalformedResponse, err, "failed to base64-decode SAML response"))
+		return v.result
+	}
+
+	if max := sp.maxXMLSize(); int64(len(samlResponseXML)) > max {
+		v.fatal(CheckDecode, validationErrorf(ErrMessageTooLarge, nil, "SAML response XML is larger than %d bytes", max))
+		return v.result
+	}
+
+	if sp.LogPayloads {
+		sp.logger().Debug("SAML response payload", "base64", samlResponse, "xml", string(samlResponseXML))
+	}
+
+	if err := checkSignedStructure(samlResponseXML, samlpNamespace, "Response"); err != nil {
+		msg := "invalid XML document"
+		if _, ok := err.(*structureError); ok {
+			msg = "unexpected document structure"
+		}
+		v.fatal(CheckDecode, validationErrorf(ErrMalformedResponse, err, "%s", msg))
+		return v.result
+	}
+
+	if sp.SchemaFile != "" {
+		if err := sp.ValidateSchema(samlResponseXML); err != nil {
+			var schemaErr *SchemaError
+			if errors.As(err, &schemaErr) {
+				err = validationErrorf(ErrMalformedResponse, err, "")
+			}
+			v.fatal(CheckSchema, err)
+			return v.result
+		}
+		v.pass(CheckSchema)
 	}
 
 	var res Response
 	err = xml.Unmarshal(samlResponseXML, &res)
 	if err != nil {
-		return nil, errors.Wrapf(err, "failed to unmarshal XML document: %s", string(samlResponseXML))
+		v.fatal(CheckDecode, validationErrorf(ErrMalformedResponse, err, "failed to unmarshal XML document"))
+		return v.result
+	}
+	v.pass(CheckDecode)
+	v.result.Response = &res
+	sp.logger().Debug("SAML response received", responseLogFields(&res)...)
+
+	if sp.IdPs != nil {
+		// The response is validated against the IdP that issued it.
+		idpSP, err := sp.ForIdP(responseIssuer(&res))
+		if err != nil {
+			v.fatal(CheckIdPMetadata, validationErrorf(ErrIssuerMismatch, err, ""))
+			return v.result
+		}
+		sp = idpSP
 	}
 
 	// TODO: Do we really need to check the IdP metadata here?
-	if _, err := sp.GetIdPMetadata(); err != nil {
-		return nil, errors.Wrap(err, "unable to retrieve IdP metadata")
+	idpMetadata, err := sp.GetIdPMetadataContext(ctx)
+	if err != nil {
+		v.fatal(CheckIdPMetadata, errors.Wrap(err, "unable to retrieve IdP metadata"))
+		return v.result
 	}
+	v.pass(CheckIdPMetadata)
 
 	// Validate message.
 
-	if res.Destination != sp.AcsURL {
-		// Note: OneLogin triggers this error when the Recipient field
-		// is left blank (or when not set to the correct ACS endpoint)
-		// in the OneLogin SAML configuration page. OneLogin returns
-		// Destination="{recipient}" in the SAML reponse in this case.
-		return nil, errors.Errorf("Wrong ACS destination, expected %q, got %q", sp.AcsURL, res.Destination)
-	}
-
-	if sp.IdPMetadata.EntityID != "" {
-		if res.Issuer == nil {
-			return nil, errors.New(`Issuer does not match expected entity ID: Missing "Issuer" node`)
-		}
-		if res.Issuer.Value != sp.IdPMetadata.EntityID {
-			return nil, errors.Errorf("Issuer does not match expected entity ID: expected %q, got %q", sp.IdPMetadata.EntityID, res.Issuer.Value)
+	switch {
+	case res.Destination == "" && res.Signature == nil && simpleSig == nil && sp.DestinationPolicy == DestinationRequiredIfSigned:
+		// The Destination is only required on signed responses (section
+		// 3.5.5.2 of saml-bindings-2.0-os).
+		v.warn(CheckDestination, errors.New("unsigned response has no Destination"))
+	case res.Destination == recipientPlaceholder && sp.AcceptRecipientPlaceholder:
+		v.warn(CheckDestination, errors.New(recipientPlaceholderHint))
+	case !sp.isAcsURL(res.Destination):
+		err := validationErrorf(ErrWrongDestination, nil, "expected %q, got %q", sp.AcsURL, res.Destination)
+		if res.Destination == recipientPlaceholder {
+			err = validationErrorf(ErrWrongDestination, nil, "expected %q, got %q: %s", sp.AcsURL, res.Destination, recipientPlaceholderHint)
 		}
+		v.fail(CheckDestination, err)
+	default:
+		v.pass(CheckDestination)
+	}
+	if v.stop() {
+		return v.result
 	}
 
-	if res.Status.StatusCode.Value != "urn:oasis:names:tc:SAML:2.0:status:Success" {
-		return nil, errors.Errorf("Unexpected status code: %v", res.Status.StatusCode.Value)
+	// With several IdPs, the response must be issued by the IdP the
+	// request was sent to.
+	switch req := findRequest(requests, res.InResponseTo); {
+	case req != nil && req.IdP != "" && responseIssuer(&res) != req.IdP:
+		v.fail(CheckIssuer, validationErrorf(ErrIssuerMismatch, nil, "request %q was sent to %q, got a response from %q", req.ID, req.IdP, responseIssuer(&res)))
+	case idpMetadata.EntityID == "":
+		v.warn(CheckIssuer, errors.New("IdP metadata has no entity ID, skipping issuer validation"))
+	case res.Issuer == nil:
+		v.fail(CheckIssuer, validationErrorf(ErrIssuerMismatch, nil, `missing "Issuer" node`))
+	case res.Issuer.Value != idpMetadata.EntityID:
+		v.fail(CheckIssuer, validationErrorf(ErrIssuerMismatch, nil, "expected %q, got %q", idpMetadata.EntityID, res.Issuer.Value))
+	default:
+		v.pass(CheckIssuer)
+	}
+	if v.stop() {
+		return v.result
 	}
 
-	expectedResponse := false
-	responseIDs := sp.possibleResponseIDs()
-	for i := range responseIDs {
-		if responseIDs[i] == res.InResponseTo {
-			expectedResponse = true
+	switch {
+	case res.Status == nil:
+		v.fail(CheckStatus, validationErrorf(ErrStatusNotSuccess, nil, "missing Response > Status"))
+	case res.Status.StatusCode.Value != "urn:oasis:names:tc:SAML:2.0:status:Success":
+		// The status may start a new login, see handleStatus: the
+		// signature of the response, if any, is verified first.
+		signed, err := sp.verifyStatusSignature(ctx, &res, samlResponseXML, simpleSig)
+		if err != nil {
+			v.fatal(CheckSignature, err)
+			return v.result
 		}
+		statusErr := newStatusError(res.Status)
+		statusErr.Signed = signed
+		v.fail(CheckStatus, &ValidationError{Kind: ErrStatusNotSuccess, cause: statusErr})
+	default:
+		v.pass(CheckStatus)
 	}
-	if len(responseIDs) == 1 && responseIDs[0] == "" {
-		expectedResponse = true
+	if v.stop() {
+		return v.result
 	}
-	if !expectedResponse && len(responseIDs) > 0 {
-		return nil, errors.Errorf("Expecting a proper InResponseTo value, got %#v", responseIDs)
+
+	if !isExpectedResponseID(possibleRequestIDs, res.InResponseTo) {
+		v.fail(CheckInResponseTo, validationErrorf(ErrUnexpectedInResponseTo, nil, "expecting one of %#v, got %q", possibleRequestIDs, res.InResponseTo))
+	} else {
+		v.pass(CheckInResponseTo)
+	}
+	if v.stop() {
+		return v.result
 	}
 
-	// Try getting the IdP's cert file before using it.
-	if _, err := sp.GetIdPCertFile(); err != nil {
-		return nil, errors.Wrap(err, "failed to get private key")
+	// Try getting the IdP's cert file before using it. With TrustPKIX, the
+	// certificates are those of the signatures.
+	if sp.TrustModel != TrustPKIX {
+		if _, err := sp.GetIdPCertFileContext(ctx); err != nil {
+			v.fatal(CheckSignature, errors.Wrap(err, "failed to get private key"))
+			return v.result
+		}
 	}
 
 	// Validate signatures
@@ -168,104 +835,274 @@ func (sp *ServiceProvider) AssertResponse(samlResponse string) (*Assertion, erro
 	if res.Signature != nil {
 		err := validateSignedNode(res.Signature, res.ID)
 		if err != nil {
-			return nil, errors.Wrap(err, "failed to validate Response + Signature")
+			v.fatal(CheckSignature, validationErrorf(ErrInvalidSignature, err, "failed to validate Response + Signature"))
+			return v.result
 		}
 	}
 
 	if res.Assertion != nil && res.Assertion.Signature != nil {
 		err := validateSignedNode(res.Assertion.Signature, res.Assertion.ID)
 		if err != nil {
-			return nil, errors.Wrap(err, "failed to validate Assertion + Signature")
+			v.fatal(CheckSignature, validationErrorf(ErrInvalidSignature, err, "failed to validate Assertion + Signature"))
+			return v.result
 		}
 	}
 
-	// Validating message.
-	signatureOK := false
+	// Validating message. The structure checks guarantee that the response
+	// signature, if any, is the first one of the document.
+	responseSigned, assertionSigned := false, false
 
 	if res.Signature != nil || (res.Assertion != nil && res.Assertion.Signature != nil) {
-		err := sp.verifySignature(samlResponseXML)
+		err := sp.verifySignature(ctx, samlResponseXML, "")
 		if err != nil {
-			return nil, errors.Wrap(err, "Unable to verify message signature")
-		} else {
-			signatureOK = true
+			v.fatal(CheckSignature, validationErrorf(ErrInvalidSignature, err, "unable to verify message signature"))
+			return v.result
+		}
+		responseSigned = res.Signature != nil
+		assertionSigned = !responseSigned
+	}
+
+	// The assertion signature is covered by the response signature, verify
+	// it on its own only if the policy requires it.
+	if responseSigned && res.Assertion != nil && res.Assertion.Signature != nil && sp.SigningPolicy.requiresAssertion() {
+		err := sp.verifySignature(ctx, samlResponseXML, res.Assertion.ID)
+		if err != nil {
+			v.fatal(CheckSignature, validationErrorf(ErrInvalidSignature, err, "unable to verify assertion signature"))
+			return v.result
+		}
+		assertionSigned = true
+	}
+
+	// A SimpleSign signature covers the whole response.
+	if simpleSig != nil {
+		if err := sp.verifySimpleSign(ctx, simpleSig); err != nil {
+			v.fatal(CheckSignature, err)
+			return v.result
 		}
+		responseSigned = true
 	}
 
 	// Retrieve assertion
 	var assertion *Assertion
+	assertionXML := samlResponseXML
 
 	if res.EncryptedAssertion != nil {
 		keyFile, err := sp.PrivkeyFile()
 		if err != nil {
-			return nil, errors.Errorf("Failed to get private key: %v", err)
+			v.fatal(CheckDecrypt, errors.Errorf("Failed to get private key: %v", err))
+			return v.result
 		}
 
 		plainTextAssertion, err := xmlsec.Decrypt(res.EncryptedAssertion.EncryptedData, keyFile)
 		if err != nil {
 			if IsSecurityException(err, &sp.SecurityOpts) {
-				return nil, errors.Wrap(err, "Unable to decrypt message")
+				v.fatal(CheckDecrypt, validationErrorf(ErrDecryption, err, "unable to decrypt message"))
+				return v.result
 			}
 		}
 
+		if err := checkSignedStructure(plainTextAssertion, samlNamespace, "Assertion"); err != nil {
+			msg := "invalid assertion XML document"
+			if _, ok := err.(*structureError); ok {
+				msg = "unexpected assertion structure"
+			}
+			v.fatal(CheckDecrypt, validationErrorf(ErrDecryption, err, "%s", msg))
+			return v.result
+		}
+		if err := sp.ValidateSchema(plainTextAssertion); err != nil {
+			var schemaErr *SchemaError
+			if errors.As(err, &schemaErr) {
+				err = validationErrorf(ErrMalformedResponse, err, "decrypted assertion")
+			}
+			v.fatal(CheckSchema, err)
+			return v.result
+		}
+
 		assertion = &Assertion{}
 		if err := xml.Unmarshal(plainTextAssertion, assertion); err != nil {
-			return nil, errors.Wrap(err, "Unable to parse assertion")
+			v.fatal(CheckDecrypt, validationErrorf(ErrDecryption, err, "unable to parse assertion"))
+			return v.result
 		}
+		v.pass(CheckDecrypt)
 
 		if assertion.Signature != nil {
 			err := validateSignedNode(assertion.Signature, assertion.ID)
 			if err != nil {
-				return nil, errors.Wrap(err, "failed to validate Assertion + Signature")
+				v.fatal(CheckSignature, validationErrorf(ErrInvalidSignature, err, "failed to validate Assertion + Signature"))
+				return v.result
 			}
 
-			err = sp.verifySignature(plainTextAssertion)
+			err = sp.verifySignature(ctx, plainTextAssertion, "")
 			if err != nil {
-				return nil, errors.Wrapf(err, "Unable to verify assertion signature")
-			} else {
-				signatureOK = true
+				v.fatal(CheckSignature, validationErrorf(ErrInvalidSignature, err, "unable to verify assertion signature"))
+				return v.result
 			}
+			assertionSigned = true
 		}
+		assertionXML = plainTextAssertion
 	} else {
 		assertion = res.Assertion
 	}
 	if assertion == nil {
-		return nil, errors.New("Missing assertion")
+		v.fatal(CheckAssertion, validationErrorf(ErrMissingAssertion, nil, ""))
+		return v.result
 	}
+	v.result.Assertion = assertion
 
-	// Did we receive a signature?
-	if !signatureOK {
-		return nil, errors.New("Unable to validate signature: node not found")
+	// Did we receive the signatures we expect?
+	if err := sp.SigningPolicy.check(responseSigned, assertionSigned); err != nil {
+		v.fatal(CheckSignature, validationErrorf(ErrMissingSignature, nil, "%v", err))
+		return v.result
+	}
+	v.pass(CheckSignature)
+	if !responseSigned && sp.SigningPolicy == SigningPolicyEither {
+		v.warn(CheckSignature, errors.New("Response is not signed, only the assertion is"))
+	}
+
+	// Make sure the assertion consumed is the very node that was signed.
+	if assertion.Signature != nil {
+		if id := strings.TrimPrefix(assertion.Signature.Reference.URI, "#"); id != "" {
+			assertion = &Assertion{}
+			if err := decodeElementByID(assertionXML, id, assertion); err != nil {
+				v.fatal(CheckSignature, validationErrorf(ErrInvalidSignature, err, "unable to decode signed assertion"))
+				return v.result
+			}
+			v.result.Assertion = assertion
+		}
 	}
 
+	// Decrypt the encrypted parts of the assertion.
+	decrypted := false
+	if subject := assertion.Subject; subject != nil && subject.NameID == nil && subject.EncryptedID != nil {
+		nameID, err := sp.DecryptNameID(subject.EncryptedID)
+		if err != nil {
+			v.fatal(CheckDecrypt, validationErrorf(ErrDecryption, err, "unable to decrypt subject"))
+			return v.result
+		}
+		subject.NameID = nameID
+		decrypted = true
+	}
+
+	if stmt := assertion.AttributeStatement; stmt != nil && len(stmt.EncryptedAttributes) > 0 {
+		for i := range stmt.EncryptedAttributes {
+			attr, err := sp.DecryptAttribute(&stmt.EncryptedAttributes[i])
+			if err != nil {
+				v.fatal(CheckDecrypt, validationErrorf(ErrDecryption, err, "unable to decrypt attribute"))
+				return v.result
+			}
+			stmt.Attributes = append(stmt.Attributes, *attr)
+		}
+		decrypted = true
+	}
+	if decrypted && res.EncryptedAssertion == nil {
+		v.pass(CheckDecrypt)
+	}
+	sp.renameAttributes(assertion)
+
 	// Validate assertion.
 	switch {
-	case sp.IdPMetadata.EntityID == "":
-		// Skip issuer validation
-	case res.Issuer == nil:
-		return nil, errors.New(`Assertion issuer does not match expected entity ID: missing Assertion > Issuer`)
-	case assertion.Issuer.Value != sp.IdPMetadata.EntityID:
-		return nil, errors.Errorf("Assertion issuer does not match expected entity ID: Expected %q, got %q", sp.IdPMetadata.EntityID, assertion.Issuer.Value)
+	case idpMetadata.EntityID == "":
+		// Skip issuer validation, a warning was already reported.
+	case assertion.Issuer == nil:
+		v.fail(CheckAssertionIssuer, validationErrorf(ErrIssuerMismatch, nil, "missing Assertion > Issuer"))
+	case assertion.Issuer.Value != idpMetadata.EntityID:
+		v.fail(CheckAssertionIssuer, validationErrorf(ErrIssuerMismatch, nil, "assertion issuer expected %q, got %q", idpMetadata.EntityID, assertion.Issuer.Value))
+	default:
+		v.pass(CheckAssertionIssuer)
+	}
+	if v.stop() {
+		return v.result
 	}
 
-	// Validate recipient
+	// Select the subject confirmation to use: the first bearer or, with the
+	// HolderOfKeyCheck, holder-of-key confirmation satisfying all the
+	// checks, or else the first of them so that its issues are reported.
+	drift := sp.clockDrift()
+	var confirmation *SubjectConfirmation
 	{
 		var err error
 		switch {
 		case assertion.Subject == nil:
 			err = errors.New(`missing Assertion > Subject`)
-		case assertion.Subject.SubjectConfirmation == nil:
+		case len(assertion.Subject.SubjectConfirmations) == 0:
 			err = errors.New(`missing Assertion > Subject > SubjectConfirmation`)
-		case assertion.Subject.SubjectConfirmation.SubjectConfirmationData.Recipient != sp.AcsURL:
-			err = errors.Errorf("unexpected assertion recipient, expected %q, got %q", sp.AcsURL, assertion.Subject.SubjectConfirmation.SubjectConfirmationData.Recipient)
 		}
 		if err != nil {
-			return nil, errors.Wrapf(err, "invalid assertion recipient")
+			v.fatal(CheckRecipient, validationErrorf(ErrWrongRecipient, err, ""))
+			return v.result
+		}
+
+		for i := range assertion.Subject.SubjectConfirmations {
+			sc := &assertion.Subject.SubjectConfirmations[i]
+			if !sp.HolderOfKeyCheck.accepts(sc.Method) {
+				continue
+			}
+			if confirmation == nil {
+				confirmation = sc
+			}
+			if sp.confirmsSubject(sc, possibleRequestIDs, now, drift) && sp.checkAddress(sc, clientIP) == nil && sp.checkHolderOfKey(sc, clientCert) == nil {
+				confirmation = sc
+				break
+			}
+		}
+		switch {
+		case confirmation != nil:
+		case sp.HolderOfKeyCheck.accepts(SubjectConfirmationMethodBearer):
+			v.fatal(CheckSubjectConfirmation, validationErrorf(ErrNoBearerConfirmation, nil, "expected method %q", SubjectConfirmationMethodBearer))
+			return v.result
+		default:
+			v.fatal(CheckHolderOfKey, validationErrorf(ErrWrongKey, nil, "expected method %q", SubjectConfirmationMethodHolderOfKey))
+			return v.result
+		}
+	}
+
+	// Validate recipient
+	switch recipient := confirmation.SubjectConfirmationData.Recipient; {
+	case recipient == recipientPlaceholder && sp.AcceptRecipientPlaceholder:
+		v.warn(CheckRecipient, errors.New(recipientPlaceholderHint))
+	case !sp.isAcsURL(recipient):
+		err := errors.Errorf("unexpected assertion recipient, expected %q, got %q", sp.AcsURL, recipient)
+		if recipient == recipientPlaceholder {
+			err = errors.Errorf("unexpected assertion recipient, expected %q, got %q: %s", sp.AcsURL, recipient, recipientPlaceholderHint)
+		}
+		v.fatal(CheckRecipient, validationErrorf(ErrWrongRecipient, err, ""))
+		return v.result
+	default:
+		v.pass(CheckRecipient)
+	}
+
+	if sp.AddressCheck != nil {
+		if clientIP == nil {
+			v.warn(CheckAddress, errors.New("client address unknown, skipping address validation"))
+		} else if err := sp.checkAddress(confirmation, clientIP); err != nil {
+			v.fail(CheckAddress, validationErrorf(ErrWrongAddress, err, ""))
+		} else {
+			v.pass(CheckAddress)
+		}
+		if v.stop() {
+			return v.result
+		}
+	}
+
+	if confirmation.Method == SubjectConfirmationMethodHolderOfKey {
+		if err := sp.checkHolderOfKey(confirmation, clientCert); err != nil {
+			v.fail(CheckHolderOfKey, validationErrorf(ErrWrongKey, err, ""))
+		} else {
+			v.pass(CheckHolderOfKey)
+		}
+		if v.stop() {
+			return v.result
 		}
 	}
 
 	// Make sure we have Conditions
 	if assertion.Conditions == nil {
-		return nil, errors.New(`missing Assertion > Conditions`)
+		v.fatal(CheckConditions, validationErrorf(ErrMissingConditions, nil, "missing Assertion > Conditions"))
+		return v.result
+	}
+	v.pass(CheckConditions)
+	for _, ext := range assertion.Conditions.Extensions {
+		v.warn(CheckConditions, errors.Errorf("unknown condition {%s}%s", ext.XMLName.Space, ext.XMLName.Local))
 	}
 
 	// The NotBefore and NotOnOrAfter attributes specify time limits on the
@@ -278,15 +1115,25 @@ func (sp *ServiceProvider) AssertResponse(samlResponse string) (*Assertion, erro
 	// NotOnOrAfter is omitted, then it is considered unspecified.
 	{
 		validFrom := assertion.Conditions.NotBefore
-		if !validFrom.IsZero() && validFrom.After(now.Add(ClockDriftTolerance)) {
-			return nil, errors.Errorf("Assertion conditions are not valid yet, got %v, current time is %v", validFrom, now)
+		if !validFrom.IsZero() && validFrom.After(now.Add(drift.NotBefore)) {
+			v.fail(CheckNotBefore, validationErrorf(ErrAssertionNotYetValid, nil, "got %v, current time is %v", validFrom, now))
+		} else {
+			v.pass(CheckNotBefore)
+		}
+		if v.stop() {
+			return v.result
 		}
 	}
 
 	{
 		validUntil := assertion.Conditions.NotOnOrAfter
-		if !validUntil.IsZero() && validUntil.Before(now.Add(-ClockDriftTolerance)) {
-			return nil, errors.Errorf("Assertion conditions already expired, got %v current time is %v, extra time is %v", validUntil, now, now.Add(-ClockDriftTolerance))
+		if !validUntil.IsZero() && validUntil.Before(now.Add(-drift.NotOnOrAfter)) {
+			v.fail(CheckNotOnOrAfter, validationErrorf(ErrExpiredAssertion, nil, "got %v current time is %v, extra time is %v", validUntil, now, now.Add(-drift.NotOnOrAfter)))
+		} else {
+			v.pass(CheckNotOnOrAfter)
+		}
+		if v.stop() {
+			return v.result
 		}
 	}
 
@@ -299,32 +1146,156 @@ func (sp *ServiceProvider) AssertResponse(samlResponse string) (*Assertion, erro
 	// NotOnOrAfter attributes. If both attributes are present, the value for
 	// NotBefore MUST be less than (earlier than) the value for NotOnOrAfter.
 
-	if validUntil := assertion.Subject.SubjectConfirmation.SubjectConfirmationData.NotOnOrAfter; validUntil.Before(now.Add(-ClockDriftTolerance)) {
-		err := errors.Errorf("Assertion conditions already expired, got %v current time is %v", validUntil, now)
-		return nil, errors.Wrap(err, "Assertion conditions already expired")
+	if err := checkSubjectConfirmationTime(&confirmation.SubjectConfirmationData, now, drift); err != nil {
+		v.fail(CheckSubjectConfirmation, err)
+	} else {
+		v.pass(CheckSubjectConfirmation)
+	}
+	if v.stop() {
+		return v.result
 	}
 
-	// if assertion.Conditions != nil && assertion.Conditions.AudienceRestriction != nil {
-	//   if assertion.Conditions.AudienceRestriction.Audience.Value != sp.MetadataURL {
-	//     returnt.Errorf("Audience restriction mismatch, got %q, expected %q", assertion.Conditions.AudienceRestriction.Audience.Value, sp.MetadataURL), errors.New("Audience restriction mismatch")
-	//   }
-	// }
+	if len(assertion.Conditions.AudienceRestrictions) == 0 {
+		v.warn(CheckAudience, errors.New("missing Assertion > Conditions > AudienceRestriction"))
+	} else if err := checkAudience(assertion.Conditions.AudienceRestrictions, sp.entityID()); err != nil {
+		v.fail(CheckAudience, err)
+	} else {
+		v.pass(CheckAudience)
+	}
+	if v.stop() {
+		return v.result
+	}
 
-	expectedResponse = false
-	for i := range responseIDs {
-		if responseIDs[i] == assertion.Subject.SubjectConfirmation.SubjectConfirmationData.InResponseTo {
-			expectedResponse = true
+	if pr := assertion.Conditions.ProxyRestriction; pr != nil && sp.CheckProxyRestriction != nil {
+		if err := sp.CheckProxyRestriction(pr); err != nil {
+			v.fail(CheckProxyRestriction, validationErrorf(ErrProxyRestriction, err, ""))
+		} else {
+			v.pass(CheckProxyRestriction)
+		}
+		if v.stop() {
+			return v.result
 		}
 	}
-	if len(responseIDs) == 1 && responseIDs[0] == "" {
-		expectedResponse = true
+	request := findRequest(requests, confirmation.SubjectConfirmationData.InResponseTo)
+	switch {
+	case request == nil:
+		v.fail(CheckAssertionInResponseTo, validationErrorf(ErrUnexpectedInResponseTo, nil, "unexpected assertion InResponseTo value %q", confirmation.SubjectConfirmationData.InResponseTo))
+	case request.IdP != "" && (assertion.Issuer == nil || assertion.Issuer.Value != request.IdP):
+		v.fail(CheckAssertionInResponseTo, validationErrorf(ErrIssuerMismatch, nil, "request %q was sent to %q, got an assertion from another issuer", request.ID, request.IdP))
+	default:
+		v.pass(CheckAssertionInResponseTo)
+	}
+	if v.stop() {
+		return v.result
+	}
+
+	if request != nil && request.Subject != nil {
+		if err := checkSubject(request.Subject, assertion); err != nil {
+			v.fail(CheckSubject, validationErrorf(ErrWrongSubject, err, ""))
+		} else {
+			v.pass(CheckSubject)
+		}
+		if v.stop() {
+			return v.result
+		}
 	}
 
-	if !expectedResponse && len(responseIDs) > 0 {
-		return nil, errors.New("Unexpected assertion InResponseTo value")
+	if request != nil && request.AuthnContext != "" {
+		if err := sp.CheckAuthnContext(assertion, request.AuthnContext); err != nil {
+			v.fail(CheckAuthnContext, err)
+		} else {
+			v.pass(CheckAuthnContext)
+		}
+		if v.stop() {
+			return v.result
+		}
 	}
 
-	return assertion, nil
+	if sp.MaxSSOAge > 0 {
+		if err := checkAuthnInstant(assertion.AuthnStatement, now, sp.MaxSSOAge, drift); err != nil {
+			v.fail(CheckAuthnInstant, err)
+		} else {
+			v.pass(CheckAuthnInstant)
+		}
+	}
+
+	return v.result
+}
+
+// checkAuthnInstant checks that the user authenticated less than maxAge
+// before now, according to stmt.
+func checkAuthnInstant(stmt *AuthnStatement, now time.Time, maxAge time.Duration, drift ClockDrift) error {
+	if stmt == nil {
+		return validationErrorf(ErrMissingAuthnStatement, nil, "missing Assertion > AuthnStatement")
+	}
+	if stmt.AuthnInstant.After(now.Add(drift.NotBefore)) {
+		return validationErrorf(ErrMalformedResponse, nil, "AuthnInstant %v is in the future, current time is %v", stmt.AuthnInstant, now)
+	}
+	if stmt.AuthnInstant.Before(now.Add(-maxAge - drift.NotOnOrAfter)) {
+		return validationErrorf(ErrAuthnTooOld, nil, "authenticated at %v, current time is %v, maximum age is %v", stmt.AuthnInstant, now, maxAge)
+	}
+	return nil
+}
+
+// checkAudience checks that entityID is among the audiences of each of the
+// restrictions, which must all be satisfied.
+func checkAudience(restrictions []AudienceRestriction, entityID string) error {
+	for i := range restrictions {
+		if !restrictions[i].includes(entityID) {
+			var audiences []string
+			for _, audience := range restrictions[i].Audiences {
+				audiences = append(audiences, audience.Value)
+			}
+			return validationErrorf(ErrWrongAudience, nil, "%q is not among the audiences %q", entityID, audiences)
+		}
+	}
+	return nil
+}
+
+// confirmsSubject returns whether a bearer subject confirmation satisfies all
+// the checks of the Web Browser SSO profile.
+func (sp *ServiceProvider) confirmsSubject(sc *SubjectConfirmation, possibleRequestIDs []string, now time.Time, drift ClockDrift) bool {
+	data := &sc.SubjectConfirmationData
+	return sp.isAcsURL(data.Recipient) &&
+		checkSubjectConfirmationTime(data, now, drift) == nil &&
+		isExpectedResponseID(possibleRequestIDs, data.InResponseTo)
+}
+
+// checkSubjectConfirmationTime verifies the validity period of a subject
+// confirmation.
+func checkSubjectConfirmationTime(data *SubjectConfirmationData, now time.Time, drift ClockDrift) error {
+	if data.NotBefore != nil {
+		if !data.NotBefore.Before(data.NotOnOrAfter) {
+			return validationErrorf(ErrMalformedResponse, nil, "subject confirmation NotBefore %v is not earlier than NotOnOrAfter %v", *data.NotBefore, data.NotOnOrAfter)
+		}
+		if data.NotBefore.After(now.Add(drift.NotBefore)) {
+			return validationErrorf(ErrAssertionNotYetValid, nil, "subject confirmation not valid yet, got %v current time is %v", *data.NotBefore, now)
+		}
+	}
+	if data.NotOnOrAfter.Before(now.Add(-drift.NotOnOrAfter)) {
+		return validationErrorf(ErrExpiredAssertion, nil, "subject confirmation expired, got %v current time is %v", data.NotOnOrAfter, now)
+	}
+	return nil
+}
+
+// checkAddress verifies the Address of a subject confirmation when the
+// AddressCheck is enabled and the client address is known.
+func (sp *ServiceProvider) checkAddress(sc *SubjectConfirmation, clientIP net.IP) error {
+	if sp.AddressCheck == nil || clientIP == nil {
+		return nil
+	}
+	return sp.AddressCheck.check(sc.SubjectConfirmationData.Address, clientIP)
+}
+
+// isExpectedResponseID returns whether a response answering the request with
+// the given ID can be accepted.
+func isExpectedResponseID(possibleRequestIDs []string, inResponseTo string) bool {
+	for i := range possibleRequestIDs {
+		if possibleRequestIDs[i] == inResponseTo {
+			return true
+		}
+	}
+	return false
 }
 
 func validateSignedNode(signature *xmlsec.Signature, nodeID string) error {
@@ -340,3 +1311,36 @@ func validateSignedNode(signature *xmlsec.Signature, nodeID string) error {
 	}
 	return fmt.Errorf("cannot lookup external URIs (%q)", signatureURI)
 }
+
+// ValidateSubject checks that the subject of the given assertion matches the
+// Subject that was requested with WithSubject. It returns nil if the request
+// did not carry a Subject. The responses to the requests sent with
+// SendAuthnRequest are checked by AssertionMiddleware.
+func (req *AuthnRequest) ValidateSubject(assertion *Assertion) error {
+	if req.Subject == nil || req.Subject.NameID == nil {
+		return nil
+	}
+	return checkSubject(req.Subject.NameID, assertion)
+}
+
+// checkSubject checks that the subject of assertion is the expected one.
+func checkSubject(expected *NameID, assertion *Assertion) error {
+	if assertion == nil || assertion.Subject == nil || assertion.Subject.NameID == nil {
+		return errors.New("missing Assertion > Subject > NameID")
+	}
+	got := assertion.Subject.NameID
+
+	if got.Value != expected.Value {
+		return errors.Errorf("unexpected subject, expected %q, got %q", expected.Value, got.Value)
+	}
+	if expected.Format != "" && got.Format != expected.Format {
+		return errors.Errorf("unexpected subject format, expected %q, got %q", expected.Format, got.Format)
+	}
+	if expected.NameQualifier != "" && got.NameQualifier != expected.NameQualifier {
+		return errors.Errorf("unexpected subject NameQualifier, expected %q, got %q", expected.NameQualifier, got.NameQualifier)
+	}
+	if expected.SPNameQualifier != "" && got.SPNameQualifier != expected.SPNameQualifier {
+		return errors.Errorf("unexpected subject SPNameQualifier, expected %q, got %q", expected.SPNameQualifier, got.SPNameQualifier)
+	}
+	return nil
+}
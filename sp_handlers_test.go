@@ -0,0 +1,720 @@
+package saml
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func encodeTestResponse(t *testing.T, res *Response) string {
+	buf, err := xml.Marshal(res)
+	assert.NoError(t, err)
+	return base64.StdEncoding.EncodeToString(buf)
+}
+
+func newTestResponseSP() *ServiceProvider {
+	return &ServiceProvider{
+		MetadataURL: "http://localhost:1235/saml/service.xml",
+		AcsURL:      "http://localhost:1235/saml/acs",
+		IdPMetadata: &Metadata{
+			EntityID: "http://localhost:1233/saml/service.xml",
+		},
+	}
+}
+
+// newTestAssertionResponse returns a response with an assertion passing all
+// the checks of sp at now, but the signature.
+func newTestAssertionResponse(sp *ServiceProvider, now time.Time) *Response {
+	return &Response{
+		ID:          "id-response",
+		Destination: sp.AcsURL,
+		Issuer:      &Issuer{Value: sp.IdPMetadata.EntityID},
+		Status:      &Status{StatusCode: StatusCode{Value: StatusSuccess}},
+		Assertion: &Assertion{
+			ID:     "id-assertion",
+			Issuer: &Issuer{Value: sp.IdPMetadata.EntityID},
+			Subject: &Subject{
+				NameID: &NameID{Value: "jane"},
+				SubjectConfirmations: []SubjectConfirmation{{
+					Method: SubjectConfirmationMethodBearer,
+					SubjectConfirmationData: SubjectConfirmationData{
+						NotOnOrAfter: now.Add(5 * time.Minute),
+						Recipient:    sp.AcsURL,
+					},
+				}},
+			},
+			Conditions: &Conditions{
+				NotBefore:            now.Add(-time.Minute),
+				NotOnOrAfter:         now.Add(5 * time.Minute),
+				AudienceRestrictions: []AudienceRestriction{{Audiences: []Audience{{Value: sp.entityID()}}}},
+			},
+			AuthnStatement: &AuthnStatement{
+				AuthnInstant: now.Add(-time.Minute),
+				SessionIndex: "_s1",
+				AuthnContext: AuthnContext{
+					AuthnContextClassRef: &AuthnContextClassRef{Value: AuthnContextPasswordProtectedTransport},
+				},
+			},
+		},
+	}
+}
+
+func TestParseResponse(t *testing.T) {
+	tearUp()
+
+	sp := newTestResponseSP()
+	now := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	_, err := sp.ParseResponse("%%%", nil, now)
+	assert.Error(t, err)
+
+	_, err = sp.ParseResponse(base64.StdEncoding.EncodeToString([]byte("<Response")), nil, now)
+	assert.Error(t, err)
+
+	res := &Response{
+		Destination: "http://localhost:1235/saml/other",
+		Issuer:      &Issuer{Value: sp.IdPMetadata.EntityID},
+		Status:      &Status{StatusCode: StatusCode{Value: StatusSuccess}},
+	}
+	_, err = sp.ParseResponse(encodeTestResponse(t, res), nil, now)
+	assert.Error(t, err)
+	assert.True(t, errors.Is(err, ErrWrongDestination))
+
+	var validationErr *ValidationError
+	assert.True(t, errors.As(err, &validationErr))
+	assert.Equal(t, ErrWrongDestination, validationErr.Kind)
+
+	res.Destination = sp.AcsURL
+	res.Issuer.Value = "http://evil.example.org"
+	_, err = sp.ParseResponse(encodeTestResponse(t, res), nil, now)
+	assert.Error(t, err)
+	assert.True(t, errors.Is(err, ErrIssuerMismatch))
+	assert.False(t, errors.Is(err, ErrWrongDestination))
+
+	_, err = sp.ParseResponse(base64.StdEncoding.EncodeToString([]byte("<Response")), nil, now)
+	assert.True(t, errors.Is(err, ErrMalformedResponse))
+}
+
+func TestValidateResponse(t *testing.T) {
+	tearUp()
+
+	sp := newTestResponseSP()
+	now := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	res := &Response{
+		Destination: "http://localhost:1235/saml/other",
+		Issuer:      &Issuer{Value: "http://evil.example.org"},
+		Status:      &Status{StatusCode: StatusCode{Value: "urn:oasis:names:tc:SAML:2.0:status:Requester"}},
+	}
+
+	result := sp.ValidateResponse(encodeTestResponse(t, res), []string{""}, now)
+	assert.False(t, result.Valid())
+	assert.Error(t, result.Err())
+	assert.Equal(t, []string{CheckDecode, CheckIdPMetadata, CheckInResponseTo}, result.Passed)
+
+	var failed []string
+	for _, issue := range result.Failures {
+		failed = append(failed, issue.Check)
+	}
+	assert.Equal(t, []string{CheckDestination, CheckIssuer, CheckStatus, CheckSignature}, failed)
+	assert.True(t, result.Incomplete)
+	assert.Contains(t, result.String(), "FAIL destination: wrong ACS destination")
+
+	// ParseResponse stops at the first failure.
+	_, err := sp.ParseResponse(encodeTestResponse(t, res), []string{""}, now)
+	assert.Equal(t, result.Failures[0].Err.Error(), err.Error())
+}
+
+func TestStatusError(t *testing.T) {
+	tearUp()
+
+	sp := newTestResponseSP()
+	now := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	samlResponse := base64.StdEncoding.EncodeToString([]byte(`<samlp:Response xmlns:samlp="urn:oasis:names:tc:SAML:2.0:protocol" xmlns:saml="urn:oasis:names:tc:SAML:2.0:assertion" ID="id-response" Destination="http://localhost:1235/saml/acs">
+	<saml:Issuer>http://localhost:1233/saml/service.xml</saml:Issuer>
+	<samlp:Status>
+		<samlp:StatusCode Value="urn:oasis:names:tc:SAML:2.0:status:Responder">
+			<samlp:StatusCode Value="urn:oasis:names:tc:SAML:2.0:status:NoPassive"/>
+		</samlp:StatusCode>
+		<samlp:StatusMessage>User is not logged in</samlp:StatusMessage>
+		<samlp:StatusDetail><Cause>session expired</Cause></samlp:StatusDetail>
+	</samlp:Status>
+</samlp:Response>`))
+
+	_, err := sp.ParseResponse(samlResponse, nil, now)
+	assert.True(t, errors.Is(err, ErrStatusNotSuccess))
+	var statusErr *StatusError
+	if assert.True(t, errors.As(err, &statusErr)) {
+		assert.Equal(t, StatusResponder, statusErr.Code)
+		assert.Equal(t, StatusNoPassive, statusErr.SubCode)
+		assert.Equal(t, "User is not logged in", statusErr.Message)
+		assert.Equal(t, "<Cause>session expired</Cause>", statusErr.Detail)
+	}
+	assert.Equal(t, "unexpected status code: urn:oasis:names:tc:SAML:2.0:status:Responder (urn:oasis:names:tc:SAML:2.0:status:NoPassive): User is not logged in [<Cause>session expired</Cause>]", err.Error())
+}
+
+func TestSubjectEncryptedID(t *testing.T) {
+	tearUp()
+
+	sp, simpleSign := newSimpleSignTestSP(t)
+	now := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	res := &Response{
+		ID:          "id-response",
+		Destination: sp.AcsURL,
+		Issuer:      &Issuer{Value: sp.IdPMetadata.EntityID},
+		Status:      &Status{StatusCode: StatusCode{Value: StatusSuccess}},
+		Assertion: &Assertion{
+			ID:     "id-assertion",
+			Issuer: &Issuer{Value: sp.IdPMetadata.EntityID},
+			Subject: &Subject{
+				EncryptedID: &EncryptedID{EncryptedData: []byte(`<xenc:EncryptedData xmlns:xenc="http://www.w3.org/2001/04/xmlenc#"></xenc:EncryptedData>`)},
+			},
+		},
+	}
+	samlResponse := encodeTestResponse(t, res)
+
+	var decoded Response
+	buf, _ := base64.StdEncoding.DecodeString(samlResponse)
+	assert.NoError(t, xml.Unmarshal(buf, &decoded))
+	if assert.NotNil(t, decoded.Assertion.Subject.EncryptedID) {
+		assert.Contains(t, string(decoded.Assertion.Subject.EncryptedID.EncryptedData), "EncryptedData")
+	}
+
+	result := sp.validateResponse(context.Background(), samlResponse, simpleSign(samlResponse), []TrackedRequest{{}}, now, nil, nil, true)
+	assert.Contains(t, result.Passed, CheckSignature)
+	if assert.Len(t, result.Failures, 1) {
+		assert.Equal(t, CheckDecrypt, result.Failures[0].Check)
+		assert.True(t, errors.Is(result.Failures[0].Err, ErrDecryption))
+	}
+}
+
+func TestEncryptNameIDError(t *testing.T) {
+	// The xmlsec1 failures are not only security exceptions: all of them
+	// must be reported rather than produce an empty EncryptedID.
+	encryptedID, err := EncryptNameID(&NameID{Value: "alice"}, "/nonexistent/cert.pem")
+	assert.Error(t, err)
+	assert.Nil(t, encryptedID)
+}
+
+func TestEncryptedAttribute(t *testing.T) {
+	tearUp()
+
+	sp, simpleSign := newSimpleSignTestSP(t)
+	now := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	samlResponse := base64.StdEncoding.EncodeToString([]byte(`<samlp:Response xmlns:samlp="urn:oasis:names:tc:SAML:2.0:protocol" xmlns:saml="urn:oasis:names:tc:SAML:2.0:assertion" ID="id-response" Destination="http://localhost:1235/saml/acs">
+	<saml:Issuer>http://localhost:1233/saml/service.xml</saml:Issuer>
+	<samlp:Status><samlp:StatusCode Value="urn:oasis:names:tc:SAML:2.0:status:Success"/></samlp:Status>
+	<saml:Assertion ID="id-assertion">
+		<saml:Issuer>http://localhost:1233/saml/service.xml</saml:Issuer>
+		<saml:AttributeStatement>
+			<saml:Attribute Name="mail"><saml:AttributeValue>jane@example.org</saml:AttributeValue></saml:Attribute>
+			<saml:EncryptedAttribute><xenc:EncryptedData xmlns:xenc="http://www.w3.org/2001/04/xmlenc#"></xenc:EncryptedData></saml:EncryptedAttribute>
+		</saml:AttributeStatement>
+	</saml:Assertion>
+</samlp:Response>`))
+
+	var decoded Response
+	buf, _ := base64.StdEncoding.DecodeString(samlResponse)
+	assert.NoError(t, xml.Unmarshal(buf, &decoded))
+	stmt := decoded.Assertion.AttributeStatement
+	assert.Len(t, stmt.Attributes, 1)
+	if assert.Len(t, stmt.EncryptedAttributes, 1) {
+		assert.Contains(t, string(stmt.EncryptedAttributes[0].EncryptedData), "EncryptedData")
+	}
+
+	result := sp.validateResponse(context.Background(), samlResponse, simpleSign(samlResponse), []TrackedRequest{{}}, now, nil, nil, true)
+	assert.Contains(t, result.Passed, CheckSignature)
+	if assert.Len(t, result.Failures, 1) {
+		assert.Equal(t, CheckDecrypt, result.Failures[0].Check)
+		assert.True(t, errors.Is(result.Failures[0].Err, ErrDecryption))
+		assert.Contains(t, result.Failures[0].Err.Error(), "unable to decrypt attribute")
+	}
+}
+
+func TestAssertionMiddlewareErrorHandler(t *testing.T) {
+	tearUp()
+
+	sp := newTestResponseSP()
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Fatal("unexpected call to the next handler")
+	})
+
+	postForm := func(values url.Values) *http.Request {
+		r := httptest.NewRequest("POST", sp.AcsURL, strings.NewReader(values.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		return r
+	}
+
+	w := httptest.NewRecorder()
+	sp.AssertionMiddleware(next).ServeHTTP(w, postForm(url.Values{}))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Header().Get("Content-Type"), "text/plain")
+	assert.Contains(t, w.Body.String(), "missing SAMLResponse")
+
+	sp.ErrorHandler = func(w http.ResponseWriter, r *http.Request, status int, err error) {
+		w.Header().Set("Content-Type", "application/problem+json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":            status,
+			"title":             "SAML login failed",
+			"wrong_destination": errors.Is(err, ErrWrongDestination),
+		})
+	}
+
+	res := &Response{
+		Destination: "http://localhost:1235/saml/other",
+		Status:      &Status{StatusCode: StatusCode{Value: StatusSuccess}},
+	}
+
+	w = httptest.NewRecorder()
+	sp.AssertionMiddleware(next).ServeHTTP(w, postForm(url.Values{
+		"SAMLResponse": {encodeTestResponse(t, res)},
+	}))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, "application/problem+json", w.Header().Get("Content-Type"))
+	assert.JSONEq(t, `{"status":400,"title":"SAML login failed","wrong_destination":true}`, w.Body.String())
+}
+
+func TestAssertionMiddlewareStatus(t *testing.T) {
+	tearUp()
+
+	sp, simpleSign := newSimpleSignTestSP(t)
+	sp.IdPMetadata.IDPSSODescriptor.SingleSignOnService = []Endpoint{{
+		Binding:  HTTPRedirectBinding,
+		Location: "http://localhost:1233/saml/sso",
+	}}
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Fatal("unexpected call to the next handler")
+	})
+
+	var failure error
+	sp.OnFailure = func(r *http.Request, level FailureLevel, err error) {
+		failure = err
+	}
+	signedRelayState := "/home"
+	serveResponse := func(code, subCode string, signed bool) *httptest.ResponseRecorder {
+		res := &Response{
+			Destination: sp.AcsURL,
+			Issuer:      &Issuer{Value: sp.IdPMetadata.EntityID},
+			Status:      &Status{StatusCode: StatusCode{Value: code}},
+		}
+		if subCode != "" {
+			res.Status.StatusCode.StatusCode = &StatusCode{Value: subCode}
+		}
+		samlResponse := encodeTestResponse(t, res)
+		values := url.Values{
+			"SAMLResponse": {samlResponse},
+			"RelayState":   {"/home"},
+		}
+		if signed {
+			sig := simpleSign(samlResponse, signedRelayState)
+			values.Set("SigAlg", sig.SigAlg)
+			values.Set("Signature", base64.StdEncoding.EncodeToString(sig.Signature))
+		}
+		r := httptest.NewRequest("POST", sp.AcsURL, strings.NewReader(values.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		sp.AssertionMiddleware(next).ServeHTTP(w, r)
+		return w
+	}
+	serve := func(code, subCode string) *httptest.ResponseRecorder {
+		return serveResponse(code, subCode, true)
+	}
+
+	// A failed passive login falls back to an interactive one.
+	w := serve(StatusResponder, StatusNoPassive)
+	assert.Equal(t, http.StatusFound, w.Code)
+	u, err := url.Parse(w.Header().Get("Location"))
+	assert.NoError(t, err)
+	msg, err := DecodeRedirect(u.RawQuery, SizeLimits{})
+	if assert.NoError(t, err) {
+		assert.Equal(t, "/home", msg.RelayState)
+		assert.NotContains(t, string(msg.XML), "IsPassive")
+	}
+
+	// Unless the response is not signed, and may be forged.
+	w = serveResponse(StatusResponder, StatusNoPassive, false)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	// The signature is verified before the status is acted on.
+	signedRelayState = "/other"
+	w = serve(StatusResponder, StatusNoPassive)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.True(t, errors.Is(failure, ErrInvalidSignature), "%v", failure)
+	signedRelayState = "/home"
+
+	w = serve(StatusResponder, StatusAuthnFailed)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Equal(t, "The identity provider could not authenticate you.\n", w.Body.String())
+
+	w = serve(StatusRequester, "")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, "The identity provider could not log you in.\n", w.Body.String())
+}
+
+func TestWithIsPassive(t *testing.T) {
+	tearUp()
+
+	req, err := newTestResponseSP().NewAuthnRequest("http://localhost:1233/saml/sso", WithIsPassive())
+	assert.NoError(t, err)
+	buf, err := xml.Marshal(req)
+	assert.NoError(t, err)
+	assert.Contains(t, string(buf), `IsPassive="true"`)
+}
+
+func TestAssertionMiddlewareOnFailure(t *testing.T) {
+	tearUp()
+
+	sp := newTestResponseSP()
+
+	var levels []FailureLevel
+	sp.OnFailure = func(r *http.Request, level FailureLevel, err error) {
+		levels = append(levels, level)
+	}
+
+	r := httptest.NewRequest("POST", sp.AcsURL, nil)
+	w := httptest.NewRecorder()
+	sp.AssertionMiddleware(http.NotFoundHandler()).ServeHTTP(w, r)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, []FailureLevel{ClientFailure}, levels)
+}
+
+func TestParseResponseLogging(t *testing.T) {
+	tearUp()
+
+	p := &testPrinter{}
+	sp := newTestResponseSP()
+	sp.Logger = NewLogrusLogger(p)
+	now := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	res := &Response{
+		ID:          "id-response",
+		Destination: "http://localhost:1235/saml/other",
+		Issuer:      &Issuer{Value: sp.IdPMetadata.EntityID},
+		Status:      &Status{StatusCode: StatusCode{Value: StatusSuccess}},
+		Assertion: &Assertion{
+			Subject: &Subject{NameID: &NameID{Value: "jane@example.org"}},
+		},
+	}
+	samlResponse := encodeTestResponse(t, res)
+
+	sp.ParseResponse(samlResponse, nil, now)
+	assert.Equal(t, []string{
+		`debug SAML response received id="id-response" in_response_to="" issuer="http://localhost:1233/saml/service.xml" destination="http://localhost:1235/saml/other" status="urn:oasis:names:tc:SAML:2.0:status:Success"`,
+	}, p.lines)
+
+	p.lines = nil
+	sp.LogPayloads = true
+	sp.ParseResponse(samlResponse, nil, now)
+	assert.Len(t, p.lines, 2)
+	assert.Contains(t, p.lines[0], samlResponse)
+	assert.Contains(t, p.lines[0], "jane@example.org")
+}
+
+func TestSigningPolicy(t *testing.T) {
+	tests := []struct {
+		policy                          SigningPolicy
+		responseSigned, assertionSigned bool
+		ok                              bool
+	}{
+		{SigningPolicyEither, false, false, false},
+		{SigningPolicyEither, true, false, true},
+		{SigningPolicyEither, false, true, true},
+		{SigningPolicyResponse, false, true, false},
+		{SigningPolicyResponse, true, false, true},
+		{SigningPolicyAssertion, true, false, false},
+		{SigningPolicyAssertion, false, true, true},
+		{SigningPolicyBoth, true, false, false},
+		{SigningPolicyBoth, false, true, false},
+		{SigningPolicyBoth, true, true, true},
+		{SigningPolicy(42), true, true, false},
+	}
+	for _, test := range tests {
+		err := test.policy.check(test.responseSigned, test.assertionSigned)
+		assert.Equal(t, test.ok, err == nil, "%v response=%v assertion=%v", test.policy, test.responseSigned, test.assertionSigned)
+	}
+}
+
+func TestDestinationPolicy(t *testing.T) {
+	tearUp()
+
+	sp := newTestResponseSP()
+	now := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	res := &Response{
+		Issuer: &Issuer{Value: sp.IdPMetadata.EntityID},
+		Status: &Status{StatusCode: StatusCode{Value: StatusSuccess}},
+	}
+
+	result := sp.ValidateResponse(encodeTestResponse(t, res), []string{""}, now)
+	assert.Equal(t, CheckDestination, result.Failures[0].Check)
+
+	sp.DestinationPolicy = DestinationRequiredIfSigned
+	result = sp.ValidateResponse(encodeTestResponse(t, res), []string{""}, now)
+	assert.Equal(t, CheckIssuer, result.Passed[2])
+	assert.Equal(t, CheckDestination, result.Warnings[0].Check)
+
+	res.Destination = "http://localhost:1235/saml/other"
+	result = sp.ValidateResponse(encodeTestResponse(t, res), []string{""}, now)
+	assert.Equal(t, CheckDestination, result.Failures[0].Check)
+}
+
+func TestSubjectConfirmations(t *testing.T) {
+	tearUp()
+
+	sp := newTestResponseSP()
+	now := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	var subject Subject
+	err := xml.Unmarshal([]byte(`<Subject xmlns="urn:oasis:names:tc:SAML:2.0:assertion">
+		<NameID>jane@example.org</NameID>
+		<SubjectConfirmation Method="urn:oasis:names:tc:SAML:2.0:cm:holder-of-key">
+			<SubjectConfirmationData Recipient="http://localhost:1235/saml/acs" NotOnOrAfter="2018-01-01T00:01:00Z"/>
+		</SubjectConfirmation>
+		<SubjectConfirmation Method="urn:oasis:names:tc:SAML:2.0:cm:bearer">
+			<SubjectConfirmationData Recipient="http://localhost:1235/saml/acs" NotOnOrAfter="2017-12-31T23:59:00Z"/>
+		</SubjectConfirmation>
+		<SubjectConfirmation Method="urn:oasis:names:tc:SAML:2.0:cm:bearer">
+			<SubjectConfirmationData Recipient="http://localhost:1235/saml/acs" NotOnOrAfter="2018-01-01T00:01:00Z" InResponseTo="id-request"/>
+		</SubjectConfirmation>
+	</Subject>`), &subject)
+	assert.NoError(t, err)
+	assert.Len(t, subject.SubjectConfirmations, 3)
+
+	confirmations := subject.SubjectConfirmations
+	assert.Equal(t, SubjectConfirmationMethodBearer, confirmations[1].Method)
+
+	// Expired.
+	assert.False(t, sp.confirmsSubject(&confirmations[1], []string{""}, now, ClockDrift{}))
+	assert.True(t, sp.confirmsSubject(&confirmations[1], []string{""}, now, ClockDrift{NotOnOrAfter: 2 * time.Minute}))
+
+	assert.True(t, sp.confirmsSubject(&confirmations[2], []string{"id-request"}, now, ClockDrift{}))
+	assert.False(t, sp.confirmsSubject(&confirmations[2], []string{"id-other"}, now, ClockDrift{}))
+
+	confirmations[2].SubjectConfirmationData.Recipient = "http://localhost:1235/saml/other"
+	assert.False(t, sp.confirmsSubject(&confirmations[2], nil, now, ClockDrift{}))
+}
+
+func TestCheckSubjectConfirmationTime(t *testing.T) {
+	now := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+	at := func(d time.Duration) *time.Time {
+		t := now.Add(d)
+		return &t
+	}
+
+	data := &SubjectConfirmationData{NotOnOrAfter: now.Add(time.Minute)}
+	assert.NoError(t, checkSubjectConfirmationTime(data, now, ClockDrift{}))
+
+	data.NotBefore = at(-time.Minute)
+	assert.NoError(t, checkSubjectConfirmationTime(data, now, ClockDrift{}))
+
+	data.NotBefore = at(30 * time.Second)
+	err := checkSubjectConfirmationTime(data, now, ClockDrift{})
+	assert.True(t, errors.Is(err, ErrAssertionNotYetValid))
+	assert.NoError(t, checkSubjectConfirmationTime(data, now, ClockDrift{NotBefore: time.Minute}))
+
+	data.NotBefore = at(time.Minute)
+	err = checkSubjectConfirmationTime(data, now, ClockDrift{NotBefore: time.Hour})
+	assert.True(t, errors.Is(err, ErrMalformedResponse))
+
+	data.NotBefore = nil
+	data.NotOnOrAfter = now.Add(-time.Second)
+	err = checkSubjectConfirmationTime(data, now, ClockDrift{})
+	assert.True(t, errors.Is(err, ErrExpiredAssertion))
+
+	out, err := xml.Marshal(data)
+	assert.NoError(t, err)
+	assert.NotContains(t, string(out), "NotBefore")
+}
+
+func TestConditionExtensions(t *testing.T) {
+	var conditions Conditions
+	err := xml.Unmarshal([]byte(`<Conditions xmlns="urn:oasis:names:tc:SAML:2.0:assertion">
+		<AudienceRestriction><Audience>https://sp.example.org</Audience></AudienceRestriction>
+		<del:Delegate xmlns:del="urn:oasis:names:tc:SAML:2.0:conditions:delegation" DelegationInstant="2018-01-01T00:00:00Z"><NameID>jane</NameID></del:Delegate>
+	</Conditions>`), &conditions)
+	assert.NoError(t, err)
+	if assert.Len(t, conditions.AudienceRestrictions, 1) {
+		assert.Equal(t, []Audience{{Value: "https://sp.example.org"}}, conditions.AudienceRestrictions[0].Audiences)
+	}
+
+	if assert.Len(t, conditions.Extensions, 1) {
+		ext := conditions.Extensions[0]
+		assert.Equal(t, xml.Name{Space: "urn:oasis:names:tc:SAML:2.0:conditions:delegation", Local: "Delegate"}, ext.XMLName)
+		assert.Contains(t, ext.Attrs, xml.Attr{Name: xml.Name{Local: "DelegationInstant"}, Value: "2018-01-01T00:00:00Z"})
+		assert.Equal(t, "<NameID>jane</NameID>", ext.InnerXML)
+	}
+}
+
+func TestAuthnRequestURLCompact(t *testing.T) {
+	tearUp()
+
+	sp := newTestResponseSP()
+	sp.IdPMetadata.IDPSSODescriptor = &IDPSSODescriptor{
+		SingleSignOnService: []Endpoint{{
+			Binding:  HTTPRedirectBinding,
+			Location: "http://localhost:1233/saml/sso",
+		}},
+	}
+
+	redirectURL, err := sp.AuthnRequestURL("")
+	assert.NoError(t, err)
+	u, err := url.Parse(redirectURL)
+	assert.NoError(t, err)
+
+	buf, err := SizeLimits{}.decodeRedirect("SAMLRequest", u.Query().Get("SAMLRequest"))
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(buf), "<samlp:AuthnRequest "))
+	assert.NotContains(t, string(buf), "\n")
+	assert.NotContains(t, string(buf), "\t")
+
+	var req AuthnRequest
+	assert.NoError(t, xml.Unmarshal(buf, &req))
+	assert.Equal(t, "http://localhost:1233/saml/sso", req.Destination)
+}
+
+func TestAudienceRestrictions(t *testing.T) {
+	tearUp()
+
+	sp, simpleSign := newSimpleSignTestSP(t)
+	now := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+	res := newTestAssertionResponse(sp, now)
+	validate := func(restrictions ...AudienceRestriction) *ValidationResult {
+		res.Assertion.Conditions.AudienceRestrictions = restrictions
+		samlResponse := encodeTestResponse(t, res)
+		return sp.validateResponse(context.Background(), samlResponse, simpleSign(samlResponse), []TrackedRequest{{}}, now, nil, nil, true)
+	}
+	audiences := func(values ...string) AudienceRestriction {
+		var ar AudienceRestriction
+		for _, value := range values {
+			ar.Audiences = append(ar.Audiences, Audience{Value: value})
+		}
+		return ar
+	}
+
+	// The SP may be any of the audiences of a restriction.
+	result := validate(audiences("https://other.example.org", sp.entityID()))
+	assert.NoError(t, result.Err())
+	assert.Contains(t, result.Passed, CheckAudience)
+
+	// It must be among the audiences of every restriction.
+	result = validate(audiences(sp.entityID()), audiences(sp.entityID(), "https://other.example.org"))
+	assert.NoError(t, result.Err())
+	result = validate(audiences(sp.entityID()), audiences("https://other.example.org"))
+	if assert.Len(t, result.Failures, 1) {
+		assert.Equal(t, CheckAudience, result.Failures[0].Check)
+		assert.True(t, errors.Is(result.Failures[0].Err, ErrWrongAudience))
+	}
+	result = validate(audiences())
+	assert.True(t, errors.Is(result.Err(), ErrWrongAudience))
+
+	result = validate()
+	assert.NoError(t, result.Err())
+	assert.NotContains(t, result.Passed, CheckAudience)
+}
+
+func TestMaxSSOAge(t *testing.T) {
+	tearUp()
+
+	sp, simpleSign := newSimpleSignTestSP(t)
+	now := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+	res := newTestAssertionResponse(sp, now)
+	res.Assertion.AuthnStatement.AuthnInstant = now.Add(-2 * time.Hour)
+
+	validate := func() *ValidationResult {
+		samlResponse := encodeTestResponse(t, res)
+		return sp.validateResponse(context.Background(), samlResponse, simpleSign(samlResponse), []TrackedRequest{{}}, now, nil, nil, true)
+	}
+
+	result := validate()
+	assert.NoError(t, result.Err())
+	assert.NotContains(t, result.Passed, CheckAuthnInstant)
+
+	sp.MaxSSOAge = time.Hour
+	result = validate()
+	if assert.Len(t, result.Failures, 1) {
+		assert.Equal(t, CheckAuthnInstant, result.Failures[0].Check)
+		assert.True(t, errors.Is(result.Failures[0].Err, ErrAuthnTooOld))
+	}
+
+	res.Assertion.AuthnStatement.AuthnInstant = now.Add(-30 * time.Minute)
+	result = validate()
+	assert.NoError(t, result.Err())
+	assert.Contains(t, result.Passed, CheckAuthnInstant)
+
+	res.Assertion.AuthnStatement.AuthnInstant = now.Add(time.Hour)
+	result = validate()
+	assert.True(t, errors.Is(result.Err(), ErrMalformedResponse))
+
+	res.Assertion.AuthnStatement = nil
+	result = validate()
+	assert.True(t, errors.Is(result.Err(), ErrMissingAuthnStatement))
+	assert.False(t, errors.Is(result.Err(), ErrAuthnTooOld))
+}
+
+func TestForceAuthnOnMaxSSOAge(t *testing.T) {
+	tearUp()
+
+	sp, simpleSign := newSimpleSignTestSP(t)
+	sp.IdPMetadata.IDPSSODescriptor.SingleSignOnService = []Endpoint{{
+		Binding:  HTTPRedirectBinding,
+		Location: "http://localhost:1233/saml/sso",
+	}}
+	sp.MaxSSOAge = time.Hour
+	now := Now()
+	res := newTestAssertionResponse(sp, now)
+	res.Assertion.AuthnStatement.AuthnInstant = now.Add(-2 * time.Hour)
+	samlResponse := encodeTestResponse(t, res)
+	sig := simpleSign(samlResponse)
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Fatal("unexpected call to the next handler")
+	})
+	serve := func() *httptest.ResponseRecorder {
+		values := url.Values{
+			"SAMLResponse": {samlResponse},
+			"SigAlg":       {sig.SigAlg},
+			"Signature":    {base64.StdEncoding.EncodeToString(sig.Signature)},
+		}
+		r := httptest.NewRequest("POST", sp.AcsURL, strings.NewReader(values.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		sp.AssertionMiddleware(next).ServeHTTP(w, r)
+		return w
+	}
+
+	w := serve()
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	sp.ForceAuthnOnMaxSSOAge = true
+	w = serve()
+	assert.Equal(t, http.StatusFound, w.Code)
+	u, err := url.Parse(w.Header().Get("Location"))
+	assert.NoError(t, err)
+	msg, err := DecodeRedirect(u.RawQuery, SizeLimits{})
+	if assert.NoError(t, err) {
+		assert.Contains(t, string(msg.XML), `ForceAuthn="true"`)
+	}
+
+	// A new authentication would not add the missing AuthnStatement.
+	res.Assertion.AuthnStatement = nil
+	samlResponse = encodeTestResponse(t, res)
+	sig = simpleSign(samlResponse)
+	w = serve()
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
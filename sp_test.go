@@ -1,7 +1,15 @@
 package saml
 
 import (
+	"context"
 	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os/exec"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -112,7 +120,7 @@ func TestGenerateSPMetadata(t *testing.T) {
 	assert.NoError(t, err)
 
 	expectedOutput := `<EntityDescriptor xmlns="urn:oasis:names:tc:SAML:2.0:metadata" validUntil="` + Now().Add(defaultValidDuration).Format(time.RFC3339Nano) + `" entityID="http://localhost:1235/saml/service.xml">
-	<SPSSODescriptor xmlns="urn:oasis:names:tc:SAML:2.0:metadata" AuthnRequestsSigned="false" WantAssertionsSigned="true" protocolSupportEnumeration="urn:oasis:names:tc:SAML:2.0:protocol">
+	<SPSSODescriptor xmlns="urn:oasis:names:tc:SAML:2.0:metadata" AuthnRequestsSigned="false" WantAssertionsSigned="false" protocolSupportEnumeration="urn:oasis:names:tc:SAML:2.0:protocol">
 		<KeyDescriptor use="signing">
 			<KeyInfo xmlns="http://www.w3.org/2000/09/xmldsig#">
 				<X509Data>
@@ -131,6 +139,7 @@ func TestGenerateSPMetadata(t *testing.T) {
 			<EncryptionMethod Algorithm="http://www.w3.org/2001/04/xmlenc#aes256-cbc"></EncryptionMethod>
 			<EncryptionMethod Algorithm="http://www.w3.org/2001/04/xmlenc#rsa-oaep-mgf1p"></EncryptionMethod>
 		</KeyDescriptor>
+		<NameIDFormat>urn:oasis:names:tc:SAML:2.0:nameid-format:transient</NameIDFormat>
 		<AssertionConsumerService Binding="urn:oasis:names:tc:SAML:2.0:bindings:HTTP-POST" Location="http://localhost:1235/saml/acs" index="1"></AssertionConsumerService>
 	</SPSSODescriptor>
 </EntityDescriptor>`
@@ -147,10 +156,578 @@ func TestMakeAuthenticationRequest(t *testing.T) {
 	out, err := xml.MarshalIndent(req, "", "\t")
 	assert.NoError(t, err)
 
-	expectedOutput := `<AuthnRequest xmlns="urn:oasis:names:tc:SAML:2.0:protocol" AssertionConsumerServiceURL="http://localhost:1235/saml/acs" Destination="http://localhost:1233/saml/sso" ID="id-MOCKID" IssueInstant="` + Now().Format(time.RFC3339Nano) + `" ProtocolBinding="" Version="2.0">
-	<Issuer xmlns="urn:oasis:names:tc:SAML:2.0:assertion" Format="urn:oasis:names:tc:SAML:2.0:nameid-format:entity">http://localhost:1235/saml/service.xml</Issuer>
-	<NameIDPolicy xmlns="urn:oasis:names:tc:SAML:2.0:protocol" AllowCreate="true">urn:oasis:names:tc:SAML:2.0:nameid-format:transient</NameIDPolicy>
-</AuthnRequest>`
+	expectedOutput := `<samlp:AuthnRequest xmlns:samlp="urn:oasis:names:tc:SAML:2.0:protocol" xmlns:saml="urn:oasis:names:tc:SAML:2.0:assertion" AssertionConsumerServiceURL="http://localhost:1235/saml/acs" Destination="http://localhost:1233/saml/sso" ID="id-MOCKID" IssueInstant="` + Now().Format(time.RFC3339Nano) + `" Version="2.0">
+	<saml:Issuer Format="urn:oasis:names:tc:SAML:2.0:nameid-format:entity">http://localhost:1235/saml/service.xml</saml:Issuer>
+	<samlp:NameIDPolicy AllowCreate="true" Format="urn:oasis:names:tc:SAML:2.0:nameid-format:transient"></samlp:NameIDPolicy>
+</samlp:AuthnRequest>`
 
 	assert.Equal(t, expectedOutput, string(out))
+
+	var decoded AuthnRequest
+	assert.NoError(t, xml.Unmarshal(out, &decoded))
+	assert.Equal(t, req.ID, decoded.ID)
+	assert.Equal(t, req.Issuer.Value, decoded.Issuer.Value)
+	assert.Equal(t, req.NameIDPolicy.Format, decoded.NameIDPolicy.Format)
+}
+
+func TestMakeAuthenticationRequestWithOptions(t *testing.T) {
+	tearUp()
+
+	req, err := testSP.NewAuthnRequest(testIdP.SSOURL,
+		WithProviderName("Test SP"),
+		WithConsent(ConsentObtained),
+	)
+	assert.NoError(t, err)
+
+	assert.Equal(t, "Test SP", req.ProviderName)
+	assert.Equal(t, ConsentObtained, req.Consent)
+
+	out, err := xml.Marshal(req)
+	assert.NoError(t, err)
+	assert.Contains(t, string(out), ` ProviderName="Test SP" Consent="urn:oasis:names:tc:SAML:2.0:consent:obtained"`)
+}
+
+func TestNewLogoutRequest(t *testing.T) {
+	tearUp()
+
+	nameID := NameID{
+		Format:          NameIDFormatPersistent,
+		NameQualifier:   "https://idp.example.com",
+		SPNameQualifier: "https://sp.example.com",
+		SPProvidedID:    "anakin",
+		Value:           "a1b2c3",
+	}
+	sp := *testSP
+	sp.IdPMetadata = &Metadata{
+		EntityID:         "https://idp.example.com",
+		IDPSSODescriptor: &IDPSSODescriptor{},
+	}
+	req, err := sp.NewLogoutRequest(testIdP.SSOURL, nameID, "_s1")
+	assert.NoError(t, err)
+
+	out, err := xml.Marshal(req)
+	assert.NoError(t, err)
+	assert.Contains(t, string(out), `<samlp:LogoutRequest xmlns:samlp="urn:oasis:names:tc:SAML:2.0:protocol" xmlns:saml="urn:oasis:names:tc:SAML:2.0:assertion" ID="`+req.ID+`"`)
+	assert.Contains(t, string(out), `<saml:NameID Format="urn:oasis:names:tc:SAML:2.0:nameid-format:persistent" NameQualifier="https://idp.example.com" SPNameQualifier="https://sp.example.com" SPProvidedID="anakin">a1b2c3</saml:NameID><samlp:SessionIndex>_s1</samlp:SessionIndex>`)
+
+	var decoded LogoutRequest
+	assert.NoError(t, xml.Unmarshal(out, &decoded))
+	assert.Equal(t, []string{"_s1"}, decoded.SessionIndex)
+	got, err := sp.LogoutRequestNameID(&decoded)
+	assert.NoError(t, err)
+	assert.Equal(t, nameID, *got)
+
+	_, err = sp.NewLogoutRequest(testIdP.SSOURL, NameID{})
+	assert.Error(t, err)
+	_, err = sp.LogoutRequestNameID(&LogoutRequest{})
+	assert.Error(t, err)
+}
+
+func TestLogoutRequestEncryptedID(t *testing.T) {
+	tearUp()
+	if _, err := exec.LookPath("xmlsec1"); err != nil {
+		t.Skip("xmlsec1 not found")
+	}
+
+	// The IdP encrypts for the test SP key pair, so that the SP can
+	// decrypt its own request.
+	spMetadata, err := testSP.Metadata()
+	assert.NoError(t, err)
+	sp := *testSP
+	sp.IdPMetadata = &Metadata{
+		EntityID: "https://idp.example.com",
+		IDPSSODescriptor: &IDPSSODescriptor{
+			KeyDescriptor: spMetadata.SPSSODescriptor.KeyDescriptor,
+		},
+	}
+
+	nameID := NameID{Format: NameIDFormatPersistent, SPNameQualifier: "https://sp.example.com", Value: "a1b2c3"}
+	req, err := sp.NewLogoutRequest(testIdP.SSOURL, nameID)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Nil(t, req.NameID)
+	out, err := xml.Marshal(req)
+	assert.NoError(t, err)
+	assert.NotContains(t, string(out), "a1b2c3")
+
+	var decoded LogoutRequest
+	assert.NoError(t, xml.Unmarshal(out, &decoded))
+	got, err := sp.LogoutRequestNameID(&decoded)
+	assert.NoError(t, err)
+	assert.Equal(t, nameID, *got)
+}
+
+func TestAuthnRequestSubject(t *testing.T) {
+	tearUp()
+
+	req, err := testSP.NewAuthnRequest(testIdP.SSOURL, WithSubject(&NameID{
+		Format: "urn:oasis:names:tc:SAML:1.1:nameid-format:emailAddress",
+		Value:  "anakin@example.org",
+	}))
+	assert.NoError(t, err)
+
+	out, err := xml.Marshal(req)
+	assert.NoError(t, err)
+	assert.Contains(t, string(out), `<saml:Subject><saml:NameID Format="urn:oasis:names:tc:SAML:1.1:nameid-format:emailAddress">anakin@example.org</saml:NameID></saml:Subject>`)
+
+	assertion := &Assertion{
+		Subject: &Subject{
+			NameID: &NameID{
+				Format: "urn:oasis:names:tc:SAML:1.1:nameid-format:emailAddress",
+				Value:  "anakin@example.org",
+			},
+		},
+	}
+	assert.NoError(t, req.ValidateSubject(assertion))
+
+	assertion.Subject.NameID.Value = "vader@example.org"
+	assert.Error(t, req.ValidateSubject(assertion))
+
+	assert.Error(t, req.ValidateSubject(&Assertion{}))
+
+	req.Subject = nil
+	assert.NoError(t, req.ValidateSubject(&Assertion{}))
+}
+
+func TestAuthnRequestNameIDPolicy(t *testing.T) {
+	tearUp()
+
+	sp := *testSP
+	sp.NameIDPolicy = &NameIDPolicy{
+		Format:          NameIDFormatPersistent,
+		SPNameQualifier: "urn:example:sp",
+	}
+
+	req, err := sp.NewAuthnRequest(testIdP.SSOURL)
+	assert.NoError(t, err)
+	assert.Equal(t, NameIDFormatPersistent, req.NameIDPolicy.Format)
+	assert.False(t, req.NameIDPolicy.AllowCreate)
+
+	out, err := xml.Marshal(req.NameIDPolicy)
+	assert.NoError(t, err)
+	assert.Equal(t, `<NameIDPolicy xmlns="urn:oasis:names:tc:SAML:2.0:protocol" AllowCreate="false" Format="urn:oasis:names:tc:SAML:2.0:nameid-format:persistent" SPNameQualifier="urn:example:sp"></NameIDPolicy>`, string(out))
+
+	req, err = sp.NewAuthnRequest(testIdP.SSOURL, WithNameIDPolicy(NameIDPolicy{
+		AllowCreate: true,
+		Format:      NameIDFormatEmailAddress,
+	}))
+	assert.NoError(t, err)
+	assert.Equal(t, NameIDFormatEmailAddress, req.NameIDPolicy.Format)
+	assert.True(t, req.NameIDPolicy.AllowCreate)
+}
+
+func TestMultipleAssertionConsumerServices(t *testing.T) {
+	tearUp()
+
+	sp := *testSP
+	sp.AssertionConsumerServices = []IndexedEndpoint{
+		{Binding: HTTPPostBinding, Location: "http://localhost:1235/saml/acs", Index: 1, IsDefault: true},
+		{Binding: HTTPPostBinding, Location: "http://localhost:1236/saml/acs", Index: 2},
+	}
+
+	metadata, err := sp.Metadata()
+	assert.NoError(t, err)
+	assert.Equal(t, sp.AssertionConsumerServices, metadata.SPSSODescriptor.AssertionConsumerService)
+
+	assert.True(t, sp.isAcsURL("http://localhost:1235/saml/acs"))
+	assert.True(t, sp.isAcsURL("http://localhost:1236/saml/acs"))
+	assert.False(t, sp.isAcsURL("http://localhost:1237/saml/acs"))
+
+	req, err := sp.NewAuthnRequest(testIdP.SSOURL, WithAssertionConsumerServiceIndex(2))
+	assert.NoError(t, err)
+
+	out, err := xml.Marshal(req)
+	assert.NoError(t, err)
+	assert.Contains(t, string(out), ` AssertionConsumerServiceIndex="2"`)
+	assert.NotContains(t, string(out), `AssertionConsumerServiceURL`)
+	assert.NotContains(t, string(out), `ProtocolBinding`)
+
+	// The index must be published, and designates the binding too.
+	_, err = sp.NewAuthnRequest(testIdP.SSOURL, WithAssertionConsumerServiceIndex(3))
+	assert.Error(t, err)
+	_, err = sp.NewAuthnRequest(testIdP.SSOURL, WithAssertionConsumerServiceIndex(2), WithProtocolBinding(HTTPPostBinding))
+	assert.Error(t, err)
+	_, err = sp.NewAuthnRequest(testIdP.SSOURL, WithProtocolBinding(HTTPPostBinding), WithAssertionConsumerServiceIndex(2))
+	assert.Error(t, err)
+}
+
+func TestAuthnRequestProtocolBinding(t *testing.T) {
+	tearUp()
+
+	sp := *testSP
+	sp.ProtocolBinding = HTTPPostBinding
+
+	req, err := sp.NewAuthnRequest(testIdP.SSOURL)
+	assert.NoError(t, err)
+	assert.Equal(t, HTTPPostBinding, req.ProtocolBinding)
+
+	req, err = sp.NewAuthnRequest(testIdP.SSOURL, WithProtocolBinding(HTTPArtifactBinding))
+	assert.NoError(t, err)
+	assert.Equal(t, HTTPArtifactBinding, req.ProtocolBinding)
+
+	out, err := xml.Marshal(req)
+	assert.NoError(t, err)
+	assert.Contains(t, string(out), ` ProtocolBinding="urn:oasis:names:tc:SAML:2.0:bindings:HTTP-Artifact"`)
+}
+
+type fixedClock time.Time
+
+func (c fixedClock) Now() time.Time {
+	return time.Time(c)
+}
+
+func TestServiceProviderClock(t *testing.T) {
+	tearUp()
+
+	instant := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	sp := *testSP
+	sp.Clock = fixedClock(instant)
+
+	req, err := sp.NewAuthnRequest(testIdP.SSOURL)
+	assert.NoError(t, err)
+	assert.True(t, instant.Equal(req.IssueInstant))
+
+	metadata, err := sp.Metadata()
+	assert.NoError(t, err)
+	assert.True(t, instant.Add(defaultValidDuration).Equal(metadata.ValidUntil))
+
+	// The validity of the certificate is checked with the clock.
+	_, certPEM, cert := newTestKeyPair(t, KeyPairOptions{Type: KeyTypeECDSA, Validity: time.Hour})
+	sp.PubkeyPEM, sp.CertFile = certPEM, ""
+	sp.Clock = fixedClock(cert.NotAfter.Add(-time.Minute))
+	_, err = sp.PubkeyFile()
+	assert.NoError(t, err)
+	sp.Clock = fixedClock(cert.NotAfter.Add(time.Minute))
+	_, err = sp.PubkeyFile()
+	assert.Error(t, err)
+}
+
+func TestServiceProviderClockDrift(t *testing.T) {
+	defer func(tolerance time.Duration) { ClockDriftTolerance = tolerance }(ClockDriftTolerance)
+	ClockDriftTolerance = time.Minute
+
+	sp := &ServiceProvider{}
+	assert.Equal(t, ClockDrift{NotBefore: time.Minute, NotOnOrAfter: time.Minute}, sp.clockDrift())
+
+	sp.ClockDrift = &ClockDrift{NotBefore: 2 * time.Minute}
+	assert.Equal(t, ClockDrift{NotBefore: 2 * time.Minute}, sp.clockDrift())
+}
+
+func TestServiceProviderIDGenerator(t *testing.T) {
+	tearUp()
+
+	sp := *testSP
+	n := 0
+	sp.IDGenerator = func() string {
+		n++
+		return fmt.Sprintf("id-request-%d", n)
+	}
+
+	req, err := sp.NewAuthnRequest(testIdP.SSOURL)
+	assert.NoError(t, err)
+	assert.Equal(t, "id-request-1", req.ID)
+
+	req, err = sp.NewAuthnRequest(testIdP.SSOURL)
+	assert.NoError(t, err)
+	assert.Equal(t, "id-request-2", req.ID)
+}
+
+func TestNormalizeURL(t *testing.T) {
+	tests := map[string]string{
+		"http://localhost:1235/saml/acs":      "http://localhost:1235/saml/acs",
+		"HTTPS://SP.Example.org:443/saml/acs": "https://sp.example.org/saml/acs",
+		"http://sp.example.org:80":            "http://sp.example.org/",
+		"https://sp.example.org:8443/acs":     "https://sp.example.org:8443/acs",
+		"https://[::1]:443/acs":               "https://[::1]/acs",
+		"/saml/acs":                           "/saml/acs",
+	}
+	for in, out := range tests {
+		assert.Equal(t, out, normalizeURL(in), in)
+	}
+
+	sp := &ServiceProvider{AcsURL: "https://sp.example.org/saml/acs"}
+	assert.False(t, sp.isAcsURL("https://SP.example.org:443/saml/acs"))
+	sp.NormalizeURLs = true
+	assert.True(t, sp.isAcsURL("https://SP.example.org:443/saml/acs"))
+	assert.False(t, sp.isAcsURL("http://sp.example.org/saml/acs"))
+}
+
+func TestGetIdPMetadataSingleFetch(t *testing.T) {
+	buf, err := xml.Marshal(&Metadata{EntityID: "https://idp.example.com"})
+	assert.NoError(t, err)
+
+	var hits int32
+	fetched := make(chan struct{}, 1)
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&hits, 1) == 1 {
+			fetched <- struct{}{}
+			<-release
+		}
+		w.Write(buf)
+	}))
+	defer srv.Close()
+
+	sp := &ServiceProvider{IdPMetadataURL: srv.URL}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			metadata, err := sp.GetIdPMetadata()
+			if err == nil && metadata.EntityID != "https://idp.example.com" {
+				err = fmt.Errorf("unexpected entity ID %q", metadata.EntityID)
+			}
+			errs <- err
+		}()
+	}
+
+	<-fetched
+	// Give the other callers the time to wait for the fetch in progress.
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, int32(1), atomic.LoadInt32(&hits))
+	assert.Equal(t, buf, sp.IdPMetadataXML)
+}
+
+func TestGetIdPMetadataFetchError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("<not metadata"))
+	}))
+	defer srv.Close()
+
+	sp := &ServiceProvider{IdPMetadataURL: srv.URL}
+	_, err := sp.GetIdPMetadata()
+	assert.Error(t, err)
+	assert.Nil(t, sp.IdPMetadata)
+
+	// The next call tries again.
+	_, err = sp.GetIdPMetadata()
+	assert.Error(t, err)
+	assert.Empty(t, metadataCalls)
+}
+
+func TestGetIdPMetadataContext(t *testing.T) {
+	buf, err := xml.Marshal(&Metadata{EntityID: "https://idp.example.com"})
+	assert.NoError(t, err)
+
+	var hits int32
+	fetched := make(chan struct{}, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&hits, 1) == 1 {
+			fetched <- struct{}{}
+			<-r.Context().Done()
+			return
+		}
+		w.Write(buf)
+	}))
+	defer srv.Close()
+
+	sp := &ServiceProvider{IdPMetadataURL: srv.URL}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	leader := make(chan error, 1)
+	go func() {
+		_, err := sp.GetIdPMetadataContext(ctx)
+		leader <- err
+	}()
+	<-fetched
+
+	// A caller whose context is done does not wait for the fetch.
+	canceled, cancelWaiter := context.WithCancel(context.Background())
+	cancelWaiter()
+	_, err = sp.GetIdPMetadataContext(canceled)
+	assert.Equal(t, context.Canceled, err)
+
+	waiter := make(chan error, 1)
+	go func() {
+		_, err := sp.GetIdPMetadataContext(context.Background())
+		waiter <- err
+	}()
+	// Give the waiter the time to wait for the fetch in progress.
+	time.Sleep(20 * time.Millisecond)
+
+	// The fetch is cancelled with the context of the caller that started
+	// it, and started again for the waiter.
+	cancel()
+	assert.Error(t, <-leader)
+	assert.NoError(t, <-waiter)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&hits))
+	assert.Equal(t, "https://idp.example.com", sp.IdPMetadata.EntityID)
+}
+
+func TestSPMetadataOrganization(t *testing.T) {
+	tearUp()
+
+	sp := *testSP
+	sp.Organization = &Organization{
+		Names:        []LocalizedName{{Lang: "en", Value: "Example"}},
+		DisplayNames: []LocalizedName{{Lang: "en", Value: "Example Inc."}},
+		URLs:         []LocalizedName{{Lang: "en", Value: "https://www.example.com"}},
+	}
+	sp.ContactPersons = []ContactPerson{
+		{ContactType: ContactTypeTechnical, GivenName: "Jane", EmailAddresses: []string{"mailto:sso@example.com"}},
+		{ContactType: ContactTypeSupport, EmailAddresses: []string{"mailto:support@example.com"}},
+	}
+
+	buf, err := sp.MetadataXML()
+	assert.NoError(t, err)
+	out := string(buf)
+	assert.Contains(t, out, "\t<Organization>\n\t\t<OrganizationName xml:lang=\"en\">Example</OrganizationName>")
+	assert.Contains(t, out, "\t<ContactPerson contactType=\"support\">\n\t\t<EmailAddress>mailto:support@example.com</EmailAddress>\n\t</ContactPerson>")
+	// The Organization comes after the role descriptors.
+	assert.True(t, strings.Index(out, "</SPSSODescriptor>") < strings.Index(out, "<Organization>"))
+
+	var metadata Metadata
+	assert.NoError(t, xml.Unmarshal(buf, &metadata))
+	assert.Equal(t, sp.Organization, metadata.Organization)
+	assert.Equal(t, sp.ContactPersons, metadata.ContactPersons)
+}
+
+func TestSPMetadataAttributeConsumingService(t *testing.T) {
+	tearUp()
+
+	sp := *testSP
+	sp.AttributeConsumingServices = []AttributeConsumingService{{
+		ServiceNames: []LocalizedName{{Lang: "en", Value: "Example"}},
+		RequestedAttributes: []RequestedAttribute{
+			{Name: "urn:oid:0.9.2342.19200300.100.1.3", NameFormat: AttributeNameFormatURI, FriendlyName: "mail", IsRequired: true},
+			{Name: "urn:oid:2.16.840.1.113730.3.1.241", NameFormat: AttributeNameFormatURI, FriendlyName: "displayName"},
+		},
+	}}
+
+	buf, err := sp.MetadataXML()
+	assert.NoError(t, err)
+	out := string(buf)
+	assert.Contains(t, out, "\t\t<AttributeConsumingService index=\"1\">\n\t\t\t<ServiceName xml:lang=\"en\">Example</ServiceName>\n")
+	assert.Contains(t, out, "<RequestedAttribute Name=\"urn:oid:0.9.2342.19200300.100.1.3\" NameFormat=\"urn:oasis:names:tc:SAML:2.0:attrname-format:uri\" FriendlyName=\"mail\" isRequired=\"true\"></RequestedAttribute>")
+	assert.Contains(t, out, "<RequestedAttribute Name=\"urn:oid:2.16.840.1.113730.3.1.241\" NameFormat=\"urn:oasis:names:tc:SAML:2.0:attrname-format:uri\" FriendlyName=\"displayName\"></RequestedAttribute>")
+	// The services of the SP are left untouched.
+	assert.Equal(t, 0, sp.AttributeConsumingServices[0].Index)
+
+	var metadata Metadata
+	assert.NoError(t, xml.Unmarshal(buf, &metadata))
+	services := metadata.SPSSODescriptor.AttributeConsumingService
+	if assert.Len(t, services, 1) {
+		assert.Equal(t, 1, services[0].Index)
+		assert.Equal(t, sp.AttributeConsumingServices[0].RequestedAttributes, services[0].RequestedAttributes)
+	}
+}
+
+func TestSPMetadataSingleLogoutService(t *testing.T) {
+	tearUp()
+
+	sp := *testSP
+	sp.SloURL = "https://sp.example.com/saml/slo"
+	sp.SloResponseURL = "https://sp.example.com/saml/slo/response"
+
+	metadata, err := sp.Metadata()
+	assert.NoError(t, err)
+	assert.Equal(t, []Endpoint{
+		{Binding: HTTPRedirectBinding, Location: sp.SloURL, ResponseLocation: sp.SloResponseURL},
+		{Binding: HTTPPostBinding, Location: sp.SloURL, ResponseLocation: sp.SloResponseURL},
+	}, metadata.SPSSODescriptor.SingleLogoutService)
+
+	buf, err := sp.MetadataXML()
+	assert.NoError(t, err)
+	// SingleLogoutService comes before NameIDFormat and AssertionConsumerService.
+	assert.Contains(t, string(buf), "\t\t<SingleLogoutService Binding=\"urn:oasis:names:tc:SAML:2.0:bindings:HTTP-POST\" Location=\"https://sp.example.com/saml/slo\" ResponseLocation=\"https://sp.example.com/saml/slo/response\"></SingleLogoutService>\n\t\t<NameIDFormat>")
+
+	// Explicit endpoints take precedence.
+	sp.SingleLogoutServices = []Endpoint{{Binding: HTTPRedirectBinding, Location: "https://sp.example.com/logout"}}
+	metadata, err = sp.Metadata()
+	assert.NoError(t, err)
+	assert.Equal(t, sp.SingleLogoutServices, metadata.SPSSODescriptor.SingleLogoutService)
+}
+
+func TestSPMetadataNameIDFormat(t *testing.T) {
+	tearUp()
+
+	sp := *testSP
+	sp.NameIDPolicy = &NameIDPolicy{AllowCreate: true, Format: NameIDFormatPersistent}
+	metadata, err := sp.Metadata()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{NameIDFormatPersistent}, metadata.SPSSODescriptor.NameIDFormat)
+
+	sp.NameIDPolicy = &NameIDPolicy{Format: NameIDFormatUnspecified}
+	metadata, err = sp.Metadata()
+	assert.NoError(t, err)
+	assert.Empty(t, metadata.SPSSODescriptor.NameIDFormat)
+
+	sp.NameIDFormats = []string{NameIDFormatEmailAddress, NameIDFormatPersistent}
+	metadata, err = sp.Metadata()
+	assert.NoError(t, err)
+	assert.Equal(t, sp.NameIDFormats, metadata.SPSSODescriptor.NameIDFormat)
+}
+
+func TestSPEntityID(t *testing.T) {
+	tearUp()
+
+	sp := *testSP
+	sp.EntityID = "urn:example:sp"
+
+	metadata, err := sp.Metadata()
+	assert.NoError(t, err)
+	assert.Equal(t, "urn:example:sp", metadata.EntityID)
+
+	req, err := sp.NewAuthnRequest("https://idp.example.com/sso")
+	assert.NoError(t, err)
+	assert.Equal(t, "urn:example:sp", req.Issuer.Value)
+
+	// The metadata is still served at MetadataURL.
+	routes, err := sp.Routes()
+	assert.NoError(t, err)
+	w := httptest.NewRecorder()
+	routes.ServeHTTP(w, httptest.NewRequest("GET", "/saml/service.xml", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `entityID="urn:example:sp"`)
+}
+
+func TestSPMetadataValidity(t *testing.T) {
+	tearUp()
+
+	sp := *testSP
+	sp.MetadataValidDuration = 7 * 24 * time.Hour
+	sp.MetadataCacheDuration = 6 * time.Hour
+
+	buf, err := sp.MetadataXML()
+	assert.NoError(t, err)
+	assert.Contains(t, string(buf), ` validUntil="`+Now().Add(7*24*time.Hour).Format(time.RFC3339Nano)+`" cacheDuration="PT6H" `)
+
+	var metadata Metadata
+	assert.NoError(t, xml.Unmarshal(buf, &metadata))
+	assert.Equal(t, 6*time.Hour, metadata.CacheDuration.Duration())
+
+	sp.MetadataValidDuration = -1
+	buf, err = sp.MetadataXML()
+	assert.NoError(t, err)
+	assert.NotContains(t, string(buf), "validUntil")
+}
+
+func TestSPMetadataWantAssertionsSigned(t *testing.T) {
+	tearUp()
+
+	for policy, want := range map[SigningPolicy]bool{
+		SigningPolicyEither:    false,
+		SigningPolicyResponse:  false,
+		SigningPolicyAssertion: true,
+		SigningPolicyBoth:      true,
+	} {
+		sp := *testSP
+		sp.SigningPolicy = policy
+		metadata, err := sp.Metadata()
+		assert.NoError(t, err)
+		assert.Equal(t, want, metadata.SPSSODescriptor.WantAssertionsSigned, policy.String())
+		assert.False(t, metadata.SPSSODescriptor.AuthnRequestsSigned)
+	}
 }
@@ -0,0 +1,128 @@
+package saml
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// Names of the checks run when validating a SAML response.
+const (
+	CheckDecode                = "decode"
+	CheckSchema                = "schema"
+	CheckIdPMetadata           = "idp-metadata"
+	CheckDestination           = "destination"
+	CheckIssuer                = "issuer"
+	CheckStatus                = "status"
+	CheckInResponseTo          = "in-response-to"
+	CheckSignature             = "signature"
+	CheckDecrypt               = "decrypt"
+	CheckAssertion             = "assertion"
+	CheckAssertionIssuer       = "assertion-issuer"
+	CheckRecipient             = "recipient"
+	CheckAddress               = "address"
+	CheckHolderOfKey           = "holder-of-key"
+	CheckConditions            = "conditions"
+	CheckNotBefore             = "not-before"
+	CheckNotOnOrAfter          = "not-on-or-after"
+	CheckSubjectConfirmation   = "subject-confirmation"
+	CheckAudience              = "audience"
+	CheckProxyRestriction      = "proxy-restriction"
+	CheckAssertionInResponseTo = "assertion-in-response-to"
+	CheckAuthnInstant          = "authn-instant"
+	CheckSubject               = "subject"
+	CheckAuthnContext          = "authn-context"
+)
+
+// ValidationIssue is a failed check or a warning reported while validating a
+// SAML response.
+type ValidationIssue struct {
+	Check string
+	Err   error
+}
+
+func (issue ValidationIssue) String() string {
+	return fmt.Sprintf("%s: %v", issue.Check, issue.Err)
+}
+
+// ValidationResult lists the outcome of every check run against a SAML
+// response.
+type ValidationResult struct {
+	// Assertion is the assertion that was found in the response, if any. It
+	// must not be trusted unless Failures is empty.
+	Assertion *Assertion
+
+	// Response is the decoded response, if it could be decoded.
+	Response *Response
+
+	Passed   []string
+	Failures []ValidationIssue
+	Warnings []ValidationIssue
+
+	// Incomplete is true when a check failed in a way that prevented the
+	// remaining checks from running.
+	Incomplete bool
+}
+
+// Valid returns whether the response passed all the checks.
+func (r *ValidationResult) Valid() bool {
+	return len(r.Failures) == 0
+}
+
+// Err returns the first failure, or nil if the response is valid.
+func (r *ValidationResult) Err() error {
+	if len(r.Failures) == 0 {
+		return nil
+	}
+	return r.Failures[0].Err
+}
+
+// String returns a human readable report of the validation.
+func (r *ValidationResult) String() string {
+	var out bytes.Buffer
+	for _, check := range r.Passed {
+		fmt.Fprintf(&out, "PASS %s\n", check)
+	}
+	for _, issue := range r.Warnings {
+		fmt.Fprintf(&out, "WARN %s\n", issue)
+	}
+	for _, issue := range r.Failures {
+		fmt.Fprintf(&out, "FAIL %s\n", issue)
+	}
+	if r.Incomplete {
+		out.WriteString("(validation stopped early)\n")
+	}
+	return out.String()
+}
+
+// validator records check outcomes into a ValidationResult. In failFast mode
+// stop reports true as soon as a check failed.
+type validator struct {
+	failFast bool
+	result   *ValidationResult
+}
+
+func (v *validator) pass(check string) {
+	v.result.Passed = append(v.result.Passed, check)
+}
+
+func (v *validator) fail(check string, err error) {
+	v.result.Failures = append(v.result.Failures, ValidationIssue{Check: check, Err: err})
+}
+
+func (v *validator) warn(check string, err error) {
+	v.result.Warnings = append(v.result.Warnings, ValidationIssue{Check: check, Err: err})
+}
+
+// fatal records a failure that prevents any further check.
+func (v *validator) fatal(check string, err error) {
+	v.fail(check, err)
+	v.result.Incomplete = true
+}
+
+func (v *validator) stop() bool {
+	if v.failFast && len(v.result.Failures) > 0 {
+		v.result.Incomplete = true
+		return true
+	}
+	return false
+}
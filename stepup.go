@@ -0,0 +1,108 @@
+package saml
+
+import "net/http"
+
+// Authentication context classes defined by the SAML specification.
+//
+// See http://docs.oasis-open.org/security/saml/v2.0/saml-authn-context-2.0-os.pdf section 3.4
+const (
+	AuthnContextUnspecified                = "urn:oasis:names:tc:SAML:2.0:ac:classes:unspecified"
+	AuthnContextPassword                   = "urn:oasis:names:tc:SAML:2.0:ac:classes:Password"
+	AuthnContextPasswordProtectedTransport = "urn:oasis:names:tc:SAML:2.0:ac:classes:PasswordProtectedTransport"
+	AuthnContextKerberos                   = "urn:oasis:names:tc:SAML:2.0:ac:classes:Kerberos"
+	AuthnContextX509                       = "urn:oasis:names:tc:SAML:2.0:ac:classes:X509"
+	AuthnContextTLSClient                  = "urn:oasis:names:tc:SAML:2.0:ac:classes:TLSClient"
+	AuthnContextTimeSyncToken              = "urn:oasis:names:tc:SAML:2.0:ac:classes:TimeSyncToken"
+	AuthnContextMobileTwoFactorContract    = "urn:oasis:names:tc:SAML:2.0:ac:classes:MobileTwoFactorContract"
+	AuthnContextSmartcardPKI               = "urn:oasis:names:tc:SAML:2.0:ac:classes:SmartcardPKI"
+)
+
+// Comparison methods of a RequestedAuthnContext.
+const (
+	AuthnContextComparisonExact   = "exact"
+	AuthnContextComparisonMinimum = "minimum"
+	AuthnContextComparisonMaximum = "maximum"
+	AuthnContextComparisonBetter  = "better"
+)
+
+// AuthnContextLevels ranks authentication context classes from the weakest
+// to the strongest. The specification does not order the classes: each SP
+// decides which ones are strong enough for its resources, e.g.
+//
+//	AuthnContextLevels{
+//		AuthnContextPasswordProtectedTransport,
+//		AuthnContextMobileTwoFactorContract,
+//		AuthnContextSmartcardPKI,
+//	}
+type AuthnContextLevels []string
+
+func (levels AuthnContextLevels) index(classRef string) int {
+	for i, level := range levels {
+		if level == classRef {
+			return i
+		}
+	}
+	return -1
+}
+
+// Satisfies reports whether classRef is at least as strong as required.
+// Classes missing from levels satisfy nothing but themselves.
+func (levels AuthnContextLevels) Satisfies(classRef, required string) bool {
+	if classRef == required {
+		return true
+	}
+	i, j := levels.index(classRef), levels.index(required)
+	return i >= 0 && j >= 0 && i >= j
+}
+
+// AtLeast returns the classes at least as strong as required, the weakest
+// first.
+func (levels AuthnContextLevels) AtLeast(required string) []string {
+	i := levels.index(required)
+	if i < 0 {
+		return []string{required}
+	}
+	return append([]string(nil), levels[i:]...)
+}
+
+// NeedsStepUp reports whether a user authenticated with the current
+// authentication context class, e.g. from the session established at
+// login, must authenticate again to access a resource requiring the
+// required class.
+func (sp *ServiceProvider) NeedsStepUp(current, required string) bool {
+	return !sp.AuthnContextLevels.Satisfies(current, required)
+}
+
+// StepUpOptions returns the options of an AuthnRequest asking the IdP to
+// authenticate the user again with a class at least as strong as required:
+// ForceAuthn is set, and the acceptable classes are listed with the exact
+// comparison, which more IdPs support than the minimum one.
+func (sp *ServiceProvider) StepUpOptions(required string) []AuthnRequestOption {
+	return []AuthnRequestOption{
+		WithForceAuthn(),
+		WithRequestedAuthnContext(AuthnContextComparisonExact, sp.AuthnContextLevels.AtLeast(required)...),
+	}
+}
+
+// StepUp sends the user agent to the IdP to authenticate again with a class
+// at least as strong as required, see StepUpOptions. The request is tracked
+// with required: AssertionMiddleware rejects the assertions received in
+// return unless they satisfy CheckAuthnContext.
+func (sp *ServiceProvider) StepUp(w http.ResponseWriter, r *http.Request, relayState, required string) {
+	sp.sendAuthnRequest(w, r, relayState, TrackedRequest{AuthnContext: required}, sp.StepUpOptions(required)...)
+}
+
+// CheckAuthnContext checks that the user was authenticated with a class at
+// least as strong as required according to assertion. IdPs may ignore the
+// RequestedAuthnContext of a request, so the assertions answering StepUp are
+// checked at the ACS. The error matches ErrAuthnContext.
+func (sp *ServiceProvider) CheckAuthnContext(assertion *Assertion, required string) error {
+	if assertion.AuthnStatement == nil {
+		return validationErrorf(ErrAuthnContext, nil, "missing Assertion > AuthnStatement")
+	}
+	classRef := assertion.AuthnStatement.AuthnContext.ClassRef()
+	if !sp.AuthnContextLevels.Satisfies(classRef, required) {
+		return validationErrorf(ErrAuthnContext, nil, "got %q, required %q", classRef, required)
+	}
+	return nil
+}
@@ -0,0 +1,129 @@
+package saml
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuthnContextLevels(t *testing.T) {
+	levels := AuthnContextLevels{
+		AuthnContextPasswordProtectedTransport,
+		AuthnContextMobileTwoFactorContract,
+		AuthnContextSmartcardPKI,
+	}
+	assert.True(t, levels.Satisfies(AuthnContextSmartcardPKI, AuthnContextMobileTwoFactorContract))
+	assert.True(t, levels.Satisfies(AuthnContextMobileTwoFactorContract, AuthnContextMobileTwoFactorContract))
+	assert.False(t, levels.Satisfies(AuthnContextPasswordProtectedTransport, AuthnContextMobileTwoFactorContract))
+	assert.False(t, levels.Satisfies(AuthnContextX509, AuthnContextPasswordProtectedTransport))
+	assert.False(t, levels.Satisfies("", AuthnContextPasswordProtectedTransport))
+	assert.True(t, levels.Satisfies(AuthnContextX509, AuthnContextX509))
+
+	assert.Equal(t, []string{AuthnContextMobileTwoFactorContract, AuthnContextSmartcardPKI}, levels.AtLeast(AuthnContextMobileTwoFactorContract))
+	assert.Equal(t, []string{AuthnContextX509}, levels.AtLeast(AuthnContextX509))
+}
+
+func TestStepUp(t *testing.T) {
+	tearUp()
+
+	sp := newTestResponseSP()
+	sp.IdPMetadata.IDPSSODescriptor = &IDPSSODescriptor{
+		SingleSignOnService: []Endpoint{{
+			Binding:  HTTPRedirectBinding,
+			Location: "http://localhost:1233/saml/sso",
+		}},
+	}
+	sp.AuthnContextLevels = AuthnContextLevels{
+		AuthnContextPasswordProtectedTransport,
+		AuthnContextMobileTwoFactorContract,
+		AuthnContextSmartcardPKI,
+	}
+
+	assert.True(t, sp.NeedsStepUp(AuthnContextPasswordProtectedTransport, AuthnContextMobileTwoFactorContract))
+	assert.False(t, sp.NeedsStepUp(AuthnContextSmartcardPKI, AuthnContextMobileTwoFactorContract))
+
+	w := httptest.NewRecorder()
+	sp.StepUp(w, httptest.NewRequest("GET", "/admin", nil), "/admin", AuthnContextMobileTwoFactorContract)
+	assert.Equal(t, http.StatusFound, w.Code)
+	u, err := url.Parse(w.Header().Get("Location"))
+	assert.NoError(t, err)
+	msg, err := DecodeRedirect(u.RawQuery, SizeLimits{})
+	if assert.NoError(t, err) {
+		assert.Equal(t, "/admin", msg.RelayState)
+		assert.Contains(t, string(msg.XML), `<samlp:RequestedAuthnContext Comparison="exact"><saml:AuthnContextClassRef>urn:oasis:names:tc:SAML:2.0:ac:classes:MobileTwoFactorContract</saml:AuthnContextClassRef><saml:AuthnContextClassRef>urn:oasis:names:tc:SAML:2.0:ac:classes:SmartcardPKI</saml:AuthnContextClassRef></samlp:RequestedAuthnContext>`)
+
+		var req AuthnRequest
+		assert.NoError(t, xml.Unmarshal(msg.XML, &req))
+		assert.True(t, req.ForceAuthn)
+		if assert.NotNil(t, req.RequestedAuthnContext) {
+			assert.Equal(t, []string{AuthnContextMobileTwoFactorContract, AuthnContextSmartcardPKI}, req.RequestedAuthnContext.AuthnContextClassRefs)
+		}
+	}
+
+	// The request is tracked with the required class.
+	r := httptest.NewRequest("POST", sp.AcsURL, nil)
+	for _, cookie := range w.Result().Cookies() {
+		r.AddCookie(cookie)
+	}
+	tracker, err := sp.requestTracker()
+	if assert.NoError(t, err) {
+		requests := tracker.TrackedRequests(r)
+		if assert.Len(t, requests, 1) {
+			assert.Equal(t, AuthnContextMobileTwoFactorContract, requests[0].AuthnContext)
+		}
+	}
+
+	// The IdP may ignore the requested context.
+	assertion := &Assertion{
+		AuthnStatement: &AuthnStatement{
+			AuthnContext: AuthnContext{
+				AuthnContextClassRef: &AuthnContextClassRef{Value: AuthnContextPasswordProtectedTransport},
+			},
+		},
+	}
+	err = sp.CheckAuthnContext(assertion, AuthnContextMobileTwoFactorContract)
+	assert.True(t, errors.Is(err, ErrAuthnContext))
+
+	assertion.AuthnStatement.AuthnContext.AuthnContextClassRef.Value = AuthnContextSmartcardPKI
+	assert.NoError(t, sp.CheckAuthnContext(assertion, AuthnContextMobileTwoFactorContract))
+
+	assert.True(t, errors.Is(sp.CheckAuthnContext(&Assertion{}, AuthnContextMobileTwoFactorContract), ErrAuthnContext))
+}
+
+func TestStepUpResponse(t *testing.T) {
+	tearUp()
+
+	sp, simpleSign := newSimpleSignTestSP(t)
+	sp.AuthnContextLevels = AuthnContextLevels{
+		AuthnContextPasswordProtectedTransport,
+		AuthnContextMobileTwoFactorContract,
+	}
+	now := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+	res := newTestAssertionResponse(sp, now)
+	res.InResponseTo = "id-req"
+	res.Assertion.Subject.SubjectConfirmations[0].SubjectConfirmationData.InResponseTo = "id-req"
+	samlResponse := encodeTestResponse(t, res)
+
+	validate := func(required string) *ValidationResult {
+		requests := []TrackedRequest{{ID: "id-req", AuthnContext: required}}
+		return sp.validateResponse(context.Background(), samlResponse, simpleSign(samlResponse), requests, now, nil, nil, true)
+	}
+
+	// The IdP ignored the requested context.
+	result := validate(AuthnContextMobileTwoFactorContract)
+	if assert.Len(t, result.Failures, 1) {
+		assert.Equal(t, CheckAuthnContext, result.Failures[0].Check)
+		assert.True(t, errors.Is(result.Failures[0].Err, ErrAuthnContext))
+	}
+
+	result = validate(AuthnContextPasswordProtectedTransport)
+	assert.NoError(t, result.Err())
+	assert.Contains(t, result.Passed, CheckAuthnContext)
+}
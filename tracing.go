@@ -0,0 +1,72 @@
+package saml
+
+import "context"
+
+// Names of the spans started by a ServiceProvider.
+const (
+	SpanAuthnRequest   = "saml.AuthnRequest"
+	SpanFetchMetadata  = "saml.FetchMetadata"
+	SpanAssertResponse = "saml.AssertResponse"
+)
+
+// Attributes set on the spans.
+const (
+	AttrRequestID     = "saml.request_id"
+	AttrResponseID    = "saml.response_id"
+	AttrInResponseTo  = "saml.in_response_to"
+	AttrIssuer        = "saml.issuer"
+	AttrStatusCode    = "saml.status_code"
+	AttrSubStatusCode = "saml.sub_status_code"
+	AttrDestination   = "saml.destination"
+	AttrMetadataURL   = "saml.metadata_url"
+)
+
+// Tracer starts the spans of the SSO flow: the AuthnRequest redirection,
+// the download of the IdP metadata and the validation of the responses. See
+// the samlotel package for an OpenTelemetry implementation.
+type Tracer interface {
+	Start(ctx context.Context, name string) (context.Context, Span)
+}
+
+// Span is a unit of work started by a Tracer.
+type Span interface {
+	SetAttribute(key, value string)
+	// End ends the span, with the error that made the operation fail, if
+	// any.
+	End(err error)
+}
+
+type nopTracer struct{}
+
+func (nopTracer) Start(ctx context.Context, name string) (context.Context, Span) {
+	return ctx, nopSpan{}
+}
+
+type nopSpan struct{}
+
+func (nopSpan) SetAttribute(key, value string) {}
+func (nopSpan) End(err error)                  {}
+
+func (sp *ServiceProvider) tracer() Tracer {
+	if sp.Tracer == nil {
+		return nopTracer{}
+	}
+	return sp.Tracer
+}
+
+// setResponseAttributes sets the identifying attributes of a response on a
+// span.
+func setResponseAttributes(span Span, res *Response) {
+	span.SetAttribute(AttrResponseID, res.ID)
+	span.SetAttribute(AttrInResponseTo, res.InResponseTo)
+	span.SetAttribute(AttrDestination, res.Destination)
+	if res.Issuer != nil {
+		span.SetAttribute(AttrIssuer, res.Issuer.Value)
+	}
+	if res.Status != nil {
+		span.SetAttribute(AttrStatusCode, res.Status.StatusCode.Value)
+		if subCode := res.Status.subCode(); subCode != "" {
+			span.SetAttribute(AttrSubStatusCode, subCode)
+		}
+	}
+}
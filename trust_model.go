@@ -0,0 +1,170 @@
+package saml
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/url"
+
+	"github.com/goware/saml/xmlsec"
+	"github.com/pkg/errors"
+)
+
+// TrustModel tells how the SP trusts the certificates of the signatures of
+// the IdP.
+type TrustModel int
+
+const (
+	// TrustMetadata pins the certificates of the IdP metadata: the
+	// signatures are verified with them.
+	TrustMetadata TrustModel = iota
+	// TrustPKIX verifies the XML signatures with the certificate of their
+	// KeyInfo, which must chain to TrustRoots and name the IdP: one of its
+	// URI SANs is the IdP entity ID, one of its DNS SANs matches the host
+	// of the entity ID, or its subject common name is a KeyName of the
+	// signing keys of the IdP metadata. The signatures of the
+	// HTTP-Redirect and HTTP-POST-SimpleSign bindings, which carry no
+	// certificate, are verified with the certificate of the IdP metadata,
+	// which must then chain to TrustRoots too.
+	TrustPKIX
+	// TrustMetadataAndPKIX verifies the signatures with the certificates of
+	// the IdP metadata, which must chain to TrustRoots.
+	TrustMetadataAndPKIX
+)
+
+func (m TrustModel) String() string {
+	switch m {
+	case TrustMetadata:
+		return "metadata"
+	case TrustPKIX:
+		return "pkix"
+	case TrustMetadataAndPKIX:
+		return "metadata+pkix"
+	}
+	return fmt.Sprintf("TrustModel(%d)", int(m))
+}
+
+// checkTrustModel rejects the unknown trust models, rather than falling back
+// to one of the others.
+func (sp *ServiceProvider) checkTrustModel() error {
+	switch sp.TrustModel {
+	case TrustMetadata, TrustPKIX, TrustMetadataAndPKIX:
+		return nil
+	}
+	return errors.Errorf("unknown trust model %v", sp.TrustModel)
+}
+
+// verifyChain verifies that cert, an IdP certificate, chains to TrustRoots.
+func (sp *ServiceProvider) verifyChain(cert *x509.Certificate) error {
+	if sp.TrustRoots == nil {
+		return errors.Errorf("no TrustRoots for the %v trust model", sp.TrustModel)
+	}
+	_, err := cert.Verify(x509.VerifyOptions{
+		Roots:         sp.TrustRoots,
+		Intermediates: sp.TrustIntermediates,
+		CurrentTime:   sp.now(),
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	return err
+}
+
+// signatureCertFile returns the file of the certificate to verify the
+// signature of the node with the given ID, or of the whole document if nodeID
+// is empty, with: the certificate of its KeyInfo with TrustPKIX, the one of
+// the IdP metadata otherwise.
+func (sp *ServiceProvider) signatureCertFile(ctx context.Context, msg []byte, nodeID string) (string, error) {
+	if sp.TrustModel != TrustPKIX {
+		return sp.GetIdPCertFileContext(ctx)
+	}
+	meta, err := sp.GetIdPMetadataContext(ctx)
+	if err != nil {
+		return "", err
+	}
+	cert, err := signatureCertificate(msg, nodeID)
+	if err == nil {
+		err = sp.KeyPolicy.checkCertificate(cert, "signing", sp.now(), true)
+	}
+	if err == nil {
+		err = sp.verifyChain(cert)
+	}
+	if err == nil {
+		err = checkIdPName(cert, meta)
+	}
+	if err == nil && sp.RevocationCheck != nil {
+		err = sp.RevocationCheck.check(ctx, cert, sp.now())
+	}
+	if err != nil {
+		return "", errors.Wrap(err, "invalid signature certificate")
+	}
+	return writeFile(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}))
+}
+
+// checkIdPName verifies that cert, the certificate of a signature with
+// TrustPKIX, names the IdP of meta. Otherwise, the certificates TrustRoots
+// issued to anyone, such as other IdPs, would be accepted.
+func checkIdPName(cert *x509.Certificate, meta *Metadata) error {
+	for _, uri := range cert.URIs {
+		if uri.String() == meta.EntityID {
+			return nil
+		}
+	}
+	if u, err := url.Parse(meta.EntityID); err == nil && u.Hostname() != "" && cert.VerifyHostname(u.Hostname()) == nil {
+		return nil
+	}
+	if meta.IDPSSODescriptor != nil && cert.Subject.CommonName != "" {
+		for _, keyDescriptor := range meta.IDPSSODescriptor.KeyDescriptor {
+			if keyDescriptor.Use != "" && keyDescriptor.Use != "signing" {
+				continue
+			}
+			for _, name := range keyDescriptor.KeyInfo.KeyNames {
+				if name == cert.Subject.CommonName {
+					return nil
+				}
+			}
+		}
+	}
+	return errors.Errorf("the certificate of %q does not name the IdP %q", cert.Subject, meta.EntityID)
+}
+
+// signatureCertificate returns the certificate of the KeyInfo of the
+// signature of the node with the given ID, or of the root if nodeID is empty.
+func signatureCertificate(msg []byte, nodeID string) (*x509.Certificate, error) {
+	d := xml.NewDecoder(bytes.NewReader(msg))
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			return nil, errors.New("no certificate in the signature")
+		}
+		if err != nil {
+			return nil, err
+		}
+		start, ok := tok.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		if nodeID == "" {
+			for _, attr := range start.Attr {
+				if attr.Name.Space == "" && attr.Name.Local == "ID" {
+					nodeID = attr.Value
+				}
+			}
+			if nodeID == "" {
+				return nil, errors.New("missing ID of the signed node")
+			}
+		}
+		if start.Name.Space != dsigNamespace || start.Name.Local != "Signature" {
+			continue
+		}
+		var sig xmlsec.Signature
+		if err := d.DecodeElement(&sig, &start); err != nil {
+			return nil, err
+		}
+		if sig.Reference.URI == "#"+nodeID && sig.X509Certificate != nil {
+			return parseKeyInfoCertificate(sig.X509Certificate.X509Certificate)
+		}
+	}
+}
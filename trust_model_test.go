@@ -0,0 +1,132 @@
+package saml
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"io/ioutil"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestSignedMessage(responseCert, assertionCert *x509.Certificate) []byte {
+	signature := func(id string, cert *x509.Certificate) string {
+		return `<ds:Signature xmlns:ds="http://www.w3.org/2000/09/xmldsig#">` +
+			`<ds:SignedInfo><ds:Reference URI="#` + id + `"/></ds:SignedInfo>` +
+			`<ds:KeyInfo><ds:X509Data><ds:X509Certificate>` + base64.StdEncoding.EncodeToString(cert.Raw) + `</ds:X509Certificate></ds:X509Data></ds:KeyInfo>` +
+			`</ds:Signature>`
+	}
+	return []byte(`<samlp:Response xmlns:samlp="urn:oasis:names:tc:SAML:2.0:protocol" xmlns:saml="urn:oasis:names:tc:SAML:2.0:assertion" ID="id-response">` +
+		`<saml:Assertion ID="id-assertion">` + signature("id-assertion", assertionCert) + `</saml:Assertion>` +
+		signature("id-response", responseCert) +
+		`</samlp:Response>`)
+}
+
+func TestSignatureCertificate(t *testing.T) {
+	tearUp()
+
+	_, _, responseCert := newTestKeyPair(t, KeyPairOptions{Type: KeyTypeECDSA, CommonName: "response"})
+	_, _, assertionCert := newTestKeyPair(t, KeyPairOptions{Type: KeyTypeECDSA, CommonName: "assertion"})
+	msg := newTestSignedMessage(responseCert, assertionCert)
+
+	cert, err := signatureCertificate(msg, "")
+	if assert.NoError(t, err) {
+		assert.Equal(t, responseCert.Raw, cert.Raw)
+	}
+	cert, err = signatureCertificate(msg, "id-assertion")
+	if assert.NoError(t, err) {
+		assert.Equal(t, assertionCert.Raw, cert.Raw)
+	}
+	_, err = signatureCertificate(msg, "id-other")
+	assert.Error(t, err)
+}
+
+func TestTrustModel(t *testing.T) {
+	tearUp()
+
+	ca, other := newTestCA(t), newTestCA(t)
+	issued := ca.issue(t, 2, &x509.Certificate{DNSNames: []string{"idp.example.com"}})
+	_, _, selfSigned := newTestKeyPair(t, KeyPairOptions{Type: KeyTypeECDSA})
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	otherRoots := x509.NewCertPool()
+	otherRoots.AddCert(other.cert)
+
+	newSP := func(model TrustModel, roots *x509.CertPool, metadataCert *x509.Certificate) *ServiceProvider {
+		return &ServiceProvider{
+			TrustModel: model,
+			TrustRoots: roots,
+			IdPMetadata: &Metadata{
+				EntityID: "https://idp.example.com",
+				IDPSSODescriptor: &IDPSSODescriptor{
+					KeyDescriptor: []KeyDescriptor{
+						{Use: "signing", KeyInfo: KeyInfo{Certificate: base64.StdEncoding.EncodeToString(metadataCert.Raw)}},
+					},
+				},
+			},
+		}
+	}
+
+	// With TrustPKIX, the signatures are verified with their own
+	// certificate, which must chain to the roots.
+	msg := newTestSignedMessage(issued, issued)
+	certFile, err := newSP(TrustPKIX, roots, selfSigned).signatureCertFile(context.Background(), msg, "")
+	if assert.NoError(t, err) {
+		data, err := ioutil.ReadFile(certFile)
+		assert.NoError(t, err)
+		block, _ := pem.Decode(data)
+		assert.Equal(t, issued.Raw, block.Bytes)
+	}
+	_, err = newSP(TrustPKIX, otherRoots, selfSigned).signatureCertFile(context.Background(), msg, "")
+	assert.Error(t, err)
+	_, err = newSP(TrustPKIX, nil, selfSigned).signatureCertFile(context.Background(), msg, "")
+	assert.Error(t, err)
+	_, err = newSP(TrustPKIX, roots, issued).signatureCertFile(context.Background(), newTestSignedMessage(selfSigned, selfSigned), "")
+	assert.Error(t, err)
+
+	// The certificate must name the IdP, not only chain to the roots.
+	unnamed := ca.issue(t, 3, &x509.Certificate{})
+	_, err = newSP(TrustPKIX, roots, selfSigned).signatureCertFile(context.Background(), newTestSignedMessage(unnamed, unnamed), "")
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "does not name the IdP")
+	}
+	foreign := ca.issue(t, 4, &x509.Certificate{DNSNames: []string{"other.example.com"}})
+	_, err = newSP(TrustPKIX, roots, selfSigned).signatureCertFile(context.Background(), newTestSignedMessage(foreign, foreign), "")
+	assert.Error(t, err)
+	entityURI, _ := url.Parse("https://idp.example.com")
+	named := ca.issue(t, 5, &x509.Certificate{URIs: []*url.URL{entityURI}})
+	_, err = newSP(TrustPKIX, roots, selfSigned).signatureCertFile(context.Background(), newTestSignedMessage(named, named), "")
+	assert.NoError(t, err)
+	sp := newSP(TrustPKIX, roots, selfSigned)
+	sp.IdPMetadata.IDPSSODescriptor.KeyDescriptor[0].KeyInfo.KeyNames = []string{unnamed.Subject.CommonName}
+	_, err = sp.signatureCertFile(context.Background(), newTestSignedMessage(unnamed, unnamed), "")
+	assert.NoError(t, err)
+
+	// With TrustMetadata, they are verified with the metadata certificate.
+	certFile, err = newSP(TrustMetadata, nil, selfSigned).signatureCertFile(context.Background(), msg, "")
+	if assert.NoError(t, err) {
+		cert, err := retriveCertificate(certFile)
+		assert.NoError(t, err)
+		assert.Equal(t, selfSigned.Raw, cert.Raw)
+	}
+
+	// With TrustMetadataAndPKIX, the metadata certificate must chain to
+	// the roots.
+	_, err = newSP(TrustMetadataAndPKIX, roots, issued).GetIdPCertFileContext(context.Background())
+	assert.NoError(t, err)
+	_, err = newSP(TrustMetadataAndPKIX, roots, selfSigned).GetIdPCertFileContext(context.Background())
+	assert.Error(t, err)
+
+	// The unknown trust models are rejected.
+	_, err = newSP(TrustModel(7), roots, issued).GetIdPCertFileContext(context.Background())
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "unknown trust model TrustModel(7)")
+	}
+	_, err = newSP(TrustModel(7), roots, issued).signatureCertFile(context.Background(), msg, "")
+	assert.Error(t, err)
+
+	assert.Equal(t, "metadata+pkix", TrustMetadataAndPKIX.String())
+}
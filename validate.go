@@ -52,18 +52,13 @@ func retriveCertificate(file string) (cert *x509.Certificate, err error) {
 	return cert, err
 }
 
-func validateKeyFile(file string, err error) (string, error) {
-	if err != nil {
-		return "", err
-	}
-
+// validateKeyFile checks that the certificate in file is valid at now.
+func validateKeyFile(file string, now time.Time) (string, error) {
 	cert, err := retriveCertificate(file)
 	if err != nil {
 		return "", errors.Wrapf(err, "failed to read certificate %v", file)
 	}
 
-	now := time.Now()
-
 	if now.Before(cert.NotBefore) {
 		return "", fmt.Errorf("security certificate is not valid yet (notBefore=%v)", cert.NotBefore)
 	}
@@ -23,6 +23,10 @@ type ValidationOptions struct {
 	DTDFile          string
 	EnableIDAttrHack bool
 	IDAttrs          []string
+
+	// NodeID is the ID of the node where the operation starts. When
+	// verifying, the first Signature found in this node is checked.
+	NodeID string
 }
 
 // ErrSelfSignedCertificate is a typed error returned when xmlsec1 detects a
@@ -370,6 +374,12 @@ func applyOptions(args *[]string, opts *ValidationOptions) {
 		}...)
 	}
 
+	if opts.NodeID != "" {
+		*args = append(*args, []string{
+			"--node-id", opts.NodeID,
+		}...)
+	}
+
 	if opts.EnableIDAttrHack {
 		*args = append(*args, []string{
 			"--id-attr:ID", attrNameResponse,
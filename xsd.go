@@ -0,0 +1,97 @@
+package saml
+
+import (
+	"bytes"
+	"embed"
+	"io/ioutil"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ProtocolSchema is the file name of the SAML 2.0 protocol schema written by
+// WriteSchemas.
+const ProtocolSchema = "saml-schema-protocol-2.0.xsd"
+
+// schemas are the SAML 2.0 protocol and assertion schemas, with the XML
+// Signature and XML Encryption schemas they import from the same directory.
+//
+//go:embed xsd/*.xsd
+var schemas embed.FS
+
+// WriteSchemas writes to dir the SAML 2.0 schemas bundled with the package,
+// and the schemas they import, so that xmllint doesn't need the network. It
+// returns the path of the protocol schema, to be used as SchemaFile.
+func WriteSchemas(dir string) (string, error) {
+	entries, err := schemas.ReadDir("xsd")
+	if err != nil {
+		return "", err
+	}
+	for _, entry := range entries {
+		data, err := schemas.ReadFile(path.Join("xsd", entry.Name()))
+		if err != nil {
+			return "", err
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, entry.Name()), data, 0644); err != nil {
+			return "", errors.Wrap(err, "failed to write schema")
+		}
+	}
+	return filepath.Join(dir, ProtocolSchema), nil
+}
+
+// SchemaError is returned for the messages that do not conform to the XML
+// schema of the SP, see ServiceProvider.SchemaFile.
+type SchemaError struct {
+	// Errors are the validity errors reported by xmllint.
+	Errors []string
+}
+
+func (e *SchemaError) Error() string {
+	if len(e.Errors) == 0 {
+		return "document does not conform to the schema"
+	}
+	return "document does not conform to the schema: " + strings.Join(e.Errors, "; ")
+}
+
+// ValidateSchema validates the XML message data, such as a LogoutRequest
+// decoded by DecodeRedirect, against the schema at SchemaFile. It returns a
+// *SchemaError when the message does not conform to the schema, and nil
+// when the SP has no SchemaFile.
+func (sp *ServiceProvider) ValidateSchema(data []byte) error {
+	if sp.SchemaFile == "" {
+		return nil
+	}
+	return validateSchema(data, sp.SchemaFile)
+}
+
+// validateSchema validates the XML document data against the XML schema at
+// schemaFile with xmllint. The network is not used: the schemas imported by
+// schemaFile must be available locally.
+func validateSchema(data []byte, schemaFile string) error {
+	var stderr bytes.Buffer
+	cmd := exec.Command("xmllint", "--noout", "--nonet", "--schema", schemaFile, "-")
+	cmd.Stdin = bytes.NewReader(data)
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	if err == nil {
+		return nil
+	}
+
+	exitErr, ok := err.(*exec.ExitError)
+	// xmllint exits with 3 for validity errors, and with other codes when
+	// the schema cannot be loaded.
+	if !ok || exitErr.ExitCode() != 3 {
+		return errors.Errorf("xmllint failed to validate against %s: %s", schemaFile, strings.TrimSpace(stderr.String()))
+	}
+	schemaErr := &SchemaError{}
+	for _, line := range strings.Split(stderr.String(), "\n") {
+		// -:12: element Issuer: Schemas validity error : Element '...': ...
+		if i := strings.Index(line, "validity error : "); i >= 0 {
+			schemaErr.Errors = append(schemaErr.Errors, line[i+len("validity error : "):])
+		}
+	}
+	return schemaErr
+}
@@ -0,0 +1,91 @@
+package saml
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSchemaFile(t *testing.T) {
+	if _, err := exec.LookPath("xmllint"); err != nil {
+		t.Skip("xmllint not found")
+	}
+	tearUp()
+
+	sp, simpleSign := newSimpleSignTestSP(t)
+	sp.SchemaFile = filepath.Join("_testdata", "schema", "response.xsd")
+	now := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+	res := newTestAssertionResponse(sp, now)
+	res.Version = "2.0"
+	validate := func() *ValidationResult {
+		samlResponse := encodeTestResponse(t, res)
+		return sp.validateResponse(context.Background(), samlResponse, simpleSign(samlResponse), []TrackedRequest{{}}, now, nil, nil, true)
+	}
+
+	result := validate()
+	assert.NoError(t, result.Err())
+	assert.Contains(t, result.Passed, CheckSchema)
+
+	res.Version = "1.1"
+	result = validate()
+	if assert.Len(t, result.Failures, 1) {
+		assert.Equal(t, CheckSchema, result.Failures[0].Check)
+		assert.True(t, errors.Is(result.Failures[0].Err, ErrMalformedResponse))
+		var schemaErr *SchemaError
+		if assert.True(t, errors.As(result.Failures[0].Err, &schemaErr)) && assert.Len(t, schemaErr.Errors, 1) {
+			assert.Contains(t, schemaErr.Errors[0], "'Version'")
+		}
+	}
+	assert.True(t, result.Incomplete)
+
+	// A missing schema is not the fault of the message.
+	sp.SchemaFile = filepath.Join("_testdata", "schema", "missing.xsd")
+	res.Version = "2.0"
+	result = validate()
+	assert.Error(t, result.Err())
+	assert.False(t, errors.Is(result.Err(), ErrMalformedResponse))
+
+	sp.SchemaFile = ""
+	assert.NoError(t, sp.ValidateSchema([]byte("<LogoutRequest/>")))
+	result = validate()
+	assert.NoError(t, result.Err())
+	assert.NotContains(t, result.Passed, CheckSchema)
+}
+
+func TestWriteSchemas(t *testing.T) {
+	if _, err := exec.LookPath("xmllint"); err != nil {
+		t.Skip("xmllint not found")
+	}
+	tearUp()
+
+	schemaFile, err := WriteSchemas(t.TempDir())
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, ProtocolSchema, filepath.Base(schemaFile))
+
+	sp, simpleSign := newSimpleSignTestSP(t)
+	sp.SchemaFile = schemaFile
+	now := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+	res := newTestAssertionResponse(sp, now)
+	res.Version = "2.0"
+	res.Assertion.Version = "2.0"
+	res.InResponseTo = "id-req"
+	res.Assertion.Subject.SubjectConfirmations[0].SubjectConfirmationData.InResponseTo = "id-req"
+	samlResponse := encodeTestResponse(t, res)
+	result := sp.validateResponse(context.Background(), samlResponse, simpleSign(samlResponse), []TrackedRequest{{ID: "id-req"}}, now, nil, nil, true)
+	assert.NoError(t, result.Err())
+	assert.Contains(t, result.Passed, CheckSchema)
+
+	// The schemas imported by the protocol schema are found next to it.
+	err = sp.ValidateSchema([]byte(`<samlp:LogoutRequest xmlns:samlp="urn:oasis:names:tc:SAML:2.0:protocol" xmlns:saml="urn:oasis:names:tc:SAML:2.0:assertion" ID="id-logout" Version="2.0" IssueInstant="2018-01-01T00:00:00Z"><saml:Issuer>https://idp.example.com</saml:Issuer><saml:NameID>jane</saml:NameID></samlp:LogoutRequest>`))
+	assert.NoError(t, err)
+	err = sp.ValidateSchema([]byte(`<samlp:LogoutRequest xmlns:samlp="urn:oasis:names:tc:SAML:2.0:protocol" ID="id-logout" Version="2.0" IssueInstant="2018-01-01T00:00:00Z"/>`))
+	var schemaErr *SchemaError
+	assert.True(t, errors.As(err, &schemaErr), "%v", err)
+}
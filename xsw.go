@@ -0,0 +1,128 @@
+package saml
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+// XML namespaces of the elements involved in signature wrapping checks.
+const (
+	samlpNamespace = "urn:oasis:names:tc:SAML:2.0:protocol"
+	samlNamespace  = "urn:oasis:names:tc:SAML:2.0:assertion"
+	dsigNamespace  = "http://www.w3.org/2000/09/xmldsig#"
+)
+
+// checkSignedStructure checks data as checkXML does, and rejects the
+// documents crafted for XML signature wrapping (XSW) attacks, reported as a
+// *structureError. xmlsec1 only tells whether some node of the document is
+// correctly signed, while encoding/xml picks the nodes to use by
+// name, so both must agree on a single candidate:
+//
+//   - the root element is rootSpace:rootLocal;
+//   - a Response has at most one Assertion or EncryptedAssertion, which is a
+//     direct child of the root element, and an Assertion has none (assertions
+//     in Advice are not supported);
+//   - Signature elements are direct children of the root element or of the
+//     assertion, at most one per parent, and the signature of the root
+//     element comes before the assertion, so that it is the one xmlsec1
+//     verifies first;
+//   - ID attributes are unique in the document.
+func checkSignedStructure(data []byte, rootSpace, rootLocal string) error {
+	var stack []xml.Name
+	ids := map[string]bool{}
+	assertions := 0
+	signatures := map[int]int{}
+
+	return scanXML(data, func(tok xml.Token) error {
+		switch tok := tok.(type) {
+		case xml.StartElement:
+			depth := len(stack)
+			name := tok.Name
+
+			if depth == 0 && (name.Space != rootSpace || name.Local != rootLocal) {
+				return errors.Errorf("unexpected root element %s", name.Local)
+			}
+
+			for _, attr := range tok.Attr {
+				if attr.Name.Space == "" && attr.Name.Local == "ID" {
+					if ids[attr.Value] {
+						return errors.Errorf("duplicate ID %q", attr.Value)
+					}
+					ids[attr.Value] = true
+				}
+			}
+
+			isAssertion := name.Space == samlNamespace && (name.Local == "Assertion" || name.Local == "EncryptedAssertion")
+			if isAssertion && depth > 0 {
+				assertions++
+				if rootLocal == "Assertion" || assertions > 1 {
+					return errors.New("more than one assertion")
+				}
+				if depth != 1 {
+					return errors.Errorf("unexpected %s in %s", name.Local, stack[depth-1].Local)
+				}
+			}
+
+			if name.Space == dsigNamespace && name.Local == "Signature" {
+				if !isSignedElement(stack) {
+					return errors.Errorf("unexpected Signature in %s", stack[depth-1].Local)
+				}
+				if depth == 1 && assertions > 0 {
+					return errors.Errorf("unexpected Signature after the assertion")
+				}
+				signatures[depth]++
+				if signatures[depth] > 1 {
+					return errors.Errorf("more than one Signature in %s", stack[depth-1].Local)
+				}
+			}
+
+			stack = append(stack, name)
+		case xml.EndElement:
+			// A new element at this depth may have its own signature.
+			delete(signatures, len(stack))
+			stack = stack[:len(stack)-1]
+		}
+		return nil
+	})
+}
+
+// isSignedElement returns whether a Signature found under the given stack of
+// elements is at a legitimate place: the root element, or the assertion
+// below it.
+func isSignedElement(stack []xml.Name) bool {
+	switch len(stack) {
+	case 1:
+		return true
+	case 2:
+		return stack[1].Space == samlNamespace && stack[1].Local == "Assertion"
+	}
+	return false
+}
+
+// decodeElementByID decodes into v the element of the document whose ID
+// attribute is id. It is used to make sure the data consumed is the very
+// node that was signed, whatever its place in the document.
+func decodeElementByID(data []byte, id string, v interface{}) error {
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			return errors.Errorf("no element with ID %q", id)
+		}
+		if err != nil {
+			return err
+		}
+		start, ok := tok.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		for _, attr := range start.Attr {
+			if attr.Name.Space == "" && attr.Name.Local == "ID" && attr.Value == id {
+				return dec.DecodeElement(v, &start)
+			}
+		}
+	}
+}
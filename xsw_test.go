@@ -0,0 +1,98 @@
+package saml
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	xswSignature = `<ds:Signature xmlns:ds="http://www.w3.org/2000/09/xmldsig#"><ds:SignedInfo><ds:Reference URI="#%s"/></ds:SignedInfo>%s</ds:Signature>`
+	xswAssertion = `<saml:Assertion xmlns:saml="urn:oasis:names:tc:SAML:2.0:assertion" ID="%s">%s<saml:Subject><saml:NameID>%s</saml:NameID></saml:Subject></saml:Assertion>`
+	xswResponse  = `<samlp:Response xmlns:samlp="urn:oasis:names:tc:SAML:2.0:protocol" ID="%s">%s</samlp:Response>`
+)
+
+func xswSig(id string, object string) string {
+	if object != "" {
+		object = `<ds:Object>` + object + `</ds:Object>`
+	}
+	return fmt.Sprintf(xswSignature, id, object)
+}
+
+func xswAssert(id, signature, user string) string {
+	return fmt.Sprintf(xswAssertion, id, signature, user)
+}
+
+func xswResp(id string, children ...string) string {
+	content := ""
+	for _, child := range children {
+		content += child
+	}
+	return fmt.Sprintf(xswResponse, id, content)
+}
+
+func TestCheckSignedStructure(t *testing.T) {
+	signedAssertion := xswAssert("id-assertion", xswSig("id-assertion", ""), "jane")
+	signedResponse := xswResp("id-response", xswSig("id-response", ""), signedAssertion)
+
+	valid := []string{
+		signedResponse,
+		xswResp("id-response", signedAssertion),
+		xswResp("id-response", xswSig("id-response", ""), xswAssert("id-assertion", "", "jane")),
+	}
+	for _, doc := range valid {
+		assert.NoError(t, checkSignedStructure([]byte(doc), samlpNamespace, "Response"), doc)
+	}
+
+	vectors := map[string]string{
+		// The signed response is moved into the signature of an evil one.
+		"XSW1": xswResp("id-evil", xswSig("id-response", signedResponse), xswAssert("id-evil-assertion", "", "admin")),
+		// The signed response is moved next to the signature of an evil one.
+		"XSW2": xswResp("id-evil", xswSig("id-response", ""), signedResponse, xswAssert("id-evil-assertion", "", "admin")),
+		// An evil assertion is inserted before the signed one.
+		"XSW3": xswResp("id-response", xswAssert("id-evil", "", "admin"), signedAssertion),
+		// The signed assertion is wrapped in an evil one.
+		"XSW4": xswResp("id-response", xswAssert("id-evil", "", "admin"+signedAssertion)),
+		// The signature of the signed assertion is moved to an evil one.
+		"XSW5": xswResp("id-response", xswAssert("id-evil", xswSig("id-assertion", ""), "admin"), xswAssert("id-assertion", "", "jane")),
+		// The signed assertion is moved into the signature of an evil one.
+		"XSW6": xswResp("id-response", xswAssert("id-evil", xswSig("id-assertion", signedAssertion), "admin")),
+		// The signed assertion is hidden in an Extensions element.
+		"XSW7": xswResp("id-response", `<samlp:Extensions>`+signedAssertion+`</samlp:Extensions>`, xswAssert("id-evil", "", "admin")),
+		// The signed assertion is hidden in the Object of its own signature.
+		"XSW8": xswResp("id-response", xswAssert("id-evil", xswSig("id-assertion", xswAssert("id-assertion", "", "jane")), "admin")),
+		// Two nodes share the signed ID.
+		"duplicate ID": xswResp("id-response", xswSig("id-response", ""), `<samlp:Extensions ID="id-response"/>`, signedAssertion),
+		// Two signatures in the same element.
+		"two signatures": xswResp("id-response", xswSig("id-response", ""), xswSig("id-response", ""), signedAssertion),
+		// A bogus response signature is moved after a signed assertion, so
+		// that only the latter is verified.
+		"signature order": xswResp("id-evil", signedAssertion, xswSig("id-evil", "")),
+		// The document is not a response.
+		"root": xswAssert("id-assertion", xswSig("id-assertion", ""), "jane"),
+	}
+	for name, doc := range vectors {
+		err := checkSignedStructure([]byte(doc), samlpNamespace, "Response")
+		assert.IsType(t, &structureError{}, err, name)
+	}
+
+	// Malformed documents are rejected as by checkXML.
+	err := checkSignedStructure([]byte(`<!DOCTYPE x><x/>`), samlpNamespace, "Response")
+	_, isStructure := err.(*structureError)
+	assert.True(t, err != nil && !isStructure)
+
+	assert.NoError(t, checkSignedStructure([]byte(signedAssertion), samlNamespace, "Assertion"))
+	assert.Error(t, checkSignedStructure([]byte(xswAssert("id-evil", "", "admin"+signedAssertion)), samlNamespace, "Assertion"))
+}
+
+func TestDecodeElementByID(t *testing.T) {
+	doc := xswResp("id-response", xswSig("id-response", ""), xswAssert("id-assertion", "", "jane"))
+
+	var assertion Assertion
+	assert.NoError(t, decodeElementByID([]byte(doc), "id-assertion", &assertion))
+	assert.Equal(t, "id-assertion", assertion.ID)
+	assert.Equal(t, "jane", assertion.Subject.NameID.Value)
+
+	assert.Error(t, decodeElementByID([]byte(doc), "id-other", &assertion))
+}